@@ -15,11 +15,15 @@ import (
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
 
+	"github.com/kintsugi-ai/backend/internal/llm"
 	"github.com/kintsugi-ai/backend/internal/modules/auth"
 	"github.com/kintsugi-ai/backend/internal/modules/chat"
 	"github.com/kintsugi-ai/backend/internal/modules/messenger"
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+	"github.com/kintsugi-ai/backend/internal/modules/search"
 	"github.com/kintsugi-ai/backend/internal/modules/subscription"
 	"github.com/kintsugi-ai/backend/internal/modules/translation"
+	"github.com/kintsugi-ai/backend/internal/storage"
 )
 
 func main() {
@@ -69,6 +73,36 @@ func main() {
 	// Folders service and handler
 	foldersService := chat.NewFoldersService(db)
 	foldersHandler := chat.NewFoldersHandler(foldersService)
+	personasService := chat.NewPersonasService(db)
+	personasHandler := chat.NewPersonasHandler(personasService)
+	importExportService := chat.NewImportExportService(db)
+	importExportHandler := chat.NewImportExportHandler(importExportService)
+	modelCatalogService := chat.NewModelCatalogService(db)
+	modelCatalogHandler := chat.NewModelCatalogHandler(modelCatalogService)
+	chatService.SetModelCatalog(modelCatalogService)
+	importExportService.SetModelCatalog(modelCatalogService)
+
+	// Documents service and handler (uploads embedded for retrieval)
+	fileStorage, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize file storage: %v", err)
+	}
+	embedder := llm.NewEmbedderFromEnv()
+	documentsService := chat.NewDocumentsService(db, fileStorage, embedder)
+	documentsHandler := chat.NewDocumentsHandler(documentsService)
+	chatService.SetDocuments(documentsService)
+	attachmentsService := chat.NewAttachmentsService(db, fileStorage)
+	attachmentsHandler := chat.NewAttachmentsHandler(attachmentsService)
+	chatService.SetAttachments(attachmentsService)
+	imagesService := chat.NewImagesService(db, fileStorage)
+	imagesHandler := chat.NewImagesHandler(imagesService, chatService)
+	chatService.SetImages(imagesService)
+	speechService := chat.NewSpeechService(db, fileStorage, llm.NewSynthesizerFromEnv())
+	speechHandler := chat.NewSpeechHandler(speechService, chatService)
+	chatService.SetSpeech(speechService)
+	memoryService := chat.NewMemoryService(db, embedder)
+	memoryHandler := chat.NewMemoryHandler(memoryService)
+	chatService.SetMemory(memoryService)
 
 	// Code execution service and handler
 	codeExecService, err := chat.NewCodeExecutionService(db)
@@ -82,24 +116,51 @@ func main() {
 		codeExecHandler = chat.NewCodeExecutionHandler(codeExecService)
 	}
 
+	// Content moderation of prompts, replies and messenger messages
+	moderationService := moderation.NewService(db, llm.NewModeratorFromEnv(), moderation.PolicyFromEnv())
+	chatService.SetModeration(moderationService)
+
 	// Analytics service and handler
 	analyticsService := chat.NewAnalyticsService(db)
 	analyticsHandler := chat.NewAnalyticsHandler(analyticsService)
+	chatService.SetAnalytics(analyticsService)
+
+	// Batch completion jobs, run in the background
+	batchService := chat.NewBatchService(db, chatService)
+	batchHandler := chat.NewBatchHandler(batchService)
+	go batchService.Run()
+
+	// Scheduled prompts, run once the messenger hub can deliver results
+	scheduleService := chat.NewScheduleService(db, chatService)
+	scheduleHandler := chat.NewScheduleHandler(scheduleService)
 
 	// Admin service and handler
 	adminService := chat.NewAdminService(db)
 	adminHandler := chat.NewAdminHandler(adminService, db)
 
 	// Register all chat routes (including folders, code execution, analytics, admin)
-	chat.RegisterRoutes(app, chatHandler, authMiddleware.Protected(), foldersHandler, codeExecHandler, analyticsHandler, adminHandler)
+	chat.RegisterRoutes(app, chatHandler, authMiddleware.Protected(), foldersHandler, codeExecHandler, analyticsHandler, adminHandler, personasHandler, documentsHandler, importExportHandler, modelCatalogHandler, attachmentsHandler, imagesHandler, speechHandler, memoryHandler, batchHandler, scheduleHandler)
+
+	// Review queue of moderated content, for admins
+	moderation.RegisterRoutes(app, moderation.NewHandler(moderationService), authMiddleware.Protected(), adminHandler.AdminOnly())
+
+	// OpenAI-compatible API for personal API keys
+	chat.RegisterOpenAIRoutes(app, chat.NewOpenAIAPIHandler(chatService, imagesService), authMiddleware.APIKey())
 
 	// Messenger module with WebSocket Hub
 	messengerHub := messenger.NewHub()
 	go messengerHub.Run()
 	messengerRepo := messenger.NewRepository(db)
 	messengerService := messenger.NewService(messengerRepo, messengerHub)
+	messengerService.SetModeration(moderationService)
 	messengerHandler := messenger.NewHandler(messengerService, messengerHub)
-	messenger.RegisterRoutes(app, messengerHandler, authMiddleware.Protected())
+	voiceService := messenger.NewVoiceService(messengerRepo, messengerHub, fileStorage, llm.NewTranscriberFromEnv())
+	voiceService.SetModeration(moderationService)
+	go voiceService.Run()
+	scheduleService.SetNotifier(messengerHub)
+	go scheduleService.Run()
+	voiceHandler := messenger.NewVoiceHandler(voiceService)
+	messenger.RegisterRoutes(app, messengerHandler, voiceHandler, authMiddleware.Protected())
 
 	// Translation module
 	translationRepo := translation.NewRepository(db)
@@ -107,6 +168,28 @@ func main() {
 	translationHandler := translation.NewHandler(translationService)
 	translation.RegisterRoutes(app, translationHandler, authMiddleware.Protected())
 
+	// Search module (keyword search, plus semantic search when embeddings
+	// are configured)
+	searchService := search.NewService(db)
+	searchService.SetEmbedder(embedder)
+	searchHandler := search.NewHandler(searchService, db)
+	search.RegisterRoutes(app, searchHandler, authMiddleware.Protected())
+	if embedder != nil {
+		go search.NewIndexer(db, embedder).Run()
+	}
+
+	// Tools the chat model can call
+	chatTools := chat.NewToolRegistry()
+	if codeExecService != nil {
+		chatTools.Register(chat.NewCodeExecutionTool(codeExecService))
+	}
+	if os.Getenv("DEEPL_API_KEY") != "" {
+		chatTools.Register(chat.NewTranslationTool(translationService))
+	}
+	chatTools.Register(chat.NewSearchTool(searchService))
+	chatTools.Register(chat.NewMemoryTool(memoryService))
+	chatService.SetTools(chatTools)
+
 	// Subscription module
 	subscriptionRepo := subscription.NewRepository(db)
 	subscriptionService := subscription.NewService(subscriptionRepo, db)
@@ -248,9 +331,63 @@ func migrateDatabase(db *gorm.DB) error {
 	if err := ensureRefreshTokensTable(db); err != nil {
 		return fmt.Errorf("failed to ensure refresh_tokens table: %w", err)
 	}
+	if err := ensureAPIKeysTable(db); err != nil {
+		return fmt.Errorf("failed to ensure api_keys table: %w", err)
+	}
 	if err := ensureChatMessagesTable(db); err != nil {
 		return fmt.Errorf("failed to ensure chat messages table: %w", err)
 	}
+	if err := ensureChatContextTables(db); err != nil {
+		return fmt.Errorf("failed to ensure chat context tables: %w", err)
+	}
+	if err := ensureChatBranchingColumns(db); err != nil {
+		return fmt.Errorf("failed to ensure chat branching columns: %w", err)
+	}
+	if err := ensureChatToolColumns(db); err != nil {
+		return fmt.Errorf("failed to ensure chat tool columns: %w", err)
+	}
+	if err := ensureMessageStatusColumn(db); err != nil {
+		return fmt.Errorf("failed to ensure message status column: %w", err)
+	}
+	if err := ensurePersonasTable(db); err != nil {
+		return fmt.Errorf("failed to ensure personas table: %w", err)
+	}
+	if err := ensureDocumentTables(db); err != nil {
+		return fmt.Errorf("failed to ensure document tables: %w", err)
+	}
+	if err := ensureSearchTables(db); err != nil {
+		return fmt.Errorf("failed to ensure search tables: %w", err)
+	}
+	if err := ensureChatSharesTable(db); err != nil {
+		return fmt.Errorf("failed to ensure chat shares table: %w", err)
+	}
+	if err := ensureChatComparisonsTable(db); err != nil {
+		return fmt.Errorf("failed to ensure chat comparisons table: %w", err)
+	}
+	if err := ensureModelCatalogTable(db); err != nil {
+		return fmt.Errorf("failed to ensure model catalog table: %w", err)
+	}
+	if err := ensureMessageAttachmentsTable(db); err != nil {
+		return fmt.Errorf("failed to ensure message attachments table: %w", err)
+	}
+	if err := ensureGeneratedImagesTable(db); err != nil {
+		return fmt.Errorf("failed to ensure generated images table: %w", err)
+	}
+	if err := ensureUserMemoriesTable(db); err != nil {
+		return fmt.Errorf("failed to ensure user memories table: %w", err)
+	}
+	if err := ensureBatchJobTables(db); err != nil {
+		return fmt.Errorf("failed to ensure batch job tables: %w", err)
+	}
+	if err := ensureScheduledPromptTables(db); err != nil {
+		return fmt.Errorf("failed to ensure scheduled prompt tables: %w", err)
+	}
+	if err := ensureChatTitleColumns(db); err != nil {
+		return fmt.Errorf("failed to ensure chat title columns: %w", err)
+	}
+	if err := ensureModerationEventsTable(db); err != nil {
+		return fmt.Errorf("failed to ensure moderation events table: %w", err)
+	}
 	if err := ensureMessengerTables(db); err != nil {
 		return fmt.Errorf("failed to ensure messenger tables: %w", err)
 	}
@@ -290,6 +427,12 @@ func migrateDatabase(db *gorm.DB) error {
 	if err := ensureAdvancedFeaturesTable(db); err != nil {
 		return fmt.Errorf("failed to ensure advanced features tables: %w", err)
 	}
+	if err := ensureVoiceTranscriptionColumns(db); err != nil {
+		return fmt.Errorf("failed to ensure voice transcription columns: %w", err)
+	}
+	if err := ensureSearchIndexes(db); err != nil {
+		return fmt.Errorf("failed to ensure search indexes: %w", err)
+	}
 
 	log.Println("Database migrations completed")
 	return nil
@@ -476,6 +619,57 @@ func ensureAdvancedFeaturesTable(db *gorm.DB) error {
 	return nil
 }
 
+func ensureVoiceTranscriptionColumns(db *gorm.DB) error {
+	statements := []string{
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS storage_key varchar(500)",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS file_name varchar(255)",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS content_type varchar(100)",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS file_size bigint DEFAULT 0",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS language varchar(16)",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS status varchar(20) DEFAULT 'pending'",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS error text",
+		"ALTER TABLE voice_messages ADD COLUMN IF NOT EXISTS transcribed_at timestamptz",
+		// Recordings created before transcription existed have nothing to process
+		"UPDATE voice_messages SET status = 'completed' WHERE storage_key IS NULL AND status = 'pending'",
+		"CREATE INDEX IF NOT EXISTS idx_voice_messages_unfinished ON voice_messages(created_at) WHERE status IN ('pending', 'processing')",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate voice messages: %v", err)
+			return fmt.Errorf("failed to migrate voice messages: %w", err)
+		}
+	}
+
+	log.Println("Voice transcription columns ensured via manual SQL")
+	return nil
+}
+
+// ensureSearchIndexes adds trigram indexes for the substring search of chat
+// and messenger messages, which matches LOWER(content) LIKE '%query%'.
+func ensureSearchIndexes(db *gorm.DB) error {
+	// Without pg_trgm search still works, by scanning the messages
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("Warning: pg_trgm extension unavailable, message search is not indexed: %v", err)
+		return nil
+	}
+
+	statements := []string{
+		"CREATE INDEX IF NOT EXISTS idx_messages_content_trgm ON messages USING gin (lower(content) gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_conversation_messages_content_trgm ON conversation_messages USING gin (lower(content) gin_trgm_ops)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate search indexes: %v", err)
+			return fmt.Errorf("failed to migrate search indexes: %w", err)
+		}
+	}
+
+	log.Println("Search indexes ensured via manual SQL")
+	return nil
+}
+
 func ensureRefreshTokensTable(db *gorm.DB) error {
 	createTableSQL := `
 		CREATE TABLE IF NOT EXISTS refresh_tokens (
@@ -501,6 +695,32 @@ func ensureRefreshTokensTable(db *gorm.DB) error {
 	return nil
 }
 
+func ensureAPIKeysTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name varchar(100) NOT NULL,
+			prefix varchar(20) NOT NULL,
+			key_hash varchar(64) NOT NULL UNIQUE,
+			last_used_at timestamptz,
+			expires_at timestamptz,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate api_keys: %v", err)
+			return fmt.Errorf("failed to migrate api_keys: %w", err)
+		}
+	}
+
+	log.Println("API keys table ensured via manual SQL")
+	return nil
+}
+
 func ensureChatMessagesTable(db *gorm.DB) error {
 	createTableSQL := `
 		CREATE TABLE IF NOT EXISTS messages (
@@ -534,6 +754,602 @@ func ensureChatMessagesTable(db *gorm.DB) error {
 	return nil
 }
 
+func ensureChatContextTables(db *gorm.DB) error {
+	if err := db.Exec("ALTER TABLE chats ADD COLUMN IF NOT EXISTS context_strategy varchar(20) DEFAULT 'summarize'").Error; err != nil {
+		log.Printf("Failed to add chats context_strategy column: %v", err)
+		return fmt.Errorf("failed to add chats context_strategy column: %w", err)
+	}
+
+	createTableSQL := `
+		CREATE TABLE IF NOT EXISTS chat_summaries (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			chat_id uuid NOT NULL UNIQUE REFERENCES chats(id) ON DELETE CASCADE,
+			content text NOT NULL,
+			last_message_id uuid,
+			summarized_until timestamptz,
+			message_count integer DEFAULT 0,
+			tokens integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			updated_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)
+	`
+
+	if err := db.Exec(createTableSQL).Error; err != nil {
+		log.Printf("Failed to create chat_summaries table: %v", err)
+		return fmt.Errorf("failed to create chat_summaries table: %w", err)
+	}
+
+	log.Println("Chat context tables ensured via manual SQL")
+	return nil
+}
+
+func ensureChatBranchingColumns(db *gorm.DB) error {
+	statements := []string{
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id uuid",
+		"CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id)",
+		"ALTER TABLE chats ADD COLUMN IF NOT EXISTS active_leaf_id uuid",
+		// Chats without an active leaf predate branching and are linear:
+		// link each message to the one before it, then select the last one
+		`UPDATE messages m
+		SET parent_id = linked.prev_id
+		FROM (
+			SELECT id, LAG(id) OVER (PARTITION BY chat_id ORDER BY created_at, id) AS prev_id
+			FROM messages
+			WHERE deleted_at IS NULL
+		) linked
+		WHERE m.id = linked.id
+			AND m.parent_id IS NULL
+			AND linked.prev_id IS NOT NULL
+			AND m.chat_id IN (SELECT id FROM chats WHERE active_leaf_id IS NULL)`,
+		`UPDATE chats c
+		SET active_leaf_id = (
+			SELECT id FROM messages
+			WHERE chat_id = c.id AND deleted_at IS NULL
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		)
+		WHERE c.active_leaf_id IS NULL`,
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate chat branching: %v", err)
+			return fmt.Errorf("failed to migrate chat branching: %w", err)
+		}
+	}
+
+	log.Println("Chat branching columns ensured via manual SQL")
+	return nil
+}
+
+func ensureChatToolColumns(db *gorm.DB) error {
+	statements := []string{
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS tool_calls text",
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS tool_call_id varchar(100)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to add message tool columns: %v", err)
+			return fmt.Errorf("failed to add message tool columns: %w", err)
+		}
+	}
+
+	log.Println("Chat tool columns ensured via manual SQL")
+	return nil
+}
+
+func ensureMessageStatusColumn(db *gorm.DB) error {
+	if err := db.Exec("ALTER TABLE messages ADD COLUMN IF NOT EXISTS status varchar(20) DEFAULT 'completed'").Error; err != nil {
+		log.Printf("Failed to add messages status column: %v", err)
+		return fmt.Errorf("failed to add messages status column: %w", err)
+	}
+
+	log.Println("Message status column ensured via manual SQL")
+	return nil
+}
+
+func ensurePersonasTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS personas (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL,
+			name varchar(100) NOT NULL,
+			description text,
+			icon varchar(50),
+			system_prompt text NOT NULL,
+			variables text,
+			model varchar(50),
+			is_public boolean DEFAULT false,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			deleted_at timestamptz
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_personas_user_id ON personas(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_personas_is_public ON personas(is_public)",
+		"CREATE INDEX IF NOT EXISTS idx_personas_deleted_at ON personas(deleted_at)",
+		"ALTER TABLE chats ADD COLUMN IF NOT EXISTS persona_id uuid REFERENCES personas(id) ON DELETE SET NULL",
+		"ALTER TABLE chats ADD COLUMN IF NOT EXISTS persona_variables text",
+		"CREATE INDEX IF NOT EXISTS idx_chats_persona_id ON chats(persona_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate personas: %v", err)
+			return fmt.Errorf("failed to migrate personas: %w", err)
+		}
+	}
+
+	log.Println("Personas table ensured via manual SQL")
+	return nil
+}
+
+func ensureDocumentTables(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS documents (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			file_name varchar(255) NOT NULL,
+			file_type varchar(100),
+			format varchar(20),
+			file_size bigint,
+			storage_key varchar(500) NOT NULL,
+			status varchar(20) DEFAULT 'processing',
+			error text,
+			chunk_count integer DEFAULT 0,
+			tokens integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			deleted_at timestamptz
+		)`,
+		`CREATE TABLE IF NOT EXISTS chat_documents (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			chat_id uuid NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+			document_id uuid NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(chat_id, document_id)
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_documents_user_id ON documents(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_documents_deleted_at ON documents(deleted_at)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_documents_chat_id ON chat_documents(chat_id)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_documents_document_id ON chat_documents(document_id)",
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS citations text",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate documents: %v", err)
+			return fmt.Errorf("failed to migrate documents: %w", err)
+		}
+	}
+
+	// The vector store needs the pgvector extension. Without it uploads are
+	// still stored but fail to index, so the server keeps starting.
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		log.Printf("Warning: pgvector extension unavailable, document search is disabled: %v", err)
+		return nil
+	}
+
+	vectorStatements := []string{
+		`CREATE TABLE IF NOT EXISTS embeddings (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			namespace varchar(50) NOT NULL,
+			source_id uuid NOT NULL,
+			scope_id uuid NOT NULL,
+			position integer DEFAULT 0,
+			content text NOT NULL,
+			metadata text,
+			model varchar(100) NOT NULL,
+			embedding vector NOT NULL,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_embeddings_namespace_scope ON embeddings(namespace, scope_id)",
+		"CREATE INDEX IF NOT EXISTS idx_embeddings_namespace_source ON embeddings(namespace, source_id)",
+	}
+
+	for _, stmt := range vectorStatements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to create embeddings table: %v", err)
+			return fmt.Errorf("failed to create embeddings table: %w", err)
+		}
+	}
+
+	log.Println("Document tables ensured via manual SQL")
+	return nil
+}
+
+func ensureSearchTables(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS recent_items (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			item_type varchar(50) NOT NULL,
+			item_id uuid NOT NULL,
+			title varchar(255),
+			url varchar(500),
+			accessed_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS suggestions (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			type varchar(50) NOT NULL,
+			title varchar(255) NOT NULL,
+			description text,
+			action varchar(255),
+			confidence decimal(3,2),
+			is_read boolean DEFAULT false,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_recent_items_user_id ON recent_items(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_suggestions_user_id ON suggestions(user_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate search tables: %v", err)
+			return fmt.Errorf("failed to migrate search tables: %w", err)
+		}
+	}
+
+	log.Println("Search tables ensured via manual SQL")
+	return nil
+}
+
+func ensureChatSharesTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS chat_shares (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			chat_id uuid NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+			user_id uuid NOT NULL,
+			slug varchar(32) NOT NULL,
+			mode varchar(20) DEFAULT 'snapshot',
+			password_hash varchar(255),
+			snapshot text,
+			expires_at timestamptz,
+			view_count integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_shares_slug ON chat_shares(slug)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_shares_chat_id ON chat_shares(chat_id)",
+		"CREATE INDEX IF NOT EXISTS idx_chat_shares_user_id ON chat_shares(user_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate chat shares: %v", err)
+			return fmt.Errorf("failed to migrate chat shares: %w", err)
+		}
+	}
+
+	log.Println("Chat shares table ensured via manual SQL")
+	return nil
+}
+
+func ensureChatComparisonsTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS chat_comparisons (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			chat_id uuid NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+			user_id uuid NOT NULL,
+			prompt_message_id uuid NOT NULL,
+			models text NOT NULL,
+			winner_id uuid,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_chat_comparisons_chat_id ON chat_comparisons(chat_id)",
+		// Answers of a comparison and their stats
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS comparison_id uuid",
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS prompt_tokens integer DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS latency_ms integer DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN IF NOT EXISTS first_token_ms integer DEFAULT 0",
+		"CREATE INDEX IF NOT EXISTS idx_messages_comparison_id ON messages(comparison_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate chat comparisons: %v", err)
+			return fmt.Errorf("failed to migrate chat comparisons: %w", err)
+		}
+	}
+
+	log.Println("Chat comparisons table ensured via manual SQL")
+	return nil
+}
+
+// ensureModelCatalogTable creates the model catalog and seeds it with the
+// default models while it is empty, so admin edits and removals stick.
+func ensureModelCatalogTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS model_catalog (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			name varchar(50) NOT NULL,
+			display_name varchar(100),
+			provider varchar(50) NOT NULL,
+			context_window integer NOT NULL,
+			input_price decimal(10,4) DEFAULT 0,
+			output_price decimal(10,4) DEFAULT 0,
+			supports_vision boolean DEFAULT false,
+			supports_tools boolean DEFAULT false,
+			supports_json_schema boolean DEFAULT false,
+			min_tier varchar(20) DEFAULT 'basic',
+			fallbacks text,
+			enabled boolean DEFAULT true,
+			position integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			updated_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_model_catalog_name ON model_catalog(name)",
+		"ALTER TABLE model_catalog ADD COLUMN IF NOT EXISTS fallbacks text",
+		"ALTER TABLE model_catalog ADD COLUMN IF NOT EXISTS supports_json_schema boolean DEFAULT false",
+		`INSERT INTO model_catalog (name, display_name, provider, context_window, input_price, output_price, supports_vision, supports_tools, supports_json_schema, min_tier, fallbacks, position)
+		SELECT * FROM (VALUES
+			('gpt-4o-mini', 'GPT-4o mini', 'openai', 128000, 0.15, 0.60, true, true, true, 'basic', NULL, 10),
+			('gpt-4o', 'GPT-4o', 'openai', 128000, 2.50, 10.00, true, true, true, 'basic', 'gpt-4o-mini', 20),
+			('gpt-4.1-mini', 'GPT-4.1 mini', 'openai', 1047576, 0.40, 1.60, true, true, true, 'basic', 'gpt-4o-mini', 30),
+			('gpt-4.1', 'GPT-4.1', 'openai', 1047576, 2.00, 8.00, true, true, true, 'premium_starter', 'gpt-4o,gpt-4o-mini', 40),
+			('o3-mini', 'o3-mini', 'openai', 200000, 1.10, 4.40, false, true, true, 'premium_starter', 'gpt-4o-mini', 50),
+			('o1', 'o1', 'openai', 200000, 15.00, 60.00, true, true, true, 'premium_pro', 'gpt-4.1,gpt-4o', 60),
+			('claude-3-5-haiku-latest', 'Claude 3.5 Haiku', 'anthropic', 200000, 0.80, 4.00, false, true, false, 'basic', 'gpt-4o-mini', 70),
+			('claude-3-5-sonnet-latest', 'Claude 3.5 Sonnet', 'anthropic', 200000, 3.00, 15.00, true, true, false, 'premium_starter', 'gpt-4o', 80),
+			('claude-3-opus-latest', 'Claude 3 Opus', 'anthropic', 200000, 15.00, 75.00, true, true, false, 'premium_ultra', 'claude-3-5-sonnet-latest,gpt-4o', 90)
+		) AS defaults(name, display_name, provider, context_window, input_price, output_price, supports_vision, supports_tools, supports_json_schema, min_tier, fallbacks, position)
+		WHERE NOT EXISTS (SELECT 1 FROM model_catalog)`,
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate model catalog: %v", err)
+			return fmt.Errorf("failed to migrate model catalog: %w", err)
+		}
+	}
+
+	log.Println("Model catalog table ensured via manual SQL")
+	return nil
+}
+
+func ensureMessageAttachmentsTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS message_attachments (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id uuid REFERENCES messages(id) ON DELETE CASCADE,
+			file_name varchar(255),
+			content_type varchar(50) NOT NULL,
+			file_size bigint NOT NULL,
+			width integer NOT NULL,
+			height integer NOT NULL,
+			storage_key varchar(500) NOT NULL,
+			source_url text,
+			detail varchar(10) DEFAULT 'auto',
+			tokens integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_message_attachments_user_id ON message_attachments(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_message_attachments_message_id ON message_attachments(message_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate message attachments: %v", err)
+			return fmt.Errorf("failed to migrate message attachments: %w", err)
+		}
+	}
+
+	log.Println("Message attachments table ensured via manual SQL")
+	return nil
+}
+
+func ensureGeneratedImagesTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS generated_images (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			chat_id uuid REFERENCES chats(id) ON DELETE SET NULL,
+			source_image_id uuid REFERENCES generated_images(id) ON DELETE SET NULL,
+			kind varchar(20) NOT NULL,
+			prompt text,
+			revised_prompt text,
+			model varchar(50) NOT NULL,
+			size varchar(20),
+			quality varchar(20),
+			content_type varchar(50) NOT NULL,
+			file_size bigint NOT NULL,
+			storage_key varchar(500) NOT NULL,
+			cost decimal(10,4) DEFAULT 0,
+			tokens integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_generated_images_user_created ON generated_images(user_id, created_at DESC)",
+		"CREATE INDEX IF NOT EXISTS idx_generated_images_chat_id ON generated_images(chat_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate generated images: %v", err)
+			return fmt.Errorf("failed to migrate generated images: %w", err)
+		}
+	}
+
+	log.Println("Generated images table ensured via manual SQL")
+	return nil
+}
+
+func ensureUserMemoriesTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS user_memories (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			content text NOT NULL,
+			source varchar(20) NOT NULL,
+			chat_id uuid REFERENCES chats(id) ON DELETE SET NULL,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			updated_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_user_memories_user_created ON user_memories(user_id, created_at DESC)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate user memories: %v", err)
+			return fmt.Errorf("failed to migrate user memories: %w", err)
+		}
+	}
+
+	log.Println("User memories table ensured via manual SQL")
+	return nil
+}
+
+func ensureBatchJobTables(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS batch_jobs (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name varchar(255),
+			model varchar(50),
+			status varchar(20) NOT NULL DEFAULT 'pending',
+			total_lines integer DEFAULT 0,
+			completed_lines integer DEFAULT 0,
+			failed_lines integer DEFAULT 0,
+			tokens bigint DEFAULT 0,
+			error text,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			started_at timestamptz,
+			finished_at timestamptz
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_batch_jobs_user_created ON batch_jobs(user_id, created_at DESC)",
+		"CREATE INDEX IF NOT EXISTS idx_batch_jobs_status ON batch_jobs(status)",
+		`CREATE TABLE IF NOT EXISTS batch_job_lines (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			job_id uuid NOT NULL REFERENCES batch_jobs(id) ON DELETE CASCADE,
+			line_number integer NOT NULL,
+			custom_id varchar(255),
+			request text NOT NULL,
+			status varchar(20) NOT NULL DEFAULT 'pending',
+			response text,
+			error text,
+			tokens integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			finished_at timestamptz
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_batch_job_lines_job_line ON batch_job_lines(job_id, line_number)",
+		"CREATE INDEX IF NOT EXISTS idx_batch_job_lines_status ON batch_job_lines(status)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate batch jobs: %v", err)
+			return fmt.Errorf("failed to migrate batch jobs: %w", err)
+		}
+	}
+
+	log.Println("Batch job tables ensured via manual SQL")
+	return nil
+}
+
+func ensureScheduledPromptTables(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS scheduled_prompts (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			chat_id uuid NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+			name varchar(255),
+			prompt text NOT NULL,
+			system_prompt text,
+			cron varchar(100),
+			run_at timestamptz,
+			timezone varchar(64) DEFAULT 'UTC',
+			status varchar(20) NOT NULL DEFAULT 'active',
+			next_run_at timestamptz,
+			last_run_at timestamptz,
+			run_count integer DEFAULT 0,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+			updated_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_user_id ON scheduled_prompts(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_chat_id ON scheduled_prompts(chat_id)",
+		"CREATE INDEX IF NOT EXISTS idx_scheduled_prompts_due ON scheduled_prompts(next_run_at) WHERE status = 'active'",
+		`CREATE TABLE IF NOT EXISTS scheduled_prompt_runs (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			schedule_id uuid NOT NULL REFERENCES scheduled_prompts(id) ON DELETE CASCADE,
+			chat_id uuid NOT NULL,
+			status varchar(20) NOT NULL,
+			message_id uuid,
+			error text,
+			tokens integer DEFAULT 0,
+			started_at timestamptz NOT NULL,
+			finished_at timestamptz
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_scheduled_prompt_runs_schedule ON scheduled_prompt_runs(schedule_id, started_at DESC)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate scheduled prompts: %v", err)
+			return fmt.Errorf("failed to migrate scheduled prompts: %w", err)
+		}
+	}
+
+	log.Println("Scheduled prompt tables ensured via manual SQL")
+	return nil
+}
+
+func ensureChatTitleColumns(db *gorm.DB) error {
+	statements := []string{
+		"ALTER TABLE chats ADD COLUMN IF NOT EXISTS title_source varchar(10)",
+		"ALTER TABLE chats ADD COLUMN IF NOT EXISTS summary text",
+		// Titles of older chats were set by their users, so automatic
+		// titling leaves them alone
+		`UPDATE chats SET title_source = 'user'
+		WHERE title_source IS NULL AND title NOT IN ('New Chat', 'Imported Chat')`,
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate chat titles: %v", err)
+			return fmt.Errorf("failed to migrate chat titles: %w", err)
+		}
+	}
+
+	log.Println("Chat title columns ensured via manual SQL")
+	return nil
+}
+
+func ensureModerationEventsTable(db *gorm.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS moderation_events (
+			id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			source varchar(30) NOT NULL,
+			action varchar(10) NOT NULL,
+			provider varchar(30),
+			categories text,
+			scores text,
+			content text NOT NULL,
+			chat_id uuid,
+			conversation_id uuid,
+			message_id uuid,
+			status varchar(20) NOT NULL DEFAULT 'pending',
+			reviewed_by uuid,
+			reviewed_at timestamptz,
+			review_note text,
+			created_at timestamptz DEFAULT CURRENT_TIMESTAMP
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_moderation_events_status_created ON moderation_events(status, created_at)",
+		"CREATE INDEX IF NOT EXISTS idx_moderation_events_user_id ON moderation_events(user_id)",
+	}
+
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Printf("Failed to migrate moderation events: %v", err)
+			return fmt.Errorf("failed to migrate moderation events: %w", err)
+		}
+	}
+
+	log.Println("Moderation events table ensured via manual SQL")
+	return nil
+}
+
 func ensureMessengerTables(db *gorm.DB) error {
 	tasks := []func(*gorm.DB) error{
 		ensureConversationsTable,
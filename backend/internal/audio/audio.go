@@ -0,0 +1,198 @@
+// Package audio reads what a player needs to draw a voice message: its
+// duration and a coarse waveform. WAV is decoded natively; other formats
+// are converted with ffmpeg when it is installed.
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// ffmpegSampleRate is the rate other formats are resampled to; plenty for
+// a waveform and cheap to hold in memory.
+const ffmpegSampleRate = 8000
+
+// Summary describes a recording. Peaks holds one value per bar in [0, 1],
+// relative to the loudest bar.
+type Summary struct {
+	Duration float64 // seconds
+	Peaks    []float64
+}
+
+// Analyze returns the duration and a waveform of bars values for data.
+func Analyze(ctx context.Context, data []byte, bars int) (*Summary, error) {
+	if bars <= 0 {
+		return nil, fmt.Errorf("invalid number of bars %d", bars)
+	}
+	if isWAV(data) {
+		return analyzeWAV(data, bars)
+	}
+	return analyzeFFmpeg(ctx, data, bars)
+}
+
+func isWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// WAV sample formats
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+func analyzeWAV(data []byte, bars int) (*Summary, error) {
+	var (
+		format, channels, bits uint16
+		sampleRate             uint32
+		samples                []byte
+		haveFormat             bool
+	)
+
+	// Walk the chunks after the RIFF header; chunks are padded to even sizes
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		if size > len(body) {
+			// Recorders that stream WAV often leave the size unset
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, fmt.Errorf("wav: short fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			sampleRate = binary.LittleEndian.Uint32(body[4:8])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format == wavFormatExtensible && size >= 26 {
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+			haveFormat = true
+		case "data":
+			samples = body
+		}
+		pos += 8 + size + size%2
+	}
+
+	if !haveFormat || samples == nil {
+		return nil, fmt.Errorf("wav: missing fmt or data chunk")
+	}
+	if channels == 0 || sampleRate == 0 {
+		return nil, fmt.Errorf("wav: invalid format")
+	}
+
+	var sample func(b []byte) float64
+	switch {
+	case format == wavFormatPCM && bits == 8:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format == wavFormatPCM && bits == 16:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case format == wavFormatPCM && bits == 24:
+		sample = func(b []byte) float64 {
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(v) / (1 << 23)
+		}
+	case format == wavFormatPCM && bits == 32:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == wavFormatFloat && bits == 32:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, fmt.Errorf("wav: %w: format %d with %d-bit samples", ErrUnsupportedFormat, format, bits)
+	}
+
+	sampleSize := int(bits / 8)
+	frameSize := sampleSize * int(channels)
+	frames := len(samples) / frameSize
+
+	return &Summary{
+		Duration: float64(frames) / float64(sampleRate),
+		Peaks: peaks(frames, bars, func(frame int) float64 {
+			// Loudest channel of the frame
+			var v float64
+			for ch := 0; ch < int(channels); ch++ {
+				offset := frame*frameSize + ch*sampleSize
+				v = math.Max(v, math.Abs(sample(samples[offset:offset+sampleSize])))
+			}
+			return v
+		}),
+	}, nil
+}
+
+// analyzeFFmpeg decodes data to mono 16-bit PCM with ffmpeg (FFMPEG_PATH,
+// default ffmpeg on the PATH).
+func analyzeFFmpeg(ctx context.Context, data []byte, bars int) (*Summary, error) {
+	bin := os.Getenv("FFMPEG_PATH")
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path,
+		"-v", "error", "-i", "pipe:0",
+		"-f", "s16le", "-ac", "1", "-ar", fmt.Sprint(ffmpegSampleRate), "pipe:1")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	pcm := stdout.Bytes()
+	frames := len(pcm) / 2
+	if frames == 0 {
+		return nil, fmt.Errorf("ffmpeg: no audio decoded")
+	}
+
+	return &Summary{
+		Duration: float64(frames) / ffmpegSampleRate,
+		Peaks: peaks(frames, bars, func(frame int) float64 {
+			v := int16(binary.LittleEndian.Uint16(pcm[frame*2:]))
+			return math.Abs(float64(v) / 32768)
+		}),
+	}, nil
+}
+
+// peaks splits frames into bars buckets, takes the loudest frame of each
+// and scales the result so the loudest bar is 1.
+func peaks(frames, bars int, amplitude func(frame int) float64) []float64 {
+	out := make([]float64, bars)
+	if frames == 0 {
+		return out
+	}
+
+	for frame := 0; frame < frames; frame++ {
+		bar := frame * bars / frames
+		if v := amplitude(frame); v > out[bar] {
+			out[bar] = v
+		}
+	}
+
+	var loudest float64
+	for _, v := range out {
+		loudest = math.Max(loudest, v)
+	}
+	if loudest == 0 {
+		return out
+	}
+	for i, v := range out {
+		out[i] = math.Round(math.Min(v/loudest, 1)*100) / 100
+	}
+	return out
+}
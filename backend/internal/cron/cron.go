@@ -0,0 +1,268 @@
+// Package cron parses cron expressions and computes when they fire next.
+//
+// Expressions have the five standard fields: minute (0-59), hour (0-23),
+// day of month (1-31), month (1-12 or JAN-DEC) and day of week (0-7 or
+// SUN-SAT, 0 and 7 both being Sunday). Each field is "*", a value, a range
+// "a-b", a step "*/n" or "a-b/n", or a comma-separated list of those. The
+// macros @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly are accepted too.
+//
+// Like in Vixie cron, when both the day of month and the day of week are
+// restricted a day matching either fires, and daylight saving time changes
+// are handled as follows. Times skipped when clocks go forward fire once
+// clocks have moved; times repeated when clocks go back fire only the first
+// time. Schedules running every hour are not moved: they fire on each hour
+// that occurs, including the repeated one.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds how far ahead Next looks, so expressions that never fire,
+// such as "0 0 30 2 *", end.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// everyHour is the hour field of schedules running every hour.
+const everyHour = 1<<24 - 1
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are set for day fields starting with "*", which
+	// then do not widen the other day field
+	domAny, dowAny bool
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields, got %d", len(fields))
+	}
+
+	var s Schedule
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return &s, nil
+}
+
+// parse returns the bit set of the values a field matches.
+func (f field) parse(value string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field: %q", f.name, part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range in %s field: %q", f.name, part)
+			}
+		default:
+			n, err := f.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			// "5/15" means from 5 to the end in steps of 15
+			if step == 1 {
+				hi = n
+			}
+		}
+
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if n, ok := f.names[strings.ToUpper(s)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid %s: %q, must be %d-%d", f.name, s, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Next returns the first time after t the schedule fires, in t's location.
+// It returns the zero time if the schedule never fires.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+
+	for t.Before(limit) {
+		if s.hour != everyHour {
+			if s.firesInGap(t) {
+				return t
+			}
+			if end, ok := repeatedUntil(t); ok {
+				t = end
+				continue
+			}
+		}
+
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = date(t.Year(), t.Month()+1, 1, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = date(t.Year(), t.Month(), t.Day()+1, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = date(t.Year(), t.Month(), t.Day(), t.Hour()+1, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matches(t time.Time) bool {
+	return s.month&(1<<uint(t.Month())) != 0 &&
+		s.dayMatches(t) &&
+		s.hour&(1<<uint(t.Hour())) != 0 &&
+		s.minute&(1<<uint(t.Minute())) != 0
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// firesInGap reports whether t is when clocks went forward, skipping a time
+// the schedule fires at.
+func (s *Schedule) firesInGap(t time.Time) bool {
+	start, _ := t.ZoneBounds()
+	if !t.Equal(start) {
+		return false
+	}
+	_, before := start.Add(-time.Second).Zone()
+	_, after := t.Zone()
+	if after <= before {
+		return false
+	}
+
+	// The skipped wall clock times, as the clock would have shown them
+	wall := start.In(time.FixedZone("", before))
+	for end := wall.Add(time.Duration(after-before) * time.Second); wall.Before(end); wall = wall.Add(time.Minute) {
+		if s.matches(wall) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeatedUntil reports whether t's wall clock time already occurred before
+// clocks went back, and if so when the repeated times end.
+func repeatedUntil(t time.Time) (time.Time, bool) {
+	start, _ := t.ZoneBounds()
+	if start.IsZero() {
+		return time.Time{}, false
+	}
+	_, before := start.Add(-time.Second).Zone()
+	_, offset := t.Zone()
+	end := start.Add(time.Duration(before-offset) * time.Second)
+	return end, t.Before(end)
+}
+
+// date returns the start of an hour in loc. An hour skipped when clocks go
+// forward starts when they have moved; time.Date would move it back instead,
+// which would make Next go in circles.
+func date(year int, month time.Month, day, hour int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, loc)
+	if t.Hour() != time.Date(year, month, day, hour, 0, 0, 0, time.UTC).Hour() {
+		_, end := t.ZoneBounds()
+		return end
+	}
+	return t
+}
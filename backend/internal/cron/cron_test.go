@@ -0,0 +1,145 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * *", "needs 5 fields, got 4"},
+		{"@every 5m", "needs 5 fields, got 2"},
+		{"60 * * * *", "invalid minute"},
+		{"* 24 * * *", "invalid hour"},
+		{"* * 0 * *", "invalid day of month"},
+		{"* * * 13 *", "invalid month"},
+		{"* * * * 8", "invalid day of week"},
+		{"* * * FOO *", "invalid month"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"30-10 * * * *", "invalid range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Parse(%q) err = %v, want %q", tt.expr, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2026-10-18 is a Sunday
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string // empty when the schedule never fires
+	}{
+		// steps, ranges and lists
+		{"every minute", "* * * * *", "2026-10-18T10:07:30Z", "2026-10-18T10:08:00Z"},
+		{"step", "*/15 * * * *", "2026-10-18T10:07:00Z", "2026-10-18T10:15:00Z"},
+		{"step from a value", "5/20 * * * *", "2026-10-18T10:26:00Z", "2026-10-18T10:45:00Z"},
+		{"stepped range", "0 9-17/4 * * *", "2026-10-18T09:00:00Z", "2026-10-18T13:00:00Z"},
+		{"stepped range ends", "0 9-17/4 * * *", "2026-10-18T17:00:00Z", "2026-10-19T09:00:00Z"},
+		{"list", "0 0 1,15 * *", "2026-10-02T00:00:00Z", "2026-10-15T00:00:00Z"},
+		{"month names", "0 0 1 JAN-MAR *", "2026-10-18T00:00:00Z", "2027-01-01T00:00:00Z"},
+		{"day names", "0 8 * * mon-fri", "2026-10-17T09:00:00Z", "2026-10-19T08:00:00Z"},
+		{"sunday as 7", "0 0 * * 7", "2026-10-18T00:00:00Z", "2026-10-25T00:00:00Z"},
+		{"leap day", "0 0 29 2 *", "2026-10-18T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"never", "0 0 30 2 *", "2026-10-18T00:00:00Z", ""},
+
+		// day of month and day of week: either matches when both are
+		// restricted, both must when one starts with *
+		{"day of month or week, month day first", "0 0 13 * MON", "2026-11-10T00:00:00Z", "2026-11-13T00:00:00Z"},
+		{"day of month or week, weekday first", "0 0 13 * MON", "2026-11-13T00:00:00Z", "2026-11-16T00:00:00Z"},
+		{"day of week only", "0 0 * * MON", "2026-10-18T00:00:00Z", "2026-10-19T00:00:00Z"},
+		{"day of month only", "0 0 13 * *", "2026-10-18T00:00:00Z", "2026-11-13T00:00:00Z"},
+		{"stepped day of month and week", "0 0 */10 * MON", "2026-10-18T00:00:00Z", "2026-12-21T00:00:00Z"},
+
+		// macros
+		{"@hourly", "@hourly", "2026-10-18T10:30:00Z", "2026-10-18T11:00:00Z"},
+		{"@daily", "@daily", "2026-10-18T10:30:00Z", "2026-10-19T00:00:00Z"},
+		{"@midnight", "@MIDNIGHT", "2026-10-18T10:30:00Z", "2026-10-19T00:00:00Z"},
+		{"@weekly", "@weekly", "2026-10-18T00:00:00Z", "2026-10-25T00:00:00Z"},
+		{"@monthly", "@monthly", "2026-10-18T00:00:00Z", "2026-11-01T00:00:00Z"},
+		{"@yearly", "@yearly", "2026-10-18T00:00:00Z", "2027-01-01T00:00:00Z"},
+		{"@annually", " @annually ", "2026-10-18T00:00:00Z", "2027-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkNext(t, tt.expr, time.UTC, tt.from, tt.want)
+		})
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	// In New York clocks go from 2:00 EST to 3:00 EDT on 2026-03-08 and
+	// from 2:00 EDT back to 1:00 EST on 2026-11-01. In Santiago they go
+	// from midnight to 1:00 on 2026-09-06.
+	tests := []struct {
+		name string
+		zone string
+		expr string
+		from string
+		want string
+	}{
+		{"skipped time fires when clocks move", "America/New_York", "30 2 * * *", "2026-03-07T03:00:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"skipped time fires next day as usual", "America/New_York", "30 2 * * *", "2026-03-08T03:00:00-04:00", "2026-03-09T02:30:00-04:00"},
+		{"hour after the gap", "America/New_York", "0 3 * * *", "2026-03-08T00:00:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"hour before the gap", "America/New_York", "15 1 * * *", "2026-03-08T00:00:00-05:00", "2026-03-08T01:15:00-05:00"},
+		{"hourly skips the gap", "America/New_York", "30 * * * *", "2026-03-08T01:30:00-05:00", "2026-03-08T03:30:00-04:00"},
+		{"repeated time fires first", "America/New_York", "30 1 * * *", "2026-11-01T00:00:00-04:00", "2026-11-01T01:30:00-04:00"},
+		{"repeated time fires once", "America/New_York", "30 1 * * *", "2026-11-01T01:30:00-04:00", "2026-11-02T01:30:00-05:00"},
+		{"hourly fires in the repeated hour", "America/New_York", "0 * * * *", "2026-11-01T01:00:00-04:00", "2026-11-01T01:00:00-05:00"},
+		{"hour after the repeated one", "America/New_York", "0 2 * * *", "2026-11-01T00:00:00-04:00", "2026-11-01T02:00:00-05:00"},
+		{"skipped midnight", "America/Santiago", "0 0 * * *", "2026-09-05T01:00:00-04:00", "2026-09-06T01:00:00-03:00"},
+		{"day after skipped midnight", "America/Santiago", "0 12 * * *", "2026-09-05T13:00:00-04:00", "2026-09-06T12:00:00-03:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkNext(t, tt.expr, loc, tt.from, tt.want)
+		})
+	}
+}
+
+func checkNext(t *testing.T, expr string, loc *time.Location, from, want string) {
+	t.Helper()
+
+	schedule, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", expr, err)
+	}
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := schedule.Next(start.In(loc))
+	if want == "" {
+		if !got.IsZero() {
+			t.Fatalf("Next = %v, want never", got)
+		}
+		return
+	}
+	wantTime, err := time.Parse(time.RFC3339, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(wantTime) || got.Location() != loc {
+		t.Fatalf("%q: Next(%v) = %v, want %v", expr, start.In(loc), got, wantTime.In(loc))
+	}
+}
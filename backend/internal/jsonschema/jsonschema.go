@@ -0,0 +1,447 @@
+// Package jsonschema validates JSON documents against a JSON Schema and
+// reports every violation with its location, so a model can be told what
+// to fix.
+//
+// It covers the keywords structured output schemas are written with: type,
+// enum, const, properties, required, additionalProperties, min/maxProperties,
+// items, prefixItems, min/maxItems, uniqueItems, min/maxLength, pattern,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, allOf,
+// anyOf, oneOf, not and local $ref ("#", "#/$defs/..." and
+// "#/definitions/..."). Other keywords, such as format, are ignored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxErrors bounds the violations reported for one document.
+	maxErrors = 20
+
+	// maxDepth stops references that loop without consuming the document,
+	// such as {"$ref": "#"}.
+	maxDepth = 64
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// ValidationError is one violation of the schema.
+type ValidationError struct {
+	Path    string `json:"path"` // JSON pointer of the value, empty for the document itself
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return path + ": " + e.Message
+}
+
+// Compile parses a schema and checks its patterns and references.
+func Compile(raw []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("schema must be an object")
+	}
+
+	s := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compile(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile walks every subschema, compiling patterns and resolving
+// references.
+func (s *Schema) compile(node interface{}) error {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if _, err := s.resolve(ref); err != nil {
+			return err
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		s.patterns[pattern] = re
+	}
+
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		if children, ok := schema[key].(map[string]interface{}); ok {
+			for _, child := range children {
+				if err := s.compile(child); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf", "prefixItems", "items"} {
+		if children, ok := schema[key].([]interface{}); ok {
+			for _, child := range children {
+				if err := s.compile(child); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if err := s.compile(schema[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the subschema a local reference points to.
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported reference %q, only local references are allowed", ref)
+	}
+
+	node := s.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+		if node, ok = object[token]; !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+	}
+	return node, nil
+}
+
+// ValidateJSON validates a JSON document. A document that does not parse
+// is reported as a single violation.
+func (s *Schema) ValidateJSON(data []byte) []ValidationError {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []ValidationError{{Message: fmt.Sprintf("not valid JSON: %v", err)}}
+	}
+	return s.Validate(value)
+}
+
+// Validate validates a decoded JSON value, as produced by encoding/json
+// into an interface{}.
+func (s *Schema) Validate(value interface{}) []ValidationError {
+	v := &validator{schema: s}
+	v.validate(s.root, value, "")
+	return v.errs
+}
+
+type validator struct {
+	schema *Schema
+	errs   []ValidationError
+	depth  int
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	if len(v.errs) < maxErrors {
+		v.errs = append(v.errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// matches reports whether value is valid against node without recording
+// violations.
+func (v *validator) matches(node, value interface{}, path string) bool {
+	sub := &validator{schema: v.schema, depth: v.depth}
+	sub.validate(node, value, path)
+	return len(sub.errs) == 0
+}
+
+func (v *validator) validate(node, value interface{}, path string) {
+	if v.depth >= maxDepth {
+		v.fail(path, "schema nests too deeply")
+		return
+	}
+	v.depth++
+	defer func() { v.depth-- }()
+
+	if allowed, ok := node.(bool); ok {
+		if !allowed {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if target, err := v.schema.resolve(ref); err == nil {
+			v.validate(target, value, path)
+		}
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if hasType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "expected %s, got %s", strings.Join(types, " or "), typeOf(value))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options, _ := json.Marshal(enum)
+			v.fail(path, "must be one of %s", options)
+		}
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		expected, _ := json.Marshal(constant)
+		v.fail(path, "must be %s", expected)
+	}
+
+	switch value := value.(type) {
+	case string:
+		v.validateString(schema, value, path)
+	case float64:
+		v.validateNumber(schema, value, path)
+	case map[string]interface{}:
+		v.validateObject(schema, value, path)
+	case []interface{}:
+		v.validateArray(schema, value, path)
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			v.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.matches(sub, value, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "must match at least one of the anyOf schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, sub := range oneOf {
+			if v.matches(sub, value, path) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(path, "must match exactly one of the oneOf schemas, matches %d", count)
+		}
+	}
+	if not, ok := schema["not"]; ok && v.matches(not, value, path) {
+		v.fail(path, "must not match the schema in not")
+	}
+}
+
+func (v *validator) validateString(schema map[string]interface{}, value, path string) {
+	length := utf8.RuneCountInString(value)
+	if min, ok := number(schema["minLength"]); ok && float64(length) < min {
+		v.fail(path, "must be at least %v characters long", min)
+	}
+	if max, ok := number(schema["maxLength"]); ok && float64(length) > max {
+		v.fail(path, "must be at most %v characters long", max)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re := v.schema.patterns[pattern]; re != nil && !re.MatchString(value) {
+			v.fail(path, "must match the pattern %q", pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(schema map[string]interface{}, value float64, path string) {
+	if min, ok := number(schema["minimum"]); ok && value < min {
+		v.fail(path, "must be at least %v", min)
+	}
+	if max, ok := number(schema["maximum"]); ok && value > max {
+		v.fail(path, "must be at most %v", max)
+	}
+	if min, ok := number(schema["exclusiveMinimum"]); ok && value <= min {
+		v.fail(path, "must be greater than %v", min)
+	}
+	if max, ok := number(schema["exclusiveMaximum"]); ok && value >= max {
+		v.fail(path, "must be less than %v", max)
+	}
+	if step, ok := number(schema["multipleOf"]); ok && step > 0 {
+		if q := value / step; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(path, "must be a multiple of %v", step)
+		}
+	}
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, value map[string]interface{}, path string) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := value[name]; !present {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	if min, ok := number(schema["minProperties"]); ok && float64(len(value)) < min {
+		v.fail(path, "must have at least %v properties", min)
+	}
+	if max, ok := number(schema["maxProperties"]); ok && float64(len(value)) > max {
+		v.fail(path, "must have at most %v properties", max)
+	}
+
+	// Walk properties in order so reports are stable
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+	for _, name := range names {
+		childPath := path + "/" + escapePointer(name)
+		if property, ok := properties[name]; ok {
+			v.validate(property, value[name], childPath)
+			continue
+		}
+		if !hasAdditional {
+			continue
+		}
+		if allowed, ok := additional.(bool); ok && !allowed {
+			v.fail(childPath, "unexpected property %q", name)
+			continue
+		}
+		v.validate(additional, value[name], childPath)
+	}
+}
+
+func (v *validator) validateArray(schema map[string]interface{}, value []interface{}, path string) {
+	if min, ok := number(schema["minItems"]); ok && float64(len(value)) < min {
+		v.fail(path, "must have at least %v items", min)
+	}
+	if max, ok := number(schema["maxItems"]); ok && float64(len(value)) > max {
+		v.fail(path, "must have at most %v items", max)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := 1; i < len(value); i++ {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					v.fail(path, "items %d and %d are equal, items must be unique", j, i)
+				}
+			}
+		}
+	}
+
+	// prefixItems (or the older array form of items) validates by
+	// position, items then applies to the rest
+	prefix, _ := schema["prefixItems"].([]interface{})
+	items := schema["items"]
+	if tuple, ok := items.([]interface{}); ok {
+		prefix, items = tuple, schema["additionalItems"]
+	}
+	for i, item := range value {
+		childPath := path + "/" + strconv.Itoa(i)
+		if i < len(prefix) {
+			v.validate(prefix[i], item, childPath)
+		} else if items != nil {
+			v.validate(items, item, childPath)
+		}
+	}
+}
+
+func schemaTypes(node interface{}) []string {
+	switch t := node.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func hasType(value interface{}, name string) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return typeOf(value) == name
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func number(node interface{}) (float64, bool) {
+	n, ok := node.(float64)
+	return n, ok
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,188 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{"object", `{"type": "object"}`, ""},
+		{"true", `true`, ""},
+		{"not JSON", `{"type":`, "not valid JSON"},
+		{"not an object", `["string"]`, "must be an object"},
+		{"bad pattern", `{"properties": {"a": {"pattern": "("}}}`, "invalid pattern"},
+		{"local ref", `{"$defs": {"a": {"type": "string"}}, "items": {"$ref": "#/$defs/a"}}`, ""},
+		{"missing ref", `{"items": {"$ref": "#/$defs/missing"}}`, "unresolvable reference"},
+		{"remote ref", `{"$ref": "https://example.com/schema.json"}`, "only local references"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		doc    string
+		want   []string // violations as "path: message" prefixes, in order
+	}{
+		// type
+		{"type ok", `{"type": "string"}`, `"a"`, nil},
+		{"type mismatch", `{"type": "string"}`, `1`, []string{"(root): expected string, got number"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"integer", `{"type": "integer"}`, `2.0`, nil},
+		{"not integer", `{"type": "integer"}`, `2.5`, []string{"(root): expected integer, got number"}},
+		{"boolean schema false", `{"properties": {"a": false}}`, `{"a": 1}`, []string{"/a: no value is allowed here"}},
+
+		// enum and const
+		{"enum ok", `{"enum": ["a", 1, null]}`, `1`, nil},
+		{"enum mismatch", `{"enum": ["a", "b"]}`, `"c"`, []string{`(root): must be one of ["a","b"]`}},
+		{"const object", `{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{"const mismatch", `{"const": "x"}`, `"y"`, []string{`(root): must be "x"`}},
+
+		// strings
+		{"minLength counts characters", `{"minLength": 3}`, `"héé"`, nil},
+		{"minLength", `{"minLength": 3}`, `"ab"`, []string{"(root): must be at least 3 characters long"}},
+		{"maxLength", `{"maxLength": 2}`, `"abc"`, []string{"(root): must be at most 2 characters long"}},
+		{"pattern ok", `{"pattern": "^[a-z]+-\\d+$"}`, `"abc-12"`, nil},
+		{"pattern unanchored", `{"pattern": "\\d"}`, `"a1b"`, nil},
+		{"pattern mismatch", `{"pattern": "^[a-z]+$"}`, `"ab1"`, []string{`(root): must match the pattern "^[a-z]+$"`}},
+
+		// numbers
+		{"minimum", `{"minimum": 1}`, `0`, []string{"(root): must be at least 1"}},
+		{"maximum", `{"maximum": 1}`, `1`, nil},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1`, []string{"(root): must be greater than 1"}},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `1`, []string{"(root): must be less than 1"}},
+		{"multipleOf fraction", `{"multipleOf": 0.1}`, `0.3`, nil},
+		{"multipleOf", `{"multipleOf": 5}`, `12`, []string{"(root): must be a multiple of 5"}},
+
+		// objects
+		{
+			"required",
+			`{"required": ["a", "b"]}`,
+			`{"a": 1}`,
+			[]string{`(root): missing required property "b"`},
+		},
+		{
+			"properties",
+			`{"properties": {"a": {"type": "string"}, "b~/c": {"type": "number"}}}`,
+			`{"a": 1, "b~/c": "x"}`,
+			[]string{"/a: expected string, got number", "/b~0~1c: expected number, got string"},
+		},
+		{
+			"additionalProperties false",
+			`{"properties": {"a": {}}, "additionalProperties": false}`,
+			`{"a": 1, "b": 2}`,
+			[]string{`/b: unexpected property "b"`},
+		},
+		{
+			"additionalProperties schema",
+			`{"additionalProperties": {"type": "integer"}}`,
+			`{"a": 1, "b": "2"}`,
+			[]string{"/b: expected integer, got string"},
+		},
+		{"minProperties", `{"minProperties": 1}`, `{}`, []string{"(root): must have at least 1 properties"}},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{"(root): must have at most 1 properties"}},
+
+		// arrays
+		{"items", `{"items": {"type": "number"}}`, `[1, "2", 3]`, []string{"/1: expected number, got string"}},
+		{"minItems", `{"minItems": 2}`, `[1]`, []string{"(root): must have at least 2 items"}},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{"(root): must have at most 1 items"}},
+		{"uniqueItems", `{"uniqueItems": true}`, `[{"a": 1}, 2, {"a": 1}]`, []string{"(root): items 0 and 2 are equal"}},
+		{
+			"prefixItems then items",
+			`{"prefixItems": [{"type": "string"}, {"type": "number"}], "items": {"type": "boolean"}}`,
+			`["a", 1, true, 2]`,
+			[]string{"/3: expected boolean, got number"},
+		},
+		{
+			"tuple items then additionalItems",
+			`{"items": [{"type": "string"}], "additionalItems": false}`,
+			`["a", "b"]`,
+			[]string{"/1: no value is allowed here"},
+		},
+
+		// combinators
+		{"allOf", `{"allOf": [{"minimum": 1}, {"maximum": 5}]}`, `7`, []string{"(root): must be at most 5"}},
+		{"anyOf ok", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `1`, nil},
+		{"anyOf none", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `true`, []string{"(root): must match at least one of the anyOf schemas"}},
+		{"oneOf ok", `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, `1`, nil},
+		{"oneOf none", `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, `true`, []string{"(root): must match exactly one of the oneOf schemas, matches 0"}},
+		{"oneOf several", `{"oneOf": [{"type": "integer"}, {"type": "number"}]}`, `1`, []string{"(root): must match exactly one of the oneOf schemas, matches 2"}},
+		{"not", `{"not": {"type": "null"}}`, `null`, []string{"(root): must not match the schema in not"}},
+
+		// references
+		{
+			"$defs ref",
+			`{"$defs": {"name": {"type": "string", "minLength": 1}}, "properties": {"name": {"$ref": "#/$defs/name"}}}`,
+			`{"name": ""}`,
+			[]string{"/name: must be at least 1 characters long"},
+		},
+		{
+			"definitions ref",
+			`{"definitions": {"n": {"type": "number"}}, "items": {"$ref": "#/definitions/n"}}`,
+			`[1, "x"]`,
+			[]string{"/1: expected number, got string"},
+		},
+		{
+			"recursive ref",
+			`{"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#"}}, "name": {"type": "string"}}}`,
+			`{"children": [{"children": [{"name": 1}]}]}`,
+			[]string{"/children/0/children/0/name: expected string, got number"},
+		},
+		{"ref loop", `{"$ref": "#"}`, `1`, []string{"(root): schema nests too deeply"}},
+
+		// documents
+		{"not JSON", `{}`, `{"a":`, []string{"(root): not valid JSON"}},
+		{"unknown keywords ignored", `{"format": "email"}`, `"nope"`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+
+			errs := schema.ValidateJSON([]byte(tt.doc))
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %d violations %v, want %d %v", len(errs), errs, len(tt.want), tt.want)
+			}
+			for i, want := range tt.want {
+				if got := errs[i].Error(); !strings.HasPrefix(got, want) {
+					t.Errorf("violation %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateErrorLimit(t *testing.T) {
+	schema, err := Compile([]byte(`{"items": {"type": "string"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := "[" + strings.Repeat("1,", 30) + "1]"
+	if errs := schema.ValidateJSON([]byte(doc)); len(errs) != maxErrors {
+		t.Errorf("got %d violations, want %d", len(errs), maxErrors)
+	}
+}
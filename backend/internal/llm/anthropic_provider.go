@@ -0,0 +1,501 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com"
+	defaultAnthropicVersion   = "2023-06-01"
+	defaultAnthropicMaxTokens = 4096
+
+	// A stream may run as long as the model writes, so the client has no
+	// overall timeout; connecting and the first response byte are bounded,
+	// and requests without a streamed body get anthropicRequestTimeout.
+	anthropicDialTimeout    = 10 * time.Second
+	anthropicHeaderTimeout  = 2 * time.Minute
+	anthropicRequestTimeout = 5 * time.Minute
+)
+
+// AnthropicProvider adapts the Anthropic Messages API to the Provider interface.
+type AnthropicProvider struct {
+	apiKey     string
+	baseURL    string
+	version    string
+	httpClient *http.Client
+}
+
+func NewAnthropicProvider(cfg ProviderConfig) *AnthropicProvider {
+	baseURL := strings.TrimSuffix(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+	version := cfg.APIVersion
+	if version == "" {
+		version = defaultAnthropicVersion
+	}
+
+	return &AnthropicProvider{
+		apiKey:     cfg.APIKey,
+		baseURL:    baseURL,
+		version:    version,
+		httpClient: &http.Client{Transport: newAnthropicTransport()},
+	}
+}
+
+func newAnthropicTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   anthropicDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = anthropicDialTimeout
+	transport.ResponseHeaderTimeout = anthropicHeaderTimeout
+	return transport
+}
+
+func (p *AnthropicProvider) Name() string {
+	return "anthropic"
+}
+
+// anthropicMessage content is either a plain string or a list of blocks.
+type anthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	Source    *anthropicImage `json:"source,omitempty"`
+}
+
+// anthropicImage is the source of an image block: inline base64 data or a
+// URL Anthropic fetches itself.
+type anthropicImage struct {
+	Type      string `json:"type"` // base64, url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    interface{}        `json:"tool_choice,omitempty"`
+	Temperature   float32            `json:"temperature,omitempty"`
+	TopP          float32            `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Model      string           `json:"model"`
+	StopReason string           `json:"stop_reason"`
+	Content    []anthropicBlock `json:"content"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+type anthropicError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *AnthropicProvider) buildRequest(req openai.ChatCompletionRequest, stream bool) anthropicRequest {
+	out := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		Stream:        stream,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = defaultAnthropicMaxTokens
+	}
+
+	for _, tool := range req.Tools {
+		if tool.Function == nil {
+			continue
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+	if len(out.Tools) > 0 {
+		switch req.ToolChoice {
+		case "none":
+			out.ToolChoice = map[string]string{"type": "none"}
+		case "required":
+			out.ToolChoice = map[string]string{"type": "any"}
+		}
+	}
+
+	// Anthropic takes system prompts as a top-level field
+	var system []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleSystem:
+			system = append(system, msg.Content)
+		case openai.ChatMessageRoleAssistant:
+			if len(msg.ToolCalls) == 0 {
+				out.Messages = append(out.Messages, anthropicMessage{Role: "assistant", Content: msg.Content})
+				continue
+			}
+			var blocks []anthropicBlock
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+			out.Messages = append(out.Messages, anthropicMessage{Role: "assistant", Content: blocks})
+		case openai.ChatMessageRoleTool:
+			// Tool results go back as user turns; results of one round share a turn
+			block := anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}
+			if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == "user" {
+				if blocks, ok := out.Messages[n-1].Content.([]anthropicBlock); ok {
+					out.Messages[n-1].Content = append(blocks, block)
+					continue
+				}
+			}
+			out.Messages = append(out.Messages, anthropicMessage{Role: "user", Content: []anthropicBlock{block}})
+		default:
+			if len(msg.MultiContent) > 0 {
+				out.Messages = append(out.Messages, anthropicMessage{Role: "user", Content: anthropicParts(msg.MultiContent)})
+				continue
+			}
+			out.Messages = append(out.Messages, anthropicMessage{Role: "user", Content: msg.Content})
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	return out
+}
+
+// anthropicParts converts multi-part user content to text and image blocks.
+// Images given as data URLs are sent inline.
+func anthropicParts(parts []openai.ChatMessagePart) []anthropicBlock {
+	blocks := make([]anthropicBlock, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case openai.ChatMessagePartTypeText:
+			if part.Text != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+			}
+		case openai.ChatMessagePartTypeImageURL:
+			if part.ImageURL == nil {
+				continue
+			}
+			source := &anthropicImage{Type: "url", URL: part.ImageURL.URL}
+			if rest, ok := strings.CutPrefix(part.ImageURL.URL, "data:"); ok {
+				if mediaType, data, ok := strings.Cut(rest, ";base64,"); ok {
+					source = &anthropicImage{Type: "base64", MediaType: mediaType, Data: data}
+				}
+			}
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: source})
+		}
+	}
+	return blocks
+}
+
+func (p *AnthropicProvider) do(ctx context.Context, body anthropicRequest) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", p.version)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+
+		apiErr := &openai.APIError{
+			HTTPStatusCode: resp.StatusCode,
+			Message:        string(raw),
+		}
+		var parsed anthropicError
+		if json.Unmarshal(raw, &parsed) == nil && parsed.Error.Message != "" {
+			apiErr.Type = parsed.Error.Type
+			apiErr.Message = parsed.Error.Message
+		}
+		return nil, apiErr
+	}
+
+	return resp, nil
+}
+
+func (p *AnthropicProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, anthropicRequestTimeout)
+	defer cancel()
+
+	resp, err := p.do(ctx, p.buildRequest(req, false))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var result anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("failed to decode anthropic response: %w", err)
+	}
+
+	var text strings.Builder
+	var toolCalls []openai.ToolCall
+	for _, block := range result.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, openai.ToolCall{
+				ID:   block.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      block.Name,
+					Arguments: string(block.Input),
+				},
+			})
+		}
+	}
+
+	return openai.ChatCompletionResponse{
+		ID:      result.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   result.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Index: 0,
+			Message: openai.ChatCompletionMessage{
+				Role:      openai.ChatMessageRoleAssistant,
+				Content:   text.String(),
+				ToolCalls: toolCalls,
+			},
+			FinishReason: anthropicFinishReason(result.StopReason),
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     result.Usage.InputTokens,
+			CompletionTokens: result.Usage.OutputTokens,
+			TotalTokens:      result.Usage.InputTokens + result.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (p *AnthropicProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	resp, err := p.do(ctx, p.buildRequest(req, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return &anthropicStream{
+		body:         resp.Body,
+		reader:       bufio.NewReader(resp.Body),
+		model:        req.Model,
+		includeUsage: req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+		toolIndex:    make(map[int]int),
+	}, nil
+}
+
+func (p *AnthropicProvider) CreateImage(ctx context.Context, req openai.ImageRequest) (openai.ImageResponse, error) {
+	return openai.ImageResponse{}, ErrNotSupported
+}
+
+func (p *AnthropicProvider) CreateEditImage(ctx context.Context, req openai.ImageEditRequest) (openai.ImageResponse, error) {
+	return openai.ImageResponse{}, ErrNotSupported
+}
+
+func (p *AnthropicProvider) CreateVariImage(ctx context.Context, req openai.ImageVariRequest) (openai.ImageResponse, error) {
+	return openai.ImageResponse{}, ErrNotSupported
+}
+
+// anthropicStream converts Anthropic SSE events into OpenAI stream chunks.
+type anthropicStream struct {
+	body         io.ReadCloser
+	reader       *bufio.Reader
+	id           string
+	model        string
+	usage        anthropicUsage
+	includeUsage bool
+	toolIndex    map[int]int // content block index -> tool call index
+}
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		ID    string         `json:"id"`
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (s *anthropicStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			s.id = event.Message.ID
+			if event.Message.Model != "" {
+				s.model = event.Message.Model
+			}
+			s.usage.InputTokens = event.Message.Usage.InputTokens
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				index := len(s.toolIndex)
+				s.toolIndex[event.Index] = index
+				return s.toolChunk(openai.ToolCall{
+					Index:    &index,
+					ID:       event.ContentBlock.ID,
+					Type:     openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: event.ContentBlock.Name},
+				}), nil
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				return s.chunk(event.Delta.Text, ""), nil
+			case "input_json_delta":
+				index, ok := s.toolIndex[event.Index]
+				if ok && event.Delta.PartialJSON != "" {
+					return s.toolChunk(openai.ToolCall{
+						Index:    &index,
+						Function: openai.FunctionCall{Arguments: event.Delta.PartialJSON},
+					}), nil
+				}
+			}
+		case "message_delta":
+			s.usage.OutputTokens = event.Usage.OutputTokens
+			if event.Delta.StopReason != "" {
+				chunk := s.chunk("", anthropicFinishReason(event.Delta.StopReason))
+				if s.includeUsage {
+					chunk.Usage = &openai.Usage{
+						PromptTokens:     s.usage.InputTokens,
+						CompletionTokens: s.usage.OutputTokens,
+						TotalTokens:      s.usage.InputTokens + s.usage.OutputTokens,
+					}
+				}
+				return chunk, nil
+			}
+		case "message_stop":
+			return openai.ChatCompletionStreamResponse{}, io.EOF
+		case "error":
+			return openai.ChatCompletionStreamResponse{}, &openai.APIError{
+				Type:    event.Error.Type,
+				Message: event.Error.Message,
+			}
+		}
+	}
+}
+
+func (s *anthropicStream) chunk(text string, finish openai.FinishReason) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   s.model,
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Index:        0,
+			Delta:        openai.ChatCompletionStreamChoiceDelta{Content: text},
+			FinishReason: finish,
+		}},
+	}
+}
+
+func (s *anthropicStream) toolChunk(call openai.ToolCall) openai.ChatCompletionStreamResponse {
+	chunk := s.chunk("", "")
+	chunk.Choices[0].Delta.ToolCalls = []openai.ToolCall{call}
+	return chunk
+}
+
+func (s *anthropicStream) Close() error {
+	return s.body.Close()
+}
+
+func anthropicFinishReason(reason string) openai.FinishReason {
+	switch reason {
+	case "max_tokens":
+		return openai.FinishReasonLength
+	case "tool_use":
+		return openai.FinishReasonToolCalls
+	case "":
+		return openai.FinishReasonNull
+	default:
+		return openai.FinishReasonStop
+	}
+}
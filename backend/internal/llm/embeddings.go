@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxEmbeddingBatch is the number of inputs sent in one embeddings request.
+const maxEmbeddingBatch = 96
+
+// Embedder turns texts into vectors for similarity search. Vectors from
+// different models are not comparable, so callers store Model() with them.
+type Embedder interface {
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// OpenAIEmbedder calls the embeddings endpoint of OpenAI or a compatible
+// server.
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAIEmbedder(cfg ProviderConfig, model string) *OpenAIEmbedder {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if cfg.OrgID != "" {
+		clientConfig.OrgID = cfg.OrgID
+	}
+
+	return &OpenAIEmbedder{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (e *OpenAIEmbedder) Model() string {
+	return e.model
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingBatch {
+		end := start + maxEmbeddingBatch
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input: texts[start:end],
+			Model: openai.EmbeddingModel(e.model),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Data) != end-start {
+			return nil, fmt.Errorf("embeddings: got %d vectors for %d inputs", len(resp.Data), end-start)
+		}
+
+		batch := make([][]float32, end-start)
+		for _, item := range resp.Data {
+			if item.Index < 0 || item.Index >= len(batch) {
+				return nil, fmt.Errorf("embeddings: unexpected index %d", item.Index)
+			}
+			batch[item.Index] = item.Embedding
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// NewEmbedderFromEnv returns the embedder configured by the environment, or
+// nil when embeddings are unavailable:
+//
+//	EMBEDDING_PROVIDER                     - openai (default) or fake for the local deterministic embedder
+//	EMBEDDING_MODEL                        - embedding model (default: text-embedding-3-small)
+//	EMBEDDING_API_KEY, EMBEDDING_BASE_URL  - endpoint (default: the OpenAI settings)
+func NewEmbedderFromEnv() Embedder {
+	if os.Getenv("EMBEDDING_PROVIDER") == "fake" {
+		return NewFakeEmbedder()
+	}
+
+	model := os.Getenv("EMBEDDING_MODEL")
+	if model == "" {
+		model = string(openai.SmallEmbedding3)
+	}
+
+	apiKey := os.Getenv("EMBEDDING_API_KEY")
+	baseURL := os.Getenv("EMBEDDING_BASE_URL")
+	if apiKey == "" && baseURL == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+		baseURL = os.Getenv("OPENAI_BASE_URL")
+	}
+	if apiKey == "" && baseURL == "" {
+		log.Println("Warning: no embedding provider configured - document and semantic search are disabled")
+		return nil
+	}
+
+	return NewOpenAIEmbedder(ProviderConfig{
+		APIKey:  apiKey,
+		BaseURL: baseURL,
+		OrgID:   os.Getenv("OPENAI_ORG_ID"),
+	}, model)
+}
@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// fakeEmbeddingDimensions is the size of vectors made by FakeEmbedder.
+const fakeEmbeddingDimensions = 256
+
+// FakeEmbedder makes deterministic embeddings locally by hashing words and
+// word pairs into a fixed-size vector. Texts sharing words get similar
+// vectors, which is enough to exercise search without an embeddings API.
+type FakeEmbedder struct{}
+
+func NewFakeEmbedder() *FakeEmbedder {
+	return &FakeEmbedder{}
+}
+
+func (e *FakeEmbedder) Model() string {
+	return "fake-embedding"
+}
+
+func (e *FakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vectors[i] = fakeEmbedding(text)
+	}
+	return vectors, nil
+}
+
+func fakeEmbedding(text string) []float32 {
+	vector := make([]float32, fakeEmbeddingDimensions)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	add := func(feature string, weight float32) {
+		h := fnv.New32a()
+		h.Write([]byte(feature))
+		sum := h.Sum32()
+		// The top bit picks the sign so unrelated features cancel out
+		if sum&(1<<31) != 0 {
+			weight = -weight
+		}
+		vector[sum%fakeEmbeddingDimensions] += weight
+	}
+	for i, word := range words {
+		add(word, 1)
+		if i > 0 {
+			add(words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		vector[0] = 1 // pgvector cannot compare zero vectors by cosine
+		return vector
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+	return vector
+}
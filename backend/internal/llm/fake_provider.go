@@ -0,0 +1,309 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// FakeProvider is an in-process, deterministic provider for tests and local
+// development. Unless replies are queued with Enqueue or EnqueueToolCalls, it
+// answers with an echo of the last user message. Every request is recorded.
+type FakeProvider struct {
+	mu       sync.Mutex
+	replies  []fakeReply
+	requests []openai.ChatCompletionRequest
+	err      error
+}
+
+type fakeReply struct {
+	content   string
+	toolCalls []openai.ToolCall
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// Enqueue schedules replies to be returned, in order, by the next requests.
+func (p *FakeProvider) Enqueue(replies ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, reply := range replies {
+		p.replies = append(p.replies, fakeReply{content: reply})
+	}
+}
+
+// EnqueueToolCalls schedules a reply that asks for the given tool calls.
+func (p *FakeProvider) EnqueueToolCalls(calls ...openai.ToolCall) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replies = append(p.replies, fakeReply{toolCalls: calls})
+}
+
+// FailWith makes every following request return err until reset with nil.
+func (p *FakeProvider) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// Requests returns a copy of all chat requests received so far.
+func (p *FakeProvider) Requests() []openai.ChatCompletionRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]openai.ChatCompletionRequest, len(p.requests))
+	copy(out, p.requests)
+	return out
+}
+
+func (p *FakeProvider) nextReply(req openai.ChatCompletionRequest) (fakeReply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return fakeReply{}, p.err
+	}
+
+	if len(p.replies) > 0 {
+		reply := p.replies[0]
+		p.replies = p.replies[1:]
+		return reply, nil
+	}
+
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == openai.ChatMessageRoleUser {
+			return fakeReply{content: "Echo: " + req.Messages[i].Content}, nil
+		}
+	}
+	return fakeReply{content: "Echo:"}, nil
+}
+
+func (r fakeReply) finishReason() openai.FinishReason {
+	if len(r.toolCalls) > 0 {
+		return openai.FinishReasonToolCalls
+	}
+	return openai.FinishReasonStop
+}
+
+func (p *FakeProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	reply, err := p.nextReply(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	promptTokens := fakeTokenCount(req.Messages)
+	completionTokens := len(strings.Fields(reply.content))
+
+	return openai.ChatCompletionResponse{
+		ID:      "fake-" + fakeHash(reply.content),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Index: 0,
+			Message: openai.ChatCompletionMessage{
+				Role:      openai.ChatMessageRoleAssistant,
+				Content:   reply.content,
+				ToolCalls: reply.toolCalls,
+			},
+			FinishReason: reply.finishReason(),
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}, nil
+}
+
+func (p *FakeProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	reply, err := p.nextReply(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split on spaces but keep them, so concatenated deltas equal the reply
+	var parts []string
+	for _, word := range strings.SplitAfter(reply.content, " ") {
+		if word != "" {
+			parts = append(parts, word)
+		}
+	}
+
+	stream := &fakeStream{
+		ctx:       ctx,
+		id:        "fake-" + fakeHash(reply.content),
+		model:     req.Model,
+		parts:     parts,
+		toolCalls: reply.toolCalls,
+		finish:    reply.finishReason(),
+	}
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		promptTokens := fakeTokenCount(req.Messages)
+		completionTokens := len(strings.Fields(reply.content))
+		stream.usage = &openai.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
+	}
+	return stream, nil
+}
+
+func (p *FakeProvider) CreateImage(ctx context.Context, req openai.ImageRequest) (openai.ImageResponse, error) {
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		return openai.ImageResponse{}, err
+	}
+
+	return fakeImageResponse(req.Prompt+req.Size, req.Prompt, req.N, req.ResponseFormat), nil
+}
+
+func (p *FakeProvider) CreateEditImage(ctx context.Context, req openai.ImageEditRequest) (openai.ImageResponse, error) {
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		return openai.ImageResponse{}, err
+	}
+
+	return fakeImageResponse("edit"+req.Prompt+req.Size, req.Prompt, req.N, req.ResponseFormat), nil
+}
+
+func (p *FakeProvider) CreateVariImage(ctx context.Context, req openai.ImageVariRequest) (openai.ImageResponse, error) {
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		return openai.ImageResponse{}, err
+	}
+
+	return fakeImageResponse("variation"+req.Size, "", req.N, req.ResponseFormat), nil
+}
+
+// fakeImageResponse returns n images derived from seed: URLs, or small
+// solid PNGs when base64 data is requested.
+func fakeImageResponse(seed, prompt string, n int, format string) openai.ImageResponse {
+	if n < 1 {
+		n = 1
+	}
+
+	resp := openai.ImageResponse{Created: time.Now().Unix()}
+	for i := 0; i < n; i++ {
+		hash := fakeHash(fmt.Sprintf("%s#%d", seed, i))
+		data := openai.ImageResponseDataInner{RevisedPrompt: prompt}
+		if format == openai.CreateImageResponseFormatB64JSON {
+			data.B64JSON = fakePNG(hash)
+		} else {
+			data.URL = fmt.Sprintf("https://fake.local/images/%s.png", hash)
+		}
+		resp.Data = append(resp.Data, data)
+	}
+	return resp
+}
+
+// fakePNG returns a base64 64x64 PNG coloured after the hex hash.
+func fakePNG(hash string) string {
+	sum, _ := hex.DecodeString(hash)
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: sum[0], G: sum[1], B: sum[2], A: 255}}, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+type fakeStream struct {
+	ctx       context.Context
+	id        string
+	model     string
+	parts     []string
+	toolCalls []openai.ToolCall
+	pos       int
+	finish    openai.FinishReason
+	usage     *openai.Usage
+}
+
+func (s *fakeStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if err := s.ctx.Err(); err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+	// Content first, then one chunk per tool call
+	if s.pos >= len(s.parts) && s.pos < len(s.parts)+len(s.toolCalls) {
+		index := s.pos - len(s.parts)
+		call := s.toolCalls[index]
+		call.Index = &index
+		s.pos++
+		return s.chunk(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{call}}), nil
+	}
+	if s.pos >= len(s.parts) {
+		// Like OpenAI, report usage on a final chunk without choices
+		if s.usage != nil {
+			usage := s.usage
+			s.usage = nil
+			return openai.ChatCompletionStreamResponse{ID: s.id, Model: s.model, Usage: usage}, nil
+		}
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+
+	part := s.parts[s.pos]
+	s.pos++
+	return s.chunk(openai.ChatCompletionStreamChoiceDelta{Content: part}), nil
+}
+
+func (s *fakeStream) chunk(delta openai.ChatCompletionStreamChoiceDelta) openai.ChatCompletionStreamResponse {
+	finish := openai.FinishReasonNull
+	if s.pos == len(s.parts)+len(s.toolCalls) {
+		finish = s.finish
+	}
+
+	return openai.ChatCompletionStreamResponse{
+		ID:      s.id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   s.model,
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Index:        0,
+			Delta:        delta,
+			FinishReason: finish,
+		}},
+	}
+}
+
+func (s *fakeStream) Close() error {
+	return nil
+}
+
+func fakeTokenCount(messages []openai.ChatCompletionMessage) int {
+	count := 0
+	for _, msg := range messages {
+		count += len(strings.Fields(msg.Content))
+	}
+	return count
+}
+
+func fakeHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,202 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ModerationResult is how a moderator classified a text. Categories lists
+// the categories the text was flagged for; Scores holds the confidence per
+// category, 0 to 1, when the provider reports it.
+type ModerationResult struct {
+	Flagged    bool
+	Categories []string
+	Scores     map[string]float64
+}
+
+// Moderator classifies texts as acceptable or not.
+type Moderator interface {
+	Name() string
+	Moderate(ctx context.Context, text string) (*ModerationResult, error)
+}
+
+// OpenAIModerator calls the moderation endpoint of OpenAI or a compatible
+// server.
+type OpenAIModerator struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAIModerator(cfg ProviderConfig, model string) *OpenAIModerator {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if cfg.OrgID != "" {
+		clientConfig.OrgID = cfg.OrgID
+	}
+
+	return &OpenAIModerator{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (m *OpenAIModerator) Name() string {
+	return "openai"
+}
+
+func (m *OpenAIModerator) Moderate(ctx context.Context, text string) (*ModerationResult, error) {
+	resp, err := m.client.Moderations(ctx, openai.ModerationRequest{
+		Input: text,
+		Model: m.model,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Results) == 0 {
+		return nil, errors.New("moderation returned no results")
+	}
+	result := resp.Results[0]
+
+	// The categories are struct fields named by their JSON tags
+	var categories map[string]bool
+	var scores map[string]float64
+	raw, _ := json.Marshal(result.Categories)
+	json.Unmarshal(raw, &categories)
+	raw, _ = json.Marshal(result.CategoryScores)
+	json.Unmarshal(raw, &scores)
+
+	moderation := &ModerationResult{Flagged: result.Flagged, Scores: scores}
+	for category, flagged := range categories {
+		if flagged {
+			moderation.Categories = append(moderation.Categories, category)
+		}
+	}
+	sort.Strings(moderation.Categories)
+	return moderation, nil
+}
+
+// KeywordModerator flags texts matching regular expressions, by category.
+// It runs locally and stands in for a moderation service in development
+// and on deployments without one.
+type KeywordModerator struct {
+	rules map[string][]*regexp.Regexp
+}
+
+// defaultModerationRules are used when no rules file is configured.
+var defaultModerationRules = map[string][]string{
+	"self-harm": {
+		`(?i)\b(kill|hurt|harm) (myself|yourself)\b`,
+		`(?i)\bsuicide (method|note)s?\b`,
+	},
+	"violence": {
+		`(?i)\b(make|build|assemble) (a |an )?(pipe )?(bomb|explosive)s?\b`,
+	},
+	"illicit": {
+		`(?i)\b(synthesi[sz]e|cook) (meth|methamphetamine|fentanyl)\b`,
+	},
+}
+
+// NewKeywordModerator compiles rules, a map from category to patterns.
+func NewKeywordModerator(rules map[string][]string) (*KeywordModerator, error) {
+	m := &KeywordModerator{rules: make(map[string][]*regexp.Regexp)}
+	for category, patterns := range rules {
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %w", category, pattern, err)
+			}
+			m.rules[category] = append(m.rules[category], re)
+		}
+	}
+	return m, nil
+}
+
+func (m *KeywordModerator) Name() string {
+	return "keyword"
+}
+
+func (m *KeywordModerator) Moderate(ctx context.Context, text string) (*ModerationResult, error) {
+	result := &ModerationResult{Scores: make(map[string]float64)}
+	for category, patterns := range m.rules {
+		for _, re := range patterns {
+			if re.MatchString(text) {
+				result.Categories = append(result.Categories, category)
+				result.Scores[category] = 1
+				break
+			}
+		}
+	}
+	sort.Strings(result.Categories)
+	result.Flagged = len(result.Categories) > 0
+	return result, nil
+}
+
+// NewModeratorFromEnv returns the moderation provider configured by the
+// environment, or nil when moderation is off:
+//
+//	MODERATION_PROVIDER                    - keyword or openai; unset turns moderation off
+//	MODERATION_MODEL                       - moderation model for openai (default: omni-moderation-latest)
+//	MODERATION_API_KEY, MODERATION_BASE_URL - endpoint (default: the OpenAI settings)
+//	MODERATION_RULES_FILE                  - JSON object of category to regular expressions
+//	                                         for keyword (default: a small built-in list)
+func NewModeratorFromEnv() Moderator {
+	switch provider := os.Getenv("MODERATION_PROVIDER"); provider {
+	case "":
+		return nil
+	case "keyword":
+		rules := defaultModerationRules
+		if path := os.Getenv("MODERATION_RULES_FILE"); path != "" {
+			rules = nil
+			data, err := os.ReadFile(path)
+			if err == nil {
+				err = json.Unmarshal(data, &rules)
+			}
+			if err != nil {
+				log.Printf("Warning: failed to load MODERATION_RULES_FILE: %v - moderation is disabled", err)
+				return nil
+			}
+		}
+		moderator, err := NewKeywordModerator(rules)
+		if err != nil {
+			log.Printf("Warning: %v - moderation is disabled", err)
+			return nil
+		}
+		return moderator
+	case "openai":
+	default:
+		log.Printf("Warning: unknown MODERATION_PROVIDER %q - moderation is disabled", provider)
+		return nil
+	}
+
+	model := os.Getenv("MODERATION_MODEL")
+	if model == "" {
+		model = openai.ModerationOmniLatest
+	}
+
+	apiKey := os.Getenv("MODERATION_API_KEY")
+	baseURL := os.Getenv("MODERATION_BASE_URL")
+	if apiKey == "" && baseURL == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+		baseURL = os.Getenv("OPENAI_BASE_URL")
+	}
+	if apiKey == "" && baseURL == "" {
+		log.Println("Warning: MODERATION_PROVIDER=openai needs an API key - moderation is disabled")
+		return nil
+	}
+
+	return NewOpenAIModerator(ProviderConfig{
+		APIKey:  apiKey,
+		BaseURL: baseURL,
+		OrgID:   os.Getenv("OPENAI_ORG_ID"),
+	}, model)
+}
@@ -0,0 +1,57 @@
+package llm
+
+import (
+	"context"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OpenAIProvider talks to the OpenAI API or any server that speaks the same
+// protocol (Ollama, vLLM, LM Studio) when BaseURL is overridden.
+type OpenAIProvider struct {
+	name   string
+	client *openai.Client
+}
+
+func NewOpenAIProvider(name string, cfg ProviderConfig) *OpenAIProvider {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if cfg.OrgID != "" {
+		clientConfig.OrgID = cfg.OrgID
+	}
+
+	return &OpenAIProvider{
+		name:   name,
+		client: openai.NewClientWithConfig(clientConfig),
+	}
+}
+
+func (p *OpenAIProvider) Name() string {
+	return p.name
+}
+
+func (p *OpenAIProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletion(ctx, req)
+}
+
+func (p *OpenAIProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (p *OpenAIProvider) CreateImage(ctx context.Context, req openai.ImageRequest) (openai.ImageResponse, error) {
+	return p.client.CreateImage(ctx, req)
+}
+
+func (p *OpenAIProvider) CreateEditImage(ctx context.Context, req openai.ImageEditRequest) (openai.ImageResponse, error) {
+	return p.client.CreateEditImage(ctx, req)
+}
+
+func (p *OpenAIProvider) CreateVariImage(ctx context.Context, req openai.ImageVariRequest) (openai.ImageResponse, error) {
+	return p.client.CreateVariImage(ctx, req)
+}
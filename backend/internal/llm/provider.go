@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"context"
+	"errors"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrNotSupported is returned by providers for operations their backend
+// does not offer (e.g. image generation on Anthropic).
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+// Provider is a chat model backend. Requests and responses use the OpenAI
+// wire types as the common format; adapters for other vendors translate
+// to and from them.
+type Provider interface {
+	Name() string
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error)
+	CreateImage(ctx context.Context, req openai.ImageRequest) (openai.ImageResponse, error)
+	CreateEditImage(ctx context.Context, req openai.ImageEditRequest) (openai.ImageResponse, error)
+	CreateVariImage(ctx context.Context, req openai.ImageVariRequest) (openai.ImageResponse, error)
+}
+
+// ChatStream yields streamed completion chunks until Recv returns io.EOF.
+// When the request sets StreamOptions.IncludeUsage, providers that can report
+// usage do so on a chunk's Usage field.
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProviderConfig holds connection settings for a single provider.
+type ProviderConfig struct {
+	APIKey     string
+	BaseURL    string
+	OrgID      string
+	APIVersion string
+}
+
+// Registry routes a chat model name to the provider that serves it.
+//
+// Routes are matched by longest prefix. A route ending in "/" is a namespace
+// and is stripped before the model name is sent upstream, so "ollama/llama3"
+// reaches the Ollama provider as "llama3", while "claude-3-5-sonnet" is sent
+// to Anthropic unchanged.
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+	routes    map[string]string
+	fallback  string
+
+	retry         RetryPolicy
+	breakerConfig BreakerConfig
+	breakers      map[string]*CircuitBreaker
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providers:     make(map[string]Provider),
+		routes:        make(map[string]string),
+		retry:         DefaultRetryPolicy,
+		breakerConfig: DefaultBreakerConfig,
+		breakers:      make(map[string]*CircuitBreaker),
+	}
+}
+
+// Register adds a provider and routes the given model prefixes to it.
+func (r *Registry) Register(p Provider, prefixes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.providers[p.Name()] = p
+	for _, prefix := range prefixes {
+		r.routes[prefix] = p.Name()
+	}
+	if r.fallback == "" {
+		r.fallback = p.Name()
+	}
+}
+
+// SetFallback selects the provider used for models matching no prefix.
+func (r *Registry) SetFallback(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = name
+}
+
+// Provider returns a registered provider by name.
+func (r *Registry) Provider(name string) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Resolve returns the provider for a chat model and the model name to send
+// to that provider.
+func (r *Registry) Resolve(model string) (Provider, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	prefixes := make([]string, 0, len(r.routes))
+	for prefix := range r.routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(model, prefix) {
+			continue
+		}
+		upstream := model
+		if strings.HasSuffix(prefix, "/") {
+			upstream = strings.TrimPrefix(model, prefix)
+		}
+		return r.providers[r.routes[prefix]], upstream, nil
+	}
+
+	if p, ok := r.providers[r.fallback]; ok {
+		return p, model, nil
+	}
+	return nil, "", fmt.Errorf("no provider configured for model %q", model)
+}
+
+// NewRegistryFromEnv builds a registry from environment variables:
+//
+//	OPENAI_API_KEY, OPENAI_BASE_URL, OPENAI_ORG_ID      - OpenAI (gpt-*, o1*, o3*, dall-e*, openai/)
+//	ANTHROPIC_API_KEY, ANTHROPIC_BASE_URL, ANTHROPIC_VERSION - Anthropic (claude-*, anthropic/)
+//	OLLAMA_BASE_URL, OLLAMA_API_KEY                     - Ollama OpenAI-compatible endpoint (ollama/)
+//	VLLM_BASE_URL, VLLM_API_KEY                         - vLLM OpenAI-compatible endpoint (vllm/)
+//	LLM_FAKE_PROVIDER=true                              - deterministic fake provider (fake/)
+//	LLM_DEFAULT_PROVIDER                                - provider for unmatched models (default: openai)
+//
+// Retries and circuit breakers are configured as described in
+// resilienceFromEnv.
+func NewRegistryFromEnv() *Registry {
+	registry := NewRegistry()
+	retry, breaker := resilienceFromEnv()
+	registry.SetRetryPolicy(retry)
+	registry.SetBreakerConfig(breaker)
+
+	openaiKey := os.Getenv("OPENAI_API_KEY")
+	if openaiKey == "" {
+		log.Println("Warning: OPENAI_API_KEY not set - chat functionality will be limited")
+		openaiKey = "sk-dummy-key-for-development" // Allow server to start without real API key
+	}
+	registry.Register(NewOpenAIProvider("openai", ProviderConfig{
+		APIKey:  openaiKey,
+		BaseURL: os.Getenv("OPENAI_BASE_URL"),
+		OrgID:   os.Getenv("OPENAI_ORG_ID"),
+	}), "gpt-", "o1", "o3", "o4", "chatgpt-", "dall-e", "openai/")
+
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		registry.Register(NewAnthropicProvider(ProviderConfig{
+			APIKey:     key,
+			BaseURL:    os.Getenv("ANTHROPIC_BASE_URL"),
+			APIVersion: os.Getenv("ANTHROPIC_VERSION"),
+		}), "claude-", "anthropic/")
+	}
+
+	if baseURL := os.Getenv("OLLAMA_BASE_URL"); baseURL != "" {
+		registry.Register(NewOpenAIProvider("ollama", ProviderConfig{
+			APIKey:  os.Getenv("OLLAMA_API_KEY"),
+			BaseURL: baseURL,
+		}), "ollama/")
+	}
+
+	if baseURL := os.Getenv("VLLM_BASE_URL"); baseURL != "" {
+		registry.Register(NewOpenAIProvider("vllm", ProviderConfig{
+			APIKey:  os.Getenv("VLLM_API_KEY"),
+			BaseURL: baseURL,
+		}), "vllm/")
+	}
+
+	if os.Getenv("LLM_FAKE_PROVIDER") == "true" {
+		registry.Register(NewFakeProvider(), "fake/")
+	}
+
+	if name := os.Getenv("LLM_DEFAULT_PROVIDER"); name != "" {
+		if _, ok := registry.Provider(name); ok {
+			registry.SetFallback(name)
+		} else {
+			log.Printf("Warning: LLM_DEFAULT_PROVIDER %q is not configured, using openai", name)
+		}
+	}
+
+	return registry
+}
@@ -0,0 +1,338 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrCircuitOpen is returned for a provider that failed repeatedly and is
+// not being called until its cooldown has passed.
+var ErrCircuitOpen = errors.New("provider temporarily unavailable")
+
+// RetryPolicy retries rate-limited and failed upstream requests with
+// exponential backoff and jitter.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts per model, including the first
+	BaseDelay   time.Duration // delay before the first retry, doubled for each one after
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    8 * time.Second,
+}
+
+// backoff returns the wait before retry n (1-based), with up to 20% jitter.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	delay := p.BaseDelay << (n - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// IsRetryable reports whether a request error is transient: rate limits,
+// server errors, timeouts and dropped connections.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if status, ok := statusCode(err); ok {
+		return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func statusCode(err error) (int, bool) {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0 {
+		return apiErr.HTTPStatusCode, true
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0 {
+		return reqErr.HTTPStatusCode, true
+	}
+	return 0, false
+}
+
+// BreakerConfig controls when a provider's circuit opens.
+type BreakerConfig struct {
+	FailureThreshold int           // consecutive failures that open the circuit
+	Cooldown         time.Duration // how long an open circuit rejects calls
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+}
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitBreaker stops calls to a provider after repeated failures. Once
+// the cooldown has passed a single trial call is let through; its outcome
+// closes the circuit or opens it again.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{config: config, state: BreakerClosed}
+}
+
+// Allow reports whether a call may be made now.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.config.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == BreakerHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Abandon ends a call whose outcome says nothing about the provider, e.g.
+// one cancelled by the caller, so a pending trial can be made again.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// State returns the breaker state for monitoring.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.config.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// call runs fn for each model of the chain in turn until one succeeds.
+// Transient errors are retried per the retry policy and counted by the
+// provider's circuit breaker; other errors end the chain, as another model
+// would most likely reject the request too. It returns the model that
+// answered.
+func (r *Registry) call(ctx context.Context, models []string, fn func(p Provider, upstream string) error) (string, error) {
+	if len(models) == 0 {
+		return "", errors.New("no model given")
+	}
+
+	r.mu.RLock()
+	retry := r.retry
+	r.mu.RUnlock()
+
+	var lastErr error
+	for i, model := range models {
+		provider, upstream, err := r.Resolve(model)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		breaker := r.breaker(provider.Name())
+		if !breaker.Allow() {
+			lastErr = fmt.Errorf("%s: %w", provider.Name(), ErrCircuitOpen)
+			continue
+		}
+
+		for attempt := 1; ; attempt++ {
+			err = fn(provider, upstream)
+			if err == nil {
+				breaker.Success()
+				if i > 0 {
+					log.Printf("LLM: %s failed, answered by fallback %s", models[0], model)
+				}
+				return model, nil
+			}
+			if !IsRetryable(err) {
+				// The provider answered (or the caller gave up), so this
+				// does not count as a failure
+				if ctx.Err() != nil {
+					breaker.Abandon()
+				} else {
+					breaker.Success()
+				}
+				return "", err
+			}
+
+			lastErr = err
+			if attempt >= retry.MaxAttempts {
+				breaker.Failure()
+				break
+			}
+
+			select {
+			case <-time.After(retry.backoff(attempt)):
+			case <-ctx.Done():
+				breaker.Abandon()
+				return "", ctx.Err()
+			}
+		}
+	}
+	return "", lastErr
+}
+
+// CreateChatCompletion sends req to the first model of the chain that
+// answers, retrying transient errors. req.Model is replaced by each model's
+// upstream name. It returns the model that answered.
+func (r *Registry) CreateChatCompletion(ctx context.Context, models []string, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, string, error) {
+	var resp openai.ChatCompletionResponse
+	used, err := r.call(ctx, models, func(p Provider, upstream string) error {
+		req.Model = upstream
+		var err error
+		resp, err = p.CreateChatCompletion(ctx, req)
+		return err
+	})
+	return resp, used, err
+}
+
+// CreateChatCompletionStream opens a stream on the first model of the chain
+// that answers. Only opening the stream is retried; errors while receiving
+// are returned by the stream.
+func (r *Registry) CreateChatCompletionStream(ctx context.Context, models []string, req openai.ChatCompletionRequest) (ChatStream, string, error) {
+	var stream ChatStream
+	used, err := r.call(ctx, models, func(p Provider, upstream string) error {
+		req.Model = upstream
+		var err error
+		stream, err = p.CreateChatCompletionStream(ctx, req)
+		return err
+	})
+	return stream, used, err
+}
+
+func (r *Registry) breaker(provider string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[provider]
+	if !ok {
+		b = NewCircuitBreaker(r.breakerConfig)
+		r.breakers[provider] = b
+	}
+	return b
+}
+
+// BreakerStates returns the circuit state of every provider called so far.
+func (r *Registry) BreakerStates() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	states := make(map[string]string, len(r.breakers))
+	for name, b := range r.breakers {
+		states[name] = b.State()
+	}
+	return states
+}
+
+// SetRetryPolicy replaces the retry policy.
+func (r *Registry) SetRetryPolicy(policy RetryPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	r.retry = policy
+}
+
+// SetBreakerConfig replaces the circuit breaker settings of providers not
+// called yet.
+func (r *Registry) SetBreakerConfig(config BreakerConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakerConfig = config
+}
+
+// resilienceFromEnv reads the retry and circuit breaker settings:
+//
+//	LLM_RETRY_MAX_ATTEMPTS   - attempts per model (default: 3)
+//	LLM_RETRY_BASE_DELAY     - first backoff delay (default: 500ms)
+//	LLM_RETRY_MAX_DELAY      - backoff cap (default: 8s)
+//	LLM_BREAKER_THRESHOLD    - consecutive failures opening a provider's circuit (default: 5)
+//	LLM_BREAKER_COOLDOWN     - time an open circuit rejects calls (default: 30s)
+func resilienceFromEnv() (RetryPolicy, BreakerConfig) {
+	retry := DefaultRetryPolicy
+	breaker := DefaultBreakerConfig
+
+	envInt("LLM_RETRY_MAX_ATTEMPTS", &retry.MaxAttempts)
+	envDuration("LLM_RETRY_BASE_DELAY", &retry.BaseDelay)
+	envDuration("LLM_RETRY_MAX_DELAY", &retry.MaxDelay)
+	envInt("LLM_BREAKER_THRESHOLD", &breaker.FailureThreshold)
+	envDuration("LLM_BREAKER_COOLDOWN", &breaker.Cooldown)
+
+	return retry, breaker
+}
+
+func envInt(key string, target *int) {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			*target = n
+		} else {
+			log.Printf("Warning: invalid %s %q, using %d", key, value, *target)
+		}
+	}
+}
+
+func envDuration(key string, target *time.Duration) {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			*target = d
+		} else {
+			log.Printf("Warning: invalid %s %q, using %s", key, value, *target)
+		}
+	}
+}
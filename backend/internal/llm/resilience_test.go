@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// namedFake is a FakeProvider registered under another name, so a registry
+// can hold two of them with separate circuit breakers.
+type namedFake struct {
+	*FakeProvider
+	name string
+}
+
+func (p namedFake) Name() string {
+	return p.name
+}
+
+var (
+	errUnavailable = &openai.APIError{HTTPStatusCode: 503, Message: "overloaded"}
+	errBadRequest  = &openai.APIError{HTTPStatusCode: 400, Message: "invalid request"}
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    2 * time.Millisecond,
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", &openai.APIError{HTTPStatusCode: 429}, true},
+		{"request timeout", &openai.APIError{HTTPStatusCode: 408}, true},
+		{"server error", &openai.APIError{HTTPStatusCode: 500}, true},
+		{"unavailable request error", &openai.RequestError{HTTPStatusCode: 503}, true},
+		{"bad request", errBadRequest, false},
+		{"unauthorized", &openai.RequestError{HTTPStatusCode: 401}, false},
+		{"wrapped server error", errors.Join(errors.New("call failed"), errUnavailable), true},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"cancelled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"other", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		retry int
+		want  time.Duration // before jitter
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{40, time.Second}, // the shift overflows
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			got := policy.backoff(tt.retry)
+			if got > tt.want || got < tt.want-tt.want/5 {
+				t.Fatalf("backoff(%d) = %v, want within 20%% below %v", tt.retry, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cooldown := 20 * time.Millisecond
+	b := NewCircuitBreaker(BreakerConfig{FailureThreshold: 2, Cooldown: cooldown})
+
+	expect := func(step, state string, allow bool) {
+		t.Helper()
+		if got := b.State(); got != state {
+			t.Fatalf("%s: state = %s, want %s", step, got, state)
+		}
+		if got := b.Allow(); got != allow {
+			t.Fatalf("%s: Allow() = %v, want %v", step, got, allow)
+		}
+	}
+
+	expect("new", BreakerClosed, true)
+	b.Failure()
+	expect("one failure", BreakerClosed, true)
+	b.Success()
+	b.Failure()
+	expect("success resets the count", BreakerClosed, true)
+	b.Failure()
+	expect("threshold reached", BreakerOpen, false)
+
+	time.Sleep(cooldown)
+	expect("cooldown passed", BreakerHalfOpen, true)
+	if b.Allow() {
+		t.Fatal("half-open breaker allowed a second trial")
+	}
+	b.Abandon()
+	expect("abandoned trial", BreakerHalfOpen, true)
+	b.Failure()
+	expect("failed trial", BreakerOpen, false)
+
+	time.Sleep(cooldown)
+	expect("second cooldown passed", BreakerHalfOpen, true)
+	b.Success()
+	expect("successful trial", BreakerClosed, true)
+}
+
+func TestRegistryRetryAndFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		primaryErr   error
+		secondaryErr error
+		models       []string
+		wantModel    string
+		wantErr      error
+		wantPrimary  int // requests received by each provider
+		wantSecond   int
+		wantBreakers map[string]string
+	}{
+		{
+			name:         "answered first time",
+			models:       []string{"primary/a", "secondary/b"},
+			wantModel:    "primary/a",
+			wantPrimary:  1,
+			wantBreakers: map[string]string{"primary": BreakerClosed},
+		},
+		{
+			name:         "retryable error retried",
+			primaryErr:   errUnavailable,
+			models:       []string{"primary/a"},
+			wantErr:      errUnavailable,
+			wantPrimary:  3,
+			wantBreakers: map[string]string{"primary": BreakerClosed},
+		},
+		{
+			name:         "falls back after retries",
+			primaryErr:   errUnavailable,
+			models:       []string{"primary/a", "secondary/b"},
+			wantModel:    "secondary/b",
+			wantPrimary:  3,
+			wantSecond:   1,
+			wantBreakers: map[string]string{"primary": BreakerClosed, "secondary": BreakerClosed},
+		},
+		{
+			name:        "fallback order kept",
+			primaryErr:  errUnavailable,
+			models:      []string{"secondary/b", "primary/a"},
+			wantModel:   "secondary/b",
+			wantPrimary: 0,
+			wantSecond:  1,
+		},
+		{
+			name:         "non-retryable error ends the chain",
+			primaryErr:   errBadRequest,
+			models:       []string{"primary/a", "secondary/b"},
+			wantErr:      errBadRequest,
+			wantPrimary:  1,
+			wantBreakers: map[string]string{"primary": BreakerClosed},
+		},
+		{
+			name:         "every model failing returns the last error",
+			primaryErr:   errUnavailable,
+			secondaryErr: &openai.APIError{HTTPStatusCode: 429},
+			models:       []string{"primary/a", "secondary/b"},
+			wantErr:      &openai.APIError{HTTPStatusCode: 429},
+			wantPrimary:  3,
+			wantSecond:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := namedFake{NewFakeProvider(), "primary"}
+			secondary := namedFake{NewFakeProvider(), "secondary"}
+			primary.FailWith(tt.primaryErr)
+			secondary.FailWith(tt.secondaryErr)
+
+			registry := NewRegistry()
+			registry.Register(primary, "primary/")
+			registry.Register(secondary, "secondary/")
+			registry.SetRetryPolicy(testRetryPolicy)
+
+			req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "hello"},
+			}}
+			resp, used, err := registry.CreateChatCompletion(context.Background(), tt.models, req)
+
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if used != tt.wantModel {
+					t.Errorf("used model = %s, want %s", used, tt.wantModel)
+				}
+				if got := resp.Choices[0].Message.Content; got != "Echo: hello" {
+					t.Errorf("reply = %q", got)
+				}
+			}
+
+			if got := len(primary.Requests()); got != tt.wantPrimary {
+				t.Errorf("primary got %d requests, want %d", got, tt.wantPrimary)
+			}
+			if got := len(secondary.Requests()); got != tt.wantSecond {
+				t.Errorf("secondary got %d requests, want %d", got, tt.wantSecond)
+			}
+			for _, r := range primary.Requests() {
+				if r.Model != "a" {
+					t.Errorf("primary was sent model %q, want the upstream name", r.Model)
+				}
+			}
+
+			states := registry.BreakerStates()
+			for provider, want := range tt.wantBreakers {
+				if states[provider] != want {
+					t.Errorf("breaker %s = %s, want %s", provider, states[provider], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryOpenCircuitSkipsProvider(t *testing.T) {
+	primary := namedFake{NewFakeProvider(), "primary"}
+	secondary := namedFake{NewFakeProvider(), "secondary"}
+
+	registry := NewRegistry()
+	registry.Register(primary, "primary/")
+	registry.Register(secondary, "secondary/")
+	registry.SetRetryPolicy(RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	registry.SetBreakerConfig(BreakerConfig{FailureThreshold: 2, Cooldown: time.Hour})
+
+	ctx := context.Background()
+	models := []string{"primary/a", "secondary/b"}
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "hello"},
+	}}
+
+	primary.FailWith(errUnavailable)
+	for i := 0; i < 2; i++ {
+		if _, used, err := registry.CreateChatCompletion(ctx, models, req); err != nil || used != "secondary/b" {
+			t.Fatalf("call %d: used %q, err %v; want the fallback", i+1, used, err)
+		}
+	}
+	if got := registry.BreakerStates()["primary"]; got != BreakerOpen {
+		t.Fatalf("primary breaker = %s after 2 failures, want open", got)
+	}
+
+	// The open circuit is skipped without a request, even once the
+	// provider has recovered
+	primary.FailWith(nil)
+	if _, used, err := registry.CreateChatCompletion(ctx, models, req); err != nil || used != "secondary/b" {
+		t.Fatalf("used %q, err %v; want the fallback", used, err)
+	}
+	if got := len(primary.Requests()); got != 2 {
+		t.Errorf("primary got %d requests, want 2", got)
+	}
+
+	_, _, err := registry.CreateChatCompletion(ctx, models[:1], req)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v, want ErrCircuitOpen", err)
+	}
+}
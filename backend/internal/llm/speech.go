@@ -0,0 +1,224 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// maxSpeechInput is the most characters the OpenAI speech endpoint takes
+// in one request; longer texts are synthesized in parts.
+const maxSpeechInput = 4096
+
+// Synthesizer turns text into speech. Audio from every call has the same
+// ContentType so it can be cached and served without inspection.
+type Synthesizer interface {
+	Name() string
+	ContentType() string
+	Voices() []string // the first is the default
+	Synthesize(ctx context.Context, text, voice string) ([]byte, error)
+}
+
+// OpenAISynthesizer calls the speech endpoint of OpenAI or a compatible
+// server, producing MP3.
+type OpenAISynthesizer struct {
+	client *openai.Client
+	model  string
+	voices []string
+}
+
+func NewOpenAISynthesizer(cfg ProviderConfig, model string, voices []string) *OpenAISynthesizer {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if cfg.OrgID != "" {
+		clientConfig.OrgID = cfg.OrgID
+	}
+
+	if len(voices) == 0 {
+		voices = []string{
+			string(openai.VoiceAlloy), string(openai.VoiceAsh), string(openai.VoiceCoral),
+			string(openai.VoiceEcho), string(openai.VoiceFable), string(openai.VoiceNova),
+			string(openai.VoiceOnyx), string(openai.VoiceShimmer),
+		}
+	}
+
+	return &OpenAISynthesizer{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+		voices: voices,
+	}
+}
+
+func (s *OpenAISynthesizer) Name() string {
+	return "openai"
+}
+
+func (s *OpenAISynthesizer) ContentType() string {
+	return "audio/mpeg"
+}
+
+func (s *OpenAISynthesizer) Voices() []string {
+	return s.voices
+}
+
+// Synthesize speaks text in parts of at most maxSpeechInput characters.
+// MP3 streams can be joined by concatenation.
+func (s *OpenAISynthesizer) Synthesize(ctx context.Context, text, voice string) ([]byte, error) {
+	var out bytes.Buffer
+	for _, part := range splitSpeechText(text, maxSpeechInput) {
+		resp, err := s.client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+			Model:          openai.SpeechModel(s.model),
+			Input:          part,
+			Voice:          openai.SpeechVoice(voice),
+			ResponseFormat: openai.SpeechResponseFormatMp3,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(&out, resp)
+		resp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// splitSpeechText cuts text into parts of at most max characters, at the
+// last paragraph, sentence or word break that fits.
+func splitSpeechText(text string, max int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > max {
+		runes := []rune(text)
+		cut := max
+		for _, breaks := range []string{"\n", ".!?", " "} {
+			if i := lastBreak(runes[:max], breaks); i > max/2 {
+				cut = i + 1
+				break
+			}
+		}
+		parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+		text = strings.TrimSpace(string(runes[cut:]))
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// lastBreak returns the index of the last rune of breaks that is followed
+// by a space, or -1.
+func lastBreak(runes []rune, breaks string) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if strings.ContainsRune(breaks, runes[i]) && (unicode.IsSpace(runes[i]) || i+1 == len(runes) || unicode.IsSpace(runes[i+1])) {
+			return i
+		}
+	}
+	return -1
+}
+
+// FakeSynthesizer returns silent WAV audio whose length follows the text,
+// for tests and local development.
+type FakeSynthesizer struct{}
+
+func NewFakeSynthesizer() *FakeSynthesizer {
+	return &FakeSynthesizer{}
+}
+
+func (s *FakeSynthesizer) Name() string {
+	return "fake"
+}
+
+func (s *FakeSynthesizer) ContentType() string {
+	return "audio/wav"
+}
+
+func (s *FakeSynthesizer) Voices() []string {
+	return []string{"fake"}
+}
+
+func (s *FakeSynthesizer) Synthesize(ctx context.Context, text, voice string) ([]byte, error) {
+	const sampleRate = 8000
+
+	// About 50ms per character, at most a minute
+	duration := time.Duration(utf8.RuneCountInString(text)) * 50 * time.Millisecond
+	if duration > time.Minute {
+		duration = time.Minute
+	}
+	samples := int(duration.Seconds() * sampleRate)
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(36+samples))
+	out.WriteString("WAVEfmt ")
+	binary.Write(&out, binary.LittleEndian, uint32(16))
+	binary.Write(&out, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&out, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&out, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&out, binary.LittleEndian, uint32(sampleRate)) // bytes per second
+	binary.Write(&out, binary.LittleEndian, uint16(1))          // block align
+	binary.Write(&out, binary.LittleEndian, uint16(8))          // bits per sample
+	out.WriteString("data")
+	binary.Write(&out, binary.LittleEndian, uint32(samples))
+	out.Write(bytes.Repeat([]byte{128}, samples)) // 8-bit silence
+	return out.Bytes(), nil
+}
+
+// NewSynthesizerFromEnv returns the text-to-speech provider configured by
+// the environment, or nil when speech is unavailable:
+//
+//	TTS_PROVIDER               - openai (default) or fake
+//	TTS_MODEL                  - speech model (default: tts-1)
+//	TTS_VOICES                 - comma-separated voices offered, the first is the default
+//	                             (default: the OpenAI voices)
+//	TTS_API_KEY, TTS_BASE_URL  - endpoint (default: the OpenAI settings)
+func NewSynthesizerFromEnv() Synthesizer {
+	switch provider := os.Getenv("TTS_PROVIDER"); provider {
+	case "fake":
+		return NewFakeSynthesizer()
+	case "", "openai":
+	default:
+		log.Printf("Warning: unknown TTS_PROVIDER %q - text-to-speech is disabled", provider)
+		return nil
+	}
+
+	model := os.Getenv("TTS_MODEL")
+	if model == "" {
+		model = string(openai.TTSModel1)
+	}
+
+	var voices []string
+	for _, voice := range strings.Split(os.Getenv("TTS_VOICES"), ",") {
+		if voice = strings.TrimSpace(voice); voice != "" {
+			voices = append(voices, voice)
+		}
+	}
+
+	apiKey := os.Getenv("TTS_API_KEY")
+	baseURL := os.Getenv("TTS_BASE_URL")
+	if apiKey == "" && baseURL == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+		baseURL = os.Getenv("OPENAI_BASE_URL")
+	}
+	if apiKey == "" && baseURL == "" {
+		log.Println("Warning: no text-to-speech provider configured - speech playback is disabled")
+		return nil
+	}
+
+	return NewOpenAISynthesizer(ProviderConfig{
+		APIKey:  apiKey,
+		BaseURL: baseURL,
+		OrgID:   os.Getenv("OPENAI_ORG_ID"),
+	}, model, voices)
+}
@@ -0,0 +1,227 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Transcript is the text of a recording with what the provider detected
+// about it. Language and Duration (seconds) are zero when not reported.
+type Transcript struct {
+	Text     string
+	Language string
+	Duration float64
+}
+
+// Transcriber turns recorded speech into text. language is an ISO-639-1
+// hint and may be empty to let the provider detect it.
+type Transcriber interface {
+	Name() string
+	Transcribe(ctx context.Context, audio io.Reader, fileName, language string) (*Transcript, error)
+}
+
+// OpenAITranscriber calls the Whisper transcription endpoint of OpenAI or a
+// compatible server.
+type OpenAITranscriber struct {
+	client *openai.Client
+	model  string
+}
+
+func NewOpenAITranscriber(cfg ProviderConfig, model string) *OpenAITranscriber {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+	if cfg.OrgID != "" {
+		clientConfig.OrgID = cfg.OrgID
+	}
+
+	return &OpenAITranscriber{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  model,
+	}
+}
+
+func (t *OpenAITranscriber) Name() string {
+	return "openai"
+}
+
+func (t *OpenAITranscriber) Transcribe(ctx context.Context, audio io.Reader, fileName, language string) (*Transcript, error) {
+	resp, err := t.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    t.model,
+		FilePath: fileName,
+		Reader:   audio,
+		Language: language,
+		Format:   openai.AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transcript{
+		Text:     strings.TrimSpace(resp.Text),
+		Language: resp.Language,
+		Duration: resp.Duration,
+	}, nil
+}
+
+// WhisperCppTranscriber calls the /inference endpoint of a local
+// whisper.cpp server. Start the server with --convert to accept formats
+// other than 16 kHz WAV.
+type WhisperCppTranscriber struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewWhisperCppTranscriber(baseURL string) *WhisperCppTranscriber {
+	return &WhisperCppTranscriber{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (t *WhisperCppTranscriber) Name() string {
+	return "whispercpp"
+}
+
+func (t *WhisperCppTranscriber) Transcribe(ctx context.Context, audio io.Reader, fileName, language string) (*Transcript, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return nil, err
+	}
+	if language == "" {
+		language = "auto"
+	}
+	form.WriteField("language", language)
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("temperature", "0")
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/inference", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("whisper.cpp: status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		Text     string  `json:"text"`
+		Language string  `json:"language"`
+		Duration float64 `json:"duration"`
+		Error    string  `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("whisper.cpp: invalid response: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("whisper.cpp: %s", result.Error)
+	}
+
+	return &Transcript{
+		Text:     strings.TrimSpace(result.Text),
+		Language: result.Language,
+		Duration: result.Duration,
+	}, nil
+}
+
+// FakeTranscriber answers with a fixed text derived from the recording's
+// size, for tests and local development.
+type FakeTranscriber struct{}
+
+func NewFakeTranscriber() *FakeTranscriber {
+	return &FakeTranscriber{}
+}
+
+func (t *FakeTranscriber) Name() string {
+	return "fake"
+}
+
+func (t *FakeTranscriber) Transcribe(ctx context.Context, audio io.Reader, fileName, language string) (*Transcript, error) {
+	n, err := io.Copy(io.Discard, audio)
+	if err != nil {
+		return nil, err
+	}
+	if language == "" {
+		language = "en"
+	}
+	return &Transcript{
+		Text:     fmt.Sprintf("Transcript of %s (%d bytes)", fileName, n),
+		Language: language,
+	}, nil
+}
+
+// NewTranscriberFromEnv returns the speech-to-text provider configured by
+// the environment, or nil when transcription is unavailable:
+//
+//	STT_PROVIDER               - openai (default), whispercpp or fake
+//	STT_MODEL                  - transcription model for openai (default: whisper-1)
+//	STT_API_KEY, STT_BASE_URL  - endpoint (default: the OpenAI settings); for
+//	                             whispercpp the server URL, e.g. http://localhost:8178
+func NewTranscriberFromEnv() Transcriber {
+	baseURL := os.Getenv("STT_BASE_URL")
+
+	switch provider := os.Getenv("STT_PROVIDER"); provider {
+	case "fake":
+		return NewFakeTranscriber()
+	case "whispercpp":
+		if baseURL == "" {
+			log.Println("Warning: STT_PROVIDER=whispercpp needs STT_BASE_URL - voice transcription is disabled")
+			return nil
+		}
+		return NewWhisperCppTranscriber(baseURL)
+	case "", "openai":
+	default:
+		log.Printf("Warning: unknown STT_PROVIDER %q - voice transcription is disabled", provider)
+		return nil
+	}
+
+	model := os.Getenv("STT_MODEL")
+	if model == "" {
+		model = openai.Whisper1
+	}
+
+	apiKey := os.Getenv("STT_API_KEY")
+	if apiKey == "" && baseURL == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+		baseURL = os.Getenv("OPENAI_BASE_URL")
+	}
+	if apiKey == "" && baseURL == "" {
+		log.Println("Warning: no speech-to-text provider configured - voice transcription is disabled")
+		return nil
+	}
+
+	return NewOpenAITranscriber(ProviderConfig{
+		APIKey:  apiKey,
+		BaseURL: baseURL,
+		OrgID:   os.Getenv("OPENAI_ORG_ID"),
+	}, model)
+}
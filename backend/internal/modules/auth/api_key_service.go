@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// apiKeyPrefix marks the keys so they are easy to spot in code and logs
+	apiKeyPrefix = "sk-kin-"
+
+	maxAPIKeysPerUser = 20
+
+	// apiKeyTouchInterval limits how often LastUsedAt is written
+	apiKeyTouchInterval = time.Minute
+)
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// CreateAPIKey issues a new key. The returned key is not stored and cannot
+// be shown again.
+func (s *Service) CreateAPIKey(userID uuid.UUID, req *CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, errors.New("name must be between 1 and 100 characters")
+	}
+	if req.ExpiresInDays < 0 {
+		return nil, errors.New("expires_in_days must not be negative")
+	}
+
+	count, err := s.repo.CountUserAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, errors.New("API key limit reached, delete an unused key first")
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(secret)
+
+	apiKey := APIKey{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  key[:len(apiKeyPrefix)+6],
+		KeyHash: hashAPIKey(key),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := s.repo.CreateAPIKey(&apiKey); err != nil {
+		return nil, err
+	}
+
+	return &CreateAPIKeyResponse{APIKey: apiKey, Key: key}, nil
+}
+
+func (s *Service) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	return s.repo.GetUserAPIKeys(userID)
+}
+
+func (s *Service) DeleteAPIKey(userID, keyID uuid.UUID) error {
+	return s.repo.DeleteAPIKey(keyID, userID)
+}
+
+// ValidateAPIKey returns the key record for a key that exists, has not
+// expired and belongs to an active user.
+func (s *Service) ValidateAPIKey(key string) (*APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.repo.GetAPIKeyByHash(hashAPIKey(key))
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		s.repo.TouchAPIKey(apiKey.ID, now)
+		apiKey.LastUsedAt = &now
+	}
+	return apiKey, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -257,3 +257,62 @@ func (h *Handler) GetUsageStats(c *fiber.Ctx) error {
 		"stats": stats,
 	})
 }
+
+// API keys
+
+func (h *Handler) GetAPIKeys(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	keys, err := h.service.GetAPIKeys(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get API keys",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_keys": keys,
+	})
+}
+
+// CreateAPIKey issues a key for the /v1 API. The key is only returned by
+// this request.
+func (h *Handler) CreateAPIKey(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	key, err := h.service.CreateAPIKey(userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(key)
+}
+
+func (h *Handler) DeleteAPIKey(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	keyID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid API key ID",
+		})
+	}
+
+	if err := h.service.DeleteAPIKey(userID, keyID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "API key deleted successfully",
+	})
+}
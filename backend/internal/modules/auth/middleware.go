@@ -54,3 +54,36 @@ func (m *Middleware) Protected() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// APIKey authenticates requests by a personal API key sent as
+// "Authorization: Bearer sk-kin-...". Errors use the OpenAI error format,
+// since the clients of these routes speak that protocol.
+func (m *Middleware) APIKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer "))
+		if key == "" {
+			return apiKeyError(c, "You didn't provide an API key. Send it in the Authorization header as 'Bearer <key>'.")
+		}
+
+		apiKey, err := m.service.ValidateAPIKey(key)
+		if err != nil {
+			return apiKeyError(c, "Incorrect API key provided.")
+		}
+
+		c.Locals("user_id", apiKey.UserID)
+		c.Locals("api_key_id", apiKey.ID)
+
+		return c.Next()
+	}
+}
+
+func apiKeyError(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": fiber.Map{
+			"message": message,
+			"type":    "invalid_request_error",
+			"param":   nil,
+			"code":    "invalid_api_key",
+		},
+	})
+}
@@ -35,6 +35,34 @@ type RefreshToken struct {
 	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
+// APIKey lets scripts and tools call the OpenAI-compatible /v1 API on a
+// user's behalf. Only a hash of the key is stored; the key itself is shown
+// once, when it is created.
+type APIKey struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name       string     `gorm:"type:varchar(100);not null" json:"name"`
+	Prefix     string     `gorm:"type:varchar(20);not null" json:"prefix"`        // start of the key, to tell keys apart
+	KeyHash    string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"` // hex SHA-256 of the key
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // nil = never
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string `json:"name" validate:"required,max=100"`
+	ExpiresInDays int    `json:"expires_in_days,omitempty"` // 0 = never expires
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"` // only returned here
+}
+
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
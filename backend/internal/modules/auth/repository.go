@@ -2,6 +2,7 @@ package auth
 
 import (
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -82,3 +83,51 @@ func (r *Repository) DeleteRefreshToken(token string) error {
 func (r *Repository) DeleteUserRefreshTokens(userID uuid.UUID) error {
 	return r.db.Where("user_id = ?", userID).Delete(&RefreshToken{}).Error
 }
+
+// API keys
+
+func (r *Repository) CreateAPIKey(key *APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *Repository) GetUserAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	var keys []APIKey
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *Repository) CountUserAPIKeys(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&APIKey{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// GetAPIKeyByHash returns a key whose owner is not banned or deleted.
+func (r *Repository) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	var key APIKey
+	err := r.db.Joins("JOIN users ON users.id = api_keys.user_id AND users.deleted_at IS NULL").
+		Where("api_keys.key_hash = ?", hash).
+		First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("API key not found")
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *Repository) TouchAPIKey(id uuid.UUID, usedAt time.Time) error {
+	return r.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+func (r *Repository) DeleteAPIKey(id, userID uuid.UUID) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("API key not found")
+	}
+	return nil
+}
@@ -25,4 +25,9 @@ func RegisterRoutes(app *fiber.App, handler *Handler, middleware *Middleware) {
 
 	// Usage stats
 	auth.Get("/usage-stats", middleware.Protected(), handler.GetUsageStats)
+
+	// Personal API keys for the OpenAI-compatible /v1 API
+	auth.Get("/api-keys", middleware.Protected(), handler.GetAPIKeys)
+	auth.Post("/api-keys", middleware.Protected(), handler.CreateAPIKey)
+	auth.Delete("/api-keys/:id", middleware.Protected(), handler.DeleteAPIKey)
 }
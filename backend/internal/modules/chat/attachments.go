@@ -0,0 +1,203 @@
+package chat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	_ "golang.org/x/image/webp"
+)
+
+// Image detail levels, as understood by OpenAI. Anthropic ignores them.
+const (
+	ImageDetailAuto = "auto"
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+)
+
+const (
+	// maxAttachmentSize is the largest image accepted, upload or URL.
+	maxAttachmentSize = 20 << 20
+
+	// maxAttachmentsPerMessage bounds the images sent with one message.
+	maxAttachmentsPerMessage = 10
+)
+
+var (
+	ErrModelNoVision       = errors.New("model does not accept images")
+	ErrUnsupportedImage    = errors.New("unsupported image, use PNG, JPEG, GIF or WebP")
+	ErrAttachmentTooLarge  = fmt.Errorf("image is larger than %d MB", maxAttachmentSize>>20)
+	ErrAttachmentsDisabled = errors.New("image attachments are not configured")
+)
+
+// imageContentTypes maps the formats registered with the image package to
+// their content type and file extension.
+var imageContentTypes = map[string][2]string{
+	"png":  {"image/png", ".png"},
+	"jpeg": {"image/jpeg", ".jpg"},
+	"gif":  {"image/gif", ".gif"},
+	"webp": {"image/webp", ".webp"},
+}
+
+// MessageAttachment is an image sent with a user message. It is stored when
+// uploaded (or fetched, for URLs) and linked to the message once sent.
+type MessageAttachment struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	MessageID   *uuid.UUID `gorm:"type:uuid;index" json:"message_id,omitempty"` // nil until sent
+	FileName    string     `gorm:"type:varchar(255)" json:"file_name"`
+	ContentType string     `gorm:"type:varchar(50);not null" json:"content_type"`
+	FileSize    int64      `gorm:"not null" json:"file_size"`
+	Width       int        `gorm:"not null" json:"width"`
+	Height      int        `gorm:"not null" json:"height"`
+	StorageKey  string     `gorm:"type:varchar(500);not null" json:"-"`
+	SourceURL   string     `gorm:"type:text" json:"source_url,omitempty"`         // set for images added by URL
+	Detail      string     `gorm:"type:varchar(10);default:'auto'" json:"detail"` // auto, low, high
+	Tokens      int        `gorm:"default:0" json:"tokens"`                       // prompt tokens with the chat's model
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// AttachmentInput references an image to send with a message: a previous
+// upload by ID, or a URL that is fetched and stored.
+type AttachmentInput struct {
+	ID     *uuid.UUID `json:"id,omitempty"`
+	URL    string     `json:"url,omitempty"`
+	Detail string     `json:"detail,omitempty"` // auto (default), low, high
+}
+
+type AttachmentResponse struct {
+	ID          uuid.UUID `json:"id"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	FileSize    int64     `json:"file_size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Detail      string    `json:"detail"`
+	Tokens      int       `json:"tokens"`
+	SourceURL   string    `json:"source_url,omitempty"`
+	URL         string    `json:"url"` // serves the stored image to its owner
+}
+
+func (a *MessageAttachment) ToDTO() AttachmentResponse {
+	return AttachmentResponse{
+		ID:          a.ID,
+		FileName:    a.FileName,
+		ContentType: a.ContentType,
+		FileSize:    a.FileSize,
+		Width:       a.Width,
+		Height:      a.Height,
+		Detail:      a.Detail,
+		Tokens:      a.Tokens,
+		SourceURL:   a.SourceURL,
+		URL:         fmt.Sprintf("/api/chat/attachments/%s/content", a.ID),
+	}
+}
+
+func normalizeImageDetail(detail string) (string, error) {
+	switch detail {
+	case "", ImageDetailAuto:
+		return ImageDetailAuto, nil
+	case ImageDetailLow, ImageDetailHigh:
+		return detail, nil
+	}
+	return "", fmt.Errorf("invalid image detail %q", detail)
+}
+
+// decodeImage checks that data is a supported image and returns its
+// content type, file extension and dimensions.
+func decodeImage(data []byte) (string, string, int, int, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", "", 0, 0, ErrUnsupportedImage
+	}
+	types, ok := imageContentTypes[format]
+	if !ok {
+		return "", "", 0, 0, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return "", "", 0, 0, ErrUnsupportedImage
+	}
+	return types[0], types[1], config.Width, config.Height, nil
+}
+
+// ImageTokens returns the prompt tokens an image costs with a provider,
+// following the provider's published formula. OpenAI's is used for
+// providers other than Anthropic.
+func ImageTokens(provider string, width, height int, detail string) int {
+	if provider == "anthropic" {
+		return anthropicImageTokens(width, height)
+	}
+	return openAIImageTokens(width, height, detail)
+}
+
+// openAIImageTokens: low detail is a flat 85 tokens. Otherwise the image is
+// scaled to fit 2048x2048, then down so its short side is at most 768, and
+// costs 170 tokens per 512px tile plus 85.
+func openAIImageTokens(width, height int, detail string) int {
+	if detail == ImageDetailLow {
+		return 85
+	}
+
+	w, h := float64(width), float64(height)
+	if long := math.Max(w, h); long > 2048 {
+		w, h = w*2048/long, h*2048/long
+	}
+	if short := math.Min(w, h); short > 768 {
+		w, h = w*768/short, h*768/short
+	}
+
+	tiles := math.Ceil(w/512) * math.Ceil(h/512)
+	return int(tiles)*170 + 85
+}
+
+// anthropicImageTokens: images are scaled so the long edge is at most
+// 1568px and cost width*height/750 tokens.
+func anthropicImageTokens(width, height int) int {
+	w, h := float64(width), float64(height)
+	if long := math.Max(w, h); long > 1568 {
+		w, h = w*1568/long, h*1568/long
+	}
+	return int(math.Ceil(w * h / 750))
+}
+
+// imageTokens sums the tokens of the images loaded into messages.
+func imageTokens(messages []Message) int {
+	total := 0
+	for _, msg := range messages {
+		if len(msg.images) == 0 {
+			continue
+		}
+		for _, attachment := range msg.Attachments {
+			total += attachment.Tokens
+		}
+	}
+	return total
+}
+
+// sentImageTokens returns the image tokens of the history sent in a
+// request. The messages sent from history are always a suffix of it.
+func sentImageTokens(history []Message, messages []openai.ChatCompletionMessage) int {
+	sent := 0
+	for _, msg := range messages {
+		if len(msg.MultiContent) > 0 {
+			sent++
+		}
+	}
+
+	total := 0
+	for i := len(history) - 1; i >= 0 && sent > 0; i-- {
+		if len(history[i].images) > 0 {
+			total += imageTokens(history[i : i+1])
+			sent--
+		}
+	}
+	return total
+}
@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type AttachmentsHandler struct {
+	service *AttachmentsService
+}
+
+func NewAttachmentsHandler(service *AttachmentsService) *AttachmentsHandler {
+	return &AttachmentsHandler{service: service}
+}
+
+// UploadAttachment stores an image to send with a later message, given as
+// a multipart "file" or a "url" to fetch it from.
+func (h *AttachmentsHandler) UploadAttachment(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		imageURL := c.FormValue("url")
+		if imageURL == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "File is required",
+			})
+		}
+
+		attachment, err := h.service.AddFromURL(c.Context(), userID, imageURL)
+		if err != nil {
+			return attachmentError(c, err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(attachment.ToDTO())
+	}
+
+	if fileHeader.Size > maxAttachmentSize {
+		return attachmentError(c, ErrAttachmentTooLarge)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	attachment, err := h.service.UploadAttachment(userID, fileHeader.Filename, data)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attachment.ToDTO())
+}
+
+func (h *AttachmentsHandler) GetAttachment(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	attachmentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	attachment, err := h.service.GetAttachment(attachmentID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(attachment.ToDTO())
+}
+
+// GetAttachmentContent serves the stored image to its owner.
+func (h *AttachmentsHandler) GetAttachmentContent(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	attachmentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	attachment, data, err := h.service.GetAttachmentContent(c.Context(), attachmentID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.Send(data)
+}
+
+func (h *AttachmentsHandler) DeleteAttachment(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	attachmentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	}
+
+	if err := h.service.DeleteAttachment(attachmentID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Attachment deleted successfully",
+	})
+}
+
+func attachmentError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	if errors.Is(err, ErrAttachmentTooLarge) {
+		status = fiber.StatusRequestEntityTooLarge
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,410 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/storage"
+)
+
+// attachmentFetchTimeout bounds downloading an image given by URL.
+const attachmentFetchTimeout = 30 * time.Second
+
+type AttachmentsService struct {
+	db      *gorm.DB
+	storage storage.Storage
+	client  *http.Client
+}
+
+func NewAttachmentsService(db *gorm.DB, store storage.Storage) *AttachmentsService {
+	return &AttachmentsService{
+		db:      db,
+		storage: store,
+		client:  newPublicHTTPClient(attachmentFetchTimeout),
+	}
+}
+
+// deniedPrefixes are special-purpose ranges that netip.Addr's checks miss but
+// that can still lead to internal services, e.g. carrier-grade NAT or NAT64
+// of a private IPv4 address.
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+}
+
+// publicAddress reports whether a user-supplied URL may be fetched from ip.
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newPublicHTTPClient returns a client that refuses to connect to loopback,
+// private, link-local and other special-purpose addresses, so user-supplied
+// URLs cannot reach internal services.
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !publicAddress(ip) {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// UploadAttachment stores an uploaded image. It can then be sent with a
+// message by its ID.
+func (s *AttachmentsService) UploadAttachment(userID uuid.UUID, fileName string, data []byte) (*MessageAttachment, error) {
+	return s.store(context.Background(), userID, filepath.Base(fileName), "", data)
+}
+
+// AddFromURL downloads an image and stores it, so the message keeps its
+// image even if the URL goes away.
+func (s *AttachmentsService) AddFromURL(ctx context.Context, userID uuid.UUID, rawURL string) (*MessageAttachment, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("image URL must be an http or https URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	if len(data) > maxAttachmentSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	fileName := path.Base(u.Path)
+	if fileName == "." || fileName == "/" {
+		fileName = u.Host
+	}
+	return s.store(ctx, userID, fileName, u.String(), data)
+}
+
+func (s *AttachmentsService) store(ctx context.Context, userID uuid.UUID, fileName, sourceURL string, data []byte) (*MessageAttachment, error) {
+	if len(data) > maxAttachmentSize {
+		return nil, ErrAttachmentTooLarge
+	}
+	contentType, ext, width, height, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	attachment := &MessageAttachment{
+		ID:          id,
+		UserID:      userID,
+		FileName:    truncateRunes(fileName, 252),
+		ContentType: contentType,
+		FileSize:    int64(len(data)),
+		Width:       width,
+		Height:      height,
+		StorageKey:  fmt.Sprintf("attachments/%s/%s%s", userID, id, ext),
+		SourceURL:   sourceURL,
+		Detail:      ImageDetailAuto,
+	}
+
+	if err := s.storage.Put(ctx, attachment.StorageKey, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to store image: %w", err)
+	}
+	if err := s.db.Create(attachment).Error; err != nil {
+		s.storage.Delete(context.Background(), attachment.StorageKey)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentsService) GetAttachment(id, userID uuid.UUID) (*MessageAttachment, error) {
+	var attachment MessageAttachment
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("attachment not found")
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// GetAttachmentContent returns an attachment with its image data.
+func (s *AttachmentsService) GetAttachmentContent(ctx context.Context, id, userID uuid.UUID) (*MessageAttachment, []byte, error) {
+	attachment, err := s.GetAttachment(id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := s.read(ctx, attachment)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, data, nil
+}
+
+// DeleteAttachment removes an upload that was not sent yet. Sent images
+// stay with their message.
+func (s *AttachmentsService) DeleteAttachment(id, userID uuid.UUID) error {
+	attachment, err := s.GetAttachment(id, userID)
+	if err != nil {
+		return err
+	}
+	if attachment.MessageID != nil {
+		return errors.New("attachment was already sent")
+	}
+
+	if err := s.db.Delete(attachment).Error; err != nil {
+		return err
+	}
+
+	// Copies made for edited prompts share the stored image
+	var count int64
+	s.db.Model(&MessageAttachment{}).Where("storage_key = ?", attachment.StorageKey).Count(&count)
+	if count > 0 {
+		return nil
+	}
+	return s.storage.Delete(context.Background(), attachment.StorageKey)
+}
+
+// resolve returns the unsent attachments a message refers to, fetching
+// those given by URL.
+func (s *AttachmentsService) resolve(ctx context.Context, userID uuid.UUID, inputs []AttachmentInput) ([]MessageAttachment, error) {
+	if len(inputs) > maxAttachmentsPerMessage {
+		return nil, fmt.Errorf("at most %d images can be sent with a message", maxAttachmentsPerMessage)
+	}
+
+	attachments := make([]MessageAttachment, 0, len(inputs))
+	for _, input := range inputs {
+		detail, err := normalizeImageDetail(input.Detail)
+		if err != nil {
+			return nil, err
+		}
+
+		var attachment *MessageAttachment
+		switch {
+		case input.ID != nil:
+			attachment, err = s.GetAttachment(*input.ID, userID)
+			if err == nil && attachment.MessageID != nil {
+				err = errors.New("attachment was already sent")
+			}
+		case input.URL != "":
+			attachment, err = s.AddFromURL(ctx, userID, input.URL)
+		default:
+			err = errors.New("attachment needs an id or a url")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		attachment.Detail = detail
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+// duplicate copies sent attachments as new unsent ones sharing the stored
+// image, e.g. for an edited prompt.
+func (s *AttachmentsService) duplicate(attachments []MessageAttachment) ([]MessageAttachment, error) {
+	copies := make([]MessageAttachment, len(attachments))
+	for i, attachment := range attachments {
+		attachment.ID = uuid.New()
+		attachment.MessageID = nil
+		attachment.CreatedAt = time.Time{}
+		copies[i] = attachment
+	}
+	if len(copies) == 0 {
+		return copies, nil
+	}
+	if err := s.db.Create(&copies).Error; err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+// imageURL returns an attachment as a data URL.
+func (s *AttachmentsService) imageURL(ctx context.Context, attachment *MessageAttachment) (openai.ChatMessageImageURL, error) {
+	data, err := s.read(ctx, attachment)
+	if err != nil {
+		return openai.ChatMessageImageURL{}, err
+	}
+
+	detail := openai.ImageURLDetail(attachment.Detail)
+	if detail == "" {
+		detail = openai.ImageURLDetailAuto
+	}
+	return openai.ChatMessageImageURL{
+		URL:    "data:" + attachment.ContentType + ";base64," + base64.StdEncoding.EncodeToString(data),
+		Detail: detail,
+	}, nil
+}
+
+func (s *AttachmentsService) read(ctx context.Context, attachment *MessageAttachment) ([]byte, error) {
+	r, err := s.storage.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", attachment.ID, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// hasImages reports whether any message carries attachments.
+func hasImages(messages []Message) bool {
+	for _, msg := range messages {
+		if len(msg.Attachments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAttachments enables image attachments in chat messages.
+func (s *Service) SetAttachments(attachments *AttachmentsService) {
+	s.attachments = attachments
+}
+
+// supportsVision reports whether a model accepts images. Without a catalog
+// every model is assumed to.
+func (s *Service) supportsVision(model string) bool {
+	if s.catalog == nil {
+		return true
+	}
+	entry, err := s.catalog.GetModel(model)
+	return err == nil && entry.SupportsVision
+}
+
+// imageProvider returns the provider whose image token formula applies to
+// a model.
+func (s *Service) imageProvider(model string) string {
+	if s.catalog != nil {
+		if entry, err := s.catalog.GetModel(model); err == nil {
+			return entry.Provider
+		}
+	}
+	if provider, _, err := s.providers.Resolve(model); err == nil {
+		return provider.Name()
+	}
+	return ""
+}
+
+// prepareAttachments resolves the images sent with a new message and prices
+// them for the chat's model. It returns their total tokens, which must fit
+// in the user's remaining quota.
+func (s *Service) prepareAttachments(ctx context.Context, chat *Chat, userID uuid.UUID, inputs []AttachmentInput) ([]MessageAttachment, int, error) {
+	if len(inputs) == 0 {
+		return nil, 0, nil
+	}
+	if s.attachments == nil {
+		return nil, 0, ErrAttachmentsDisabled
+	}
+	if !s.supportsVision(chat.Model) {
+		return nil, 0, fmt.Errorf("%w: %s, switch to a vision model to send images", ErrModelNoVision, chat.Model)
+	}
+
+	attachments, err := s.attachments.resolve(ctx, userID, inputs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	provider := s.imageProvider(chat.Model)
+	total := 0
+	for i := range attachments {
+		attachment := &attachments[i]
+		attachment.Tokens = ImageTokens(provider, attachment.Width, attachment.Height, attachment.Detail)
+		total += attachment.Tokens
+	}
+
+	_, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if projected := tokensUsed + int64(total); tokensLimit != -1 && projected > tokensLimit {
+		return nil, 0, fmt.Errorf("not enough tokens for the attached images (%d/%d)", projected, tokensLimit)
+	}
+	return attachments, total, nil
+}
+
+// loadAttachmentImages reads the images of history into the messages so
+// they are sent along. Models without vision get the text only, e.g. after
+// the chat switched models; it reports whether images were loaded.
+func (s *Service) loadAttachmentImages(ctx context.Context, chat *Chat, history []Message) bool {
+	if s.attachments == nil || !hasImages(history) || !s.supportsVision(chat.Model) {
+		return false
+	}
+
+	loaded := false
+	for i := range history {
+		for j := range history[i].Attachments {
+			image, err := s.attachments.imageURL(ctx, &history[i].Attachments[j])
+			if err != nil {
+				log.Printf("Chat %s: %v", chat.ID, err)
+				continue
+			}
+			history[i].images = append(history[i].images, image)
+			loaded = true
+		}
+	}
+	return loaded
+}
+
+// visionChain drops the fallbacks of a model chain that cannot read images.
+func (s *Service) visionChain(models []string) []string {
+	chain := models[:1]
+	for _, model := range models[1:] {
+		if s.supportsVision(model) {
+			chain = append(chain, model)
+		}
+	}
+	return chain
+}
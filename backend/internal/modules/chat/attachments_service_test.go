@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"192.0.0.170", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"64:ff9b::a9fe:a9fe", false}, // NAT64 of 169.254.169.254
+		{"64:ff9b:1::a00:1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"::ffff:93.184.216.34", true},
+	}
+
+	for _, tt := range tests {
+		if got := publicAddress(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Batch jobs run many completion requests in the background. The requests
+// come as a JSONL file, one /v1/chat/completions body per line, and every
+// line is charged like an API request as soon as it completes.
+
+// Batch job and line statuses
+const (
+	BatchStatusPending   = "pending"
+	BatchStatusRunning   = "running"
+	BatchStatusCompleted = "completed"
+	BatchStatusFailed    = "failed"
+	BatchStatusCancelled = "cancelled"
+)
+
+const (
+	// maxBatchFileSize limits uploaded batch files to 20MB.
+	maxBatchFileSize = 20 * 1024 * 1024
+	maxBatchLines    = 5000
+
+	// maxActiveBatchJobs is how many unfinished jobs a user may have.
+	maxActiveBatchJobs = 3
+
+	// batchConcurrencyPerUser is how many lines of a user's jobs run at
+	// once, across all of their jobs.
+	batchConcurrencyPerUser = 4
+
+	batchWorkers     = 16 // lines running at once, across all users
+	batchLineTimeout = 5 * time.Minute
+
+	// batchSweepInterval is how often pending jobs are looked for, besides
+	// when a job is created.
+	batchSweepInterval = time.Minute
+)
+
+var (
+	ErrBatchNotFound       = errors.New("batch job not found")
+	ErrBatchTooLarge       = errors.New("batch file is too large, maximum size is 20MB")
+	ErrBatchLimitReached   = errors.New("too many unfinished batch jobs, wait for one to finish")
+	errBatchNotCancellable = errors.New("only pending or running batch jobs can be cancelled")
+)
+
+// BatchJob is an uploaded file of requests and its progress.
+type BatchJob struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name           string     `gorm:"type:varchar(255)" json:"name"`
+	Model          string     `gorm:"type:varchar(50)" json:"model,omitempty"` // for lines that name none
+	Status         string     `gorm:"type:varchar(20);not null;default:'pending'" json:"status"`
+	TotalLines     int        `gorm:"default:0" json:"total_lines"`
+	CompletedLines int        `gorm:"default:0" json:"completed_lines"`
+	FailedLines    int        `gorm:"default:0" json:"failed_lines"`
+	Tokens         int64      `gorm:"default:0" json:"tokens"` // charged so far
+	Error          string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+func (BatchJob) TableName() string {
+	return "batch_jobs"
+}
+
+// BatchLine is one request of a batch job and its result.
+type BatchLine struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	JobID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"job_id"`
+	LineNumber int        `gorm:"not null" json:"line_number"`
+	CustomID   string     `gorm:"type:varchar(255)" json:"custom_id,omitempty"`
+	Request    string     `gorm:"type:text;not null" json:"-"` // JSON completion request
+	Status     string     `gorm:"type:varchar(20);not null;default:'pending'" json:"status"`
+	Response   string     `gorm:"type:text" json:"-"` // JSON completion response
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	Tokens     int        `gorm:"default:0" json:"tokens"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (BatchLine) TableName() string {
+	return "batch_job_lines"
+}
+
+// batchInput is a line of a batch file: either the OpenAI batch format,
+// with the request in body, or the request itself.
+type batchInput struct {
+	CustomID string          `json:"custom_id"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// BatchResult is a line of the results file.
+type BatchResult struct {
+	Line     int               `json:"line"`
+	CustomID string            `json:"custom_id,omitempty"`
+	Status   string            `json:"status"`
+	Response json.RawMessage   `json:"response"` // the completion, null unless completed
+	Error    *BatchResultError `json:"error"`
+}
+
+type BatchResultError struct {
+	Message string `json:"message"`
+}
+
+type BatchJobResponse struct {
+	*BatchJob
+	Progress float64 `json:"progress"` // share of lines done, 0 to 1
+}
+
+func (j *BatchJob) ToDTO() *BatchJobResponse {
+	progress := 0.0
+	if j.TotalLines > 0 {
+		progress = float64(j.CompletedLines+j.FailedLines) / float64(j.TotalLines)
+	}
+	return &BatchJobResponse{BatchJob: j, Progress: progress}
+}
+
+// finished reports whether the job will not run any more lines.
+func (j *BatchJob) finished() bool {
+	return j.Status != BatchStatusPending && j.Status != BatchStatusRunning
+}
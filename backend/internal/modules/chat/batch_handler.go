@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type BatchHandler struct {
+	service *BatchService
+}
+
+func NewBatchHandler(service *BatchService) *BatchHandler {
+	return &BatchHandler{service: service}
+}
+
+// CreateJob accepts a multipart "file" with one completion request per
+// line, an optional "model" for lines that name none and an optional "name".
+func (h *BatchHandler) CreateJob(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is required",
+		})
+	}
+	if fileHeader.Size > maxBatchFileSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": ErrBatchTooLarge.Error(),
+		})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBatchFileSize))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	name := c.FormValue("name")
+	if name == "" {
+		name = fileHeader.Filename
+	}
+
+	job, err := h.service.CreateJob(userID, name, c.FormValue("model"), data)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, ErrBatchLimitReached) {
+			status = fiber.StatusTooManyRequests
+		}
+		return c.Status(modelErrorStatus(err, status)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(job.ToDTO())
+}
+
+func (h *BatchHandler) GetJobs(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	jobs, err := h.service.GetJobs(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	responses := make([]*BatchJobResponse, len(jobs))
+	for i := range jobs {
+		responses[i] = jobs[i].ToDTO()
+	}
+
+	return c.JSON(responses)
+}
+
+// GetJob reports a job's progress.
+func (h *BatchHandler) GetJob(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid batch job ID",
+		})
+	}
+
+	job, err := h.service.GetJob(jobID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(job.ToDTO())
+}
+
+// GetResults downloads the results as JSONL, see BatchResult.
+func (h *BatchHandler) GetResults(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid batch job ID",
+		})
+	}
+
+	file, err := h.service.GetResults(jobID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return sendExport(c, file)
+}
+
+func (h *BatchHandler) CancelJob(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid batch job ID",
+		})
+	}
+
+	job, err := h.service.CancelJob(jobID, userID)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, ErrBatchNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(job.ToDTO())
+}
+
+func (h *BatchHandler) DeleteJob(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	jobID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid batch job ID",
+		})
+	}
+
+	if err := h.service.DeleteJob(jobID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Batch job deleted successfully",
+	})
+}
@@ -0,0 +1,606 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+)
+
+// BatchService queues batch jobs and runs their lines through the chat
+// service, as API requests of the job's owner. Lines are scheduled one at a
+// time, taking turns between users, so a large job does not hold up the
+// jobs of others.
+type BatchService struct {
+	db      *gorm.DB
+	chat    *Service
+	created chan struct{} // a job was created
+	freed   chan struct{} // a line finished
+
+	mu     sync.Mutex
+	runs   map[uuid.UUID]*batchRun  // per job
+	users  map[uuid.UUID]*batchUser // users with running jobs
+	order  []uuid.UUID              // users in turn order
+	next   int                      // index in order of the next user's turn
+	active int                      // lines running
+}
+
+// batchRun is a job being run and the lines it has left.
+type batchRun struct {
+	job      BatchJob
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lines    []uuid.UUID // pending, in file order
+	inFlight int
+	quotaErr error
+}
+
+// batchUser holds the running jobs of a user, oldest first.
+type batchUser struct {
+	runs     []*batchRun
+	inFlight int // see batchConcurrencyPerUser
+}
+
+func NewBatchService(db *gorm.DB, chat *Service) *BatchService {
+	return &BatchService{
+		db:      db,
+		chat:    chat,
+		created: make(chan struct{}, 1),
+		freed:   make(chan struct{}, 1),
+		runs:    make(map[uuid.UUID]*batchRun),
+		users:   make(map[uuid.UUID]*batchUser),
+	}
+}
+
+// Run schedules the lines of pending jobs until the process exits, starting
+// with those left unfinished by a previous run. Pending jobs are looked for
+// when a job is created and every batchSweepInterval.
+func (s *BatchService) Run() {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&BatchLine{}).
+			Where("status = ?", BatchStatusRunning).
+			Update("status", BatchStatusPending).Error; err != nil {
+			return err
+		}
+		return tx.Model(&BatchJob{}).
+			Where("status = ?", BatchStatusRunning).
+			Update("status", BatchStatusPending).Error
+	})
+	if err != nil {
+		log.Printf("Batch jobs: failed to reset running jobs: %v", err)
+	}
+
+	ticker := time.NewTicker(batchSweepInterval)
+	defer ticker.Stop()
+
+	sweep := true
+	for {
+		if sweep {
+			s.sweep()
+		}
+		s.dispatch()
+
+		select {
+		case <-s.created:
+			sweep = true
+		case <-ticker.C:
+			sweep = true
+		case <-s.freed:
+			sweep = false
+		}
+	}
+}
+
+// CreateJob reads a JSONL file of completion requests and queues them.
+// Lines without a model use model. The whole file is checked first, so a
+// bad line rejects the job.
+func (s *BatchService) CreateJob(userID uuid.UUID, name, model string, data []byte) (*BatchJob, error) {
+	if len(data) > maxBatchFileSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	var active int64
+	if err := s.db.Model(&BatchJob{}).
+		Where("user_id = ? AND status IN ?", userID, []string{BatchStatusPending, BatchStatusRunning}).
+		Count(&active).Error; err != nil {
+		return nil, err
+	}
+	if active >= maxActiveBatchJobs {
+		return nil, ErrBatchLimitReached
+	}
+	if err := s.chat.ensureTokenCapacity(userID); err != nil {
+		return nil, err
+	}
+
+	lines, err := s.parseLines(userID, strings.TrimSpace(model), data)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &BatchJob{
+		UserID:     userID,
+		Name:       strings.TrimSpace(name),
+		Model:      strings.TrimSpace(model),
+		Status:     BatchStatusPending,
+		TotalLines: len(lines),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		for i := range lines {
+			lines[i].JobID = job.ID
+		}
+		return tx.CreateInBatches(lines, 100).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueue()
+	return job, nil
+}
+
+// parseLines turns a batch file into pending lines. Blank lines are skipped
+// but still counted, so line numbers match the file.
+func (s *BatchService) parseLines(userID uuid.UUID, model string, data []byte) ([]BatchLine, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchFileSize)
+
+	allowed := make(map[string]error)
+	var lines []BatchLine
+	for number := 1; scanner.Scan(); number++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if len(lines) == maxBatchLines {
+			return nil, fmt.Errorf("batch file has too many requests, maximum is %d", maxBatchLines)
+		}
+
+		var input batchInput
+		if err := json.Unmarshal(raw, &input); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON", number)
+		}
+		body := raw
+		if len(input.Body) > 0 {
+			body = input.Body
+		}
+
+		// The request is kept as sent, only the model is filled in
+		var fields map[string]json.RawMessage
+		var req openai.ChatCompletionRequest
+		if json.Unmarshal(body, &fields) != nil || json.Unmarshal(body, &req) != nil {
+			return nil, fmt.Errorf("line %d: invalid completion request", number)
+		}
+		if req.Model == "" {
+			if model == "" {
+				return nil, fmt.Errorf("line %d: model is required", number)
+			}
+			req.Model = model
+			fields["model"], _ = json.Marshal(model)
+		}
+		if len(req.Messages) == 0 {
+			return nil, fmt.Errorf("line %d: messages must not be empty", number)
+		}
+
+		if _, checked := allowed[req.Model]; !checked {
+			allowed[req.Model] = s.chat.checkModel(userID, req.Model)
+		}
+		if err := allowed[req.Model]; err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", number, req.Model, err)
+		}
+
+		request, _ := json.Marshal(fields)
+		lines = append(lines, BatchLine{
+			LineNumber: number,
+			CustomID:   input.CustomID,
+			Request:    string(request),
+			Status:     BatchStatusPending,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read batch file: %w", err)
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("batch file has no requests")
+	}
+	return lines, nil
+}
+
+func (s *BatchService) GetJobs(userID uuid.UUID) ([]BatchJob, error) {
+	var jobs []BatchJob
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&jobs).Error
+	return jobs, err
+}
+
+func (s *BatchService) GetJob(id, userID uuid.UUID) (*BatchJob, error) {
+	var job BatchJob
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBatchNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelJob stops a job. Lines that completed keep their results and
+// charges; the others are not run.
+func (s *BatchService) CancelJob(id, userID uuid.UUID) (*BatchJob, error) {
+	job, err := s.GetJob(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := s.db.Model(&BatchJob{}).
+		Where("id = ? AND status IN ?", id, []string{BatchStatusPending, BatchStatusRunning}).
+		Updates(map[string]interface{}{"status": BatchStatusCancelled, "finished_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errBatchNotCancellable
+	}
+	s.stop(id)
+
+	job.Status = BatchStatusCancelled
+	job.FinishedAt = &now
+	return job, nil
+}
+
+// DeleteJob cancels a job and removes it with its results.
+func (s *BatchService) DeleteJob(id, userID uuid.UUID) error {
+	if _, err := s.GetJob(id, userID); err != nil {
+		return err
+	}
+	s.stop(id)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", id).Delete(&BatchLine{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&BatchJob{}, "id = ?", id).Error
+	})
+}
+
+// GetResults returns the job's results as JSONL, one line per request in
+// file order. Results can be fetched while the job runs; lines that have
+// not run yet are reported as pending.
+func (s *BatchService) GetResults(id, userID uuid.UUID) (*ExportFile, error) {
+	job, err := s.GetJob(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []BatchLine
+	if err := s.db.Where("job_id = ?", id).Order("line_number").Find(&lines).Error; err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, line := range lines {
+		result := BatchResult{
+			Line:     line.LineNumber,
+			CustomID: line.CustomID,
+			Status:   line.Status,
+			Response: json.RawMessage("null"),
+		}
+		switch {
+		case line.Status == BatchStatusCompleted:
+			result.Response = json.RawMessage(line.Response)
+		case line.Status == BatchStatusFailed:
+			result.Error = &BatchResultError{Message: line.Error}
+		case job.finished():
+			result.Error = &BatchResultError{Message: fmt.Sprintf("not run, the job is %s", job.Status)}
+		}
+		if err := encoder.Encode(result); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ExportFile{
+		FileName:    fmt.Sprintf("batch-%s-results.jsonl", job.ID),
+		ContentType: "application/x-ndjson",
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+// enqueue has the scheduler pick up new jobs.
+func (s *BatchService) enqueue() {
+	wake(s.created)
+}
+
+// wake signals the scheduler without blocking; one pending wake-up is
+// enough.
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// stop cancels the lines of a job that is running. A job with no line
+// running is finished right away.
+func (s *BatchService) stop(id uuid.UUID) {
+	s.mu.Lock()
+	run, ok := s.runs[id]
+	if ok {
+		run.cancel()
+		if run.inFlight == 0 {
+			s.remove(run)
+		} else {
+			ok = false // finished by its last line
+		}
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.finish(run)
+	}
+}
+
+// sweep starts the pending jobs, oldest first.
+func (s *BatchService) sweep() {
+	var ids []uuid.UUID
+	if err := s.db.Model(&BatchJob{}).
+		Where("status = ?", BatchStatusPending).
+		Order("created_at").
+		Pluck("id", &ids).Error; err != nil {
+		log.Printf("Batch jobs: failed to load pending jobs: %v", err)
+		return
+	}
+	for _, id := range ids {
+		s.start(id)
+	}
+}
+
+// start claims a pending job and adds its pending lines to the schedule.
+func (s *BatchService) start(id uuid.UUID) {
+	now := time.Now()
+	result := s.db.Model(&BatchJob{}).
+		Where("id = ? AND status = ?", id, BatchStatusPending).
+		Updates(map[string]interface{}{"status": BatchStatusRunning, "started_at": now})
+	if result.Error != nil {
+		log.Printf("Batch jobs: failed to claim %s: %v", id, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		// Cancelled or deleted meanwhile
+		return
+	}
+
+	run := &batchRun{}
+	if err := s.db.First(&run.job, "id = ?", id).Error; err != nil {
+		log.Printf("Batch jobs: %v", err)
+		return
+	}
+	if err := s.db.Model(&BatchLine{}).
+		Where("job_id = ? AND status = ?", id, BatchStatusPending).
+		Order("line_number").
+		Pluck("id", &run.lines).Error; err != nil {
+		log.Printf("Batch jobs: failed to load lines of %s: %v", id, err)
+		return
+	}
+	run.ctx, run.cancel = context.WithCancel(context.Background())
+
+	if len(run.lines) == 0 {
+		s.finish(run)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs[id] = run
+	user, ok := s.users[run.job.UserID]
+	if !ok {
+		user = &batchUser{}
+		s.users[run.job.UserID] = user
+		s.order = append(s.order, run.job.UserID)
+	}
+	user.runs = append(user.runs, run)
+}
+
+// dispatch starts lines until batchWorkers are running, giving users a
+// line each in turn.
+func (s *BatchService) dispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.active < batchWorkers {
+		run, lineID, ok := s.nextLine()
+		if !ok {
+			return
+		}
+		s.active++
+		run.inFlight++
+		s.users[run.job.UserID].inFlight++
+		go s.runLine(run, lineID)
+	}
+}
+
+// nextLine takes the next line of the first user in turn who has one and
+// is below batchConcurrencyPerUser. Their oldest job goes first.
+func (s *BatchService) nextLine() (*batchRun, uuid.UUID, bool) {
+	for i := 0; i < len(s.order); i++ {
+		index := (s.next + i) % len(s.order)
+		user := s.users[s.order[index]]
+		if user.inFlight >= batchConcurrencyPerUser {
+			continue
+		}
+		for _, run := range user.runs {
+			if run.ctx.Err() != nil || len(run.lines) == 0 {
+				continue
+			}
+			lineID := run.lines[0]
+			run.lines = run.lines[1:]
+			s.next = (index + 1) % len(s.order)
+			return run, lineID, true
+		}
+	}
+	return nil, uuid.Nil, false
+}
+
+// runLine runs a line and finishes its job after the job's last line.
+func (s *BatchService) runLine(run *batchRun, lineID uuid.UUID) {
+	err := s.processLine(run.ctx, &run.job, lineID)
+
+	s.mu.Lock()
+	s.active--
+	run.inFlight--
+	s.users[run.job.UserID].inFlight--
+	if err != nil && run.quotaErr == nil {
+		run.quotaErr = err
+		run.cancel()
+	}
+	done := run.inFlight == 0 && (len(run.lines) == 0 || run.ctx.Err() != nil)
+	if done {
+		s.remove(run)
+	}
+	s.mu.Unlock()
+
+	if done {
+		s.finish(run)
+	}
+	wake(s.freed)
+}
+
+// remove takes a run off the schedule. s.mu must be held.
+func (s *BatchService) remove(run *batchRun) {
+	delete(s.runs, run.job.ID)
+
+	userID := run.job.UserID
+	user := s.users[userID]
+	for i, r := range user.runs {
+		if r == run {
+			user.runs = append(user.runs[:i], user.runs[i+1:]...)
+			break
+		}
+	}
+	if len(user.runs) > 0 || user.inFlight > 0 {
+		return
+	}
+
+	delete(s.users, userID)
+	for i, id := range s.order {
+		if id == userID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			if i < s.next {
+				s.next--
+			}
+			break
+		}
+	}
+	if s.next >= len(s.order) {
+		s.next = 0
+	}
+}
+
+// finish records the end of a job. Running out of tokens fails the job;
+// the lines not run yet stay pending. A cancelled or deleted job keeps its
+// state.
+func (s *BatchService) finish(run *batchRun) {
+	run.cancel()
+
+	updates := map[string]interface{}{
+		"status":      BatchStatusCompleted,
+		"finished_at": time.Now(),
+	}
+	if run.quotaErr != nil {
+		updates["status"] = BatchStatusFailed
+		updates["error"] = run.quotaErr.Error()
+	}
+	if err := s.db.Model(&BatchJob{}).
+		Where("id = ? AND status = ?", run.job.ID, BatchStatusRunning).
+		Updates(updates).Error; err != nil {
+		log.Printf("Batch jobs: failed to finish %s: %v", run.job.ID, err)
+	}
+}
+
+// processLine runs one line and records its result on the line and the
+// job. It only returns an error when the user is out of tokens, which ends
+// the job; the line then stays pending, like lines of a stopped job. Lines
+// of a job that is no longer running, e.g. cancelled while it was being
+// started, are skipped.
+func (s *BatchService) processLine(ctx context.Context, job *BatchJob, lineID uuid.UUID) error {
+	result := s.db.Model(&BatchLine{}).
+		Where("id = ? AND status = ?", lineID, BatchStatusPending).
+		Where("EXISTS (SELECT 1 FROM batch_jobs WHERE batch_jobs.id = batch_job_lines.job_id AND batch_jobs.status = ?)", BatchStatusRunning).
+		Update("status", BatchStatusRunning)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil
+	}
+
+	var line BatchLine
+	if err := s.db.First(&line, "id = ?", lineID).Error; err != nil {
+		return nil
+	}
+
+	var req openai.ChatCompletionRequest
+	var format struct {
+		ResponseFormat *ResponseFormat `json:"response_format"`
+	}
+	json.Unmarshal([]byte(line.Request), &req)
+	json.Unmarshal([]byte(line.Request), &format)
+
+	lineCtx, cancel := context.WithTimeout(ctx, batchLineTimeout)
+	defer cancel()
+
+	resp, err := s.chat.APIChatCompletion(lineCtx, job.UserID, req, format.ResponseFormat)
+	if err != nil && (ctx.Err() != nil || errors.Is(err, errAPIQuotaExceeded)) {
+		s.db.Model(&BatchLine{}).Where("id = ?", lineID).Update("status", BatchStatusPending)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"status":      BatchStatusCompleted,
+		"finished_at": time.Now(),
+	}
+	counter := "completed_lines"
+	tokens := 0
+	if err != nil {
+		updates["status"] = BatchStatusFailed
+		updates["error"] = err.Error()
+		counter = "failed_lines"
+	} else {
+		response, _ := json.Marshal(resp)
+		tokens = resp.Usage.TotalTokens
+		updates["response"] = string(response)
+		updates["tokens"] = tokens
+	}
+
+	// The job may have been deleted meanwhile
+	result = s.db.Model(&BatchLine{}).
+		Where("id = ? AND status = ?", lineID, BatchStatusRunning).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("Batch jobs: failed to save line %d of %s: %v", line.LineNumber, job.ID, result.Error)
+		return nil
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	s.db.Model(&BatchJob{}).
+		Where("id = ?", job.ID).
+		Updates(map[string]interface{}{
+			counter:  gorm.Expr(counter + " + 1"),
+			"tokens": gorm.Expr("tokens + ?", tokens),
+		})
+	return nil
+}
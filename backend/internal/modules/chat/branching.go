@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// Messages form a tree through ParentID: editing a prompt or regenerating an
+// answer adds a sibling instead of overwriting. Chat.ActiveLeafID selects the
+// branch shown to the user and sent to the model.
+
+// ActivePath returns the messages from the root to the active leaf.
+func (c *Chat) ActivePath() []Message {
+	return activePath(c.Messages, c.ActiveLeafID)
+}
+
+// leafID returns the message new prompts are attached to.
+func (c *Chat) leafID() *uuid.UUID {
+	if c.ActiveLeafID != nil {
+		return c.ActiveLeafID
+	}
+	// Chats from before branching are linear; continue from the last message
+	if len(c.Messages) > 0 {
+		id := c.Messages[len(c.Messages)-1].ID
+		return &id
+	}
+	return nil
+}
+
+// activePath walks from leafID up to the root and returns the branch in
+// chronological order. Without a leaf the chat is treated as linear.
+func activePath(messages []Message, leafID *uuid.UUID) []Message {
+	if leafID == nil {
+		return messages
+	}
+
+	byID := make(map[uuid.UUID]Message, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+
+	var path []Message
+	for id := leafID; id != nil && len(path) < len(messages); {
+		msg, ok := byID[*id]
+		if !ok {
+			break
+		}
+		path = append(path, msg)
+		id = msg.ParentID
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// messageTree indexes messages by their parent, so walking a chat's tree
+// does not scan every message at each step. Roots are under uuid.Nil, and
+// each level keeps the order of the messages given, oldest first.
+type messageTree map[uuid.UUID][]Message
+
+func newMessageTree(messages []Message) messageTree {
+	tree := make(messageTree)
+	for _, msg := range messages {
+		parent := uuid.Nil
+		if msg.ParentID != nil {
+			parent = *msg.ParentID
+		}
+		tree[parent] = append(tree[parent], msg)
+	}
+	return tree
+}
+
+// children returns the messages below parentID, oldest first.
+func (t messageTree) children(parentID *uuid.UUID) []Message {
+	if parentID == nil {
+		return t[uuid.Nil]
+	}
+	return t[*parentID]
+}
+
+// latestLeaf descends from id through the most recent child at each level.
+func (t messageTree) latestLeaf(id uuid.UUID) uuid.UUID {
+	for {
+		children := t[id]
+		if len(children) == 0 {
+			return id
+		}
+		id = children[len(children)-1].ID
+	}
+}
+
+func findMessage(messages []Message, id uuid.UUID) *Message {
+	for i := range messages {
+		if messages[i].ID == id {
+			return &messages[i]
+		}
+	}
+	return nil
+}
+
+// EditMessage stores an edited copy of a user prompt as a sibling of the
+// original and streams a new answer on that branch.
+func (s *Service) EditMessage(ctx context.Context, chatID, messageID, userID uuid.UUID, req *EditMessageRequest) (<-chan StreamChunk, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	original := findMessage(chat.Messages, messageID)
+	if original == nil || original.Role != "user" {
+		return nil, errors.New("message not found or not a user message")
+	}
+
+	if err := s.checkModel(userID, chat.Model); err != nil {
+		return nil, err
+	}
+	output, err := newStructuredOutput(req.ResponseFormat)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureTokenCapacity(userID); err != nil {
+		return nil, err
+	}
+
+	verdict, err := s.moderatePrompt(ctx, chatID, userID, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := &Message{
+		ChatID:   chatID,
+		ParentID: original.ParentID,
+		Role:     "user",
+		Content:  req.Content,
+		Tokens:   tokenizer.Count(chat.Model, req.Content),
+	}
+
+	// The edited prompt keeps the original's images
+	var attachments []MessageAttachment
+	if s.attachments != nil && len(original.Attachments) > 0 {
+		attachments, err = s.attachments.duplicate(original.Attachments)
+		if err != nil {
+			return nil, err
+		}
+		for _, attachment := range attachments {
+			edited.Tokens += attachment.Tokens
+		}
+	}
+
+	if err := s.repo.CreateMessageWithAttachments(edited, attachments); err != nil {
+		return nil, err
+	}
+	s.recordPrompt(verdict, userID, edited)
+	if err := s.repo.SetActiveLeaf(chatID, edited.ID); err != nil {
+		return nil, err
+	}
+
+	return s.streamReply(ctx, chat, userID, req.SystemPrompt, edited.ID, output)
+}
+
+// SwitchBranch makes the branch through messageID active, following the
+// most recent replies below it.
+func (s *Service) SwitchBranch(chatID, messageID, userID uuid.UUID) (*Chat, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if findMessage(chat.Messages, messageID) == nil {
+		return nil, errors.New("message not found")
+	}
+
+	leaf := newMessageTree(chat.Messages).latestLeaf(messageID)
+	if err := s.repo.SetActiveLeaf(chatID, leaf); err != nil {
+		return nil, err
+	}
+
+	chat.ActiveLeafID = &leaf
+	return chat, nil
+}
+
+// GetMessageSiblings returns all versions of a message, including itself.
+func (s *Service) GetMessageSiblings(chatID, messageID, userID uuid.UUID) ([]Message, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message := findMessage(chat.Messages, messageID)
+	if message == nil {
+		return nil, errors.New("message not found")
+	}
+
+	return newMessageTree(chat.Messages).children(message.ParentID), nil
+}
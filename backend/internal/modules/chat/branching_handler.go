@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func (h *Handler) EditMessage(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("chatId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	var req EditMessageRequest
+	if err := c.BodyParser(&req); err != nil || req.Content == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunkChan, err := h.service.EditMessage(ctx, chatID, messageID, userID, &req)
+	if err != nil {
+		cancel()
+		return c.Status(modelErrorStatus(err, fiber.StatusBadRequest)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return streamChunks(c, chunkChan, cancel)
+}
+
+func (h *Handler) SwitchBranch(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	var req SwitchBranchRequest
+	if err := c.BodyParser(&req); err != nil || req.MessageID == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	chat, err := h.service.SwitchBranch(chatID, req.MessageID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(chat.ToDTO())
+}
+
+func (h *Handler) GetMessageSiblings(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("chatId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	siblings, err := h.service.GetMessageSiblings(chatID, messageID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	messages := make([]MessageDTO, len(siblings))
+	for i, msg := range siblings {
+		messages[i] = msg.ToDTO()
+		messages[i].SiblingCount = len(siblings)
+		messages[i].SiblingIndex = i
+	}
+
+	return c.JSON(fiber.Map{
+		"messages": messages,
+	})
+}
@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestChatToDTOSiblings(t *testing.T) {
+	// prompt ─┬─ first answer
+	//         └─ second answer ─┬─ follow-up
+	//                           └─ edited follow-up ── last answer
+	id := func() uuid.UUID { return uuid.New() }
+	prompt, first, second, followUp, edited, last := id(), id(), id(), id(), id(), id()
+	message := func(msgID uuid.UUID, parent *uuid.UUID) Message {
+		return Message{ID: msgID, ParentID: parent, Role: "user"}
+	}
+	messages := []Message{
+		message(prompt, nil),
+		message(first, &prompt),
+		message(second, &prompt),
+		message(followUp, &second),
+		message(edited, &second),
+		message(last, &edited),
+	}
+
+	tree := newMessageTree(messages)
+	tests := []struct {
+		from uuid.UUID
+		want uuid.UUID
+	}{
+		{prompt, last},
+		{first, first},
+		{second, last},
+		{followUp, followUp},
+		{last, last},
+	}
+	for _, tt := range tests {
+		if got := tree.latestLeaf(tt.from); got != tt.want {
+			t.Errorf("latestLeaf(%s) = %s, want %s", tt.from, got, tt.want)
+		}
+	}
+
+	chat := &Chat{Messages: messages, ActiveLeafID: &last}
+	dto := chat.ToDTO()
+	want := []struct {
+		id           uuid.UUID
+		count, index int
+	}{
+		{prompt, 1, 0},
+		{second, 2, 1},
+		{edited, 2, 1},
+		{last, 1, 0},
+	}
+	if len(dto.Messages) != len(want) {
+		t.Fatalf("active branch has %d messages, want %d", len(dto.Messages), len(want))
+	}
+	for i, w := range want {
+		got := dto.Messages[i]
+		if got.ID != w.id || got.SiblingCount != w.count || got.SiblingIndex != w.index {
+			t.Errorf("message %d = %s, %d of %d, want %s, %d of %d",
+				i, got.ID, got.SiblingIndex, got.SiblingCount, w.id, w.index, w.count)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kintsugi-ai/backend/internal/modules/search"
+	"github.com/kintsugi-ai/backend/internal/modules/translation"
+)
+
+// NewCodeExecutionTool lets the model run Python or JavaScript in the
+// sandboxed Docker runner.
+func NewCodeExecutionTool(codeExec *CodeExecutionService) Tool {
+	return Tool{
+		Name:        "run_code",
+		Description: "Execute Python or JavaScript code in an isolated sandbox without network access and return stdout and stderr. Use it for calculations, data processing and verifying code.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"language": map[string]interface{}{
+					"type": "string",
+					"enum": []string{"python", "javascript"},
+				},
+				"code": map[string]interface{}{
+					"type":        "string",
+					"description": "Complete program to run. Print the results you need.",
+				},
+			},
+			"required": []string{"language", "code"},
+		},
+		Handler: func(ctx context.Context, tc ToolContext, raw json.RawMessage) (string, error) {
+			var args struct {
+				Language string `json:"language"`
+				Code     string `json:"code"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if args.Language != "python" && args.Language != "javascript" {
+				return "", errors.New("language must be python or javascript")
+			}
+
+			chatID := tc.ChatID
+			execution, err := codeExec.RunCode(tc.UserID, args.Language, args.Code, &chatID)
+			if err != nil {
+				return "", err
+			}
+
+			return toolResultJSON(map[string]interface{}{
+				"execution_id": execution.ID,
+				"status":       execution.Status,
+				"output":       execution.Output,
+				"error":        execution.Error,
+			}), nil
+		},
+	}
+}
+
+// NewTranslationTool exposes the translation service. Translations are
+// recorded and billed like the ones made from the translation page.
+func NewTranslationTool(translator *translation.Service) Tool {
+	return Tool{
+		Name:        "translate_text",
+		Description: "Translate text with a professional translation engine. Use it when the user asks for a translation of a longer text or needs a high quality translation.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"text": map[string]interface{}{
+					"type": "string",
+				},
+				"source_language": map[string]interface{}{
+					"type":        "string",
+					"description": "ISO 639-1 code of the source language, e.g. en",
+				},
+				"target_language": map[string]interface{}{
+					"type":        "string",
+					"description": "ISO 639-1 code of the target language, e.g. uk",
+				},
+			},
+			"required": []string{"text", "source_language", "target_language"},
+		},
+		Handler: func(ctx context.Context, tc ToolContext, raw json.RawMessage) (string, error) {
+			var req translation.TranslationRequest
+			if err := json.Unmarshal(raw, &req); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if req.Text == "" || req.TargetLanguage == "" {
+				return "", errors.New("text and target_language are required")
+			}
+			req.Service = "deepl"
+
+			result, err := translator.Translate(tc.UserID, &req)
+			if err != nil {
+				return "", err
+			}
+
+			return toolResultJSON(map[string]interface{}{
+				"translation_id":  result.ID,
+				"translated_text": result.TranslatedText,
+				"target_language": result.TargetLanguage,
+			}), nil
+		},
+	}
+}
+
+// NewSearchTool lets the model look up the user's own chats, messages,
+// translations and files.
+func NewSearchTool(searcher *search.Service) Tool {
+	return Tool{
+		Name:        "search_workspace",
+		Description: "Search the user's previous chats, messenger conversations, translations and files. Use it when the user refers to something discussed or created earlier.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type": "string",
+				},
+				"modules": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "string",
+						"enum": []string{"chat", "messenger", "translation", "files"},
+					},
+					"description": "Where to search; all modules when omitted",
+				},
+				"limit": map[string]interface{}{
+					"type":    "integer",
+					"minimum": 1,
+					"maximum": 20,
+				},
+			},
+			"required": []string{"query"},
+		},
+		Handler: func(ctx context.Context, tc ToolContext, raw json.RawMessage) (string, error) {
+			var req search.SearchRequest
+			if err := json.Unmarshal(raw, &req); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			if req.Query == "" {
+				return "", errors.New("query is required")
+			}
+			if req.Limit <= 0 || req.Limit > 20 {
+				req.Limit = 10
+			}
+
+			resp, err := searcher.GlobalSearch(tc.UserID, req)
+			if err != nil {
+				return "", err
+			}
+
+			results := make([]map[string]interface{}, 0, len(resp.Results))
+			for _, r := range resp.Results {
+				results = append(results, map[string]interface{}{
+					"type":       r.Type,
+					"title":      r.Title,
+					"content":    truncateRunes(r.Content, 500),
+					"url":        r.URL,
+					"created_at": r.CreatedAt,
+				})
+			}
+
+			return toolResultJSON(map[string]interface{}{
+				"total":   resp.Total,
+				"results": results,
+			}), nil
+		},
+	}
+}
+
+// NewMemoryTool lets the model save facts about the user for later chats.
+func NewMemoryTool(memory *MemoryService) Tool {
+	return Tool{
+		Name:        memoryToolName,
+		Description: "Save a lasting fact about the user, such as their name, preferences, projects or circumstances, so it is remembered in future conversations. Only save what the user would want remembered, one short self-contained fact per call.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"fact": map[string]interface{}{
+					"type":        "string",
+					"description": "The fact, written in the third person, e.g. \"Prefers answers in Ukrainian\".",
+				},
+			},
+			"required": []string{"fact"},
+		},
+		Handler: func(ctx context.Context, tc ToolContext, raw json.RawMessage) (string, error) {
+			var args struct {
+				Fact string `json:"fact"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+
+			enabled, err := memory.Enabled(tc.UserID)
+			if err != nil {
+				return "", err
+			}
+			if !enabled {
+				return "", ErrMemoryDisabled
+			}
+
+			chatID := tc.ChatID
+			saved, err := memory.CreateMemory(ctx, tc.UserID, args.Fact, MemorySourceAssistant, &chatID)
+			if err != nil {
+				return "", err
+			}
+			return toolResultJSON(map[string]interface{}{
+				"saved":     true,
+				"memory_id": saved.ID,
+			}), nil
+		},
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -56,7 +56,31 @@ func (s *CodeExecutionService) ExecuteJavaScriptCode(userID uuid.UUID, code stri
 	return s.executeCode(userID, "javascript", code, chatID)
 }
 
+// RunCode executes code and waits for the result, for callers such as chat
+// tools that need the output right away.
+func (s *CodeExecutionService) RunCode(userID uuid.UUID, language, code string, chatID *uuid.UUID) (*CodeExecution, error) {
+	execution, err := s.createExecution(userID, language, code, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.runCodeInDocker(execution)
+	return execution, nil
+}
+
 func (s *CodeExecutionService) executeCode(userID uuid.UUID, language, code string, chatID *uuid.UUID) (*CodeExecution, error) {
+	execution, err := s.createExecution(userID, language, code, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute in background
+	go s.runCodeInDocker(execution)
+
+	return execution, nil
+}
+
+func (s *CodeExecutionService) createExecution(userID uuid.UUID, language, code string, chatID *uuid.UUID) (*CodeExecution, error) {
 	// Validate input
 	if strings.TrimSpace(code) == "" {
 		return nil, errors.New("code is required")
@@ -78,9 +102,6 @@ func (s *CodeExecutionService) executeCode(userID uuid.UUID, language, code stri
 		return nil, err
 	}
 
-	// Execute in background
-	go s.runCodeInDocker(execution)
-
 	return execution, nil
 }
 
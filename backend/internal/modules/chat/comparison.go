@@ -0,0 +1,478 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// A comparison sends one prompt to several models at once. Their answers are
+// saved as sibling assistant messages of the prompt, so the chat continues
+// on whichever answer the user picks as the winner.
+
+const (
+	minCompareModels = 2
+	maxCompareModels = 4
+
+	// ChunkTypeComparison chunks open and close a comparison stream
+	ChunkTypeComparison = "comparison"
+
+	// ComparisonReplyFailed is the status of a model that gave no answer
+	ComparisonReplyFailed = "failed"
+)
+
+var ErrComparisonNotFound = errors.New("comparison not found")
+
+// Comparison is one prompt answered by several models.
+type Comparison struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ChatID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"chat_id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null" json:"user_id"`
+	PromptMessageID uuid.UUID  `gorm:"type:uuid;not null" json:"prompt_message_id"`
+	Models          string     `gorm:"type:text;not null" json:"-"` // JSON []string, in channel order
+	WinnerID        *uuid.UUID `gorm:"type:uuid" json:"winner_id,omitempty"`
+	CreatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (Comparison) TableName() string {
+	return "chat_comparisons"
+}
+
+type CompareRequest struct {
+	Content      string            `json:"content" validate:"required"`
+	Models       []string          `json:"models" validate:"required"`
+	SystemPrompt string            `json:"system_prompt,omitempty"`
+	Attachments  []AttachmentInput `json:"attachments,omitempty"`
+}
+
+type PickWinnerRequest struct {
+	MessageID uuid.UUID `json:"message_id" validate:"required"`
+	UseModel  bool      `json:"use_model"` // switch the chat to the winner's model
+}
+
+// ComparisonReply is the answer of one model and what it cost.
+type ComparisonReply struct {
+	Channel          int        `json:"channel"`
+	Model            string     `json:"model"`
+	MessageID        *uuid.UUID `json:"message_id,omitempty"`
+	Status           string     `json:"status"` // completed, stopped, failed
+	PromptTokens     int        `json:"prompt_tokens"`
+	CompletionTokens int        `json:"completion_tokens"`
+	TotalTokens      int        `json:"total_tokens"`
+	LatencyMs        int        `json:"latency_ms"`
+	FirstTokenMs     int        `json:"first_token_ms"`
+	Error            string     `json:"error,omitempty"`
+}
+
+type ComparisonResponse struct {
+	ID              uuid.UUID         `json:"id"`
+	ChatID          uuid.UUID         `json:"chat_id"`
+	PromptMessageID uuid.UUID         `json:"prompt_message_id"`
+	WinnerID        *uuid.UUID        `json:"winner_id,omitempty"`
+	Replies         []ComparisonReply `json:"replies"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// CompareChunk is a chunk of a comparison stream. The answers of all models
+// share the stream, each chunk carrying the channel it belongs to: the
+// position of its model in the request. The first and last chunks have the
+// comparison type and describe the whole run.
+type CompareChunk struct {
+	StreamChunk
+	Channel      int                 `json:"channel"`
+	LatencyMs    int                 `json:"latency_ms,omitempty"`     // sent with the final chunk of a channel
+	FirstTokenMs int                 `json:"first_token_ms,omitempty"` // sent with the final chunk of a channel
+	Comparison   *ComparisonResponse `json:"comparison,omitempty"`
+}
+
+// DecodeModels returns the compared models in channel order.
+func (c *Comparison) DecodeModels() []string {
+	var models []string
+	if err := json.Unmarshal([]byte(c.Models), &models); err != nil {
+		return nil
+	}
+	return models
+}
+
+// ToDTO reports the comparison with the replies found in messages. Models
+// without a saved reply failed.
+func (c *Comparison) ToDTO(messages []Message) *ComparisonResponse {
+	models := c.DecodeModels()
+	replies := make([]ComparisonReply, len(models))
+	for i, model := range models {
+		replies[i] = ComparisonReply{Channel: i, Model: model, Status: ComparisonReplyFailed}
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		if msg.ComparisonID == nil || *msg.ComparisonID != c.ID {
+			continue
+		}
+		// Models are unique within a comparison
+		var reply *ComparisonReply
+		for j := range replies {
+			if replies[j].Model == msg.Model {
+				reply = &replies[j]
+			}
+		}
+		if reply == nil {
+			continue
+		}
+		reply.MessageID = &msg.ID
+		reply.Status = msg.Status
+		reply.PromptTokens = msg.PromptTokens
+		reply.CompletionTokens = msg.Tokens
+		reply.TotalTokens = msg.PromptTokens + msg.Tokens
+		reply.LatencyMs = msg.LatencyMs
+		reply.FirstTokenMs = msg.FirstTokenMs
+	}
+
+	return &ComparisonResponse{
+		ID:              c.ID,
+		ChatID:          c.ChatID,
+		PromptMessageID: c.PromptMessageID,
+		WinnerID:        c.WinnerID,
+		Replies:         replies,
+		CreatedAt:       c.CreatedAt,
+	}
+}
+
+// compareModels cleans up the requested models: duplicates are dropped and
+// the user must be allowed to use each.
+func (s *Service) compareModels(userID uuid.UUID, requested []string) ([]string, error) {
+	var models []string
+	seen := make(map[string]bool)
+	for _, model := range requested {
+		model = strings.TrimSpace(model)
+		if model == "" || seen[model] {
+			continue
+		}
+		seen[model] = true
+		models = append(models, model)
+	}
+
+	if len(models) < minCompareModels || len(models) > maxCompareModels {
+		return nil, fmt.Errorf("compare between %d and %d different models", minCompareModels, maxCompareModels)
+	}
+	for _, model := range models {
+		if err := s.checkModel(userID, model); err != nil {
+			return nil, fmt.Errorf("%s: %w", model, err)
+		}
+	}
+	return models, nil
+}
+
+// CompareModels saves a prompt on the chat's active branch and streams the
+// answers of all requested models concurrently. Each model answers on its
+// own, without fallbacks or tools. When all are done the first answer in
+// request order becomes the active branch until a winner is picked.
+//
+// Each answer can be stopped through StopGeneration with its message ID.
+func (s *Service) CompareModels(ctx context.Context, chatID, userID uuid.UUID, req *CompareRequest) (<-chan CompareChunk, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	models, err := s.compareModels(userID, req.Models)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ensureTokenCapacity(userID); err != nil {
+		return nil, err
+	}
+
+	// Every model gets the same prompt, cut to the smallest context window
+	// so none of them sees more of the conversation
+	promptChat := *chat
+	promptChat.Model = models[0]
+	vision := true
+	for _, model := range models {
+		if s.contextWindow(model) < s.contextWindow(promptChat.Model) {
+			promptChat.Model = model
+		}
+		vision = vision && s.supportsVision(model)
+	}
+
+	if len(req.Attachments) > 0 && !vision {
+		return nil, fmt.Errorf("%w: compare vision models only to send images", ErrModelNoVision)
+	}
+	verdict, err := s.moderatePrompt(ctx, chatID, userID, req.Content)
+	if err != nil {
+		return nil, err
+	}
+	attachments, attachmentTokens, err := s.prepareAttachments(ctx, &promptChat, userID, req.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	userMessage := &Message{
+		ChatID:   chatID,
+		ParentID: chat.leafID(),
+		Role:     "user",
+		Content:  req.Content,
+		Tokens:   tokenizer.Count(chat.Model, req.Content) + attachmentTokens,
+	}
+	if err := s.repo.CreateMessageWithAttachments(userMessage, attachments); err != nil {
+		return nil, err
+	}
+	s.recordPrompt(verdict, userID, userMessage)
+	if err := s.repo.SetActiveLeaf(chatID, userMessage.ID); err != nil {
+		return nil, err
+	}
+
+	encoded, _ := json.Marshal(models)
+	comparison := &Comparison{
+		ChatID:          chatID,
+		UserID:          userID,
+		PromptMessageID: userMessage.ID,
+		Models:          string(encoded),
+	}
+	if err := s.repo.CreateComparison(comparison); err != nil {
+		return nil, err
+	}
+
+	messages, err := s.repo.GetChatMessages(chatID)
+	if err != nil {
+		return nil, err
+	}
+	history := activePath(messages, &userMessage.ID)
+	if vision {
+		s.loadAttachmentImages(ctx, &promptChat, history)
+	}
+
+	systemPrompt, err := s.composeSystemPrompt(chat, userID, req.SystemPrompt)
+	if err != nil {
+		return nil, err
+	}
+	systemPrompt = withMemories(systemPrompt, s.recallMemories(ctx, userID, history))
+	citations := s.retrieveCitations(ctx, chatID, history)
+	systemPrompt = withCitations(systemPrompt, citations)
+	openaiMessages := s.buildContext(ctx, &promptChat, userID, systemPrompt, history)
+
+	chunkChan := make(chan CompareChunk)
+	send := func(chunk CompareChunk) {
+		select {
+		case chunkChan <- chunk:
+		case <-ctx.Done():
+		}
+	}
+
+	// Message IDs are chosen up front so the opening chunk can announce them
+	replies := make([]ComparisonReply, len(models))
+	for i, model := range models {
+		messageID := uuid.New()
+		replies[i] = ComparisonReply{Channel: i, Model: model, MessageID: &messageID}
+	}
+	response := &ComparisonResponse{
+		ID:              comparison.ID,
+		ChatID:          chatID,
+		PromptMessageID: userMessage.ID,
+		Replies:         replies,
+		CreatedAt:       comparison.CreatedAt,
+	}
+
+	go func() {
+		defer close(chunkChan)
+
+		send(CompareChunk{
+			StreamChunk: StreamChunk{Type: ChunkTypeComparison, MessageID: userMessage.ID.String()},
+			Comparison:  cloneComparison(response),
+		})
+
+		var wg sync.WaitGroup
+		for i := range replies {
+			wg.Add(1)
+			go func(reply *ComparisonReply) {
+				defer wg.Done()
+				s.compareReply(ctx, chat, userID, comparison.ID, userMessage.ID, reply, openaiMessages, history, citations, send)
+			}(&replies[i])
+		}
+		wg.Wait()
+
+		// Continue on the first answer until the user picks one
+		for _, reply := range replies {
+			if reply.Status != ComparisonReplyFailed {
+				s.repo.SetActiveLeaf(chatID, *reply.MessageID)
+				break
+			}
+		}
+
+		send(CompareChunk{
+			StreamChunk: StreamChunk{Type: ChunkTypeComparison, MessageID: userMessage.ID.String(), Done: true},
+			Comparison:  cloneComparison(response),
+		})
+	}()
+
+	return chunkChan, nil
+}
+
+// compareReply streams one model's answer on its channel and saves it as a
+// reply to the prompt. The outcome is recorded in reply.
+func (s *Service) compareReply(ctx context.Context, chat *Chat, userID, comparisonID, promptID uuid.UUID, reply *ComparisonReply, messages []openai.ChatCompletionMessage, history []Message, citations []Citation, send func(CompareChunk)) {
+	messageID := *reply.MessageID
+	genCtx, gen := s.generations.start(ctx, chat.ID, userID, messageID)
+	defer s.generations.finish(gen)
+
+	started := time.Now()
+	var firstToken time.Duration
+	forward := func(chunk StreamChunk) {
+		if firstToken == 0 {
+			firstToken = time.Since(started)
+		}
+		chunk.Model = reply.Model
+		send(CompareChunk{StreamChunk: chunk, Channel: reply.Channel})
+	}
+
+	promptTokens := tokenizer.CountMessages(reply.Model, messages) + sentImageTokens(history, messages)
+	streamReq := openai.ChatCompletionRequest{
+		Messages:      messages,
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	}
+
+	stream, _, err := s.providers.CreateChatCompletionStream(genCtx, []string{reply.Model}, streamReq)
+	if err != nil {
+		stream = failedStream{err: err}
+	}
+	turn, err := receiveTurn(stream, reply.Model, promptTokens, messageID, forward)
+	latency := time.Since(started)
+
+	reply.PromptTokens = turn.usage.PromptTokens
+	reply.CompletionTokens = turn.usage.CompletionTokens
+	reply.TotalTokens = turn.usage.TotalTokens
+	reply.LatencyMs = int(latency.Milliseconds())
+	reply.FirstTokenMs = int(firstToken.Milliseconds())
+	s.addTokenUsage(userID, turn.usage.TotalTokens)
+
+	// Stopped answers are kept like in chats, failed ones are not
+	if err != nil && (genCtx.Err() == nil || turn.content == "") {
+		reply.Status = ComparisonReplyFailed
+		chunk := StreamChunk{
+			Type:      ChunkTypeContent,
+			MessageID: messageID.String(),
+			Done:      true,
+			Model:     reply.Model,
+			Stopped:   genCtx.Err() != nil,
+		}
+		if !chunk.Stopped {
+			reply.Error = err.Error()
+			chunk.Delta = fmt.Sprintf("Error: %v", err)
+		}
+		send(CompareChunk{StreamChunk: chunk, Channel: reply.Channel})
+		return
+	}
+
+	reply.Status = MessageStatusCompleted
+	if err != nil {
+		reply.Status = MessageStatusStopped
+	}
+	message := &Message{
+		ID:           messageID,
+		ChatID:       chat.ID,
+		ParentID:     &promptID,
+		Role:         "assistant",
+		Content:      turn.content,
+		Tokens:       turn.usage.CompletionTokens,
+		Model:        reply.Model,
+		Status:       reply.Status,
+		Citations:    encodeCitations(citations),
+		ComparisonID: &comparisonID,
+		PromptTokens: turn.usage.PromptTokens,
+		LatencyMs:    reply.LatencyMs,
+		FirstTokenMs: reply.FirstTokenMs,
+	}
+	blocked := reply.Status == MessageStatusCompleted && s.moderateReply(genCtx, userID, message)
+	s.repo.CreateMessage(message)
+
+	send(CompareChunk{
+		StreamChunk: StreamChunk{
+			Type:             ChunkTypeContent,
+			MessageID:        messageID.String(),
+			Done:             true,
+			Model:            reply.Model,
+			Stopped:          reply.Status == MessageStatusStopped,
+			Blocked:          blocked,
+			PromptTokens:     turn.usage.PromptTokens,
+			CompletionTokens: turn.usage.CompletionTokens,
+			TotalTokens:      turn.usage.TotalTokens,
+			Citations:        citations,
+		},
+		Channel:      reply.Channel,
+		LatencyMs:    reply.LatencyMs,
+		FirstTokenMs: reply.FirstTokenMs,
+	})
+}
+
+// cloneComparison copies a comparison whose replies are still being written.
+func cloneComparison(c *ComparisonResponse) *ComparisonResponse {
+	clone := *c
+	clone.Replies = append([]ComparisonReply(nil), c.Replies...)
+	return &clone
+}
+
+// GetComparison returns a comparison of one of the user's chats.
+func (s *Service) GetComparison(chatID, comparisonID, userID uuid.UUID) (*ComparisonResponse, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison, err := s.repo.GetComparison(comparisonID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return comparison.ToDTO(chat.Messages), nil
+}
+
+// PickComparisonWinner records the answer the user preferred and continues
+// the chat from it, optionally with its model.
+func (s *Service) PickComparisonWinner(chatID, comparisonID, userID uuid.UUID, req *PickWinnerRequest) (*Chat, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison, err := s.repo.GetComparison(comparisonID, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	winner := findMessage(chat.Messages, req.MessageID)
+	if winner == nil || winner.ComparisonID == nil || *winner.ComparisonID != comparison.ID {
+		return nil, errors.New("message is not an answer of this comparison")
+	}
+
+	if req.UseModel && winner.Model != chat.Model {
+		if err := s.checkModel(userID, winner.Model); err != nil {
+			return nil, err
+		}
+		chat.Model = winner.Model
+		if err := s.repo.UpdateChat(chatID, map[string]interface{}{"model": chat.Model}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.SetComparisonWinner(comparison.ID, winner.ID); err != nil {
+		return nil, err
+	}
+
+	// The user may have continued below the winner already
+	leaf := newMessageTree(chat.Messages).latestLeaf(winner.ID)
+	if err := s.repo.SetActiveLeaf(chatID, leaf); err != nil {
+		return nil, err
+	}
+
+	chat.ActiveLeafID = &leaf
+	return chat, nil
+}
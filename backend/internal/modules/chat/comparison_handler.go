@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// CompareModels streams the answers of several models to one prompt as
+// server-sent events, see CompareChunk.
+func (h *Handler) CompareModels(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	var req CompareRequest
+	if err := c.BodyParser(&req); err != nil || req.Content == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunkChan, err := h.service.CompareModels(ctx, chatID, userID, &req)
+	if err != nil {
+		cancel()
+		return c.Status(modelErrorStatus(err, fiber.StatusBadRequest)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return streamChunks(c, chunkChan, cancel)
+}
+
+func (h *Handler) GetComparison(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	comparisonID, err := uuid.Parse(c.Params("comparisonId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid comparison ID",
+		})
+	}
+
+	comparison, err := h.service.GetComparison(chatID, comparisonID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(comparison)
+}
+
+// PickComparisonWinner continues the chat from the chosen answer.
+func (h *Handler) PickComparisonWinner(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	comparisonID, err := uuid.Parse(c.Params("comparisonId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid comparison ID",
+		})
+	}
+
+	var req PickWinnerRequest
+	if err := c.BodyParser(&req); err != nil || req.MessageID == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	chat, err := h.service.PickComparisonWinner(chatID, comparisonID, userID, &req)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, ErrComparisonNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(modelErrorStatus(err, status)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(chat.ToDTO())
+}
@@ -0,0 +1,330 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// Context strategies decide what happens when a chat outgrows the model's
+// context window.
+const (
+	ContextStrategySummarize = "summarize" // older turns replaced by a rolling summary
+	ContextStrategyTruncate  = "truncate"  // older turns dropped
+	ContextStrategyFull      = "full"      // history sent as-is
+)
+
+const (
+	defaultContextWindow   = 8192
+	maxCompletionReserve   = 4096
+	defaultSummaryModel    = "gpt-4o-mini"
+	summaryPromptPrefix    = "Summary of the earlier part of this conversation:\n\n"
+	summarizerInstructions = "You maintain a running summary of a conversation between a user and an AI assistant. " +
+		"Merge the previous summary with the new messages into one concise summary. Preserve facts, decisions, " +
+		"names, numbers, code identifiers and open questions. Write in the conversation's language. " +
+		"Reply with the summary only."
+)
+
+// modelContextWindows maps model name prefixes to their context window in tokens.
+var modelContextWindows = map[string]int{
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"gpt-4-turbo":   128000,
+	"gpt-4":         8192,
+	"gpt-3.5-turbo": 16385,
+	"chatgpt-4o":    128000,
+	"o1":            200000,
+	"o3":            200000,
+	"o4":            200000,
+	"claude-":       200000,
+}
+
+func IsValidContextStrategy(strategy string) bool {
+	switch strategy {
+	case ContextStrategySummarize, ContextStrategyTruncate, ContextStrategyFull:
+		return true
+	}
+	return false
+}
+
+// ContextWindow returns the context window for a model, matching the longest
+// known prefix. Provider namespaces such as "ollama/" are ignored.
+func ContextWindow(model string) int {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+
+	prefixes := make([]string, 0, len(modelContextWindows))
+	for prefix := range modelContextWindows {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(model, prefix) {
+			return modelContextWindows[prefix]
+		}
+	}
+	return defaultContextWindow
+}
+
+// promptBudget is the part of the window available for the prompt, leaving
+// room for the completion.
+func promptBudget(window int) int {
+	reserve := window / 4
+	if reserve > maxCompletionReserve {
+		reserve = maxCompletionReserve
+	}
+	return window - reserve
+}
+
+func toOpenAIMessage(msg Message) openai.ChatCompletionMessage {
+	switch msg.Role {
+	case "assistant":
+		out := openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: msg.Content,
+		}
+		for _, call := range msg.DecodeToolCalls() {
+			out.ToolCalls = append(out.ToolCalls, openai.ToolCall{
+				ID:   call.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			})
+		}
+		return out
+	case "tool":
+		return openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+	case "system":
+		return openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: msg.Content,
+		}
+	default:
+		if len(msg.images) == 0 {
+			return openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: msg.Content,
+			}
+		}
+
+		// Images make the content multi-part; Content must then stay empty
+		var parts []openai.ChatMessagePart
+		if msg.Content != "" {
+			parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: msg.Content})
+		}
+		for i := range msg.images {
+			parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &msg.images[i]})
+		}
+		return openai.ChatCompletionMessage{
+			Role:         openai.ChatMessageRoleUser,
+			MultiContent: parts,
+		}
+	}
+}
+
+func toOpenAIMessages(messages []Message) []openai.ChatCompletionMessage {
+	out := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, msg := range messages {
+		out = append(out, toOpenAIMessage(msg))
+	}
+	return out
+}
+
+// buildContext turns a chat's history into the message list sent to the
+// model, applying the chat's context strategy so the prompt fits the model's
+// window. The system prompt and the most recent turn are always kept.
+func (s *Service) buildContext(ctx context.Context, chat *Chat, userID uuid.UUID, systemPrompt string, history []Message) []openai.ChatCompletionMessage {
+	var system []openai.ChatCompletionMessage
+	if systemPrompt != "" {
+		system = append(system, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		})
+	}
+
+	strategy := chat.ContextStrategy
+	if strategy == "" {
+		strategy = ContextStrategySummarize
+	}
+
+	if strategy == ContextStrategyFull {
+		return append(system, toOpenAIMessages(history)...)
+	}
+
+	budget := promptBudget(s.contextWindow(chat.Model))
+
+	if strategy == ContextStrategySummarize {
+		messages, err := s.buildSummarizedContext(ctx, chat, userID, system, history, budget)
+		if err == nil {
+			return messages
+		}
+		log.Printf("Chat %s: summarization failed, truncating instead: %v", chat.ID, err)
+	}
+
+	recent := tailWithinBudget(chat.Model, history, budget-tokenizer.CountMessages(chat.Model, system))
+	return append(system, toOpenAIMessages(recent)...)
+}
+
+// buildSummarizedContext keeps the turns after the stored summary when they
+// fit. Otherwise it folds the older half of those turns into the summary, so
+// the next few turns fit again without another summarization call.
+func (s *Service) buildSummarizedContext(ctx context.Context, chat *Chat, userID uuid.UUID, system []openai.ChatCompletionMessage, history []Message, budget int) ([]openai.ChatCompletionMessage, error) {
+	summary, err := s.repo.GetChatSummary(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := history
+	if summary != nil {
+		var found bool
+		if pending, found = messagesAfter(history, summary.LastMessageID); !found {
+			// The summary covers messages beyond this history (e.g. when
+			// regenerating an older reply), so it cannot be used here
+			summary = nil
+		}
+	}
+
+	assemble := func(summary *ChatSummary, recent []Message) []openai.ChatCompletionMessage {
+		out := append([]openai.ChatCompletionMessage{}, system...)
+		if summary != nil {
+			out = append(out, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: summaryPromptPrefix + summary.Content,
+			})
+		}
+		return append(out, toOpenAIMessages(recent)...)
+	}
+
+	messages := assemble(summary, pending)
+	if tokenizer.CountMessages(chat.Model, messages)+imageTokens(pending) <= budget {
+		return messages, nil
+	}
+
+	fixed := tokenizer.CountMessages(chat.Model, assemble(summary, nil))
+	recent := tailWithinBudget(chat.Model, pending, (budget-fixed)/2)
+	older := pending[:len(pending)-len(recent)]
+	if len(older) == 0 {
+		return assemble(summary, recent), nil
+	}
+
+	summary, err = s.summarize(ctx, chat, userID, summary, older)
+	if err != nil {
+		return nil, err
+	}
+
+	return assemble(summary, recent), nil
+}
+
+// summarize merges older turns into the chat's rolling summary and stores it.
+func (s *Service) summarize(ctx context.Context, chat *Chat, userID uuid.UUID, previous *ChatSummary, older []Message) (*ChatSummary, error) {
+	// The chat's own model, checked by the caller, stands in when the user
+	// may not use the summary model
+	model := os.Getenv("CHAT_SUMMARY_MODEL")
+	if model == "" {
+		model = defaultSummaryModel
+	}
+	if s.checkModel(userID, model) != nil {
+		model = chat.Model
+	}
+
+	var transcript strings.Builder
+	if previous != nil {
+		transcript.WriteString("Previous summary:\n")
+		transcript.WriteString(previous.Content)
+		transcript.WriteString("\n\n")
+	}
+	transcript.WriteString("New messages:\n")
+	for _, msg := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, msg.Content)
+	}
+
+	req := openai.ChatCompletionRequest{
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summarizerInstructions},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	}
+
+	resp, model, err := s.providers.CreateChatCompletion(ctx, s.modelChain(userID, model), req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return nil, errors.New("summarizer returned an empty response")
+	}
+
+	content := strings.TrimSpace(resp.Choices[0].Message.Content)
+	usage := resolveUsage(&resp.Usage, model, tokenizer.CountMessages(model, req.Messages), content)
+	s.addTokenUsage(userID, usage.TotalTokens)
+
+	last := older[len(older)-1]
+	summary := &ChatSummary{
+		ChatID:          chat.ID,
+		Content:         content,
+		LastMessageID:   last.ID,
+		SummarizedUntil: last.CreatedAt,
+		MessageCount:    len(older),
+		Tokens:          tokenizer.Count(chat.Model, content),
+	}
+	if previous != nil {
+		summary.MessageCount += previous.MessageCount
+	}
+
+	if err := s.repo.SaveChatSummary(summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// tailWithinBudget returns the longest suffix of history that fits in budget
+// tokens, always including at least the last message.
+func tailWithinBudget(model string, history []Message, budget int) []Message {
+	if len(history) == 0 {
+		return history
+	}
+
+	used := 0
+	start := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		cost := tokenizer.CountMessages(model, []openai.ChatCompletionMessage{toOpenAIMessage(history[i])}) + imageTokens(history[i:i+1])
+		if used+cost > budget && start < len(history) {
+			break
+		}
+		used += cost
+		start = i
+	}
+
+	// Tool results are only valid right after the call that requested them
+	for start < len(history)-1 && history[start].Role == "tool" {
+		start++
+	}
+	return history[start:]
+}
+
+// messagesAfter returns the messages following the one with the given ID, or
+// the whole history if it is not found.
+func messagesAfter(history []Message, id uuid.UUID) ([]Message, bool) {
+	for i, msg := range history {
+		if msg.ID == id {
+			return history[i+1:], true
+		}
+	}
+	return history, false
+}
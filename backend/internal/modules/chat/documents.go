@@ -0,0 +1,172 @@
+package chat
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/textextract"
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// Document statuses
+const (
+	DocumentStatusProcessing = "processing"
+	DocumentStatusReady      = "ready"
+	DocumentStatusFailed     = "failed"
+)
+
+const (
+	// documentChunkTokens is the target size of an embedded chunk.
+	documentChunkTokens = 400
+
+	// documentChunkOverlap is how much of the previous chunk is repeated at
+	// the start of the next one, so sentences on a boundary keep context.
+	documentChunkOverlap = 60
+
+	// documentNamespace marks document chunks in the vector store.
+	documentNamespace = "document"
+)
+
+// Document is a file uploaded by a user whose text is chunked and embedded
+// for retrieval in the chats it is attached to.
+type Document struct {
+	ID         uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	FileName   string         `gorm:"type:varchar(255);not null" json:"file_name"`
+	FileType   string         `gorm:"type:varchar(100)" json:"file_type"` // MIME type
+	Format     string         `gorm:"type:varchar(20)" json:"format"`     // pdf, docx, markdown, text
+	FileSize   int64          `gorm:"type:bigint" json:"file_size"`
+	StorageKey string         `gorm:"type:varchar(500);not null" json:"-"`
+	Status     string         `gorm:"type:varchar(20);default:'processing'" json:"status"` // processing, ready, failed
+	Error      string         `gorm:"type:text" json:"error,omitempty"`
+	ChunkCount int            `gorm:"default:0" json:"chunk_count"`
+	Tokens     int            `gorm:"default:0" json:"tokens"`
+	CreatedAt  time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt  time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// ChatDocument attaches a document to a chat
+type ChatDocument struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ChatID     uuid.UUID `gorm:"type:uuid;not null;index" json:"chat_id"`
+	DocumentID uuid.UUID `gorm:"type:uuid;not null;index" json:"document_id"`
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// Citation is a document excerpt given to the model for a reply. Index is
+// the number the model uses to cite it, e.g. [1].
+type Citation struct {
+	Index      int       `json:"index"`
+	DocumentID uuid.UUID `json:"document_id"`
+	FileName   string    `json:"file_name"`
+	Page       int       `json:"page,omitempty"`
+	Excerpt    string    `json:"excerpt"`
+	Score      float64   `json:"score"`
+}
+
+type AttachDocumentRequest struct {
+	DocumentID uuid.UUID `json:"document_id" validate:"required"`
+}
+
+// documentChunk is a piece of document text ready to be embedded.
+type documentChunk struct {
+	Page   int
+	Text   string
+	Tokens int
+}
+
+// chunkMetadata is stored with each chunk in the vector store.
+type chunkMetadata struct {
+	FileName string `json:"file_name"`
+	Page     int    `json:"page,omitempty"`
+}
+
+// chunkSections splits extracted text into chunks of about
+// documentChunkTokens tokens. Paragraphs are kept together where possible
+// and chunks never span PDF pages.
+func chunkSections(sections []textextract.Section, model string) []documentChunk {
+	var chunks []documentChunk
+	for _, section := range sections {
+		var current []string
+		currentTokens := 0
+
+		flush := func() {
+			text := strings.TrimSpace(strings.Join(current, "\n\n"))
+			if text != "" {
+				chunks = append(chunks, documentChunk{Page: section.Page, Text: text, Tokens: currentTokens})
+			}
+			current, currentTokens = nil, 0
+		}
+
+		for _, paragraph := range splitParagraphs(section.Text, model) {
+			tokens := tokenizer.Count(model, paragraph)
+			if currentTokens > 0 && currentTokens+tokens > documentChunkTokens {
+				last := current[len(current)-1]
+				flush()
+				// Carry a short trailing paragraph into the next chunk
+				if lastTokens := tokenizer.Count(model, last); lastTokens <= documentChunkOverlap {
+					current, currentTokens = []string{last}, lastTokens
+				}
+			}
+			current = append(current, paragraph)
+			currentTokens += tokens
+		}
+		flush()
+	}
+	return chunks
+}
+
+// splitParagraphs returns the paragraphs of text, cutting any paragraph
+// longer than a chunk into overlapping word windows.
+func splitParagraphs(text, model string) []string {
+	var out []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if tokenizer.Count(model, paragraph) <= documentChunkTokens {
+			out = append(out, paragraph)
+			continue
+		}
+
+		// Roughly three words per four tokens
+		words := strings.Fields(paragraph)
+		size := documentChunkTokens * 3 / 4
+		step := size - documentChunkOverlap*3/4
+		for start := 0; start < len(words); start += step {
+			end := start + size
+			if end > len(words) {
+				end = len(words)
+			}
+			out = append(out, strings.Join(words[start:end], " "))
+			if end == len(words) {
+				break
+			}
+		}
+	}
+	return out
+}
+
+func encodeCitations(citations []Citation) string {
+	if len(citations) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(citations)
+	return string(data)
+}
+
+// DecodeCitations returns the document excerpts the message was based on.
+func (m *Message) DecodeCitations() []Citation {
+	if m.Citations == "" {
+		return nil
+	}
+	var citations []Citation
+	json.Unmarshal([]byte(m.Citations), &citations)
+	return citations
+}
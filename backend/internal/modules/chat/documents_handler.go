@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// maxDocumentSize limits uploaded documents to 20MB.
+const maxDocumentSize = 20 * 1024 * 1024
+
+type DocumentsHandler struct {
+	service *DocumentsService
+}
+
+func NewDocumentsHandler(service *DocumentsService) *DocumentsHandler {
+	return &DocumentsHandler{service: service}
+}
+
+// UploadDocument accepts a multipart "file" and an optional "chat_id" to
+// attach the document to.
+func (h *DocumentsHandler) UploadDocument(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is required",
+		})
+	}
+	if fileHeader.Size > maxDocumentSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "File is too large, maximum size is 20MB",
+		})
+	}
+
+	var chatID *uuid.UUID
+	if value := c.FormValue("chat_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid chat ID",
+			})
+		}
+		chatID = &id
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxDocumentSize))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	document, err := h.service.UploadDocument(userID, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), data, chatID)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, ErrDocumentsDisabled) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(document)
+}
+
+func (h *DocumentsHandler) GetUserDocuments(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	documents, err := h.service.GetUserDocuments(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(documents)
+}
+
+func (h *DocumentsHandler) GetDocument(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	document, err := h.service.GetDocument(documentID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(document)
+}
+
+func (h *DocumentsHandler) DeleteDocument(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	documentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	if err := h.service.DeleteDocument(documentID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Document deleted successfully",
+	})
+}
+
+func (h *DocumentsHandler) GetChatDocuments(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	documents, err := h.service.GetChatDocuments(chatID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(documents)
+}
+
+func (h *DocumentsHandler) AttachDocumentToChat(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	var req AttachDocumentRequest
+	if err := c.BodyParser(&req); err != nil || req.DocumentID == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.service.AttachDocumentToChat(chatID, req.DocumentID, userID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Document attached to chat successfully",
+	})
+}
+
+func (h *DocumentsHandler) DetachDocumentFromChat(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	documentID, err := uuid.Parse(c.Params("documentId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid document ID",
+		})
+	}
+
+	if err := h.service.DetachDocumentFromChat(chatID, documentID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Document removed from chat successfully",
+	})
+}
@@ -0,0 +1,374 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/storage"
+	"github.com/kintsugi-ai/backend/internal/textextract"
+	"github.com/kintsugi-ai/backend/internal/vectorstore"
+)
+
+const (
+	// documentTopK is how many chunks are given to the model per reply.
+	documentTopK = 5
+
+	// documentMinScore drops chunks that are barely related to the question.
+	documentMinScore = 0.2
+
+	// documentProcessTimeout bounds extraction and embedding of one upload.
+	documentProcessTimeout = 10 * time.Minute
+
+	documentContextPrefix = "The user attached documents to this chat. The excerpts below were retrieved for the latest message. Use them when they are relevant and cite them inline by number in square brackets, e.g. [1]. If they do not contain the answer, say so instead of guessing."
+)
+
+var ErrDocumentsDisabled = errors.New("document search is not configured")
+
+type DocumentsService struct {
+	db       *gorm.DB
+	storage  storage.Storage
+	embedder llm.Embedder
+	vectors  *vectorstore.Store
+}
+
+func NewDocumentsService(db *gorm.DB, store storage.Storage, embedder llm.Embedder) *DocumentsService {
+	return &DocumentsService{
+		db:       db,
+		storage:  store,
+		embedder: embedder,
+		vectors:  vectorstore.New(db),
+	}
+}
+
+// UploadDocument stores a file and starts extracting and embedding it in
+// the background. When chatID is set the document is attached to that chat.
+func (s *DocumentsService) UploadDocument(userID uuid.UUID, fileName, contentType string, data []byte, chatID *uuid.UUID) (*Document, error) {
+	if s.embedder == nil {
+		return nil, ErrDocumentsDisabled
+	}
+
+	format, err := textextract.DetectFormat(fileName, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if chatID != nil {
+		if err := s.checkChatOwner(*chatID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	id := uuid.New()
+	document := &Document{
+		ID:         id,
+		UserID:     userID,
+		FileName:   filepath.Base(fileName),
+		FileType:   contentType,
+		Format:     format,
+		FileSize:   int64(len(data)),
+		StorageKey: fmt.Sprintf("documents/%s/%s%s", userID, id, strings.ToLower(filepath.Ext(fileName))),
+		Status:     DocumentStatusProcessing,
+	}
+
+	if err := s.storage.Put(context.Background(), document.StorageKey, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+	if err := s.db.Create(document).Error; err != nil {
+		s.storage.Delete(context.Background(), document.StorageKey)
+		return nil, err
+	}
+	if chatID != nil {
+		if err := s.db.Create(&ChatDocument{ChatID: *chatID, DocumentID: document.ID}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	go s.processDocument(document, data)
+
+	return document, nil
+}
+
+// processDocument extracts, chunks and embeds a document and records the
+// outcome on it.
+func (s *DocumentsService) processDocument(document *Document, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), documentProcessTimeout)
+	defer cancel()
+
+	chunkCount, tokens, err := s.indexDocument(ctx, document, data)
+	updates := map[string]interface{}{
+		"status":      DocumentStatusReady,
+		"error":       "",
+		"chunk_count": chunkCount,
+		"tokens":      tokens,
+		"updated_at":  time.Now(),
+	}
+	if err != nil {
+		log.Printf("Document %s: processing failed: %v", document.ID, err)
+		updates["status"] = DocumentStatusFailed
+		updates["error"] = err.Error()
+		updates["chunk_count"] = 0
+	}
+
+	if err := s.db.Model(&Document{}).Where("id = ?", document.ID).Updates(updates).Error; err != nil {
+		log.Printf("Document %s: failed to save status: %v", document.ID, err)
+	}
+}
+
+func (s *DocumentsService) indexDocument(ctx context.Context, document *Document, data []byte) (int, int, error) {
+	sections, err := textextract.Extract(document.Format, data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	chunks := chunkSections(sections, s.embedder.Model())
+	if len(chunks) == 0 {
+		return 0, 0, errors.New("document contains no text")
+	}
+
+	texts := make([]string, len(chunks))
+	tokens := 0
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+		tokens += chunk.Tokens
+	}
+
+	vectors, err := s.embedder.Embed(ctx, texts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to embed document: %w", err)
+	}
+
+	entries := make([]vectorstore.Entry, len(chunks))
+	for i, chunk := range chunks {
+		metadata, _ := json.Marshal(chunkMetadata{FileName: document.FileName, Page: chunk.Page})
+		entries[i] = vectorstore.Entry{
+			Namespace: documentNamespace,
+			SourceID:  document.ID,
+			ScopeID:   document.ID,
+			Position:  i,
+			Content:   chunk.Text,
+			Metadata:  string(metadata),
+			Model:     s.embedder.Model(),
+			Embedding: vectors[i],
+		}
+	}
+
+	if err := s.vectors.DeleteSource(ctx, documentNamespace, document.ID); err != nil {
+		return 0, 0, err
+	}
+	if err := s.vectors.Add(ctx, entries); err != nil {
+		return 0, 0, fmt.Errorf("failed to store embeddings: %w", err)
+	}
+
+	return len(chunks), tokens, nil
+}
+
+func (s *DocumentsService) GetUserDocuments(userID uuid.UUID) ([]Document, error) {
+	var documents []Document
+	err := s.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&documents).Error
+	return documents, err
+}
+
+func (s *DocumentsService) GetDocument(documentID, userID uuid.UUID) (*Document, error) {
+	var document Document
+	err := s.db.Where("id = ? AND user_id = ?", documentID, userID).First(&document).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("document not found")
+		}
+		return nil, err
+	}
+	return &document, nil
+}
+
+// DeleteDocument removes a document, its chunks, its chat attachments and
+// the stored file.
+func (s *DocumentsService) DeleteDocument(documentID, userID uuid.UUID) error {
+	document, err := s.GetDocument(documentID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("document_id = ?", document.ID).Delete(&ChatDocument{}).Error; err != nil {
+			return err
+		}
+		if err := vectorstore.New(tx).DeleteSource(context.Background(), documentNamespace, document.ID); err != nil {
+			return err
+		}
+		return tx.Delete(document).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.Delete(context.Background(), document.StorageKey); err != nil {
+		log.Printf("Document %s: failed to delete file: %v", document.ID, err)
+	}
+	return nil
+}
+
+func (s *DocumentsService) AttachDocumentToChat(chatID, documentID, userID uuid.UUID) error {
+	if err := s.checkChatOwner(chatID, userID); err != nil {
+		return err
+	}
+	if _, err := s.GetDocument(documentID, userID); err != nil {
+		return err
+	}
+
+	var existing ChatDocument
+	err := s.db.Where("chat_id = ? AND document_id = ?", chatID, documentID).First(&existing).Error
+	if err == nil {
+		return errors.New("document already attached to this chat")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return s.db.Create(&ChatDocument{ChatID: chatID, DocumentID: documentID}).Error
+}
+
+func (s *DocumentsService) DetachDocumentFromChat(chatID, documentID, userID uuid.UUID) error {
+	if err := s.checkChatOwner(chatID, userID); err != nil {
+		return err
+	}
+
+	result := s.db.Where("chat_id = ? AND document_id = ?", chatID, documentID).Delete(&ChatDocument{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("document not attached to this chat")
+	}
+	return nil
+}
+
+func (s *DocumentsService) GetChatDocuments(chatID, userID uuid.UUID) ([]Document, error) {
+	if err := s.checkChatOwner(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	var documents []Document
+	err := s.db.Joins("JOIN chat_documents ON chat_documents.document_id = documents.id").
+		Where("chat_documents.chat_id = ?", chatID).
+		Order("chat_documents.created_at ASC").
+		Find(&documents).Error
+	return documents, err
+}
+
+// Retrieve returns the chunks of the chat's ready documents most relevant to
+// query, numbered for citation.
+func (s *DocumentsService) Retrieve(ctx context.Context, chatID uuid.UUID, query string) ([]Citation, error) {
+	if s == nil || s.embedder == nil || strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	var documentIDs []uuid.UUID
+	err := s.db.Model(&Document{}).
+		Joins("JOIN chat_documents ON chat_documents.document_id = documents.id").
+		Where("chat_documents.chat_id = ? AND documents.status = ?", chatID, DocumentStatusReady).
+		Pluck("documents.id", &documentIDs).Error
+	if err != nil || len(documentIDs) == 0 {
+		return nil, err
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+
+	matches, err := s.vectors.Search(ctx, vectorstore.Query{
+		Namespace: documentNamespace,
+		ScopeIDs:  documentIDs,
+		Model:     s.embedder.Model(),
+		Vector:    vectors[0],
+		Limit:     documentTopK,
+		MinScore:  documentMinScore,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	citations := make([]Citation, len(matches))
+	for i, match := range matches {
+		var metadata chunkMetadata
+		json.Unmarshal([]byte(match.Metadata), &metadata)
+		citations[i] = Citation{
+			Index:      i + 1,
+			DocumentID: match.SourceID,
+			FileName:   metadata.FileName,
+			Page:       metadata.Page,
+			Excerpt:    match.Content,
+			Score:      match.Score,
+		}
+	}
+	return citations, nil
+}
+
+func (s *DocumentsService) checkChatOwner(chatID, userID uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&Chat{}).Where("id = ? AND user_id = ?", chatID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("chat not found")
+	}
+	return nil
+}
+
+// SetDocuments enables retrieval from documents attached to chats.
+func (s *Service) SetDocuments(documents *DocumentsService) {
+	s.documents = documents
+}
+
+// retrieveCitations looks up document excerpts for the latest user message
+// of history. Failures only cost the reply its document context.
+func (s *Service) retrieveCitations(ctx context.Context, chatID uuid.UUID, history []Message) []Citation {
+	query := ""
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			query = history[i].Content
+			break
+		}
+	}
+
+	citations, err := s.documents.Retrieve(ctx, chatID, query)
+	if err != nil {
+		log.Printf("Chat %s: document retrieval failed: %v", chatID, err)
+		return nil
+	}
+	return citations
+}
+
+// withCitations appends the retrieved excerpts to the system prompt.
+func withCitations(systemPrompt string, citations []Citation) string {
+	if len(citations) == 0 {
+		return systemPrompt
+	}
+
+	var b strings.Builder
+	if systemPrompt != "" {
+		b.WriteString(systemPrompt)
+		b.WriteString("\n\n")
+	}
+	b.WriteString(documentContextPrefix)
+	for _, citation := range citations {
+		b.WriteString(fmt.Sprintf("\n\n[%d] %s", citation.Index, citation.FileName))
+		if citation.Page > 0 {
+			b.WriteString(fmt.Sprintf(", page %d", citation.Page))
+		}
+		b.WriteString(":\n")
+		b.WriteString(citation.Excerpt)
+	}
+	return b.String()
+}
@@ -0,0 +1,325 @@
+package chat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// Export formats
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatHTML     = "html"
+	ExportFormatPDF      = "pdf"
+)
+
+var ErrUnsupportedExportFormat = errors.New("unsupported export format. Allowed: md, html, pdf")
+
+// exportDocument is one export file: a single chat or every chat of a folder.
+type exportDocument struct {
+	Title string
+	Chats []exportChat
+}
+
+type exportChat struct {
+	Title     string
+	Model     string
+	CreatedAt time.Time
+	Messages  []exportMessage
+}
+
+type exportMessage struct {
+	Role      string
+	Model     string
+	Content   string
+	CreatedAt time.Time
+}
+
+// newExportChat keeps the visible turns of the chat's active branch; tool
+// plumbing and system messages are left out.
+func newExportChat(chat *Chat) exportChat {
+	out := exportChat{
+		Title:     chat.Title,
+		Model:     chat.Model,
+		CreatedAt: chat.CreatedAt,
+	}
+	for _, msg := range chat.ActivePath() {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			continue
+		}
+		if strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		out.Messages = append(out.Messages, exportMessage{
+			Role:      msg.Role,
+			Model:     msg.Model,
+			Content:   msg.Content,
+			CreatedAt: msg.CreatedAt,
+		})
+	}
+	return out
+}
+
+// NormalizeExportFormat maps accepted format names to an Export* constant.
+func NormalizeExportFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "md", "markdown":
+		return ExportFormatMarkdown, nil
+	case "html", "htm":
+		return ExportFormatHTML, nil
+	case "pdf":
+		return ExportFormatPDF, nil
+	}
+	return "", ErrUnsupportedExportFormat
+}
+
+// ExportContentType returns the MIME type of an export format.
+func ExportContentType(format string) string {
+	switch format {
+	case ExportFormatHTML:
+		return "text/html; charset=utf-8"
+	case ExportFormatPDF:
+		return "application/pdf"
+	default:
+		return "text/markdown; charset=utf-8"
+	}
+}
+
+var fileNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// exportFileName builds a download name like "my-chat.md".
+func exportFileName(title, format string) string {
+	name := strings.Trim(fileNameUnsafe.ReplaceAllString(title, "-"), "-.")
+	if name == "" {
+		name = "chat"
+	}
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return name + "." + format
+}
+
+func renderExport(doc *exportDocument, format string) ([]byte, error) {
+	switch format {
+	case ExportFormatMarkdown:
+		return renderMarkdown(doc), nil
+	case ExportFormatHTML:
+		return renderHTML(doc)
+	case ExportFormatPDF:
+		return renderPDF(doc)
+	}
+	return nil, ErrUnsupportedExportFormat
+}
+
+func roleLabel(msg exportMessage) string {
+	if msg.Role == "user" {
+		return "User"
+	}
+	if msg.Model != "" {
+		return "Assistant (" + msg.Model + ")"
+	}
+	return "Assistant"
+}
+
+const exportTimeLayout = "2006-01-02 15:04 MST"
+
+// Markdown
+
+func renderMarkdown(doc *exportDocument) []byte {
+	var b strings.Builder
+	heading := "#"
+	if len(doc.Chats) != 1 {
+		fmt.Fprintf(&b, "# %s\n\n", doc.Title)
+		heading = "##"
+	}
+
+	for i, chat := range doc.Chats {
+		if i > 0 {
+			b.WriteString("\n---\n\n")
+		}
+		fmt.Fprintf(&b, "%s %s\n\n", heading, chat.Title)
+		fmt.Fprintf(&b, "_Model: %s · Created: %s_\n\n", chat.Model, chat.CreatedAt.Format(exportTimeLayout))
+
+		for _, msg := range chat.Messages {
+			fmt.Fprintf(&b, "%s# %s\n\n", heading, roleLabel(msg))
+			fmt.Fprintf(&b, "_%s_\n\n", msg.CreatedAt.Format(exportTimeLayout))
+			b.WriteString(strings.TrimRight(msg.Content, "\n"))
+			b.WriteString("\n\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// Code blocks
+
+// contentBlock is a run of prose or a fenced code block of a message.
+type contentBlock struct {
+	Code     bool
+	Language string
+	Text     string
+}
+
+// splitCodeBlocks separates ``` fenced code from prose. An unclosed fence
+// runs to the end of the message, as it does in chat rendering.
+func splitCodeBlocks(content string) []contentBlock {
+	var blocks []contentBlock
+	var current strings.Builder
+	inCode := false
+	language := ""
+
+	flush := func(code bool) {
+		text := current.String()
+		current.Reset()
+		if code {
+			text = strings.TrimSuffix(text, "\n")
+		} else {
+			text = strings.Trim(text, "\n")
+			if text == "" {
+				return
+			}
+		}
+		blocks = append(blocks, contentBlock{Code: code, Language: language, Text: text})
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode {
+				flush(true)
+				inCode, language = false, ""
+			} else {
+				flush(false)
+				inCode, language = true, strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			}
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+	}
+	flush(inCode)
+	return blocks
+}
+
+// HTML
+
+var exportHTMLTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"blocks": splitCodeBlocks,
+	"role":   roleLabel,
+	"time":   func(t time.Time) string { return t.Format(exportTimeLayout) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,-apple-system,sans-serif;max-width:800px;margin:0 auto;padding:24px 16px;color:#1f2328}
+h1{font-size:1.6rem}
+h2{font-size:1.3rem;margin-top:40px;border-bottom:1px solid #e5e7eb;padding-bottom:6px}
+.meta{color:#6e7781;font-size:.85rem}
+.msg{padding:12px 16px;border-radius:10px;margin:12px 0;line-height:1.5}
+.user{background:#e7f0ff}
+.assistant{background:#f6f8fa;border:1px solid #e5e7eb}
+.role{font-size:.8rem;font-weight:600;color:#57606a;margin-bottom:6px}
+.text{white-space:pre-wrap;word-wrap:break-word;margin:8px 0}
+pre{background:#0d1117;color:#e6edf3;padding:12px;border-radius:8px;overflow-x:auto;font-size:.85rem}
+.lang{display:block;color:#8b949e;font-size:.75rem;margin-bottom:6px}
+</style>
+</head>
+<body>
+{{$multi := ne (len .Chats) 1}}
+{{if $multi}}<h1>{{.Title}}</h1>{{end}}
+{{range .Chats}}
+{{if $multi}}<h2>{{.Title}}</h2>{{else}}<h1>{{.Title}}</h1>{{end}}
+<p class="meta">Model: {{.Model}} &middot; Created: {{time .CreatedAt}}</p>
+{{range .Messages}}
+<div class="msg {{.Role}}">
+<div class="role">{{role .}} &middot; {{time .CreatedAt}}</div>
+{{range blocks .Content}}{{if .Code}}<pre><code{{if .Language}} class="language-{{.Language}}"{{end}}>{{if .Language}}<span class="lang">{{.Language}}</span>{{end}}{{.Text}}</code></pre>{{else}}<div class="text">{{.Text}}</div>{{end}}
+{{end}}
+</div>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func renderHTML(doc *exportDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := exportHTMLTemplate.Execute(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PDF
+
+// renderPDF lays the chats out with the Go fonts, embedded as UTF-8 fonts
+// so Latin, Greek and Cyrillic text is printed as written.
+func renderPDF(doc *exportDocument) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(doc.Title, true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AddUTF8FontFromBytes("Go", "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes("Go", "B", gobold.TTF)
+	pdf.AddUTF8FontFromBytes("Go Mono", "", gomono.TTF)
+	width, _ := pdf.GetPageSize()
+	width -= 30
+
+	for i, chat := range doc.Chats {
+		pdf.AddPage()
+		if i == 0 && len(doc.Chats) != 1 {
+			pdf.SetFont("Go", "B", 20)
+			pdf.MultiCell(width, 9, doc.Title, "", "L", false)
+			pdf.Ln(4)
+		}
+
+		pdf.SetFont("Go", "B", 16)
+		pdf.SetTextColor(31, 35, 40)
+		pdf.MultiCell(width, 8, chat.Title, "", "L", false)
+		pdf.SetFont("Go", "", 9)
+		pdf.SetTextColor(110, 119, 129)
+		pdf.MultiCell(width, 5, fmt.Sprintf("Model: %s - Created: %s", chat.Model, chat.CreatedAt.Format(exportTimeLayout)), "", "L", false)
+		pdf.Ln(4)
+
+		for _, msg := range chat.Messages {
+			pdf.SetFont("Go", "B", 10)
+			pdf.SetTextColor(87, 96, 106)
+			pdf.MultiCell(width, 6, roleLabel(msg)+" - "+msg.CreatedAt.Format(exportTimeLayout), "", "L", false)
+			pdf.SetTextColor(31, 35, 40)
+
+			for _, block := range splitCodeBlocks(msg.Content) {
+				if block.Code {
+					pdf.SetFont("Go Mono", "", 8.5)
+					pdf.SetFillColor(240, 242, 245)
+					pdf.MultiCell(width, 4.5, expandTabs(block.Text), "", "L", true)
+				} else {
+					pdf.SetFont("Go", "", 10.5)
+					pdf.MultiCell(width, 5.5, block.Text, "", "L", false)
+				}
+				pdf.Ln(1.5)
+			}
+			pdf.Ln(3)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", "    ")
+}
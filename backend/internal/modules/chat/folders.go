@@ -62,18 +62,6 @@ type CodeExecution struct {
 	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 }
 
-// VoiceMessage represents a voice message
-type VoiceMessage struct {
-	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID       uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
-	MessageID    uuid.UUID `gorm:"type:uuid;index" json:"message_id,omitempty"` // Conversation message ID
-	FileURL      string    `gorm:"type:varchar(500);not null" json:"file_url"`
-	Duration     int       `gorm:"type:integer" json:"duration"` // Duration in seconds
-	WaveformData string    `gorm:"type:text" json:"waveform_data"` // JSON array of amplitude values
-	Transcription string   `gorm:"type:text" json:"transcription,omitempty"`
-	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-}
-
 // FileAttachment represents a file attachment
 type FileAttachment struct {
 	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Message statuses
+const (
+	MessageStatusCompleted = "completed"
+	MessageStatusStopped   = "stopped"
+)
+
+// generation is an assistant reply being streamed. It can be cancelled by
+// the user through the stop endpoint or by the client disconnecting.
+type generation struct {
+	chatID uuid.UUID
+	userID uuid.UUID
+	cancel context.CancelFunc
+}
+
+// generationRegistry indexes running generations by the IDs of the messages
+// they stream, so a stop request can name any message of the reply.
+type generationRegistry struct {
+	mu     sync.Mutex
+	active map[uuid.UUID]*generation
+}
+
+func newGenerationRegistry() *generationRegistry {
+	return &generationRegistry{active: make(map[uuid.UUID]*generation)}
+}
+
+// start registers a generation streaming messageID and returns its context.
+func (r *generationRegistry) start(parent context.Context, chatID, userID, messageID uuid.UUID) (context.Context, *generation) {
+	ctx, cancel := context.WithCancel(parent)
+	gen := &generation{chatID: chatID, userID: userID, cancel: cancel}
+
+	r.mu.Lock()
+	r.active[messageID] = gen
+	r.mu.Unlock()
+
+	return ctx, gen
+}
+
+// track adds another message streamed by gen, e.g. after a tool round.
+func (r *generationRegistry) track(gen *generation, messageID uuid.UUID) {
+	r.mu.Lock()
+	r.active[messageID] = gen
+	r.mu.Unlock()
+}
+
+// finish removes gen and releases its context.
+func (r *generationRegistry) finish(gen *generation) {
+	r.mu.Lock()
+	for id, g := range r.active {
+		if g == gen {
+			delete(r.active, id)
+		}
+	}
+	r.mu.Unlock()
+
+	gen.cancel()
+}
+
+func (r *generationRegistry) stop(chatID, messageID, userID uuid.UUID) bool {
+	r.mu.Lock()
+	gen, ok := r.active[messageID]
+	r.mu.Unlock()
+
+	if !ok || gen.chatID != chatID || gen.userID != userID {
+		return false
+	}
+	gen.cancel()
+	return true
+}
+
+// StopGeneration cancels the reply that is streaming messageID. The content
+// generated so far is kept with the "stopped" status.
+func (s *Service) StopGeneration(chatID, messageID, userID uuid.UUID) error {
+	if !s.generations.stop(chatID, messageID, userID) {
+		return errors.New("no active generation for this message")
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package chat
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
 )
 
 type Handler struct {
@@ -27,9 +31,15 @@ func (h *Handler) CreateChat(c *fiber.Ctx) error {
 		})
 	}
 
+	if req.ContextStrategy != "" && !IsValidContextStrategy(req.ContextStrategy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid context strategy. Allowed: summarize, truncate, full",
+		})
+	}
+
 	chat, err := h.service.CreateChat(userID, &req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		return c.Status(modelErrorStatus(err, fiber.StatusInternalServerError)).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
@@ -93,9 +103,15 @@ func (h *Handler) UpdateChat(c *fiber.Ctx) error {
 		})
 	}
 
+	if req.ContextStrategy != "" && !IsValidContextStrategy(req.ContextStrategy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid context strategy. Allowed: summarize, truncate, full",
+		})
+	}
+
 	chat, err := h.service.UpdateChat(chatID, userID, &req)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		return c.Status(modelErrorStatus(err, fiber.StatusNotFound)).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
@@ -139,29 +155,17 @@ func (h *Handler) SendMessage(c *fiber.Ctx) error {
 		})
 	}
 
-	// Start streaming
-	chunkChan, err := h.service.SendMessage(chatID, userID, &req)
+	// Start streaming; cancelled when the client disconnects
+	ctx, cancel := context.WithCancel(context.Background())
+	chunkChan, err := h.service.SendMessage(ctx, chatID, userID, &req)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		cancel()
+		return c.Status(modelErrorStatus(err, fiber.StatusBadRequest)).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
-	// Set headers for SSE
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("Transfer-Encoding", "chunked")
-
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		for chunk := range chunkChan {
-			data, _ := json.Marshal(chunk)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			w.Flush()
-		}
-	})
-
-	return nil
+	return streamChunks(c, chunkChan, cancel)
 }
 
 func (h *Handler) RegenerateMessage(c *fiber.Ctx) error {
@@ -180,13 +184,32 @@ func (h *Handler) RegenerateMessage(c *fiber.Ctx) error {
 		})
 	}
 
-	chunkChan, err := h.service.RegenerateMessage(chatID, messageID, userID)
+	// The body is optional
+	var req RegenerateMessageRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunkChan, err := h.service.RegenerateMessage(ctx, chatID, messageID, userID, &req)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		cancel()
+		return c.Status(modelErrorStatus(err, fiber.StatusBadRequest)).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	return streamChunks(c, chunkChan, cancel)
+}
+
+// streamChunks writes stream chunks to the client as server-sent events.
+// If the client goes away, cancel stops the generation; the channel is still
+// drained so the producer can finish.
+func streamChunks[T any](c *fiber.Ctx, chunkChan <-chan T, cancel context.CancelFunc) error {
 	// Set headers for SSE
 	c.Set("Content-Type", "text/event-stream")
 	c.Set("Cache-Control", "no-cache")
@@ -194,16 +217,52 @@ func (h *Handler) RegenerateMessage(c *fiber.Ctx) error {
 	c.Set("Transfer-Encoding", "chunked")
 
 	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		connected := true
 		for chunk := range chunkChan {
+			if !connected {
+				continue
+			}
 			data, _ := json.Marshal(chunk)
 			fmt.Fprintf(w, "data: %s\n\n", data)
-			w.Flush()
+			if err := w.Flush(); err != nil {
+				connected = false
+				cancel()
+			}
 		}
 	})
 
 	return nil
 }
 
+func (h *Handler) StopGeneration(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	if err := h.service.StopGeneration(chatID, messageID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Generation stopped",
+	})
+}
+
 func (h *Handler) GetTokenUsage(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(uuid.UUID)
 
@@ -227,8 +286,9 @@ func (h *Handler) ChatCompletions(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(uuid.UUID)
 
 	var req struct {
-		Model    string                   `json:"model"`
-		Messages []map[string]interface{} `json:"messages"`
+		Model          string                   `json:"model"`
+		Messages       []map[string]interface{} `json:"messages"`
+		ResponseFormat *ResponseFormat          `json:"response_format"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -238,9 +298,22 @@ func (h *Handler) ChatCompletions(c *fiber.Ctx) error {
 	}
 
 	// Call OpenAI directly for messenger AI
-	response, err := h.service.CallOpenAI(userID, req.Model, req.Messages)
+	response, err := h.service.CallOpenAI(userID, req.Model, req.Messages, req.ResponseFormat)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		var outputErr *StructuredOutputError
+		switch {
+		case errors.As(err, &outputErr):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":             err.Error(),
+				"validation_errors": outputErr.Errors,
+				"content":           outputErr.Content,
+			})
+		case errors.Is(err, ErrInvalidResponseFormat), errors.Is(err, moderation.ErrContentBlocked):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(modelErrorStatus(err, fiber.StatusInternalServerError)).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
@@ -248,28 +321,28 @@ func (h *Handler) ChatCompletions(c *fiber.Ctx) error {
 	return c.JSON(response)
 }
 
+// GenerateImage creates an image and returns the URL it is served from.
+// The images endpoints offer more options and variations or edits.
 func (h *Handler) GenerateImage(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(uuid.UUID)
 
-	var req struct {
-		Prompt string `json:"prompt"`
-		Size   string `json:"size"`
-	}
-
+	var req GenerateImageRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
 		})
 	}
 
-	imageURL, err := h.service.GenerateImage(userID, req.Prompt, req.Size)
+	images, err := h.service.GenerateImage(c.Context(), userID, &req)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	image := images[0].ToDTO()
 	return c.JSON(fiber.Map{
-		"url": imageURL,
+		"url":   image.URL,
+		"image": image,
 	})
 }
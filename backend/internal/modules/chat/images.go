@@ -0,0 +1,227 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Ways a generated image was made
+const (
+	ImageKindGeneration = "generation"
+	ImageKindVariation  = "variation"
+	ImageKindEdit       = "edit"
+)
+
+const (
+	// maxImagesPerRequest bounds n for generations, variations and edits.
+	maxImagesPerRequest = 4
+
+	// maxImageSourceSize is the largest image or mask accepted for edits
+	// and variations. DALL-E 2 takes at most 4MB, see validateSource.
+	maxImageSourceSize = 20 << 20
+)
+
+var ErrImagesDisabled = errors.New("image storage is not configured")
+
+// GeneratedImage is an image created by an image model, stored so it
+// outlives the provider's temporary URL.
+type GeneratedImage struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ChatID        *uuid.UUID `gorm:"type:uuid;index" json:"chat_id,omitempty"`
+	SourceImageID *uuid.UUID `gorm:"type:uuid" json:"source_image_id,omitempty"` // image a variation or edit was made from
+	Kind          string     `gorm:"type:varchar(20);not null" json:"kind"`      // generation, variation, edit
+	Prompt        string     `gorm:"type:text" json:"prompt"`                    // empty for variations
+	RevisedPrompt string     `gorm:"type:text" json:"revised_prompt,omitempty"`  // as rewritten by the model
+	Model         string     `gorm:"type:varchar(50);not null" json:"model"`
+	Size          string     `gorm:"type:varchar(20)" json:"size"`
+	Quality       string     `gorm:"type:varchar(20)" json:"quality,omitempty"`
+	ContentType   string     `gorm:"type:varchar(50);not null" json:"content_type"`
+	FileSize      int64      `gorm:"not null" json:"file_size"`
+	StorageKey    string     `gorm:"type:varchar(500);not null" json:"-"`
+	Cost          float64    `gorm:"type:decimal(10,4);default:0" json:"cost"` // USD
+	Tokens        int        `gorm:"default:0" json:"tokens"`                  // charged against the token quota
+	CreatedAt     time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+type GeneratedImageResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	ChatID        *uuid.UUID `json:"chat_id,omitempty"`
+	SourceImageID *uuid.UUID `json:"source_image_id,omitempty"`
+	Kind          string     `json:"kind"`
+	Prompt        string     `json:"prompt"`
+	RevisedPrompt string     `json:"revised_prompt,omitempty"`
+	Model         string     `json:"model"`
+	Size          string     `json:"size"`
+	Quality       string     `json:"quality,omitempty"`
+	ContentType   string     `json:"content_type"`
+	FileSize      int64      `json:"file_size"`
+	Cost          float64    `json:"cost"`
+	Tokens        int        `json:"tokens"`
+	URL           string     `json:"url"` // serves the stored image to its owner
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (i *GeneratedImage) ToDTO() GeneratedImageResponse {
+	return GeneratedImageResponse{
+		ID:            i.ID,
+		ChatID:        i.ChatID,
+		SourceImageID: i.SourceImageID,
+		Kind:          i.Kind,
+		Prompt:        i.Prompt,
+		RevisedPrompt: i.RevisedPrompt,
+		Model:         i.Model,
+		Size:          i.Size,
+		Quality:       i.Quality,
+		ContentType:   i.ContentType,
+		FileSize:      i.FileSize,
+		Cost:          i.Cost,
+		Tokens:        i.Tokens,
+		URL:           fmt.Sprintf("/api/chat/images/%s/content", i.ID),
+		CreatedAt:     i.CreatedAt,
+	}
+}
+
+type GenerateImageRequest struct {
+	Prompt  string     `json:"prompt"`
+	Model   string     `json:"model,omitempty"` // dall-e-2 (default), dall-e-3, gpt-image-1
+	Size    string     `json:"size,omitempty"`
+	Quality string     `json:"quality,omitempty"`
+	Style   string     `json:"style,omitempty"` // dall-e-3: vivid, natural
+	N       int        `json:"n,omitempty"`
+	ChatID  *uuid.UUID `json:"chat_id,omitempty"`
+}
+
+// ImageVariationRequest makes variations of an uploaded image or of a
+// gallery image (SourceImageID).
+type ImageVariationRequest struct {
+	Model         string
+	Size          string
+	N             int
+	ChatID        *uuid.UUID
+	SourceImageID *uuid.UUID
+}
+
+// ImageEditRequest redraws an uploaded or gallery image following the
+// prompt. With a mask only its transparent areas are redrawn.
+type ImageEditRequest struct {
+	Prompt        string
+	Model         string
+	Size          string
+	Quality       string
+	N             int
+	ChatID        *uuid.UUID
+	SourceImageID *uuid.UUID
+}
+
+// imageModel describes what an image model accepts and what it costs.
+type imageModel struct {
+	sizes      []string           // the first is the default
+	qualities  []string           // the first is the default; none if unsupported
+	maxN       int                // images per request
+	edits      bool               // supports edits
+	variations bool               // supports variations
+	base64     bool               // accepts response_format=b64_json; others always return base64
+	prices     map[string]float64 // USD per image by "quality size"
+}
+
+var imageModels = map[string]imageModel{
+	openai.CreateImageModelDallE2: {
+		sizes:      []string{"1024x1024", "512x512", "256x256"},
+		maxN:       maxImagesPerRequest,
+		edits:      true,
+		variations: true,
+		base64:     true,
+		prices: map[string]float64{
+			" 1024x1024": 0.020,
+			" 512x512":   0.018,
+			" 256x256":   0.016,
+		},
+	},
+	openai.CreateImageModelDallE3: {
+		sizes:     []string{"1024x1024", "1792x1024", "1024x1792"},
+		qualities: []string{"standard", "hd"},
+		maxN:      1,
+		base64:    true,
+		prices: map[string]float64{
+			"standard 1024x1024": 0.040,
+			"standard 1792x1024": 0.080,
+			"standard 1024x1792": 0.080,
+			"hd 1024x1024":       0.080,
+			"hd 1792x1024":       0.120,
+			"hd 1024x1792":       0.120,
+		},
+	},
+	openai.CreateImageModelGptImage1: {
+		sizes:     []string{"1024x1024", "1536x1024", "1024x1536"},
+		qualities: []string{"medium", "low", "high"},
+		maxN:      maxImagesPerRequest,
+		edits:     true,
+		prices: map[string]float64{
+			"low 1024x1024":    0.011,
+			"low 1536x1024":    0.016,
+			"low 1024x1536":    0.016,
+			"medium 1024x1024": 0.042,
+			"medium 1536x1024": 0.063,
+			"medium 1024x1536": 0.063,
+			"high 1024x1024":   0.167,
+			"high 1536x1024":   0.250,
+			"high 1024x1536":   0.250,
+		},
+	},
+}
+
+// gpt-image-1 token prices in USD per million tokens, used when the
+// response reports usage
+const (
+	gptImageTextInputPrice  = 5.0
+	gptImageImageInputPrice = 10.0
+	gptImageOutputPrice     = 40.0
+)
+
+// resolveImageOptions fills in defaults and checks the options against the
+// model. It returns the model spec with the size and quality to use.
+func resolveImageOptions(model, size, quality string, n int) (imageModel, string, string, int, error) {
+	spec, ok := imageModels[model]
+	if !ok {
+		return imageModel{}, "", "", 0, fmt.Errorf("unsupported image model %q", model)
+	}
+
+	if size == "" {
+		size = spec.sizes[0]
+	} else if !slices.Contains(spec.sizes, size) {
+		return imageModel{}, "", "", 0, fmt.Errorf("%s does not support size %s", model, size)
+	}
+
+	if len(spec.qualities) == 0 {
+		quality = ""
+	} else if quality == "" {
+		quality = spec.qualities[0]
+	} else if !slices.Contains(spec.qualities, quality) {
+		return imageModel{}, "", "", 0, fmt.Errorf("%s does not support quality %q", model, quality)
+	}
+
+	if n == 0 {
+		n = 1
+	}
+	if n < 1 || n > spec.maxN {
+		return imageModel{}, "", "", 0, fmt.Errorf("%s makes 1 to %d images per request", model, spec.maxN)
+	}
+	return spec, size, quality, n, nil
+}
+
+// cost returns the price of one image. Usage reported by the provider is
+// split evenly over the n images.
+func (m imageModel) cost(model, size, quality string, usage openai.ImageResponseUsage, n int) float64 {
+	if model == openai.CreateImageModelGptImage1 && usage.TotalTokens > 0 {
+		input := float64(usage.InputTokensDetails.TextTokens)*gptImageTextInputPrice +
+			float64(usage.InputTokensDetails.ImageTokens)*gptImageImageInputPrice
+		return (input + float64(usage.OutputTokens)*gptImageOutputPrice) / 1e6 / float64(n)
+	}
+	return m.prices[quality+" "+size]
+}
@@ -0,0 +1,269 @@
+package chat
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ImagesHandler struct {
+	service     *ImagesService
+	chatService *Service
+}
+
+func NewImagesHandler(service *ImagesService, chatService *Service) *ImagesHandler {
+	return &ImagesHandler{service: service, chatService: chatService}
+}
+
+func (h *ImagesHandler) GenerateImages(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req GenerateImageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	images, err := h.chatService.GenerateImage(c.Context(), userID, &req)
+	if err != nil {
+		return imageError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(imageDTOs(images))
+}
+
+// CreateVariations takes a multipart "image" (or "image_id" of a gallery
+// image) and optional "model", "size", "n" and "chat_id".
+func (h *ImagesHandler) CreateVariations(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	image, err := formImage(c, "image")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	req := ImageVariationRequest{
+		Model: c.FormValue("model"),
+		Size:  c.FormValue("size"),
+		N:     formInt(c, "n"),
+	}
+	if req.SourceImageID, err = formUUID(c, "image_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+	if req.ChatID, err = formUUID(c, "chat_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	images, err := h.chatService.CreateImageVariation(c.Context(), userID, &req, image)
+	if err != nil {
+		return imageError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(imageDTOs(images))
+}
+
+// EditImages takes a multipart "image" (or "image_id" of a gallery image),
+// a "prompt", an optional PNG "mask" whose transparent areas are redrawn,
+// and optional "model", "size", "quality", "n" and "chat_id".
+func (h *ImagesHandler) EditImages(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	image, err := formImage(c, "image")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	mask, err := formImage(c, "mask")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	req := ImageEditRequest{
+		Prompt:  c.FormValue("prompt"),
+		Model:   c.FormValue("model"),
+		Size:    c.FormValue("size"),
+		Quality: c.FormValue("quality"),
+		N:       formInt(c, "n"),
+	}
+	if req.SourceImageID, err = formUUID(c, "image_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+	if req.ChatID, err = formUUID(c, "chat_id"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	images, err := h.chatService.EditImage(c.Context(), userID, &req, image, mask)
+	if err != nil {
+		return imageError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(imageDTOs(images))
+}
+
+// GetImages lists the user's gallery, optionally filtered by ?chat_id=.
+func (h *ImagesHandler) GetImages(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	limit := c.QueryInt("limit", 50)
+	offset := c.QueryInt("offset", 0)
+
+	var chatID *uuid.UUID
+	if value := c.Query("chat_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid chat ID",
+			})
+		}
+		chatID = &id
+	}
+
+	images, total, err := h.service.GetImages(userID, chatID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"images": imageDTOs(images),
+		"total":  total,
+	})
+}
+
+func (h *ImagesHandler) GetImage(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	imageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+
+	image, err := h.service.GetImage(imageID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(image.ToDTO())
+}
+
+// GetImageContent serves the stored image to its owner.
+func (h *ImagesHandler) GetImageContent(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	imageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+
+	image, data, err := h.service.GetImageContent(c.Context(), imageID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, image.ContentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.Send(data)
+}
+
+func (h *ImagesHandler) DeleteImage(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	imageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+
+	if err := h.service.DeleteImage(imageID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Image deleted successfully",
+	})
+}
+
+func imageDTOs(images []GeneratedImage) []GeneratedImageResponse {
+	out := make([]GeneratedImageResponse, len(images))
+	for i := range images {
+		out[i] = images[i].ToDTO()
+	}
+	return out
+}
+
+func imageError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	if errors.Is(err, ErrImagesDisabled) {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// formImage reads an optional multipart image file; nil if absent.
+func formImage(c *fiber.Ctx, name string) ([]byte, error) {
+	fileHeader, err := c.FormFile(name)
+	if err != nil {
+		return nil, nil
+	}
+	if fileHeader.Size > maxImageSourceSize {
+		return nil, errors.New("file is too large, maximum size is 20MB")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, errors.New("failed to read file")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSourceSize))
+	if err != nil {
+		return nil, errors.New("failed to read file")
+	}
+	return data, nil
+}
+
+func formUUID(c *fiber.Ctx, name string) (*uuid.UUID, error) {
+	value := c.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// formInt returns an integer form value, or 0 if absent or invalid.
+func formInt(c *fiber.Ctx, name string) int {
+	n, _ := strconv.Atoi(c.FormValue(name))
+	return n
+}
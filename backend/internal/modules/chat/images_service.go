@@ -0,0 +1,505 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+	"github.com/kintsugi-ai/backend/internal/storage"
+)
+
+// imageDownloadTimeout bounds fetching an image from a provider URL.
+const imageDownloadTimeout = time.Minute
+
+type ImagesService struct {
+	db      *gorm.DB
+	storage storage.Storage
+	client  *http.Client
+}
+
+func NewImagesService(db *gorm.DB, store storage.Storage) *ImagesService {
+	return &ImagesService{
+		db:      db,
+		storage: store,
+		client:  &http.Client{Timeout: imageDownloadTimeout},
+	}
+}
+
+// GetImages lists a user's gallery, newest first, optionally for one chat.
+func (s *ImagesService) GetImages(userID uuid.UUID, chatID *uuid.UUID, limit, offset int) ([]GeneratedImage, int64, error) {
+	query := s.db.Model(&GeneratedImage{}).Where("user_id = ?", userID)
+	if chatID != nil {
+		query = query.Where("chat_id = ?", *chatID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var images []GeneratedImage
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&images).Error
+	return images, total, err
+}
+
+func (s *ImagesService) GetImage(id, userID uuid.UUID) (*GeneratedImage, error) {
+	var image GeneratedImage
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("image not found")
+		}
+		return nil, err
+	}
+	return &image, nil
+}
+
+// GetImageContent returns an image with its data.
+func (s *ImagesService) GetImageContent(ctx context.Context, id, userID uuid.UUID) (*GeneratedImage, []byte, error) {
+	image, err := s.GetImage(id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := s.storage.Get(ctx, image.StorageKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return image, data, nil
+}
+
+func (s *ImagesService) DeleteImage(id, userID uuid.UUID) error {
+	image, err := s.GetImage(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(image).Error; err != nil {
+		return err
+	}
+	if err := s.storage.Delete(context.Background(), image.StorageKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Image %s: failed to delete file: %v", image.ID, err)
+	}
+	return nil
+}
+
+func (s *ImagesService) checkChatOwner(chatID, userID uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&Chat{}).Where("id = ? AND user_id = ?", chatID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("chat not found")
+	}
+	return nil
+}
+
+// saveAll stores the images of a provider response. The records are filled
+// in from template; images that cannot be fetched are skipped.
+func (s *ImagesService) saveAll(ctx context.Context, template GeneratedImage, resp openai.ImageResponse) ([]GeneratedImage, error) {
+	images := make([]GeneratedImage, 0, len(resp.Data))
+	var lastErr error
+	for _, item := range resp.Data {
+		data, err := s.fetch(ctx, item)
+		if err != nil {
+			lastErr = err
+			log.Printf("Image: failed to fetch generated image: %v", err)
+			continue
+		}
+
+		image := template
+		image.RevisedPrompt = item.RevisedPrompt
+		if err := s.save(ctx, &image, data); err != nil {
+			lastErr = err
+			log.Printf("Image: failed to store generated image: %v", err)
+			continue
+		}
+		images = append(images, image)
+	}
+
+	if len(images) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("image service returned an invalid response")
+		}
+		return nil, lastErr
+	}
+	return images, nil
+}
+
+// fetch returns the bytes of a generated image, given inline or by URL.
+func (s *ImagesService) fetch(ctx context.Context, item openai.ImageResponseDataInner) ([]byte, error) {
+	if item.B64JSON != "" {
+		return base64.StdEncoding.DecodeString(item.B64JSON)
+	}
+	if item.URL == "" {
+		return nil, errors.New("image service returned no image")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxImageSourceSize))
+}
+
+func (s *ImagesService) save(ctx context.Context, image *GeneratedImage, data []byte) error {
+	contentType, ext, _, _, err := decodeImage(data)
+	if err != nil {
+		return err
+	}
+
+	image.ID = uuid.New()
+	image.ContentType = contentType
+	image.FileSize = int64(len(data))
+	image.StorageKey = fmt.Sprintf("images/%s/%s%s", image.UserID, image.ID, ext)
+
+	if err := s.storage.Put(ctx, image.StorageKey, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := s.db.Create(image).Error; err != nil {
+		s.storage.Delete(context.Background(), image.StorageKey)
+		return err
+	}
+	return nil
+}
+
+// SetImages stores generated images in the gallery. Image generation is
+// unavailable without it.
+func (s *Service) SetImages(images *ImagesService) {
+	s.images = images
+}
+
+// GenerateImage creates images from a prompt and adds them to the user's
+// gallery.
+func (s *Service) GenerateImage(ctx context.Context, userID uuid.UUID, req *GenerateImageRequest) ([]GeneratedImage, error) {
+	prompt := strings.TrimSpace(req.Prompt)
+	if prompt == "" {
+		return nil, errors.New("prompt is required")
+	}
+
+	model := req.Model
+	if model == "" {
+		model = defaultImageModel
+	}
+	spec, size, quality, n, err := resolveImageOptions(model, req.Size, req.Quality, req.N)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.prepareImages(userID, req.ChatID, n); err != nil {
+		return nil, err
+	}
+	if err := s.moderateRequest(ctx, userID, moderation.SourceImagePrompt, prompt); err != nil {
+		return nil, err
+	}
+
+	provider, upstream, err := s.providers.Resolve(model)
+	if err != nil {
+		return nil, err
+	}
+
+	imageReq := openai.ImageRequest{
+		Prompt:  prompt,
+		Model:   upstream,
+		Size:    size,
+		Quality: quality,
+		Style:   req.Style,
+		N:       n,
+	}
+	if spec.base64 {
+		imageReq.ResponseFormat = openai.CreateImageResponseFormatB64JSON
+	}
+
+	resp, err := provider.CreateImage(ctx, imageReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.saveImages(ctx, userID, spec, resp, GeneratedImage{
+		UserID:  userID,
+		ChatID:  req.ChatID,
+		Kind:    ImageKindGeneration,
+		Prompt:  prompt,
+		Model:   model,
+		Size:    size,
+		Quality: quality,
+	})
+}
+
+// CreateImageVariation makes variations of image, or of the gallery image
+// req.SourceImageID when image is nil.
+func (s *Service) CreateImageVariation(ctx context.Context, userID uuid.UUID, req *ImageVariationRequest, image []byte) ([]GeneratedImage, error) {
+	model := req.Model
+	if model == "" {
+		model = openai.CreateImageModelDallE2
+	}
+	spec, size, _, n, err := resolveImageOptions(model, req.Size, "", req.N)
+	if err != nil {
+		return nil, err
+	}
+	if !spec.variations {
+		return nil, fmt.Errorf("%s does not support variations", model)
+	}
+
+	if image != nil {
+		req.SourceImageID = nil // an upload takes precedence over a gallery image
+	}
+	image, err = s.sourceImage(ctx, userID, req.SourceImageID, image)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSourceImage(model, image, nil); err != nil {
+		return nil, err
+	}
+	if err := s.prepareImages(userID, req.ChatID, n); err != nil {
+		return nil, err
+	}
+
+	provider, upstream, err := s.providers.Resolve(model)
+	if err != nil {
+		return nil, err
+	}
+
+	variReq := openai.ImageVariRequest{
+		Image: openai.WrapReader(bytes.NewReader(image), "image.png", "image/png"),
+		Model: upstream,
+		Size:  size,
+		N:     n,
+	}
+	if spec.base64 {
+		variReq.ResponseFormat = openai.CreateImageResponseFormatB64JSON
+	}
+
+	resp, err := provider.CreateVariImage(ctx, variReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.saveImages(ctx, userID, spec, resp, GeneratedImage{
+		UserID:        userID,
+		ChatID:        req.ChatID,
+		SourceImageID: req.SourceImageID,
+		Kind:          ImageKindVariation,
+		Model:         model,
+		Size:          size,
+	})
+}
+
+// EditImage redraws image, or the gallery image req.SourceImageID when image
+// is nil, following the prompt. An optional mask limits the edit to its
+// transparent areas.
+func (s *Service) EditImage(ctx context.Context, userID uuid.UUID, req *ImageEditRequest, image, mask []byte) ([]GeneratedImage, error) {
+	prompt := strings.TrimSpace(req.Prompt)
+	if prompt == "" {
+		return nil, errors.New("prompt is required")
+	}
+
+	model := req.Model
+	if model == "" {
+		model = openai.CreateImageModelDallE2
+	}
+	spec, size, quality, n, err := resolveImageOptions(model, req.Size, req.Quality, req.N)
+	if err != nil {
+		return nil, err
+	}
+	if !spec.edits {
+		return nil, fmt.Errorf("%s does not support edits", model)
+	}
+
+	if image != nil {
+		req.SourceImageID = nil // an upload takes precedence over a gallery image
+	}
+	image, err = s.sourceImage(ctx, userID, req.SourceImageID, image)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSourceImage(model, image, mask); err != nil {
+		return nil, err
+	}
+	if err := s.prepareImages(userID, req.ChatID, n); err != nil {
+		return nil, err
+	}
+	if err := s.moderateRequest(ctx, userID, moderation.SourceImagePrompt, prompt); err != nil {
+		return nil, err
+	}
+
+	provider, upstream, err := s.providers.Resolve(model)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, ext, _, _, _ := decodeImage(image)
+	editReq := openai.ImageEditRequest{
+		Image:   openai.WrapReader(bytes.NewReader(image), "image"+ext, contentType),
+		Prompt:  prompt,
+		Model:   upstream,
+		Size:    size,
+		Quality: quality,
+		N:       n,
+	}
+	if mask != nil {
+		editReq.Mask = openai.WrapReader(bytes.NewReader(mask), "mask.png", "image/png")
+	}
+	if spec.base64 {
+		editReq.ResponseFormat = openai.CreateImageResponseFormatB64JSON
+	}
+
+	resp, err := provider.CreateEditImage(ctx, editReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.saveImages(ctx, userID, spec, resp, GeneratedImage{
+		UserID:        userID,
+		ChatID:        req.ChatID,
+		SourceImageID: req.SourceImageID,
+		Kind:          ImageKindEdit,
+		Prompt:        prompt,
+		Model:         model,
+		Size:          size,
+		Quality:       quality,
+	})
+}
+
+// prepareImages checks that the user may create n images and owns the chat
+// they are linked to.
+func (s *Service) prepareImages(userID uuid.UUID, chatID *uuid.UUID, n int) error {
+	if s.images == nil {
+		return ErrImagesDisabled
+	}
+
+	var user struct {
+		SubscriptionTier string
+		Role             string
+	}
+	err := s.db.Table("users").
+		Where("id = ?", userID).
+		Select("subscription_tier, role").
+		Scan(&user).Error
+	if err != nil {
+		return err
+	}
+
+	// Superadmins can always generate images
+	if user.Role != "superadmin" {
+		// Only Premium and Unlimited tiers can generate images
+		if user.SubscriptionTier == "basic" {
+			return errors.New("image generation is only available for Premium and Unlimited subscribers")
+		}
+	}
+
+	hasCapacity, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
+	if err != nil {
+		return err
+	}
+	if !hasCapacity {
+		return errors.New("token limit exceeded")
+	}
+
+	projectedTokens := tokensUsed + int64(n*imageTokenCost)
+	if tokensLimit != -1 && projectedTokens > tokensLimit {
+		return fmt.Errorf("not enough tokens for image generation (%d/%d)", projectedTokens, tokensLimit)
+	}
+
+	if chatID != nil {
+		return s.images.checkChatOwner(*chatID, userID)
+	}
+	return nil
+}
+
+// sourceImage returns the uploaded image, or loads the gallery image id.
+func (s *Service) sourceImage(ctx context.Context, userID uuid.UUID, id *uuid.UUID, image []byte) ([]byte, error) {
+	if image != nil {
+		return image, nil
+	}
+	if id == nil {
+		return nil, errors.New("an image or image_id is required")
+	}
+	if s.images == nil {
+		return nil, ErrImagesDisabled
+	}
+	_, data, err := s.images.GetImageContent(ctx, *id, userID)
+	return data, err
+}
+
+// saveImages stores the images of a response and charges them to the user.
+func (s *Service) saveImages(ctx context.Context, userID uuid.UUID, spec imageModel, resp openai.ImageResponse, template GeneratedImage) ([]GeneratedImage, error) {
+	template.Tokens = imageTokenCost
+	template.Cost = spec.cost(template.Model, template.Size, template.Quality, resp.Usage, len(resp.Data))
+
+	images, err := s.images.saveAll(ctx, template, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.addTokenUsage(userID, len(images)*imageTokenCost); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// validateSourceImage checks an image and mask against the model's limits.
+// DALL-E 2 takes square PNGs below 4MB; masks must match the image size.
+func validateSourceImage(model string, image, mask []byte) error {
+	if len(image) > maxImageSourceSize || len(mask) > maxImageSourceSize {
+		return fmt.Errorf("image is larger than %d MB", maxImageSourceSize>>20)
+	}
+
+	contentType, _, width, height, err := decodeImage(image)
+	if err != nil {
+		return err
+	}
+
+	if model == openai.CreateImageModelDallE2 {
+		if contentType != "image/png" {
+			return fmt.Errorf("%s needs a PNG image", model)
+		}
+		if width != height {
+			return fmt.Errorf("%s needs a square image", model)
+		}
+		if len(image) > 4<<20 || len(mask) > 4<<20 {
+			return fmt.Errorf("%s takes images up to 4 MB", model)
+		}
+	} else if contentType == "image/gif" {
+		return fmt.Errorf("%s does not take GIF images", model)
+	}
+
+	if mask != nil {
+		maskType, _, maskWidth, maskHeight, err := decodeImage(mask)
+		if err != nil {
+			return err
+		}
+		if maskType != "image/png" {
+			return errors.New("the mask must be a PNG with transparent areas to edit")
+		}
+		if maskWidth != width || maskHeight != height {
+			return errors.New("the mask must have the same size as the image")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package chat
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Import sources
+const (
+	ImportSourceChatGPT = "chatgpt"
+	ImportSourceClaude  = "claude"
+)
+
+// maxImportSize limits conversations.json once unpacked, so a small archive
+// cannot expand into gigabytes.
+const maxImportSize = 256 << 20
+
+// defaultClaudeImportModel is used for Claude conversations, as the Claude
+// export does not say which model answered.
+const defaultClaudeImportModel = "claude-3-5-sonnet-latest"
+
+var ErrUnrecognizedImport = errors.New("unrecognized export file, expected ChatGPT or Claude conversations.json")
+
+// importedConversation is a conversation parsed from an export, ready to be
+// stored as a Chat with its messages in order.
+type importedConversation struct {
+	Title     string
+	Model     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Messages  []importedMessage
+}
+
+type importedMessage struct {
+	Role      string
+	Content   string
+	Model     string
+	CreatedAt time.Time
+}
+
+type ImportResult struct {
+	Source   string         `json:"source"`
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"` // conversations without text messages
+	Chats    []ImportedChat `json:"chats"`
+}
+
+type ImportedChat struct {
+	ID           uuid.UUID `json:"id"`
+	Title        string    `json:"title"`
+	Model        string    `json:"model"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// readConversationsFile returns conversations.json from an upload, which
+// may be the JSON file itself or the zip archive both services send.
+func readConversationsFile(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		return data, nil
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range archive.File {
+		if file.Name != "conversations.json" && !strings.HasSuffix(file.Name, "/conversations.json") {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		data, err := io.ReadAll(io.LimitReader(rc, maxImportSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxImportSize {
+			return nil, errors.New("conversations.json is too large, maximum size is 256MB")
+		}
+		return data, nil
+	}
+	return nil, errors.New("conversations.json not found in archive")
+}
+
+// parseConversations detects the export format unless source is given.
+func parseConversations(data []byte, source string) (string, []importedConversation, error) {
+	data, err := readConversationsFile(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return "", nil, ErrUnrecognizedImport
+	}
+
+	if source == "" {
+		source = detectImportSource(raw)
+	}
+
+	var conversations []importedConversation
+	switch source {
+	case ImportSourceChatGPT:
+		conversations, err = parseChatGPTExport(raw)
+	case ImportSourceClaude:
+		conversations, err = parseClaudeExport(raw)
+	default:
+		return "", nil, ErrUnrecognizedImport
+	}
+	return source, conversations, err
+}
+
+func detectImportSource(raw []json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var probe struct {
+		Mapping      json.RawMessage `json:"mapping"`
+		ChatMessages json.RawMessage `json:"chat_messages"`
+	}
+	if err := json.Unmarshal(raw[0], &probe); err != nil {
+		return ""
+	}
+	switch {
+	case probe.Mapping != nil:
+		return ImportSourceChatGPT
+	case probe.ChatMessages != nil:
+		return ImportSourceClaude
+	}
+	return ""
+}
+
+// ChatGPT
+
+type chatGPTConversation struct {
+	Title            string                 `json:"title"`
+	CreateTime       float64                `json:"create_time"`
+	UpdateTime       float64                `json:"update_time"`
+	CurrentNode      string                 `json:"current_node"`
+	DefaultModelSlug string                 `json:"default_model_slug"`
+	Mapping          map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID      string          `json:"id"`
+	Parent  string          `json:"parent"`
+	Message *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+		Language    string            `json:"language"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+}
+
+// parseChatGPTExport follows each conversation from its current node back
+// to the root, which is the branch that was on screen in ChatGPT.
+func parseChatGPTExport(raw []json.RawMessage) ([]importedConversation, error) {
+	conversations := make([]importedConversation, 0, len(raw))
+	for _, item := range raw {
+		var conv chatGPTConversation
+		if err := json.Unmarshal(item, &conv); err != nil {
+			return nil, ErrUnrecognizedImport
+		}
+
+		out := importedConversation{
+			Title:     conv.Title,
+			Model:     conv.DefaultModelSlug,
+			CreatedAt: unixSeconds(conv.CreateTime),
+			UpdatedAt: unixSeconds(conv.UpdateTime),
+		}
+
+		nodeID := conv.CurrentNode
+		if nodeID == "" {
+			nodeID = latestChatGPTNode(conv.Mapping)
+		}
+		seen := make(map[string]bool)
+		for nodeID != "" && !seen[nodeID] {
+			seen[nodeID] = true
+			node, ok := conv.Mapping[nodeID]
+			if !ok {
+				break
+			}
+			if msg := node.Message; msg != nil && (msg.Author.Role == "user" || msg.Author.Role == "assistant") {
+				if content := chatGPTText(msg); content != "" {
+					out.Messages = append(out.Messages, importedMessage{
+						Role:      msg.Author.Role,
+						Content:   content,
+						Model:     msg.Metadata.ModelSlug,
+						CreatedAt: unixSeconds(msg.CreateTime),
+					})
+				}
+			}
+			nodeID = node.Parent
+		}
+
+		// Collected leaf first
+		for i, j := 0, len(out.Messages)-1; i < j; i, j = i+1, j-1 {
+			out.Messages[i], out.Messages[j] = out.Messages[j], out.Messages[i]
+		}
+		for i := len(out.Messages) - 1; i >= 0; i-- {
+			if out.Messages[i].Model != "" {
+				out.Model = out.Messages[i].Model
+				break
+			}
+		}
+
+		conversations = append(conversations, out)
+	}
+	return conversations, nil
+}
+
+func chatGPTText(msg *chatGPTMessage) string {
+	switch msg.Content.ContentType {
+	case "text", "multimodal_text":
+		var parts []string
+		for _, part := range msg.Content.Parts {
+			// Non-text parts (images, audio) are objects and are skipped
+			var text string
+			if json.Unmarshal(part, &text) == nil && text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.TrimSpace(strings.Join(parts, "\n"))
+	case "code":
+		if strings.TrimSpace(msg.Content.Text) == "" {
+			return ""
+		}
+		return "```" + msg.Content.Language + "\n" + msg.Content.Text + "\n```"
+	}
+	return ""
+}
+
+// latestChatGPTNode picks the newest message when current_node is missing.
+func latestChatGPTNode(mapping map[string]chatGPTNode) string {
+	ids := make([]string, 0, len(mapping))
+	for id := range mapping {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	best, bestTime := "", -1.0
+	for _, id := range ids {
+		node := mapping[id]
+		if node.Message != nil && node.Message.CreateTime > bestTime {
+			best, bestTime = id, node.Message.CreateTime
+		}
+	}
+	return best
+}
+
+func unixSeconds(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+// Claude
+
+type claudeConversation struct {
+	Name         string          `json:"name"`
+	Model        string          `json:"model"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	ChatMessages []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	Sender    string    `json:"sender"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	Content   []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+func parseClaudeExport(raw []json.RawMessage) ([]importedConversation, error) {
+	conversations := make([]importedConversation, 0, len(raw))
+	for _, item := range raw {
+		var conv claudeConversation
+		if err := json.Unmarshal(item, &conv); err != nil {
+			return nil, ErrUnrecognizedImport
+		}
+
+		model := conv.Model
+		if model == "" {
+			model = defaultClaudeImportModel
+		}
+
+		out := importedConversation{
+			Title:     conv.Name,
+			Model:     model,
+			CreatedAt: conv.CreatedAt,
+			UpdatedAt: conv.UpdatedAt,
+		}
+
+		for _, msg := range conv.ChatMessages {
+			role := "user"
+			if msg.Sender == "assistant" {
+				role = "assistant"
+			}
+
+			content := strings.TrimSpace(msg.Text)
+			if content == "" {
+				var parts []string
+				for _, block := range msg.Content {
+					if block.Type == "text" && block.Text != "" {
+						parts = append(parts, block.Text)
+					}
+				}
+				content = strings.TrimSpace(strings.Join(parts, "\n\n"))
+			}
+			if content == "" {
+				continue
+			}
+
+			message := importedMessage{
+				Role:      role,
+				Content:   content,
+				CreatedAt: msg.CreatedAt,
+			}
+			if role == "assistant" {
+				message.Model = model
+			}
+			out.Messages = append(out.Messages, message)
+		}
+
+		conversations = append(conversations, out)
+	}
+	return conversations, nil
+}
@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ImportExportHandler struct {
+	service *ImportExportService
+}
+
+func NewImportExportHandler(service *ImportExportService) *ImportExportHandler {
+	return &ImportExportHandler{service: service}
+}
+
+// ExportChat downloads a chat as ?format=md (default), html or pdf.
+func (h *ImportExportHandler) ExportChat(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	file, err := h.service.ExportChat(chatID, userID, c.Query("format"))
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	return sendExport(c, file)
+}
+
+// ExportFolder downloads every chat of a folder as one file.
+func (h *ImportExportHandler) ExportFolder(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	folderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid folder ID",
+		})
+	}
+
+	file, err := h.service.ExportFolder(folderID, userID, c.Query("format"))
+	if err != nil {
+		return exportError(c, err)
+	}
+
+	return sendExport(c, file)
+}
+
+// ImportConversations takes a multipart "file" with a ChatGPT or Claude
+// export (conversations.json or the zip archive), an optional "source"
+// (chatgpt, claude) and an optional "folder_id".
+func (h *ImportExportHandler) ImportConversations(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is required",
+		})
+	}
+
+	var folderID *uuid.UUID
+	if value := c.FormValue("folder_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid folder ID",
+			})
+		}
+		folderID = &id
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	result, err := h.service.ImportConversations(userID, data, c.FormValue("source"), folderID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(result)
+}
+
+func exportError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusNotFound
+	if errors.Is(err, ErrUnsupportedExportFormat) {
+		status = fiber.StatusBadRequest
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+func sendExport(c *fiber.Ctx, file *ExportFile) error {
+	c.Set(fiber.HeaderContentType, file.ContentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", file.FileName))
+	return c.Send(file.Data)
+}
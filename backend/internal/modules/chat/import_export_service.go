@@ -0,0 +1,244 @@
+package chat
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// ExportFile is a rendered export ready for download.
+type ExportFile struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+type ImportExportService struct {
+	db      *gorm.DB
+	repo    *Repository
+	catalog *ModelCatalogService
+}
+
+func NewImportExportService(db *gorm.DB) *ImportExportService {
+	return &ImportExportService{
+		db:   db,
+		repo: NewRepository(db),
+	}
+}
+
+// SetModelCatalog replaces the models of imported chats the user may not
+// use with the default model.
+func (s *ImportExportService) SetModelCatalog(catalog *ModelCatalogService) {
+	s.catalog = catalog
+}
+
+// ExportChat renders the active branch of one of the user's chats.
+func (s *ImportExportService) ExportChat(chatID, userID uuid.UUID, format string) (*ExportFile, error) {
+	format, err := NormalizeExportFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &exportDocument{
+		Title: chat.Title,
+		Chats: []exportChat{newExportChat(chat)},
+	}
+	return s.render(doc, format)
+}
+
+// ExportFolder renders every chat of a folder into one file, newest chat
+// first.
+func (s *ImportExportService) ExportFolder(folderID, userID uuid.UUID, format string) (*ExportFile, error) {
+	format, err := NormalizeExportFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var folder ChatFolder
+	if err := s.db.Where("id = ? AND user_id = ?", folderID, userID).First(&folder).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("folder not found")
+		}
+		return nil, err
+	}
+
+	var chats []Chat
+	err = s.db.Where("user_id = ? AND id IN (?)", userID,
+		s.db.Model(&ChatFolderAssignment{}).Select("chat_id").Where("folder_id = ?", folderID)).
+		Preload("Messages", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Order("updated_at DESC").
+		Find(&chats).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, errors.New("folder has no chats")
+	}
+
+	doc := &exportDocument{
+		Title: folder.Name,
+		Chats: make([]exportChat, len(chats)),
+	}
+	for i := range chats {
+		doc.Chats[i] = newExportChat(&chats[i])
+	}
+	return s.render(doc, format)
+}
+
+func (s *ImportExportService) render(doc *exportDocument, format string) (*ExportFile, error) {
+	data, err := renderExport(doc, format)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportFile{
+		FileName:    exportFileName(doc.Title, format),
+		ContentType: ExportContentType(format),
+		Data:        data,
+	}, nil
+}
+
+// ImportConversations stores the conversations of a ChatGPT or Claude
+// export as new chats of the user, keeping their original timestamps and
+// the models the user may use. source may be empty to detect the format. Chats are added to
+// folderID when it is given.
+func (s *ImportExportService) ImportConversations(userID uuid.UUID, data []byte, source string, folderID *uuid.UUID) (*ImportResult, error) {
+	if source != "" && source != ImportSourceChatGPT && source != ImportSourceClaude {
+		return nil, errors.New("invalid import source. Allowed: chatgpt, claude")
+	}
+
+	if folderID != nil {
+		var folder ChatFolder
+		if err := s.db.Where("id = ? AND user_id = ?", *folderID, userID).First(&folder).Error; err != nil {
+			return nil, errors.New("folder not found")
+		}
+	}
+
+	source, conversations, err := parseConversations(data, source)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		Source: source,
+		Chats:  []ImportedChat{},
+	}
+
+	// The file is imported whole or not at all, so a failed import can be
+	// retried without duplicating the chats that made it in
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		repo := NewRepository(tx)
+		models := make(map[string]string) // exported model to the one used
+		for _, conv := range conversations {
+			if len(conv.Messages) == 0 {
+				result.Skipped++
+				continue
+			}
+
+			chat, messages := importedChat(userID, conv)
+			model, ok := models[chat.Model]
+			if !ok {
+				model = s.catalog.ModelOrDefault(userID, chat.Model)
+				models[chat.Model] = model
+			}
+			chat.Model = model
+			if err := repo.CreateChatWithMessages(chat, messages); err != nil {
+				return err
+			}
+			if folderID != nil {
+				if err := tx.Create(&ChatFolderAssignment{ChatID: chat.ID, FolderID: *folderID}).Error; err != nil {
+					return err
+				}
+			}
+
+			result.Imported++
+			result.Chats = append(result.Chats, ImportedChat{
+				ID:           chat.ID,
+				Title:        chat.Title,
+				Model:        chat.Model,
+				MessageCount: len(messages),
+				CreatedAt:    chat.CreatedAt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// importedChat builds a linear branch from a parsed conversation. Missing
+// or out-of-order timestamps are nudged forward so messages keep their
+// order when sorted by creation time.
+func importedChat(userID uuid.UUID, conv importedConversation) (*Chat, []Message) {
+	chat := &Chat{
+		ID:              uuid.New(),
+		UserID:          userID,
+		Title:           truncateRunes(conv.Title, 252),
+		Model:           truncateRunes(conv.Model, 47),
+		ContextStrategy: ContextStrategySummarize,
+		CreatedAt:       conv.CreatedAt,
+		UpdatedAt:       conv.UpdatedAt,
+	}
+	if chat.Title == "" {
+		chat.Title = "Imported Chat"
+	} else {
+		chat.TitleSource = TitleSourceUser
+	}
+
+	messages := make([]Message, 0, len(conv.Messages))
+	var parentID *uuid.UUID
+	last := conv.CreatedAt
+	if last.IsZero() {
+		last = time.Now()
+		for _, msg := range conv.Messages {
+			if !msg.CreatedAt.IsZero() {
+				last = msg.CreatedAt.Add(-time.Millisecond)
+				break
+			}
+		}
+	}
+	for _, msg := range conv.Messages {
+		createdAt := msg.CreatedAt
+		if createdAt.IsZero() || !createdAt.After(last) {
+			createdAt = last.Add(time.Millisecond)
+		}
+		last = createdAt
+
+		id := uuid.New()
+		messages = append(messages, Message{
+			ID:        id,
+			ChatID:    chat.ID,
+			ParentID:  parentID,
+			Role:      msg.Role,
+			Content:   msg.Content,
+			Tokens:    tokenizer.Count(chat.Model, msg.Content),
+			Model:     truncateRunes(msg.Model, 47),
+			Status:    MessageStatusCompleted,
+			CreatedAt: createdAt,
+		})
+		parentID = &id
+	}
+	chat.ActiveLeafID = parentID
+
+	if chat.CreatedAt.IsZero() {
+		chat.CreatedAt = messages[0].CreatedAt
+	}
+	if chat.UpdatedAt.Before(last) {
+		chat.UpdatedAt = last
+	}
+
+	return chat, messages
+}
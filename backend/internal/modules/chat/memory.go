@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory sources
+const (
+	MemorySourceUser      = "user"      // saved through the API
+	MemorySourceAssistant = "assistant" // saved by the model with the memory tool
+)
+
+const (
+	// memoryPreference is the user preference turning memory off when set
+	// to false. Memory is on by default.
+	memoryPreference = "memory_enabled"
+
+	// memoryNamespace marks memories in the vector store.
+	memoryNamespace = "memory"
+
+	// memoryToolName is the tool the model saves memories with.
+	memoryToolName = "save_memory"
+
+	maxMemoriesPerUser = 200
+	maxMemoryLength    = 500
+
+	// memoryTopK is how many memories are given to the model per reply.
+	// Users with no more memories than this get all of them.
+	memoryTopK = 8
+
+	// memoryMinScore drops memories that are barely related to the message.
+	memoryMinScore = 0.3
+
+	memoryContextPrefix = "Things you remember about the user from earlier conversations. Use them when they are relevant, without mentioning that you remember them unless asked:"
+)
+
+var (
+	ErrMemoryDisabled = errors.New("memory is turned off in the user's preferences")
+	ErrMemoryFull     = errors.New("memory is full, delete some memories first")
+)
+
+// Memory is a fact about a user kept across chats.
+type Memory struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Content   string     `gorm:"type:text;not null" json:"content"`
+	Source    string     `gorm:"type:varchar(20);not null" json:"source"` // user, assistant
+	ChatID    *uuid.UUID `gorm:"type:uuid" json:"chat_id,omitempty"`      // chat the assistant saved it in
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (Memory) TableName() string {
+	return "user_memories"
+}
+
+type CreateMemoryRequest struct {
+	Content string `json:"content" validate:"required"`
+}
+
+type UpdateMemoryRequest struct {
+	Content string `json:"content" validate:"required"`
+}
+
+type MemoriesResponse struct {
+	Memories []Memory `json:"memories"`
+	Enabled  bool     `json:"enabled"` // the user's preference
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type MemoryHandler struct {
+	service *MemoryService
+}
+
+func NewMemoryHandler(service *MemoryService) *MemoryHandler {
+	return &MemoryHandler{service: service}
+}
+
+// GetMemories lists what the assistant remembers about the user, and
+// whether memory is turned on.
+func (h *MemoryHandler) GetMemories(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	memories, err := h.service.GetMemories(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(memories)
+}
+
+func (h *MemoryHandler) CreateMemory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req CreateMemoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	memory, err := h.service.CreateMemory(c.Context(), userID, req.Content, MemorySourceUser, nil)
+	if err != nil {
+		return memoryError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(memory)
+}
+
+func (h *MemoryHandler) UpdateMemory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	memoryID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid memory ID",
+		})
+	}
+
+	var req UpdateMemoryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	memory, err := h.service.UpdateMemory(c.Context(), memoryID, userID, req.Content)
+	if err != nil {
+		return memoryError(c, err)
+	}
+
+	return c.JSON(memory)
+}
+
+func (h *MemoryHandler) DeleteMemory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	memoryID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid memory ID",
+		})
+	}
+
+	if err := h.service.DeleteMemory(memoryID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Memory deleted successfully",
+	})
+}
+
+// DeleteAllMemories clears the user's memory.
+func (h *MemoryHandler) DeleteAllMemories(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	if err := h.service.DeleteAllMemories(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Memories deleted successfully",
+	})
+}
+
+func memoryError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	if errors.Is(err, ErrMemoryFull) {
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,320 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/vectorstore"
+)
+
+// MemoryService keeps facts about users that carry over between chats.
+// With an embedder the memories relevant to a message are picked by
+// similarity, otherwise the most recent ones are used.
+type MemoryService struct {
+	db       *gorm.DB
+	embedder llm.Embedder
+	vectors  *vectorstore.Store
+}
+
+func NewMemoryService(db *gorm.DB, embedder llm.Embedder) *MemoryService {
+	return &MemoryService{
+		db:       db,
+		embedder: embedder,
+		vectors:  vectorstore.New(db),
+	}
+}
+
+// Enabled reports whether the user has memory turned on.
+func (s *MemoryService) Enabled(userID uuid.UUID) (bool, error) {
+	var preferences string
+	err := s.db.Table("users").
+		Where("id = ?", userID).
+		Select("preferences").
+		Scan(&preferences).Error
+	if err != nil {
+		return false, err
+	}
+
+	var values map[string]interface{}
+	if json.Unmarshal([]byte(preferences), &values) == nil {
+		if enabled, ok := values[memoryPreference].(bool); ok {
+			return enabled, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *MemoryService) GetMemories(userID uuid.UUID) (*MemoriesResponse, error) {
+	enabled, err := s.Enabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var memories []Memory
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&memories).Error; err != nil {
+		return nil, err
+	}
+	return &MemoriesResponse{Memories: memories, Enabled: enabled}, nil
+}
+
+func (s *MemoryService) GetMemory(id, userID uuid.UUID) (*Memory, error) {
+	var memory Memory
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&memory).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("memory not found")
+		}
+		return nil, err
+	}
+	return &memory, nil
+}
+
+// CreateMemory saves a fact. Saving a fact the user already has returns
+// the existing memory.
+func (s *MemoryService) CreateMemory(ctx context.Context, userID uuid.UUID, content, source string, chatID *uuid.UUID) (*Memory, error) {
+	content, err := cleanMemory(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing Memory
+	err = s.db.Where("user_id = ? AND LOWER(content) = LOWER(?)", userID, content).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&Memory{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= maxMemoriesPerUser {
+		return nil, ErrMemoryFull
+	}
+
+	memory := &Memory{
+		ID:      uuid.New(),
+		UserID:  userID,
+		Content: content,
+		Source:  source,
+		ChatID:  chatID,
+	}
+	if err := s.db.Create(memory).Error; err != nil {
+		return nil, err
+	}
+
+	s.index(ctx, memory)
+	return memory, nil
+}
+
+func (s *MemoryService) UpdateMemory(ctx context.Context, id, userID uuid.UUID, content string) (*Memory, error) {
+	memory, err := s.GetMemory(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if memory.Content, err = cleanMemory(content); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(memory).Error; err != nil {
+		return nil, err
+	}
+
+	s.index(ctx, memory)
+	return memory, nil
+}
+
+func (s *MemoryService) DeleteMemory(id, userID uuid.UUID) error {
+	memory, err := s.GetMemory(id, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if s.embedder != nil {
+			if err := vectorstore.New(tx).DeleteSource(context.Background(), memoryNamespace, memory.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(memory).Error
+	})
+}
+
+// DeleteAllMemories makes the assistant forget everything about the user.
+func (s *MemoryService) DeleteAllMemories(userID uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Without an embedder there may be no vector store at all
+		if s.embedder != nil {
+			if err := vectorstore.New(tx).DeleteScope(context.Background(), memoryNamespace, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Where("user_id = ?", userID).Delete(&Memory{}).Error
+	})
+}
+
+// Recall returns the memories relevant to query, at most memoryTopK.
+func (s *MemoryService) Recall(ctx context.Context, userID uuid.UUID, query string) ([]Memory, error) {
+	var count int64
+	if err := s.db.Model(&Memory{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	if count <= memoryTopK || s.embedder == nil || strings.TrimSpace(query) == "" {
+		var memories []Memory
+		err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(memoryTopK).Find(&memories).Error
+		return memories, err
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	matches, err := s.vectors.Search(ctx, vectorstore.Query{
+		Namespace: memoryNamespace,
+		ScopeIDs:  []uuid.UUID{userID},
+		Model:     s.embedder.Model(),
+		Vector:    vectors[0],
+		Limit:     memoryTopK,
+		MinScore:  memoryMinScore,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	memories := make([]Memory, len(matches))
+	for i, match := range matches {
+		memories[i] = Memory{ID: match.SourceID, UserID: userID, Content: match.Content}
+	}
+	return memories, nil
+}
+
+// index embeds a memory for Recall. A memory that cannot be embedded is
+// kept and only found while the user has few memories.
+func (s *MemoryService) index(ctx context.Context, memory *Memory) {
+	if s.embedder == nil {
+		return
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{memory.Content})
+	if err == nil {
+		err = s.vectors.Transaction(ctx, func(store *vectorstore.Store) error {
+			if err := store.DeleteSource(ctx, memoryNamespace, memory.ID); err != nil {
+				return err
+			}
+			return store.Add(ctx, []vectorstore.Entry{{
+				Namespace: memoryNamespace,
+				SourceID:  memory.ID,
+				ScopeID:   memory.UserID,
+				Content:   memory.Content,
+				Model:     s.embedder.Model(),
+				Embedding: vectors[0],
+			}})
+		})
+	}
+	if err != nil {
+		log.Printf("Memory %s: failed to index: %v", memory.ID, err)
+	}
+}
+
+func cleanMemory(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return "", errors.New("memory content is required")
+	}
+	if utf8.RuneCountInString(content) > maxMemoryLength {
+		return "", fmt.Errorf("memory is too long, maximum length is %d characters", maxMemoryLength)
+	}
+	return content, nil
+}
+
+// Service methods for memory
+
+// SetMemory recalls the user's memories in chats.
+func (s *Service) SetMemory(memory *MemoryService) {
+	s.memory = memory
+}
+
+// memoryEnabled reports whether memories are used in the user's chats.
+func (s *Service) memoryEnabled(userID uuid.UUID) bool {
+	if s.memory == nil {
+		return false
+	}
+	enabled, err := s.memory.Enabled(userID)
+	if err != nil {
+		log.Printf("User %s: failed to read memory preference: %v", userID, err)
+		return false
+	}
+	return enabled
+}
+
+// recallMemories looks up memories for the latest user message of
+// history. Failures only cost the reply its memories.
+func (s *Service) recallMemories(ctx context.Context, userID uuid.UUID, history []Message) []Memory {
+	if !s.memoryEnabled(userID) {
+		return nil
+	}
+
+	query := ""
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == "user" {
+			query = history[i].Content
+			break
+		}
+	}
+
+	memories, err := s.memory.Recall(ctx, userID, query)
+	if err != nil {
+		log.Printf("User %s: memory recall failed: %v", userID, err)
+		return nil
+	}
+	return memories
+}
+
+// filterMemoryTool drops the memory tool from the tools offered in a chat
+// unless the user has memory on.
+func (s *Service) filterMemoryTool(userID uuid.UUID, tools []openai.Tool) []openai.Tool {
+	if s.memoryEnabled(userID) {
+		return tools
+	}
+
+	offered := tools[:0]
+	for _, tool := range tools {
+		if tool.Function == nil || tool.Function.Name != memoryToolName {
+			offered = append(offered, tool)
+		}
+	}
+	return offered
+}
+
+// withMemories appends the recalled memories to the system prompt.
+func withMemories(systemPrompt string, memories []Memory) string {
+	if len(memories) == 0 {
+		return systemPrompt
+	}
+
+	var b strings.Builder
+	if systemPrompt != "" {
+		b.WriteString(systemPrompt)
+		b.WriteString("\n\n")
+	}
+	b.WriteString(memoryContextPrefix)
+	for _, memory := range memories {
+		b.WriteString("\n- ")
+		b.WriteString(memory.Content)
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultModel is used for chats created without a model.
+const defaultModel = "gpt-4o"
+
+var (
+	ErrModelNotAvailable = errors.New("model is not available")
+	ErrModelTierRequired = errors.New("model requires a higher subscription tier")
+
+	ErrDefaultModelRequired = errors.New("the default model cannot be deleted")
+)
+
+// subscriptionTierRanks orders subscription tiers for model gating. Unknown
+// tiers rank as basic.
+var subscriptionTierRanks = map[string]int{
+	"basic":           0,
+	"premium_starter": 1,
+	"premium":         1,
+	"premium_pro":     2,
+	"premium_ultra":   3,
+	"unlimited":       4,
+}
+
+func tierRank(tier string) int {
+	return subscriptionTierRanks[tier]
+}
+
+func IsValidSubscriptionTier(tier string) bool {
+	_, ok := subscriptionTierRanks[tier]
+	return ok
+}
+
+// CatalogModel is a chat model users may select. Prices are in USD per
+// million tokens.
+type CatalogModel struct {
+	ID                 uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name               string    `gorm:"type:varchar(50);not null;uniqueIndex" json:"name"` // as stored in Chat.Model
+	DisplayName        string    `gorm:"type:varchar(100)" json:"display_name"`
+	Provider           string    `gorm:"type:varchar(50);not null" json:"provider"`
+	ContextWindow      int       `gorm:"not null" json:"context_window"`
+	InputPrice         float64   `gorm:"type:decimal(10,4);default:0" json:"input_price"`
+	OutputPrice        float64   `gorm:"type:decimal(10,4);default:0" json:"output_price"`
+	SupportsVision     bool      `gorm:"default:false" json:"supports_vision"`
+	SupportsTools      bool      `gorm:"default:false" json:"supports_tools"`
+	SupportsJSONSchema bool      `gorm:"default:false" json:"supports_json_schema"` // takes response_format json_schema natively
+	MinTier            string    `gorm:"type:varchar(20);default:'basic'" json:"min_tier"`
+	Fallbacks          string    `gorm:"type:text" json:"fallbacks"` // comma-separated models tried in order when this one fails
+	Enabled            bool      `gorm:"default:true" json:"enabled"`
+	Position           int       `gorm:"default:0" json:"position"`
+	CreatedAt          time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt          time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (CatalogModel) TableName() string {
+	return "model_catalog"
+}
+
+// Cost returns the price in USD of a request with the given token counts.
+func (m *CatalogModel) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*m.InputPrice + float64(completionTokens)*m.OutputPrice) / 1e6
+}
+
+// FallbackChain returns the models to try, in order, when this one fails.
+func (m *CatalogModel) FallbackChain() []string {
+	var chain []string
+	for _, name := range strings.Split(m.Fallbacks, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
+
+func encodeFallbacks(names []string) string {
+	cleaned := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			cleaned = append(cleaned, name)
+		}
+	}
+	return strings.Join(cleaned, ",")
+}
+
+// AvailableTo reports whether a user of the given tier may use the model.
+func (m *CatalogModel) AvailableTo(tier string) bool {
+	return tierRank(tier) >= tierRank(m.MinTier)
+}
+
+type ModelResponse struct {
+	Name               string  `json:"name"`
+	DisplayName        string  `json:"display_name"`
+	Provider           string  `json:"provider"`
+	ContextWindow      int     `json:"context_window"`
+	InputPrice         float64 `json:"input_price"`
+	OutputPrice        float64 `json:"output_price"`
+	SupportsVision     bool    `json:"supports_vision"`
+	SupportsTools      bool    `json:"supports_tools"`
+	SupportsJSONSchema bool    `json:"supports_json_schema"`
+	MinTier            string  `json:"min_tier"`
+	Available          bool    `json:"available"` // for the requesting user's tier
+}
+
+type CreateCatalogModelRequest struct {
+	Name               string   `json:"name"`
+	DisplayName        string   `json:"display_name"`
+	Provider           string   `json:"provider"`
+	ContextWindow      int      `json:"context_window"`
+	InputPrice         float64  `json:"input_price"`
+	OutputPrice        float64  `json:"output_price"`
+	SupportsVision     bool     `json:"supports_vision"`
+	SupportsTools      bool     `json:"supports_tools"`
+	SupportsJSONSchema bool     `json:"supports_json_schema"`
+	MinTier            string   `json:"min_tier"`
+	Fallbacks          []string `json:"fallbacks,omitempty"`
+	Enabled            *bool    `json:"enabled,omitempty"` // default true
+	Position           int      `json:"position"`
+}
+
+type UpdateCatalogModelRequest struct {
+	DisplayName        *string   `json:"display_name,omitempty"`
+	Provider           *string   `json:"provider,omitempty"`
+	ContextWindow      *int      `json:"context_window,omitempty"`
+	InputPrice         *float64  `json:"input_price,omitempty"`
+	OutputPrice        *float64  `json:"output_price,omitempty"`
+	SupportsVision     *bool     `json:"supports_vision,omitempty"`
+	SupportsTools      *bool     `json:"supports_tools,omitempty"`
+	SupportsJSONSchema *bool     `json:"supports_json_schema,omitempty"`
+	MinTier            *string   `json:"min_tier,omitempty"`
+	Fallbacks          *[]string `json:"fallbacks,omitempty"`
+	Enabled            *bool     `json:"enabled,omitempty"`
+	Position           *int      `json:"position,omitempty"`
+}
+
+func (m *CatalogModel) ToDTO(tier string) ModelResponse {
+	return ModelResponse{
+		Name:               m.Name,
+		DisplayName:        m.DisplayName,
+		Provider:           m.Provider,
+		ContextWindow:      m.ContextWindow,
+		InputPrice:         m.InputPrice,
+		OutputPrice:        m.OutputPrice,
+		SupportsVision:     m.SupportsVision,
+		SupportsTools:      m.SupportsTools,
+		SupportsJSONSchema: m.SupportsJSONSchema,
+		MinTier:            m.MinTier,
+		Available:          m.AvailableTo(tier),
+	}
+}
+
+func (m *CatalogModel) validate() error {
+	if m.Name == "" {
+		return errors.New("model name is required")
+	}
+	if m.Provider == "" {
+		return errors.New("provider is required")
+	}
+	if m.ContextWindow <= 0 {
+		return errors.New("context window must be positive")
+	}
+	if m.InputPrice < 0 || m.OutputPrice < 0 {
+		return errors.New("prices cannot be negative")
+	}
+	if !IsValidSubscriptionTier(m.MinTier) {
+		return fmt.Errorf("invalid minimum tier %q", m.MinTier)
+	}
+	for _, name := range m.FallbackChain() {
+		if name == m.Name {
+			return errors.New("a model cannot be its own fallback")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ModelCatalogHandler struct {
+	service *ModelCatalogService
+}
+
+func NewModelCatalogHandler(service *ModelCatalogService) *ModelCatalogHandler {
+	return &ModelCatalogHandler{service: service}
+}
+
+// modelErrorStatus maps catalog errors to a status, or returns fallback for
+// other errors.
+func modelErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, ErrModelNotAvailable):
+		return fiber.StatusBadRequest
+	case errors.Is(err, ErrModelTierRequired):
+		return fiber.StatusForbidden
+	}
+	return fallback
+}
+
+func (h *ModelCatalogHandler) GetModels(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	models, err := h.service.GetModels(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(models)
+}
+
+// Admin handlers
+
+func (h *ModelCatalogHandler) GetAllModels(c *fiber.Ctx) error {
+	models, err := h.service.GetAllModels()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(models)
+}
+
+func (h *ModelCatalogHandler) CreateModel(c *fiber.Ctx) error {
+	var req CreateCatalogModelRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	model, err := h.service.CreateModel(&req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(model)
+}
+
+func (h *ModelCatalogHandler) UpdateModel(c *fiber.Ctx) error {
+	modelID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid model ID",
+		})
+	}
+
+	var req UpdateCatalogModelRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	model, err := h.service.UpdateModel(modelID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(model)
+}
+
+func (h *ModelCatalogHandler) DeleteModel(c *fiber.Ctx) error {
+	modelID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid model ID",
+		})
+	}
+
+	if err := h.service.DeleteModel(modelID); err != nil {
+		status := fiber.StatusNotFound
+		if errors.Is(err, ErrDefaultModelRequired) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Model deleted successfully",
+	})
+}
@@ -0,0 +1,250 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ModelCatalogService struct {
+	db *gorm.DB
+}
+
+func NewModelCatalogService(db *gorm.DB) *ModelCatalogService {
+	return &ModelCatalogService{db: db}
+}
+
+// GetModels lists the enabled models, marking those the user's tier allows.
+func (s *ModelCatalogService) GetModels(userID uuid.UUID) ([]ModelResponse, error) {
+	tier, _, err := s.userTier(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []CatalogModel
+	if err := s.db.Where("enabled = ?", true).Order("position ASC, name ASC").Find(&models).Error; err != nil {
+		return nil, err
+	}
+
+	out := make([]ModelResponse, len(models))
+	for i := range models {
+		out[i] = models[i].ToDTO(tier)
+	}
+	return out, nil
+}
+
+// GetModel returns an enabled catalog model by name.
+func (s *ModelCatalogService) GetModel(name string) (*CatalogModel, error) {
+	var model CatalogModel
+	if err := s.db.Where("name = ? AND enabled = ?", name, true).First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrModelNotAvailable, name)
+		}
+		return nil, err
+	}
+	return &model, nil
+}
+
+// CheckAccess returns the catalog entry of a model if the user may use it.
+// Superadmins may use every enabled model.
+func (s *ModelCatalogService) CheckAccess(userID uuid.UUID, name string) (*CatalogModel, error) {
+	model, err := s.GetModel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tier, role, err := s.userTier(userID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "superadmin" && !model.AvailableTo(tier) {
+		return nil, fmt.Errorf("%w: %s needs the %s tier", ErrModelTierRequired, name, model.MinTier)
+	}
+	return model, nil
+}
+
+// ModelOrDefault returns model if the user may use it, or the default model
+// otherwise. It is used for chats copied from elsewhere, whose model the user
+// did not pick. A nil catalog accepts any model.
+func (s *ModelCatalogService) ModelOrDefault(userID uuid.UUID, model string) string {
+	if model == "" {
+		return defaultModel
+	}
+	if s == nil {
+		return model
+	}
+	if _, err := s.CheckAccess(userID, model); err != nil {
+		return defaultModel
+	}
+	return model
+}
+
+// CheapestModel returns the enabled model the user may use with the lowest
+// price, for background work such as titling chats.
+func (s *ModelCatalogService) CheapestModel(userID uuid.UUID) (string, error) {
+	tier, role, err := s.userTier(userID)
+	if err != nil {
+		return "", err
+	}
+
+	var models []CatalogModel
+	if err := s.db.Where("enabled = ?", true).Order("input_price + output_price ASC, position ASC").Find(&models).Error; err != nil {
+		return "", err
+	}
+	for i := range models {
+		if role == "superadmin" || models[i].AvailableTo(tier) {
+			return models[i].Name, nil
+		}
+	}
+	return "", ErrModelNotAvailable
+}
+
+func (s *ModelCatalogService) userTier(userID uuid.UUID) (string, string, error) {
+	var user struct {
+		SubscriptionTier string
+		Role             string
+	}
+	err := s.db.Table("users").
+		Where("id = ?", userID).
+		Select("subscription_tier, role").
+		Scan(&user).Error
+	return user.SubscriptionTier, user.Role, err
+}
+
+// Admin
+
+// GetAllModels lists the whole catalog, disabled models included.
+func (s *ModelCatalogService) GetAllModels() ([]CatalogModel, error) {
+	var models []CatalogModel
+	err := s.db.Order("position ASC, name ASC").Find(&models).Error
+	return models, err
+}
+
+func (s *ModelCatalogService) CreateModel(req *CreateCatalogModelRequest) (*CatalogModel, error) {
+	model := &CatalogModel{
+		ID:                 uuid.New(),
+		Name:               strings.TrimSpace(req.Name),
+		DisplayName:        req.DisplayName,
+		Provider:           req.Provider,
+		ContextWindow:      req.ContextWindow,
+		InputPrice:         req.InputPrice,
+		OutputPrice:        req.OutputPrice,
+		SupportsVision:     req.SupportsVision,
+		SupportsTools:      req.SupportsTools,
+		SupportsJSONSchema: req.SupportsJSONSchema,
+		MinTier:            req.MinTier,
+		Fallbacks:          encodeFallbacks(req.Fallbacks),
+		Enabled:            true,
+		Position:           req.Position,
+	}
+	if model.MinTier == "" {
+		model.MinTier = "basic"
+	}
+	if model.DisplayName == "" {
+		model.DisplayName = model.Name
+	}
+	if req.Enabled != nil {
+		model.Enabled = *req.Enabled
+	}
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	s.db.Model(&CatalogModel{}).Where("name = ?", model.Name).Count(&count)
+	if count > 0 {
+		return nil, errors.New("a model with this name already exists")
+	}
+
+	// Select all columns so an explicit enabled=false is not replaced by the
+	// column default
+	if err := s.db.Select("*").Create(model).Error; err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (s *ModelCatalogService) UpdateModel(id uuid.UUID, req *UpdateCatalogModelRequest) (*CatalogModel, error) {
+	var model CatalogModel
+	if err := s.db.Where("id = ?", id).First(&model).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("model not found")
+		}
+		return nil, err
+	}
+
+	if req.DisplayName != nil {
+		model.DisplayName = *req.DisplayName
+	}
+	if req.Provider != nil {
+		model.Provider = *req.Provider
+	}
+	if req.ContextWindow != nil {
+		model.ContextWindow = *req.ContextWindow
+	}
+	if req.InputPrice != nil {
+		model.InputPrice = *req.InputPrice
+	}
+	if req.OutputPrice != nil {
+		model.OutputPrice = *req.OutputPrice
+	}
+	if req.SupportsVision != nil {
+		model.SupportsVision = *req.SupportsVision
+	}
+	if req.SupportsTools != nil {
+		model.SupportsTools = *req.SupportsTools
+	}
+	if req.SupportsJSONSchema != nil {
+		model.SupportsJSONSchema = *req.SupportsJSONSchema
+	}
+	if req.MinTier != nil {
+		model.MinTier = *req.MinTier
+	}
+	if req.Fallbacks != nil {
+		model.Fallbacks = encodeFallbacks(*req.Fallbacks)
+	}
+	if req.Enabled != nil {
+		model.Enabled = *req.Enabled
+	}
+	if req.Position != nil {
+		model.Position = *req.Position
+	}
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(&model).Error; err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// DeleteModel removes a model from the catalog. Chats on it are moved to the
+// default model, which cannot be deleted, and personas suggesting it fall
+// back to the default too.
+func (s *ModelCatalogService) DeleteModel(id uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var model CatalogModel
+		if err := tx.Where("id = ?", id).First(&model).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("model not found")
+			}
+			return err
+		}
+		if model.Name == defaultModel {
+			return ErrDefaultModelRequired
+		}
+
+		if err := tx.Delete(&model).Error; err != nil {
+			return err
+		}
+		// Deleted chats too, so they can still be used once restored
+		if err := tx.Table("chats").Where("model = ?", model.Name).Update("model", defaultModel).Error; err != nil {
+			return err
+		}
+		return tx.Table("personas").Where("model = ?", model.Name).Update("model", "").Error
+	})
+}
@@ -1,97 +1,262 @@
 package chat
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
 	"gorm.io/gorm"
 )
 
 type Chat struct {
-	ID        uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID    uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
-	Title     string         `gorm:"type:varchar(255);default:'New Chat'" json:"title"`
-	Model     string         `gorm:"type:varchar(50);default:'gpt-4o'" json:"model"`
-	CreatedAt time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
-	Messages  []Message      `gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE" json:"messages,omitempty"`
+	ID               uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID           uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Title            string         `gorm:"type:varchar(255);default:'New Chat'" json:"title"`
+	Model            string         `gorm:"type:varchar(50);default:'gpt-4o'" json:"model"`
+	ContextStrategy  string         `gorm:"type:varchar(20);default:'summarize'" json:"context_strategy"` // summarize, truncate, full
+	ActiveLeafID     *uuid.UUID     `gorm:"type:uuid" json:"active_leaf_id,omitempty"`                    // last message of the selected branch
+	PersonaID        *uuid.UUID     `gorm:"type:uuid;index" json:"persona_id,omitempty"`
+	PersonaVariables string         `gorm:"type:text" json:"-"`                             // JSON map of persona variable values
+	TitleSource      string         `gorm:"type:varchar(10)" json:"title_source,omitempty"` // user or auto, empty until titled
+	Summary          string         `gorm:"type:text" json:"summary,omitempty"`             // generated with the title
+	CreatedAt        time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+	Messages         []Message      `gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE" json:"messages,omitempty"`
 }
 
 type Message struct {
-	ID        uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	ChatID    uuid.UUID      `gorm:"type:uuid;not null;index" json:"chat_id"`
-	Role      string         `gorm:"type:varchar(20);not null" json:"role"` // user, assistant, system
-	Content   string         `gorm:"type:text;not null" json:"content"`
-	Tokens    int            `gorm:"default:0" json:"tokens"`
-	Model     string         `gorm:"type:varchar(50)" json:"model,omitempty"`
-	CreatedAt time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID         uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ChatID     uuid.UUID      `gorm:"type:uuid;not null;index" json:"chat_id"`
+	ParentID   *uuid.UUID     `gorm:"type:uuid;index" json:"parent_id,omitempty"` // previous message in the branch, nil for roots
+	Role       string         `gorm:"type:varchar(20);not null" json:"role"`      // user, assistant, system, tool
+	Content    string         `gorm:"type:text;not null" json:"content"`
+	ToolCalls  string         `gorm:"type:text" json:"-"`                              // JSON []ToolCall requested by an assistant message
+	ToolCallID string         `gorm:"type:varchar(100)" json:"tool_call_id,omitempty"` // call answered by a tool message
+	Tokens     int            `gorm:"default:0" json:"tokens"`
+	Model      string         `gorm:"type:varchar(50)" json:"model,omitempty"`
+	Status     string         `gorm:"type:varchar(20);default:'completed'" json:"status"` // completed, stopped
+	Citations  string         `gorm:"type:text" json:"-"`                                 // JSON []Citation of document excerpts used
+	CreatedAt  time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Set on the answers of a comparison, see CompareModels
+	ComparisonID *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	PromptTokens int        `gorm:"default:0" json:"-"`
+	LatencyMs    int        `gorm:"default:0" json:"-"` // until the answer was complete
+	FirstTokenMs int        `gorm:"default:0" json:"-"` // until its first token
+
+	Attachments []MessageAttachment `gorm:"foreignKey:MessageID" json:"attachments,omitempty"`
+
+	// images holds the attachments as image URLs (data URLs for stored
+	// files) while a request is built; see loadAttachmentImages
+	images []openai.ChatMessageImageURL
+}
+
+// ChatSummary is the rolling summary of a chat's older messages, used when
+// the history no longer fits the model's context window.
+type ChatSummary struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ChatID          uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"chat_id"`
+	Content         string    `gorm:"type:text;not null" json:"content"`
+	LastMessageID   uuid.UUID `gorm:"type:uuid" json:"last_message_id"` // last message folded into the summary
+	SummarizedUntil time.Time `json:"summarized_until"`
+	MessageCount    int       `gorm:"default:0" json:"message_count"`
+	Tokens          int       `gorm:"default:0" json:"tokens"`
+	CreatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
 type CreateChatRequest struct {
-	Title string `json:"title"`
-	Model string `json:"model" validate:"required"`
+	Title            string            `json:"title"`
+	Model            string            `json:"model" validate:"required"`
+	ContextStrategy  string            `json:"context_strategy,omitempty"`
+	PersonaID        *uuid.UUID        `json:"persona_id,omitempty"`
+	PersonaVariables map[string]string `json:"persona_variables,omitempty"`
 }
 
 type UpdateChatRequest struct {
-	Title string `json:"title"`
-	Model string `json:"model"`
+	Title           string `json:"title"`
+	Model           string `json:"model"`
+	ContextStrategy string `json:"context_strategy,omitempty"`
 }
 
 type SendMessageRequest struct {
-	Content      string `json:"content" validate:"required"`
-	SystemPrompt string `json:"system_prompt,omitempty"`
+	Content        string            `json:"content" validate:"required"`
+	SystemPrompt   string            `json:"system_prompt,omitempty"`
+	Attachments    []AttachmentInput `json:"attachments,omitempty"`     // images, for vision models
+	ResponseFormat *ResponseFormat   `json:"response_format,omitempty"` // JSON reply, see streamReply
+}
+
+// EditMessageRequest replaces a user prompt by creating a sibling branch.
+type EditMessageRequest struct {
+	Content        string          `json:"content" validate:"required"`
+	SystemPrompt   string          `json:"system_prompt,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// RegenerateMessageRequest is the optional body of a regeneration.
+type RegenerateMessageRequest struct {
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type SwitchBranchRequest struct {
+	MessageID uuid.UUID `json:"message_id" validate:"required"`
 }
 
 type ChatResponse struct {
-	ID        uuid.UUID    `json:"id"`
-	UserID    uuid.UUID    `json:"user_id"`
-	Title     string       `json:"title"`
-	Model     string       `json:"model"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
-	Messages  []MessageDTO `json:"messages"`
+	ID               uuid.UUID         `json:"id"`
+	UserID           uuid.UUID         `json:"user_id"`
+	Title            string            `json:"title"`
+	Model            string            `json:"model"`
+	ContextStrategy  string            `json:"context_strategy"`
+	ActiveLeafID     *uuid.UUID        `json:"active_leaf_id,omitempty"`
+	PersonaID        *uuid.UUID        `json:"persona_id,omitempty"`
+	PersonaVariables map[string]string `json:"persona_variables,omitempty"`
+	TitleSource      string            `json:"title_source,omitempty"`
+	Summary          string            `json:"summary,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+	Messages         []MessageDTO      `json:"messages"`
 }
 
 type MessageDTO struct {
-	ID        uuid.UUID `json:"id"`
-	ChatID    uuid.UUID `json:"chat_id"`
-	Role      string    `json:"role"`
-	Content   string    `json:"content"`
-	Tokens    int       `json:"tokens"`
-	Model     string    `json:"model,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	ID           uuid.UUID            `json:"id"`
+	ChatID       uuid.UUID            `json:"chat_id"`
+	ParentID     *uuid.UUID           `json:"parent_id,omitempty"`
+	SiblingCount int                  `json:"sibling_count"` // versions of this message, including itself
+	SiblingIndex int                  `json:"sibling_index"` // position among them, oldest first
+	Role         string               `json:"role"`
+	Content      string               `json:"content"`
+	Attachments  []AttachmentResponse `json:"attachments,omitempty"`
+	ToolCalls    []ToolCall           `json:"tool_calls,omitempty"`
+	ToolCallID   string               `json:"tool_call_id,omitempty"`
+	Tokens       int                  `json:"tokens"`
+	Model        string               `json:"model,omitempty"`
+	Status       string               `json:"status"`
+	Citations    []Citation           `json:"citations,omitempty"`
+	ComparisonID *uuid.UUID           `json:"comparison_id,omitempty"`
+	PromptTokens int                  `json:"prompt_tokens,omitempty"`
+	LatencyMs    int                  `json:"latency_ms,omitempty"`
+	FirstTokenMs int                  `json:"first_token_ms,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+// ToolCall is a tool invocation requested by the model.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Stream chunk types
+const (
+	ChunkTypeContent    = "content"
+	ChunkTypeToolCall   = "tool_call"
+	ChunkTypeToolResult = "tool_result"
+)
+
+// ToolChunk describes a tool call or its result in a stream.
+type ToolChunk struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments,omitempty"`
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 type StreamChunk struct {
-	Delta      string `json:"delta"`
-	MessageID  string `json:"message_id"`
-	Done       bool   `json:"done"`
-	TotalTokens int   `json:"total_tokens,omitempty"`
+	Type             string     `json:"type"`
+	Delta            string     `json:"delta"`
+	MessageID        string     `json:"message_id"`
+	Tool             *ToolChunk `json:"tool,omitempty"`
+	Done             bool       `json:"done"`
+	Model            string     `json:"model,omitempty"` // model that answered, sent with the final chunk
+	Stopped          bool       `json:"stopped,omitempty"`
+	Blocked          bool       `json:"blocked,omitempty"` // reply withheld by moderation, the streamed text is to be discarded
+	PromptTokens     int        `json:"prompt_tokens,omitempty"`
+	CompletionTokens int        `json:"completion_tokens,omitempty"`
+	TotalTokens      int        `json:"total_tokens,omitempty"`
+	Citations        []Citation `json:"citations,omitempty"` // sent with the final chunk
+}
+
+// DecodeToolCalls returns the tool calls stored on an assistant message.
+func (m *Message) DecodeToolCalls() []ToolCall {
+	if m.ToolCalls == "" {
+		return nil
+	}
+	var calls []ToolCall
+	if err := json.Unmarshal([]byte(m.ToolCalls), &calls); err != nil {
+		return nil
+	}
+	return calls
 }
 
+func (m *Message) ToDTO() MessageDTO {
+	var attachments []AttachmentResponse
+	for i := range m.Attachments {
+		attachments = append(attachments, m.Attachments[i].ToDTO())
+	}
+
+	return MessageDTO{
+		ID:           m.ID,
+		ChatID:       m.ChatID,
+		ParentID:     m.ParentID,
+		SiblingCount: 1,
+		Role:         m.Role,
+		Content:      m.Content,
+		Attachments:  attachments,
+		ToolCalls:    m.DecodeToolCalls(),
+		ToolCallID:   m.ToolCallID,
+		Tokens:       m.Tokens,
+		Model:        m.Model,
+		Status:       m.Status,
+		Citations:    m.DecodeCitations(),
+		ComparisonID: m.ComparisonID,
+		PromptTokens: m.PromptTokens,
+		LatencyMs:    m.LatencyMs,
+		FirstTokenMs: m.FirstTokenMs,
+		CreatedAt:    m.CreatedAt,
+	}
+}
+
+// ToDTO returns the chat with the messages of its active branch only. Each
+// message reports how many sibling versions exist so clients can switch.
 func (c *Chat) ToDTO() *ChatResponse {
-	messages := make([]MessageDTO, len(c.Messages))
-	for i, msg := range c.Messages {
-		messages[i] = MessageDTO{
-			ID:        msg.ID,
-			ChatID:    msg.ChatID,
-			Role:      msg.Role,
-			Content:   msg.Content,
-			Tokens:    msg.Tokens,
-			Model:     msg.Model,
-			CreatedAt: msg.CreatedAt,
+	path := c.ActivePath()
+	messages := make([]MessageDTO, len(path))
+	var tree messageTree
+	if c.ActiveLeafID != nil {
+		tree = newMessageTree(c.Messages)
+	}
+	for i, msg := range path {
+		messages[i] = msg.ToDTO()
+		if tree == nil {
+			continue // linear chat from before branching
+		}
+		siblings := tree.children(msg.ParentID)
+		messages[i].SiblingCount = len(siblings)
+		for j, sibling := range siblings {
+			if sibling.ID == msg.ID {
+				messages[i].SiblingIndex = j
+			}
 		}
 	}
 
 	return &ChatResponse{
-		ID:        c.ID,
-		UserID:    c.UserID,
-		Title:     c.Title,
-		Model:     c.Model,
-		CreatedAt: c.CreatedAt,
-		UpdatedAt: c.UpdatedAt,
-		Messages:  messages,
+		ID:               c.ID,
+		UserID:           c.UserID,
+		Title:            c.Title,
+		Model:            c.Model,
+		ContextStrategy:  c.ContextStrategy,
+		ActiveLeafID:     c.ActiveLeafID,
+		PersonaID:        c.PersonaID,
+		PersonaVariables: c.DecodePersonaVariables(),
+		TitleSource:      c.TitleSource,
+		Summary:          c.Summary,
+		CreatedAt:        c.CreatedAt,
+		UpdatedAt:        c.UpdatedAt,
+		Messages:         messages,
 	}
 }
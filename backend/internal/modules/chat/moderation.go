@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+)
+
+// SetModeration checks prompts before they are saved and replies before
+// they are kept. Without it content is not moderated.
+func (s *Service) SetModeration(moderationService *moderation.Service) {
+	s.moderation = moderationService
+}
+
+// moderatePrompt checks a prompt about to be saved to a chat. A blocked
+// prompt is recorded and rejected with moderation.ErrContentBlocked; the
+// verdict of a flagged one is returned to be recorded with recordPrompt once
+// the prompt has an ID.
+func (s *Service) moderatePrompt(ctx context.Context, chatID, userID uuid.UUID, content string) (*moderation.Verdict, error) {
+	if s.moderation == nil {
+		return nil, nil
+	}
+
+	verdict := s.moderation.Check(ctx, content)
+	if verdict.Blocked() {
+		s.moderation.Record(verdict, &moderation.ModerationEvent{
+			UserID:  userID,
+			Source:  moderation.SourceChatPrompt,
+			Content: content,
+			ChatID:  &chatID,
+		})
+		return nil, moderation.ErrContentBlocked
+	}
+	return verdict, nil
+}
+
+// recordPrompt queues a saved prompt for review if it was flagged.
+func (s *Service) recordPrompt(verdict *moderation.Verdict, userID uuid.UUID, message *Message) {
+	if s.moderation == nil {
+		return
+	}
+	s.moderation.Record(verdict, &moderation.ModerationEvent{
+		UserID:    userID,
+		Source:    moderation.SourceChatPrompt,
+		Content:   message.Content,
+		ChatID:    &message.ChatID,
+		MessageID: &message.ID,
+	})
+}
+
+// moderateReply checks a finished reply before it is saved. A blocked reply
+// is saved as moderation.BlockedReplyNotice instead, and true is returned
+// so the client can replace the text it was streamed.
+func (s *Service) moderateReply(ctx context.Context, userID uuid.UUID, message *Message) bool {
+	if s.moderation == nil {
+		return false
+	}
+
+	verdict := s.moderation.Check(ctx, message.Content)
+	s.moderation.Record(verdict, &moderation.ModerationEvent{
+		UserID:    userID,
+		Source:    moderation.SourceChatReply,
+		Content:   message.Content,
+		ChatID:    &message.ChatID,
+		MessageID: &message.ID,
+	})
+	if verdict.Blocked() {
+		message.Content = moderation.BlockedReplyNotice
+		return true
+	}
+	return false
+}
+
+// moderateRequest checks a prompt that is not saved to a chat, e.g. of the
+// OpenAI-compatible API or of an image. Flagged prompts are recorded, and
+// blocked ones are recorded and rejected with moderation.ErrContentBlocked.
+func (s *Service) moderateRequest(ctx context.Context, userID uuid.UUID, source, content string) error {
+	if s.moderation == nil {
+		return nil
+	}
+
+	verdict := s.moderation.Check(ctx, content)
+	s.moderation.Record(verdict, &moderation.ModerationEvent{
+		UserID:  userID,
+		Source:  source,
+		Content: content,
+	})
+	if verdict.Blocked() {
+		return moderation.ErrContentBlocked
+	}
+	return nil
+}
+
+// moderateChoices checks the replies of a completion that is not saved to
+// a chat. Blocked replies are replaced by moderation.BlockedReplyNotice
+// with the content_filter finish reason.
+func (s *Service) moderateChoices(ctx context.Context, userID uuid.UUID, choices []openai.ChatCompletionChoice) {
+	for i := range choices {
+		if s.moderateCompletion(ctx, userID, choices[i].Message.Content) {
+			choices[i].Message.Content = moderation.BlockedReplyNotice
+			choices[i].Message.ToolCalls = nil
+			choices[i].FinishReason = openai.FinishReasonContentFilter
+		}
+	}
+}
+
+// moderateCompletion checks a reply that is not saved to a chat, records it
+// if flagged and reports whether it must be withheld.
+func (s *Service) moderateCompletion(ctx context.Context, userID uuid.UUID, content string) bool {
+	if s.moderation == nil {
+		return false
+	}
+
+	verdict := s.moderation.Check(ctx, content)
+	s.moderation.Record(verdict, &moderation.ModerationEvent{
+		UserID:  userID,
+		Source:  moderation.SourceAPIReply,
+		Content: content,
+	})
+	return verdict.Blocked()
+}
+
+// promptText returns the text of the latest turn of a conversation: the
+// user and system messages after the last assistant message. Earlier turns
+// were checked when they were sent.
+func promptText(messages []openai.ChatCompletionMessage) string {
+	start := 0
+	for i, msg := range messages {
+		if msg.Role == openai.ChatMessageRoleAssistant {
+			start = i + 1
+		}
+	}
+
+	var parts []string
+	for _, msg := range messages[start:] {
+		if msg.Role != openai.ChatMessageRoleUser && msg.Role != openai.ChatMessageRoleSystem {
+			continue
+		}
+		if msg.Content != "" {
+			parts = append(parts, msg.Content)
+		}
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeText && part.Text != "" {
+				parts = append(parts, part.Text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+)
+
+// The /v1 API speaks the OpenAI wire format so OpenAI SDKs and tools can be
+// pointed at this server with a personal API key. Requests are metered like
+// chats: against the token quota and in the usage analytics.
+
+var (
+	errAPIInvalidRequest = errors.New("invalid request")
+	errAPIQuotaExceeded  = errors.New("token quota exceeded")
+)
+
+// APIModel is a model as listed by /v1/models.
+type APIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"` // always "model"
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type APIModelList struct {
+	Object string     `json:"object"` // always "list"
+	Data   []APIModel `json:"data"`
+}
+
+// APIImageRequest is the body of /v1/images/generations.
+type APIImageRequest struct {
+	Prompt         string `json:"prompt"`
+	Model          string `json:"model"`
+	N              int    `json:"n"`
+	Size           string `json:"size"`
+	Quality        string `json:"quality"`
+	Style          string `json:"style"`
+	ResponseFormat string `json:"response_format"` // url (default) or b64_json
+	User           string `json:"user"`
+}
+
+type APIImage struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+type APIImageResponse struct {
+	Created int64      `json:"created"`
+	Data    []APIImage `json:"data"`
+}
+
+// APIStream relays a provider stream to an API client. The usage is charged
+// when the stream is closed, counted locally if the provider reported none.
+type APIStream struct {
+	service      *Service
+	stream       llm.ChatStream
+	userID       uuid.UUID
+	id           string
+	model        string
+	created      int64
+	promptTokens int
+	includeUsage bool // the client asked for the usage chunk
+
+	completion strings.Builder
+	usage      *openai.Usage
+	moderated  bool // the reply was checked by the moderation policy
+	closed     bool
+}
+
+// completedStream replays a finished completion as a stream: the reply in
+// one chunk, then the usage.
+type completedStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+}
+
+func newCompletedStream(resp openai.ChatCompletionResponse) *completedStream {
+	stream := &completedStream{}
+	for _, choice := range resp.Choices {
+		stream.chunks = append(stream.chunks, openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{
+				Index: choice.Index,
+				Delta: openai.ChatCompletionStreamChoiceDelta{
+					Role:      openai.ChatMessageRoleAssistant,
+					Content:   choice.Message.Content,
+					ToolCalls: choice.Message.ToolCalls,
+				},
+				FinishReason: choice.FinishReason,
+			}},
+		})
+	}
+	usage := resp.Usage
+	stream.chunks = append(stream.chunks, openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{},
+		Usage:   &usage,
+	})
+	return stream
+}
+
+func (s *completedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *completedStream) Close() error {
+	return nil
+}
@@ -0,0 +1,213 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+)
+
+// OpenAIAPIHandler serves the /v1 routes. Responses and errors follow the
+// OpenAI API so its SDKs work unchanged.
+type OpenAIAPIHandler struct {
+	service *Service
+	images  *ImagesService
+}
+
+func NewOpenAIAPIHandler(service *Service, images *ImagesService) *OpenAIAPIHandler {
+	return &OpenAIAPIHandler{service: service, images: images}
+}
+
+// ChatCompletions answers POST /v1/chat/completions, streamed as
+// server-sent events when "stream" is set.
+func (h *OpenAIAPIHandler) ChatCompletions(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req openai.ChatCompletionRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return apiError(c, fmt.Errorf("%w: the request body is not valid JSON", errAPIInvalidRequest), fiber.StatusBadRequest)
+	}
+	// The response format again, with the schema exactly as sent
+	var format struct {
+		ResponseFormat *ResponseFormat `json:"response_format"`
+	}
+	json.Unmarshal(c.Body(), &format)
+
+	if !req.Stream {
+		resp, err := h.service.APIChatCompletion(c.Context(), userID, req, format.ResponseFormat)
+		if err != nil {
+			return apiError(c, err, fiber.StatusBadGateway)
+		}
+		return c.JSON(resp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := h.service.APIChatCompletionStream(ctx, userID, req, format.ResponseFormat)
+	if err != nil {
+		cancel()
+		return apiError(c, err, fiber.StatusBadGateway)
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("Transfer-Encoding", "chunked")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer stream.Close()
+
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				data, _ := json.Marshal(apiErrorBody(err, "server_error", nil))
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				w.Flush()
+				return
+			}
+
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			if err := w.Flush(); err != nil {
+				// Client went away
+				return
+			}
+		}
+
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		w.Flush()
+	})
+
+	return nil
+}
+
+// GetModels answers GET /v1/models.
+func (h *OpenAIAPIHandler) GetModels(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	models, err := h.service.APIModels(userID)
+	if err != nil {
+		return apiError(c, err, fiber.StatusInternalServerError)
+	}
+
+	return c.JSON(models)
+}
+
+// GetModel answers GET /v1/models/:model.
+func (h *OpenAIAPIHandler) GetModel(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	model, err := h.service.APIModel(userID, c.Params("model"))
+	if err != nil {
+		return apiError(c, err, fiber.StatusInternalServerError)
+	}
+
+	return c.JSON(model)
+}
+
+// GenerateImages answers POST /v1/images/generations. URLs point to
+// /v1/images/:id/content, which takes the same API key.
+func (h *OpenAIAPIHandler) GenerateImages(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req APIImageRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return apiError(c, fmt.Errorf("%w: the request body is not valid JSON", errAPIInvalidRequest), fiber.StatusBadRequest)
+	}
+
+	images, err := h.service.APIGenerateImages(c.Context(), userID, &req)
+	if err != nil {
+		return apiError(c, err, fiber.StatusBadRequest)
+	}
+
+	resp := APIImageResponse{
+		Created: time.Now().Unix(),
+		Data:    make([]APIImage, 0, len(images)),
+	}
+	for _, image := range images {
+		item := APIImage{RevisedPrompt: image.RevisedPrompt}
+		if req.ResponseFormat == "b64_json" {
+			_, data, err := h.images.GetImageContent(c.Context(), image.ID, userID)
+			if err != nil {
+				return apiError(c, err, fiber.StatusInternalServerError)
+			}
+			item.B64JSON = base64.StdEncoding.EncodeToString(data)
+		} else {
+			item.URL = fmt.Sprintf("%s/v1/images/%s/content", c.BaseURL(), image.ID)
+		}
+		resp.Data = append(resp.Data, item)
+	}
+
+	return c.JSON(resp)
+}
+
+// GetImageContent serves an image generated through the API.
+func (h *OpenAIAPIHandler) GetImageContent(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	imageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return apiError(c, fmt.Errorf("%w: invalid image ID", errAPIInvalidRequest), fiber.StatusBadRequest)
+	}
+
+	image, data, err := h.images.GetImageContent(c.Context(), imageID, userID)
+	if err != nil {
+		return apiError(c, err, fiber.StatusNotFound)
+	}
+
+	c.Set(fiber.HeaderContentType, image.ContentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.Send(data)
+}
+
+// apiError writes err in the OpenAI error format, with fallback as the
+// status of errors that are not recognized.
+func apiError(c *fiber.Ctx, err error, fallback int) error {
+	status, errType := fallback, "invalid_request_error"
+	var code interface{}
+	var outputErr *StructuredOutputError
+	switch {
+	case errors.Is(err, errAPIInvalidRequest):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, ErrInvalidResponseFormat):
+		status, code = fiber.StatusBadRequest, "invalid_response_format"
+	case errors.As(err, &outputErr):
+		status, code = fiber.StatusUnprocessableEntity, "response_format_mismatch"
+	case errors.Is(err, moderation.ErrContentBlocked):
+		status, code = fiber.StatusBadRequest, "content_policy_violation"
+	case errors.Is(err, errAPIQuotaExceeded):
+		status, errType, code = fiber.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"
+	case errors.Is(err, ErrModelNotAvailable):
+		status, code = fiber.StatusNotFound, "model_not_found"
+	case errors.Is(err, ErrModelTierRequired):
+		status, code = fiber.StatusForbidden, "model_not_available"
+	case errors.Is(err, ErrImagesDisabled):
+		status, errType = fiber.StatusServiceUnavailable, "server_error"
+	case fallback >= fiber.StatusInternalServerError:
+		errType = "server_error"
+	}
+	return c.Status(status).JSON(apiErrorBody(err, errType, code))
+}
+
+func apiErrorBody(err error, errType string, code interface{}) fiber.Map {
+	return fiber.Map{
+		"error": fiber.Map{
+			"message": err.Error(),
+			"type":    errType,
+			"param":   nil,
+			"code":    code,
+		},
+	}
+}
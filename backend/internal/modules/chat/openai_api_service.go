@@ -0,0 +1,327 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// Recv returns the next chunk, tagged with the request's ID and model. The
+// usage chunk is only passed on when the client asked for it. A streamed
+// reply can only be checked once it has been sent; when the policy blocks
+// it, a last chunk with the content_filter finish reason tells the client
+// to discard it.
+func (st *APIStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for {
+		chunk, err := st.stream.Recv()
+		if errors.Is(err, io.EOF) && !st.moderated {
+			st.moderated = true
+			if st.service.moderateCompletion(context.Background(), st.userID, st.completion.String()) {
+				return st.tag(openai.ChatCompletionStreamResponse{
+					Choices: []openai.ChatCompletionStreamChoice{{
+						Delta:        openai.ChatCompletionStreamChoiceDelta{Content: ""},
+						FinishReason: openai.FinishReasonContentFilter,
+					}},
+				}), nil
+			}
+		}
+		if err != nil {
+			return chunk, err
+		}
+
+		for _, choice := range chunk.Choices {
+			st.completion.WriteString(choice.Delta.Content)
+			for _, call := range choice.Delta.ToolCalls {
+				st.completion.WriteString(call.Function.Arguments)
+			}
+		}
+		if chunk.Usage != nil {
+			st.usage = chunk.Usage
+			if !st.includeUsage {
+				if len(chunk.Choices) == 0 {
+					continue
+				}
+				chunk.Usage = nil
+			}
+		}
+
+		return st.tag(chunk), nil
+	}
+}
+
+func (st *APIStream) tag(chunk openai.ChatCompletionStreamResponse) openai.ChatCompletionStreamResponse {
+	chunk.ID = st.id
+	chunk.Object = "chat.completion.chunk"
+	chunk.Created = st.created
+	chunk.Model = st.model
+	return chunk
+}
+
+// Close ends the stream and charges what was generated so far.
+func (st *APIStream) Close() error {
+	if st.closed {
+		return nil
+	}
+	st.closed = true
+
+	err := st.stream.Close()
+	usage := resolveUsage(st.usage, st.model, st.promptTokens, st.completion.String())
+	st.service.recordAPIUsage(st.userID, usage.TotalTokens)
+	return err
+}
+
+// Service methods for the OpenAI-compatible API
+
+// SetAnalytics records API usage in the usage analytics.
+func (s *Service) SetAnalytics(analytics *AnalyticsService) {
+	s.analytics = analytics
+}
+
+// prepareAPICompletion checks a completion request against the catalog, the
+// user's quota and the moderation policy, and returns the models to try
+// with the prompt size.
+func (s *Service) prepareAPICompletion(ctx context.Context, userID uuid.UUID, req *openai.ChatCompletionRequest) ([]string, int, error) {
+	if req.Model == "" {
+		return nil, 0, fmt.Errorf("%w: you must provide a model parameter", errAPIInvalidRequest)
+	}
+	if len(req.Messages) == 0 {
+		return nil, 0, fmt.Errorf("%w: messages must not be empty", errAPIInvalidRequest)
+	}
+	if err := s.checkModel(userID, req.Model); err != nil {
+		return nil, 0, err
+	}
+
+	hasCapacity, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !hasCapacity {
+		return nil, 0, fmt.Errorf("%w: %d/%d tokens used", errAPIQuotaExceeded, tokensUsed, tokensLimit)
+	}
+	promptTokens := tokenizer.CountMessages(req.Model, req.Messages)
+	if tokensLimit != -1 && tokensUsed+int64(promptTokens) > tokensLimit {
+		return nil, 0, fmt.Errorf("%w: the prompt needs %d tokens, %d left", errAPIQuotaExceeded, promptTokens, tokensLimit-tokensUsed)
+	}
+	if err := s.moderateRequest(ctx, userID, moderation.SourceAPIPrompt, promptText(req.Messages)); err != nil {
+		return nil, 0, err
+	}
+
+	return s.modelChain(userID, req.Model), promptTokens, nil
+}
+
+// APIChatCompletion answers a /v1/chat/completions request. With a JSON
+// response format the reply is validated, see completeStructured.
+func (s *Service) APIChatCompletion(ctx context.Context, userID uuid.UUID, req openai.ChatCompletionRequest, format *ResponseFormat) (*openai.ChatCompletionResponse, error) {
+	output, err := newStructuredOutput(format)
+	if err != nil {
+		return nil, err
+	}
+	models, promptTokens, err := s.prepareAPICompletion(ctx, userID, &req)
+	if err != nil {
+		return nil, err
+	}
+	req.Stream = false
+	req.StreamOptions = nil
+
+	if output != nil {
+		resp, usedModel, usage, err := s.completeStructured(ctx, models, req, output)
+		s.recordAPIUsage(userID, usage.TotalTokens)
+		if err != nil {
+			return nil, err
+		}
+		s.moderateChoices(ctx, userID, resp.Choices)
+		fillAPIResponse(&resp, usedModel)
+		return &resp, nil
+	}
+
+	resp, usedModel, err := s.providers.CreateChatCompletion(ctx, models, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Usage.TotalTokens == 0 {
+		var completion strings.Builder
+		for _, choice := range resp.Choices {
+			completion.WriteString(choice.Message.Content)
+		}
+		resp.Usage = resolveUsage(nil, usedModel, promptTokens, completion.String())
+	}
+	s.moderateChoices(ctx, userID, resp.Choices)
+	fillAPIResponse(&resp, usedModel)
+
+	s.recordAPIUsage(userID, resp.Usage.TotalTokens)
+	return &resp, nil
+}
+
+// APIChatCompletionStream starts a streamed /v1/chat/completions request.
+// The caller must close the stream. A reply with a JSON response format
+// has to be validated whole, so it is generated first and then sent as a
+// single chunk.
+func (s *Service) APIChatCompletionStream(ctx context.Context, userID uuid.UUID, req openai.ChatCompletionRequest, format *ResponseFormat) (*APIStream, error) {
+	output, err := newStructuredOutput(format)
+	if err != nil {
+		return nil, err
+	}
+	models, promptTokens, err := s.prepareAPICompletion(ctx, userID, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Usage is always requested for metering, the client only gets it
+	// when it asked too
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+
+	var stream llm.ChatStream
+	var usedModel string
+	if output != nil {
+		req.Stream = false
+		req.StreamOptions = nil
+		resp, model, usage, err := s.completeStructured(ctx, models, req, output)
+		if err != nil {
+			s.recordAPIUsage(userID, usage.TotalTokens)
+			return nil, err
+		}
+		s.moderateChoices(ctx, userID, resp.Choices)
+		stream, usedModel = newCompletedStream(resp), model
+	} else {
+		req.Stream = true
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+		stream, usedModel, err = s.providers.CreateChatCompletionStream(ctx, models, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &APIStream{
+		service:      s,
+		stream:       stream,
+		moderated:    output != nil,
+		userID:       userID,
+		id:           newAPICompletionID(),
+		model:        usedModel,
+		created:      time.Now().Unix(),
+		promptTokens: promptTokens,
+		includeUsage: includeUsage,
+	}, nil
+}
+
+// APIModels lists the enabled catalog models the user may use.
+func (s *Service) APIModels(userID uuid.UUID) (*APIModelList, error) {
+	list := &APIModelList{Object: "list", Data: []APIModel{}}
+	if s.catalog == nil {
+		return list, nil
+	}
+
+	tier, role, err := s.catalog.userTier(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []CatalogModel
+	if err := s.db.Where("enabled = ?", true).Order("position ASC, name ASC").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	for i := range models {
+		if role != "superadmin" && !models[i].AvailableTo(tier) {
+			continue
+		}
+		list.Data = append(list.Data, APIModel{
+			ID:      models[i].Name,
+			Object:  "model",
+			Created: models[i].CreatedAt.Unix(),
+			OwnedBy: models[i].Provider,
+		})
+	}
+	return list, nil
+}
+
+// APIModel returns one model of APIModels.
+func (s *Service) APIModel(userID uuid.UUID, name string) (*APIModel, error) {
+	list, err := s.APIModels(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list.Data {
+		if list.Data[i].ID == name {
+			return &list.Data[i], nil
+		}
+	}
+	return nil, ErrModelNotAvailable
+}
+
+// APIGenerateImages answers a /v1/images/generations request. Images are
+// kept in the user's gallery like any other.
+func (s *Service) APIGenerateImages(ctx context.Context, userID uuid.UUID, req *APIImageRequest) ([]GeneratedImage, error) {
+	switch req.ResponseFormat {
+	case "", "url", "b64_json":
+	default:
+		return nil, fmt.Errorf("%w: response_format must be url or b64_json", errAPIInvalidRequest)
+	}
+
+	images, err := s.GenerateImage(ctx, userID, &GenerateImageRequest{
+		Prompt:  req.Prompt,
+		Model:   req.Model,
+		Size:    req.Size,
+		Quality: req.Quality,
+		Style:   req.Style,
+		N:       req.N,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.analytics != nil {
+		for range images {
+			if err := s.analytics.RecordImageGeneration(userID); err != nil {
+				log.Printf("Failed to record image generation for %s: %v", userID, err)
+			}
+		}
+	}
+	return images, nil
+}
+
+// recordAPIUsage charges tokens against the quota and the analytics.
+func (s *Service) recordAPIUsage(userID uuid.UUID, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	if err := s.addTokenUsage(userID, tokens); err != nil {
+		log.Printf("Failed to add token usage for %s: %v", userID, err)
+	}
+	if s.analytics != nil {
+		if err := s.analytics.RecordChatUsage(userID, int64(tokens)); err != nil {
+			log.Printf("Failed to record chat usage for %s: %v", userID, err)
+		}
+		if err := s.analytics.RecordMessage(userID); err != nil {
+			log.Printf("Failed to record message for %s: %v", userID, err)
+		}
+	}
+}
+
+// fillAPIResponse sets the fields of a completion that providers may leave
+// out, and names the model that answered.
+func fillAPIResponse(resp *openai.ChatCompletionResponse, usedModel string) {
+	if resp.ID == "" {
+		resp.ID = newAPICompletionID()
+	}
+	resp.Object = "chat.completion"
+	if resp.Created == 0 {
+		resp.Created = time.Now().Unix()
+	}
+	resp.Model = usedModel
+}
+
+func newAPICompletionID() string {
+	return "chatcmpl-" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Persona is a reusable system prompt template. Users own private personas;
+// admins publish public ones that every user can bind to a chat.
+type Persona struct {
+	ID           uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name         string         `gorm:"type:varchar(100);not null" json:"name"`
+	Description  string         `gorm:"type:text" json:"description"`
+	Icon         string         `gorm:"type:varchar(50)" json:"icon"`
+	SystemPrompt string         `gorm:"type:text;not null" json:"system_prompt"` // may contain {{variables}}
+	Variables    string         `gorm:"type:text" json:"-"`                      // JSON []PersonaVariable
+	Model        string         `gorm:"type:varchar(50)" json:"model,omitempty"` // default model for new chats
+	IsPublic     bool           `gorm:"default:false;index" json:"is_public"`    // published by an admin
+	CreatedAt    time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// PersonaVariable is a {{name}} placeholder in a persona's system prompt.
+type PersonaVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type PersonaResponse struct {
+	ID           uuid.UUID         `json:"id"`
+	UserID       uuid.UUID         `json:"user_id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Icon         string            `json:"icon"`
+	SystemPrompt string            `json:"system_prompt"`
+	Variables    []PersonaVariable `json:"variables"`
+	Model        string            `json:"model,omitempty"`
+	IsPublic     bool              `json:"is_public"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+type CreatePersonaRequest struct {
+	Name         string            `json:"name" validate:"required,min=1,max=100"`
+	Description  string            `json:"description"`
+	Icon         string            `json:"icon"`
+	SystemPrompt string            `json:"system_prompt" validate:"required"`
+	Variables    []PersonaVariable `json:"variables"`
+	Model        string            `json:"model"`
+}
+
+type UpdatePersonaRequest struct {
+	Name         string            `json:"name"`
+	Description  *string           `json:"description"`
+	Icon         *string           `json:"icon"`
+	SystemPrompt string            `json:"system_prompt"`
+	Variables    []PersonaVariable `json:"variables"`
+	Model        *string           `json:"model"`
+}
+
+// BindPersonaRequest binds a persona to a chat; a nil PersonaID unbinds it.
+type BindPersonaRequest struct {
+	PersonaID *uuid.UUID        `json:"persona_id"`
+	Variables map[string]string `json:"variables"`
+}
+
+type PreviewPersonaRequest struct {
+	Variables map[string]string `json:"variables"`
+}
+
+// builtinPersonaVariables are filled in at render time and need no value.
+var builtinPersonaVariables = map[string]func() string{
+	"date": func() string { return time.Now().Format("2006-01-02") },
+}
+
+var personaVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+func (p *Persona) DecodeVariables() []PersonaVariable {
+	var vars []PersonaVariable
+	if p.Variables != "" {
+		json.Unmarshal([]byte(p.Variables), &vars)
+	}
+	if vars == nil {
+		vars = []PersonaVariable{}
+	}
+	return vars
+}
+
+func (p *Persona) ToDTO() *PersonaResponse {
+	return &PersonaResponse{
+		ID:           p.ID,
+		UserID:       p.UserID,
+		Name:         p.Name,
+		Description:  p.Description,
+		Icon:         p.Icon,
+		SystemPrompt: p.SystemPrompt,
+		Variables:    p.DecodeVariables(),
+		Model:        p.Model,
+		IsPublic:     p.IsPublic,
+		CreatedAt:    p.CreatedAt,
+		UpdatedAt:    p.UpdatedAt,
+	}
+}
+
+// Render fills the persona's system prompt with values, falling back to the
+// declared defaults. A required variable without a value is an error.
+func (p *Persona) Render(values map[string]string) (string, error) {
+	if err := p.CheckVariables(values); err != nil {
+		return "", err
+	}
+
+	declared := make(map[string]PersonaVariable)
+	for _, v := range p.DecodeVariables() {
+		declared[v.Name] = v
+	}
+
+	return personaVariablePattern.ReplaceAllStringFunc(p.SystemPrompt, func(match string) string {
+		name := personaVariablePattern.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok && value != "" {
+			return value
+		}
+		if v, ok := declared[name]; ok && v.Default != "" {
+			return v.Default
+		}
+		if builtin, ok := builtinPersonaVariables[name]; ok {
+			return builtin()
+		}
+		return ""
+	}), nil
+}
+
+// CheckVariables reports the first required variable missing from values.
+func (p *Persona) CheckVariables(values map[string]string) error {
+	for _, v := range p.DecodeVariables() {
+		if v.Required && v.Default == "" && values[v.Name] == "" {
+			return fmt.Errorf("missing value for variable %q", v.Name)
+		}
+	}
+	return nil
+}
+
+// normalizePersonaVariables validates declared variables and declares any
+// placeholder used in the template but missing from the list.
+func normalizePersonaVariables(template string, vars []PersonaVariable) ([]PersonaVariable, error) {
+	seen := make(map[string]bool)
+	out := make([]PersonaVariable, 0, len(vars))
+	for _, v := range vars {
+		v.Name = strings.TrimSpace(v.Name)
+		if !personaVariablePattern.MatchString("{{" + v.Name + "}}") {
+			return nil, fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("duplicate variable %q", v.Name)
+		}
+		seen[v.Name] = true
+		out = append(out, v)
+	}
+
+	for _, match := range personaVariablePattern.FindAllStringSubmatch(template, -1) {
+		name := match[1]
+		if seen[name] {
+			continue
+		}
+		if _, ok := builtinPersonaVariables[name]; ok {
+			continue
+		}
+		seen[name] = true
+		out = append(out, PersonaVariable{Name: name})
+	}
+	return out, nil
+}
+
+// DecodePersonaVariables returns the values bound to the chat's persona.
+func (c *Chat) DecodePersonaVariables() map[string]string {
+	if c.PersonaVariables == "" {
+		return nil
+	}
+	var values map[string]string
+	json.Unmarshal([]byte(c.PersonaVariables), &values)
+	return values
+}
+
+func encodePersonaVariables(values map[string]string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
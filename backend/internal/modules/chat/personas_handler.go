@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type PersonasHandler struct {
+	service *PersonasService
+}
+
+func NewPersonasHandler(service *PersonasService) *PersonasHandler {
+	return &PersonasHandler{service: service}
+}
+
+func (h *PersonasHandler) CreatePersona(c *fiber.Ctx) error {
+	return h.createPersona(c, false)
+}
+
+func (h *PersonasHandler) GetPersonas(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	personas, err := h.service.GetPersonas(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	response := make([]*PersonaResponse, len(personas))
+	for i := range personas {
+		response[i] = personas[i].ToDTO()
+	}
+
+	return c.JSON(response)
+}
+
+func (h *PersonasHandler) GetPersona(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	personaID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid persona ID",
+		})
+	}
+
+	persona, err := h.service.GetPersona(personaID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(persona.ToDTO())
+}
+
+func (h *PersonasHandler) UpdatePersona(c *fiber.Ctx) error {
+	return h.updatePersona(c, false)
+}
+
+func (h *PersonasHandler) DeletePersona(c *fiber.Ctx) error {
+	return h.deletePersona(c, false)
+}
+
+func (h *PersonasHandler) PreviewPersona(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	personaID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid persona ID",
+		})
+	}
+
+	var req PreviewPersonaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	prompt, err := h.service.PreviewPersona(personaID, userID, req.Variables)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"system_prompt": prompt,
+	})
+}
+
+// Admin handlers for public personas
+
+func (h *PersonasHandler) CreatePublicPersona(c *fiber.Ctx) error {
+	return h.createPersona(c, true)
+}
+
+func (h *PersonasHandler) UpdatePublicPersona(c *fiber.Ctx) error {
+	return h.updatePersona(c, true)
+}
+
+func (h *PersonasHandler) DeletePublicPersona(c *fiber.Ctx) error {
+	return h.deletePersona(c, true)
+}
+
+func (h *PersonasHandler) createPersona(c *fiber.Ctx, public bool) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req CreatePersonaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	persona, err := h.service.CreatePersona(userID, &req, public)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(persona.ToDTO())
+}
+
+func (h *PersonasHandler) updatePersona(c *fiber.Ctx, public bool) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	personaID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid persona ID",
+		})
+	}
+
+	var req UpdatePersonaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	persona, err := h.service.UpdatePersona(personaID, userID, &req, public)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(persona.ToDTO())
+}
+
+func (h *PersonasHandler) deletePersona(c *fiber.Ctx, public bool) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	personaID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid persona ID",
+		})
+	}
+
+	if err := h.service.DeletePersona(personaID, userID, public); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Persona deleted successfully",
+	})
+}
+
+// Chat persona binding
+
+func (h *Handler) SetChatPersona(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	var req BindPersonaRequest
+	if err := c.BodyParser(&req); err != nil || req.PersonaID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	chat, err := h.service.SetChatPersona(chatID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(chat.ToDTO())
+}
+
+func (h *Handler) RemoveChatPersona(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	chat, err := h.service.SetChatPersona(chatID, userID, &BindPersonaRequest{})
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(chat.ToDTO())
+}
@@ -0,0 +1,231 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PersonasService struct {
+	db *gorm.DB
+}
+
+func NewPersonasService(db *gorm.DB) *PersonasService {
+	return &PersonasService{db: db}
+}
+
+// CreatePersona saves a persona owned by userID. Public personas are created
+// by admins and shown to every user.
+func (s *PersonasService) CreatePersona(userID uuid.UUID, req *CreatePersonaRequest, public bool) (*Persona, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, errors.New("persona name is required")
+	}
+	if strings.TrimSpace(req.SystemPrompt) == "" {
+		return nil, errors.New("system prompt is required")
+	}
+
+	variables, err := normalizePersonaVariables(req.SystemPrompt, req.Variables)
+	if err != nil {
+		return nil, err
+	}
+	encoded, _ := json.Marshal(variables)
+
+	persona := &Persona{
+		UserID:       userID,
+		Name:         req.Name,
+		Description:  req.Description,
+		Icon:         req.Icon,
+		SystemPrompt: req.SystemPrompt,
+		Variables:    string(encoded),
+		Model:        req.Model,
+		IsPublic:     public,
+	}
+
+	if err := s.db.Create(persona).Error; err != nil {
+		return nil, err
+	}
+
+	return persona, nil
+}
+
+// GetPersonas lists the user's own personas followed by the public ones.
+func (s *PersonasService) GetPersonas(userID uuid.UUID) ([]Persona, error) {
+	var personas []Persona
+	err := s.db.Where("user_id = ? OR is_public = ?", userID, true).
+		Order("is_public ASC, name ASC").
+		Find(&personas).Error
+	return personas, err
+}
+
+func (s *PersonasService) GetPersona(personaID, userID uuid.UUID) (*Persona, error) {
+	var persona Persona
+	err := s.db.Where("id = ? AND (user_id = ? OR is_public = ?)", personaID, userID, true).
+		First(&persona).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("persona not found")
+		}
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// UpdatePersona changes a persona. Users can only change their own private
+// personas, admins (public set) only the public ones.
+func (s *PersonasService) UpdatePersona(personaID, userID uuid.UUID, req *UpdatePersonaRequest, public bool) (*Persona, error) {
+	persona, err := s.findEditable(personaID, userID, public)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		persona.Name = req.Name
+	}
+	if req.Description != nil {
+		persona.Description = *req.Description
+	}
+	if req.Icon != nil {
+		persona.Icon = *req.Icon
+	}
+	if req.Model != nil {
+		persona.Model = *req.Model
+	}
+	if req.SystemPrompt != "" || req.Variables != nil {
+		if req.SystemPrompt != "" {
+			persona.SystemPrompt = req.SystemPrompt
+		}
+		declared := req.Variables
+		if declared == nil {
+			declared = persona.DecodeVariables()
+		}
+		variables, err := normalizePersonaVariables(persona.SystemPrompt, declared)
+		if err != nil {
+			return nil, err
+		}
+		encoded, _ := json.Marshal(variables)
+		persona.Variables = string(encoded)
+	}
+	persona.UpdatedAt = time.Now()
+
+	if err := s.db.Save(persona).Error; err != nil {
+		return nil, err
+	}
+
+	return persona, nil
+}
+
+// DeletePersona removes a persona and unbinds it from the chats using it.
+func (s *PersonasService) DeletePersona(personaID, userID uuid.UUID, public bool) error {
+	persona, err := s.findEditable(personaID, userID, public)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Chat{}).
+			Where("persona_id = ?", persona.ID).
+			Updates(map[string]interface{}{
+				"persona_id":        nil,
+				"persona_variables": "",
+			}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(persona).Error
+	})
+}
+
+// PreviewPersona renders the persona's system prompt with the given values.
+func (s *PersonasService) PreviewPersona(personaID, userID uuid.UUID, values map[string]string) (string, error) {
+	persona, err := s.GetPersona(personaID, userID)
+	if err != nil {
+		return "", err
+	}
+	return persona.Render(values)
+}
+
+func (s *PersonasService) findEditable(personaID, userID uuid.UUID, public bool) (*Persona, error) {
+	query := s.db.Where("id = ? AND is_public = ?", personaID, public)
+	if !public {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var persona Persona
+	if err := query.First(&persona).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("persona not found")
+		}
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// SetChatPersona binds a persona to the chat. The values must cover the
+// persona's required variables; a nil personaID unbinds the persona.
+func (s *Service) SetChatPersona(chatID, userID uuid.UUID, req *BindPersonaRequest) (*Chat, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := ""
+	if req.PersonaID != nil {
+		if _, err := s.loadPersona(*req.PersonaID, userID, req.Variables); err != nil {
+			return nil, err
+		}
+		variables = encodePersonaVariables(req.Variables)
+	}
+
+	if err := s.repo.SetChatPersona(chat.ID, req.PersonaID, variables); err != nil {
+		return nil, err
+	}
+	chat.PersonaID = req.PersonaID
+	chat.PersonaVariables = variables
+
+	return chat, nil
+}
+
+// loadPersona fetches a persona the user may bind and checks that values
+// fill its required variables.
+func (s *Service) loadPersona(personaID, userID uuid.UUID, values map[string]string) (*Persona, error) {
+	persona, err := s.repo.GetPersona(personaID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("persona not found")
+		}
+		return nil, err
+	}
+	if err := persona.CheckVariables(values); err != nil {
+		return nil, err
+	}
+	return persona, nil
+}
+
+// composeSystemPrompt renders the chat's persona and appends the prompt
+// given with the request, if any.
+func (s *Service) composeSystemPrompt(chat *Chat, userID uuid.UUID, systemPrompt string) (string, error) {
+	if chat.PersonaID == nil {
+		return systemPrompt, nil
+	}
+
+	persona, err := s.repo.GetPersona(*chat.PersonaID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return systemPrompt, nil // persona removed since it was bound
+		}
+		return "", err
+	}
+
+	rendered, err := persona.Render(chat.DecodePersonaVariables())
+	if err != nil {
+		return "", fmt.Errorf("persona %s: %w", persona.Name, err)
+	}
+	if systemPrompt == "" {
+		return rendered, nil
+	}
+	return rendered + "\n\n" + systemPrompt, nil
+}
@@ -2,9 +2,11 @@ package chat
 
 import (
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository struct {
@@ -25,6 +27,7 @@ func (r *Repository) GetChatByID(id uuid.UUID, userID uuid.UUID) (*Chat, error)
 		Preload("Messages", func(db *gorm.DB) *gorm.DB {
 			return db.Order("created_at ASC")
 		}).
+		Preload("Messages.Attachments").
 		First(&chat).Error
 
 	if err != nil {
@@ -50,29 +53,68 @@ func (r *Repository) GetUserChats(userID uuid.UUID, limit, offset int) ([]Chat,
 	return chats, nil
 }
 
-func (r *Repository) UpdateChat(chat *Chat) error {
-	return r.db.Save(chat).Error
+// UpdateChat sets only the given columns of a chat and bumps its
+// updated_at, so concurrent changes to other columns (the active leaf, an
+// automatic title) are kept.
+func (r *Repository) UpdateChat(chatID uuid.UUID, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now()
+	return r.db.Model(&Chat{}).
+		Where("id = ?", chatID).
+		Updates(updates).Error
 }
 
+// DeleteChat soft-deletes a chat and revokes its share links.
 func (r *Repository) DeleteChat(id uuid.UUID, userID uuid.UUID) error {
-	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&Chat{})
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("chat not found")
-	}
-	return nil
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&Chat{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("chat not found")
+		}
+		return tx.Where("chat_id = ?", id).Delete(&ChatShare{}).Error
+	})
 }
 
 func (r *Repository) CreateMessage(message *Message) error {
 	return r.db.Create(message).Error
 }
 
+// CreateMessageWithAttachments saves a message and links the given unsent
+// attachments to it, recording the detail and tokens set on them.
+func (r *Repository) CreateMessageWithAttachments(message *Message, attachments []MessageAttachment) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Attachments").Create(message).Error; err != nil {
+			return err
+		}
+
+		for i := range attachments {
+			result := tx.Model(&MessageAttachment{}).
+				Where("id = ? AND message_id IS NULL", attachments[i].ID).
+				Updates(map[string]interface{}{
+					"message_id": message.ID,
+					"detail":     attachments[i].Detail,
+					"tokens":     attachments[i].Tokens,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errors.New("attachment was already sent")
+			}
+			attachments[i].MessageID = &message.ID
+		}
+		message.Attachments = attachments
+		return nil
+	})
+}
+
 func (r *Repository) GetChatMessages(chatID uuid.UUID) ([]Message, error) {
 	var messages []Message
 	err := r.db.Where("chat_id = ?", chatID).
 		Order("created_at ASC").
+		Preload("Attachments").
 		Find(&messages).Error
 
 	if err != nil {
@@ -85,6 +127,17 @@ func (r *Repository) UpdateMessage(message *Message) error {
 	return r.db.Save(message).Error
 }
 
+// SetActiveLeaf selects the branch ending at leafID and bumps the chat's
+// updated_at.
+func (r *Repository) SetActiveLeaf(chatID, leafID uuid.UUID) error {
+	return r.db.Model(&Chat{}).
+		Where("id = ?", chatID).
+		Updates(map[string]interface{}{
+			"active_leaf_id": leafID,
+			"updated_at":     time.Now(),
+		}).Error
+}
+
 func (r *Repository) GetTotalTokensUsedToday(userID uuid.UUID) (int64, error) {
 	var total int64
 	err := r.db.Model(&Message{}).
@@ -95,3 +148,126 @@ func (r *Repository) GetTotalTokensUsedToday(userID uuid.UUID) (int64, error) {
 
 	return total, err
 }
+
+// GetChatSummary returns the chat's rolling summary, or nil if none exists yet.
+func (r *Repository) GetChatSummary(chatID uuid.UUID) (*ChatSummary, error) {
+	var summary ChatSummary
+	err := r.db.Where("chat_id = ?", chatID).First(&summary).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// SaveChatSummary creates or replaces the chat's summary.
+func (r *Repository) SaveChatSummary(summary *ChatSummary) error {
+	summary.UpdatedAt = time.Now()
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content", "last_message_id", "summarized_until", "message_count", "tokens", "updated_at"}),
+	}).Create(summary).Error
+}
+
+// GetPersona returns a persona visible to userID: one of their own or a
+// public one.
+func (r *Repository) GetPersona(id, userID uuid.UUID) (*Persona, error) {
+	var persona Persona
+	err := r.db.Where("id = ? AND (user_id = ? OR is_public = ?)", id, userID, true).
+		First(&persona).Error
+	if err != nil {
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// SetChatPersona binds a persona and its variable values to the chat; a nil
+// personaID unbinds it.
+func (r *Repository) SetChatPersona(chatID uuid.UUID, personaID *uuid.UUID, variables string) error {
+	return r.db.Model(&Chat{}).
+		Where("id = ?", chatID).
+		Updates(map[string]interface{}{
+			"persona_id":        personaID,
+			"persona_variables": variables,
+			"updated_at":        time.Now(),
+		}).Error
+}
+
+// CreateChatWithMessages saves a chat together with its history, e.g. a
+// forked shared chat.
+func (r *Repository) CreateChatWithMessages(chat *Chat, messages []Message) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Messages").Create(chat).Error; err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(messages, 100).Error
+	})
+}
+
+func (r *Repository) CreateShare(share *ChatShare) error {
+	return r.db.Create(share).Error
+}
+
+func (r *Repository) GetChatShares(chatID uuid.UUID) ([]ChatShare, error) {
+	var shares []ChatShare
+	err := r.db.Where("chat_id = ?", chatID).
+		Order("created_at DESC").
+		Find(&shares).Error
+	return shares, err
+}
+
+// GetShareBySlug finds a share whose chat has not been deleted.
+func (r *Repository) GetShareBySlug(slug string) (*ChatShare, error) {
+	var share ChatShare
+	err := r.db.Joins("JOIN chats ON chats.id = chat_shares.chat_id AND chats.deleted_at IS NULL").
+		Where("chat_shares.slug = ?", slug).
+		First(&share).Error
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (r *Repository) DeleteShare(chatID, shareID, userID uuid.UUID) error {
+	result := r.db.Where("id = ? AND chat_id = ? AND user_id = ?", shareID, chatID, userID).
+		Delete(&ChatShare{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("share not found")
+	}
+	return nil
+}
+
+func (r *Repository) IncrementShareViews(shareID uuid.UUID) {
+	r.db.Model(&ChatShare{}).
+		Where("id = ?", shareID).
+		UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+}
+
+func (r *Repository) CreateComparison(comparison *Comparison) error {
+	return r.db.Create(comparison).Error
+}
+
+func (r *Repository) GetComparison(id, chatID uuid.UUID) (*Comparison, error) {
+	var comparison Comparison
+	if err := r.db.Where("id = ? AND chat_id = ?", id, chatID).First(&comparison).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrComparisonNotFound
+		}
+		return nil, err
+	}
+	return &comparison, nil
+}
+
+func (r *Repository) SetComparisonWinner(id, messageID uuid.UUID) error {
+	return r.db.Model(&Comparison{}).
+		Where("id = ?", id).
+		Update("winner_id", messageID).Error
+}
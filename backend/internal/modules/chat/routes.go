@@ -4,22 +4,50 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handler, foldersHandler *FoldersHandler, codeExecHandler *CodeExecutionHandler, analyticsHandler *AnalyticsHandler, adminHandler *AdminHandler) {
+func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handler, foldersHandler *FoldersHandler, codeExecHandler *CodeExecutionHandler, analyticsHandler *AnalyticsHandler, adminHandler *AdminHandler, personasHandler *PersonasHandler, documentsHandler *DocumentsHandler, importExportHandler *ImportExportHandler, modelCatalogHandler *ModelCatalogHandler, attachmentsHandler *AttachmentsHandler, imagesHandler *ImagesHandler, speechHandler *SpeechHandler, memoryHandler *MemoryHandler, batchHandler *BatchHandler, scheduleHandler *ScheduleHandler) {
 	// OpenAI-compatible streaming endpoint (for both chats and messenger AI)
 	app.Post("/api/chat/stream", authMiddleware, handler.SendMessage)
 	app.Post("/api/chat/completions", authMiddleware, handler.ChatCompletions)
 	app.Post("/api/chat/generate-image", authMiddleware, handler.GenerateImage)
 
+	// Model catalog
+	app.Get("/api/models", authMiddleware, modelCatalogHandler.GetModels)
+
 	chats := app.Group("/api/chats", authMiddleware)
 
 	chats.Post("/", handler.CreateChat)
 	chats.Get("/", handler.GetUserChats)
 	chats.Get("/tokens", handler.GetTokenUsage)
+	chats.Post("/import", importExportHandler.ImportConversations)
 	chats.Get("/:id", handler.GetChat)
 	chats.Put("/:id", handler.UpdateChat)
 	chats.Delete("/:id", handler.DeleteChat)
+	chats.Post("/:id/title", handler.GenerateTitle)
 	chats.Post("/:id/messages", handler.SendMessage)
+	chats.Post("/:id/compare", handler.CompareModels)
+	chats.Get("/:id/comparisons/:comparisonId", handler.GetComparison)
+	chats.Put("/:id/comparisons/:comparisonId/winner", handler.PickComparisonWinner)
 	chats.Post("/:chatId/messages/:messageId/regenerate", handler.RegenerateMessage)
+	chats.Post("/:chatId/messages/:messageId/edit", handler.EditMessage)
+	chats.Get("/:chatId/messages/:messageId/siblings", handler.GetMessageSiblings)
+	chats.Get("/:chatId/messages/:messageId/speech", speechHandler.GetMessageSpeech)
+	chats.Put("/:id/branch", handler.SwitchBranch)
+	chats.Post("/:id/messages/:messageId/stop", handler.StopGeneration)
+	chats.Put("/:id/persona", handler.SetChatPersona)
+	chats.Delete("/:id/persona", handler.RemoveChatPersona)
+	chats.Get("/:id/documents", documentsHandler.GetChatDocuments)
+	chats.Post("/:id/documents", documentsHandler.AttachDocumentToChat)
+	chats.Delete("/:id/documents/:documentId", documentsHandler.DetachDocumentFromChat)
+	chats.Post("/:id/shares", handler.CreateShare)
+	chats.Get("/:id/shares", handler.GetChatShares)
+	chats.Delete("/:id/shares/:shareId", handler.DeleteShare)
+	chats.Get("/:id/export", importExportHandler.ExportChat)
+
+	// Shared chats (public, read-only)
+	app.Get("/api/shared/:slug", handler.GetSharedChat)
+	app.Post("/api/shared/:slug/fork", authMiddleware, handler.ForkSharedChat)
+	app.Get("/share/:slug", handler.ViewSharedChat)
+	app.Post("/share/:slug", handler.ViewSharedChat)
 
 	// Folders routes
 	folders := app.Group("/api/chat/folders", authMiddleware)
@@ -28,6 +56,7 @@ func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handl
 	folders.Put("/:id", foldersHandler.UpdateFolder)
 	folders.Delete("/:id", foldersHandler.DeleteFolder)
 	folders.Get("/:id/chats", foldersHandler.GetFolderChats)
+	folders.Get("/:id/export", importExportHandler.ExportFolder)
 
 	// Folder assignments
 	app.Post("/api/chat/folders/assign", authMiddleware, foldersHandler.AssignChatToFolder)
@@ -47,6 +76,69 @@ func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handl
 	// Auto-categorization
 	app.Post("/api/chat/chats/:chatId/auto-categorize", authMiddleware, foldersHandler.AutoCategorizeChat)
 
+	// Personas routes
+	personas := app.Group("/api/chat/personas", authMiddleware)
+	personas.Post("/", personasHandler.CreatePersona)
+	personas.Get("/", personasHandler.GetPersonas)
+	personas.Get("/:id", personasHandler.GetPersona)
+	personas.Put("/:id", personasHandler.UpdatePersona)
+	personas.Delete("/:id", personasHandler.DeletePersona)
+	personas.Post("/:id/preview", personasHandler.PreviewPersona)
+
+	// Documents routes
+	documents := app.Group("/api/chat/documents", authMiddleware)
+	documents.Post("/", documentsHandler.UploadDocument)
+	documents.Get("/", documentsHandler.GetUserDocuments)
+	documents.Get("/:id", documentsHandler.GetDocument)
+	documents.Delete("/:id", documentsHandler.DeleteDocument)
+
+	// Image attachments for messages
+	attachments := app.Group("/api/chat/attachments", authMiddleware)
+	attachments.Post("/", attachmentsHandler.UploadAttachment)
+	attachments.Get("/:id", attachmentsHandler.GetAttachment)
+	attachments.Get("/:id/content", attachmentsHandler.GetAttachmentContent)
+	attachments.Delete("/:id", attachmentsHandler.DeleteAttachment)
+
+	// Generated images gallery
+	images := app.Group("/api/chat/images", authMiddleware)
+	images.Post("/generations", imagesHandler.GenerateImages)
+	images.Post("/variations", imagesHandler.CreateVariations)
+	images.Post("/edits", imagesHandler.EditImages)
+	images.Get("/", imagesHandler.GetImages)
+	images.Get("/:id", imagesHandler.GetImage)
+	images.Get("/:id/content", imagesHandler.GetImageContent)
+	images.Delete("/:id", imagesHandler.DeleteImage)
+
+	// Text-to-speech voices (replies are read at /api/chats/:chatId/messages/:messageId/speech)
+	app.Get("/api/chat/speech/voices", authMiddleware, speechHandler.GetVoices)
+
+	// Long-term memory shared across chats
+	memories := app.Group("/api/chat/memories", authMiddleware)
+	memories.Get("/", memoryHandler.GetMemories)
+	memories.Post("/", memoryHandler.CreateMemory)
+	memories.Delete("/", memoryHandler.DeleteAllMemories)
+	memories.Put("/:id", memoryHandler.UpdateMemory)
+	memories.Delete("/:id", memoryHandler.DeleteMemory)
+
+	// Batch completion jobs
+	batches := app.Group("/api/chat/batches", authMiddleware)
+	batches.Post("/", batchHandler.CreateJob)
+	batches.Get("/", batchHandler.GetJobs)
+	batches.Get("/:id", batchHandler.GetJob)
+	batches.Get("/:id/results", batchHandler.GetResults)
+	batches.Post("/:id/cancel", batchHandler.CancelJob)
+	batches.Delete("/:id", batchHandler.DeleteJob)
+
+	// Scheduled and recurring prompts
+	schedules := app.Group("/api/chat/schedules", authMiddleware)
+	schedules.Post("/", scheduleHandler.CreateSchedule)
+	schedules.Get("/", scheduleHandler.GetSchedules)
+	schedules.Get("/:id", scheduleHandler.GetSchedule)
+	schedules.Delete("/:id", scheduleHandler.DeleteSchedule)
+	schedules.Post("/:id/pause", scheduleHandler.PauseSchedule)
+	schedules.Post("/:id/resume", scheduleHandler.ResumeSchedule)
+	schedules.Get("/:id/runs", scheduleHandler.GetScheduleRuns)
+
 	// Code execution routes
 	codeExec := app.Group("/api/chat/execute", authMiddleware)
 	codeExec.Post("/", codeExecHandler.ExecuteCode)
@@ -87,8 +179,26 @@ func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handl
 	admin.Post("/users/:userId/reset-tokens", adminHandler.ResetUserTokens)
 	admin.Get("/token-usage", adminHandler.GetTokenUsageByUser)
 	admin.Get("/revenue", adminHandler.GetRevenueAnalytics)
+	admin.Post("/personas", personasHandler.CreatePublicPersona)
+	admin.Put("/personas/:id", personasHandler.UpdatePublicPersona)
+	admin.Delete("/personas/:id", personasHandler.DeletePublicPersona)
+	admin.Get("/models", modelCatalogHandler.GetAllModels)
+	admin.Post("/models", modelCatalogHandler.CreateModel)
+	admin.Put("/models/:id", modelCatalogHandler.UpdateModel)
+	admin.Delete("/models/:id", modelCatalogHandler.DeleteModel)
 
 	// Superadmin-only routes
 	superAdmin := app.Group("/api/admin", authMiddleware, adminHandler.SuperAdminOnly())
 	superAdmin.Delete("/users/:userId", adminHandler.DeleteUser)
 }
+
+// RegisterOpenAIRoutes mounts the OpenAI-compatible API, authenticated by
+// personal API keys rather than sessions.
+func RegisterOpenAIRoutes(app *fiber.App, handler *OpenAIAPIHandler, apiKeyMiddleware fiber.Handler) {
+	v1 := app.Group("/v1", apiKeyMiddleware)
+	v1.Post("/chat/completions", handler.ChatCompletions)
+	v1.Get("/models", handler.GetModels)
+	v1.Get("/models/:model", handler.GetModel)
+	v1.Post("/images/generations", handler.GenerateImages)
+	v1.Get("/images/:id/content", handler.GetImageContent)
+}
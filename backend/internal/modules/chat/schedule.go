@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scheduled prompts are sent to a chat by a background worker, once at a
+// given time or repeatedly on a cron expression. Each run appends the
+// prompt and the reply to the chat like a message sent by the user.
+
+// Schedule statuses
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusPaused    = "paused"
+	ScheduleStatusCompleted = "completed" // one-off schedules after their run
+)
+
+// Schedule run statuses
+const (
+	ScheduleRunCompleted = "completed"
+	ScheduleRunFailed    = "failed"
+)
+
+const (
+	// scheduleTick is how often the worker looks for due schedules.
+	scheduleTick = 30 * time.Second
+
+	// minScheduleInterval is the shortest time allowed between two runs of
+	// a recurring schedule.
+	minScheduleInterval = 15 * time.Minute
+
+	scheduleWorkers    = 4
+	scheduleRunTimeout = 5 * time.Minute
+
+	// ScheduleEvent is sent over the messenger hub after each run
+	ScheduleEvent = "scheduled_prompt"
+)
+
+// scheduleLimits is how many schedules a user may keep, by subscription
+// tier rank. Superadmins are not limited.
+var scheduleLimits = map[int]int{
+	0: 3,
+	1: 10,
+	2: 25,
+	3: 50,
+	4: 100,
+}
+
+var (
+	ErrScheduleNotFound     = errors.New("scheduled prompt not found")
+	ErrScheduleLimitReached = errors.New("scheduled prompt limit reached for your subscription tier")
+)
+
+// Notifier pushes events to the open connections of users, e.g. the
+// messenger hub.
+type Notifier interface {
+	BroadcastToUsers(userIDs []uuid.UUID, messageType string, payload interface{})
+}
+
+// ScheduledPrompt is a prompt sent to a chat on a schedule.
+type ScheduledPrompt struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ChatID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"chat_id"`
+	Name         string     `gorm:"type:varchar(255)" json:"name"`
+	Prompt       string     `gorm:"type:text;not null" json:"prompt"`
+	SystemPrompt string     `gorm:"type:text" json:"system_prompt,omitempty"`
+	Cron         string     `gorm:"type:varchar(100)" json:"cron,omitempty"` // recurring schedules
+	RunAt        *time.Time `json:"run_at,omitempty"`                        // one-off schedules
+	Timezone     string     `gorm:"type:varchar(64);default:'UTC'" json:"timezone"`
+	Status       string     `gorm:"type:varchar(20);not null;default:'active'" json:"status"`
+	NextRunAt    *time.Time `gorm:"index" json:"next_run_at,omitempty"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	RunCount     int        `gorm:"default:0" json:"run_count"`
+	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+func (ScheduledPrompt) TableName() string {
+	return "scheduled_prompts"
+}
+
+// ScheduleRun is one execution of a scheduled prompt.
+type ScheduleRun struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ScheduleID uuid.UUID  `gorm:"type:uuid;not null;index" json:"schedule_id"`
+	ChatID     uuid.UUID  `gorm:"type:uuid;not null" json:"chat_id"`
+	Status     string     `gorm:"type:varchar(20);not null" json:"status"`
+	MessageID  *uuid.UUID `gorm:"type:uuid" json:"message_id,omitempty"` // the reply
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	Tokens     int        `gorm:"default:0" json:"tokens"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func (ScheduleRun) TableName() string {
+	return "scheduled_prompt_runs"
+}
+
+// CreateScheduleRequest needs either a cron expression or a time to run at.
+type CreateScheduleRequest struct {
+	ChatID       uuid.UUID  `json:"chat_id" validate:"required"`
+	Name         string     `json:"name"`
+	Prompt       string     `json:"prompt" validate:"required"`
+	SystemPrompt string     `json:"system_prompt,omitempty"`
+	Cron         string     `json:"cron,omitempty"`
+	RunAt        *time.Time `json:"run_at,omitempty"`
+	Timezone     string     `json:"timezone,omitempty"` // IANA name the cron expression is read in, UTC by default
+}
+
+// ScheduleNotification is the payload of ScheduleEvent.
+type ScheduleNotification struct {
+	ScheduleID uuid.UUID  `json:"schedule_id"`
+	RunID      uuid.UUID  `json:"run_id"`
+	ChatID     uuid.UUID  `json:"chat_id"`
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	MessageID  *uuid.UUID `json:"message_id,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
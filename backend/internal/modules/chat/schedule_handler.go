@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ScheduleHandler struct {
+	service *ScheduleService
+}
+
+func NewScheduleHandler(service *ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{service: service}
+}
+
+func (h *ScheduleHandler) CreateSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req CreateScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	schedule, err := h.service.Create(userID, &req)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(schedule)
+}
+
+func (h *ScheduleHandler) GetSchedules(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	schedules, err := h.service.List(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(schedules)
+}
+
+func (h *ScheduleHandler) GetSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	scheduleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid schedule ID",
+		})
+	}
+
+	schedule, err := h.service.Get(scheduleID, userID)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+
+	return c.JSON(schedule)
+}
+
+func (h *ScheduleHandler) DeleteSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	scheduleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid schedule ID",
+		})
+	}
+
+	if err := h.service.Delete(scheduleID, userID); err != nil {
+		return scheduleError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Schedule deleted successfully",
+	})
+}
+
+func (h *ScheduleHandler) PauseSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	scheduleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid schedule ID",
+		})
+	}
+
+	schedule, err := h.service.Pause(scheduleID, userID)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+
+	return c.JSON(schedule)
+}
+
+func (h *ScheduleHandler) ResumeSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	scheduleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid schedule ID",
+		})
+	}
+
+	schedule, err := h.service.Resume(scheduleID, userID)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+
+	return c.JSON(schedule)
+}
+
+// GetScheduleRuns lists past runs of a schedule, newest first.
+func (h *ScheduleHandler) GetScheduleRuns(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	scheduleID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid schedule ID",
+		})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	offset := c.QueryInt("offset", 0)
+
+	runs, err := h.service.History(scheduleID, userID, limit, offset)
+	if err != nil {
+		return scheduleError(c, err)
+	}
+
+	return c.JSON(runs)
+}
+
+func scheduleError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrScheduleNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, ErrScheduleLimitReached):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,438 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kintsugi-ai/backend/internal/cron"
+	"gorm.io/gorm"
+)
+
+// ScheduleService runs scheduled prompts through the chat service when they
+// are due and tells their owners about the results.
+type ScheduleService struct {
+	db       *gorm.DB
+	chat     *Service
+	notifier Notifier
+	slots    chan struct{} // see scheduleWorkers
+}
+
+func NewScheduleService(db *gorm.DB, chat *Service) *ScheduleService {
+	return &ScheduleService{
+		db:    db,
+		chat:  chat,
+		slots: make(chan struct{}, scheduleWorkers),
+	}
+}
+
+// SetNotifier sends the results of runs to the users' open connections.
+// Without a notifier runs are only recorded.
+func (s *ScheduleService) SetNotifier(notifier Notifier) {
+	s.notifier = notifier
+}
+
+// Run checks for due schedules until the process exits. Runs missed while
+// the server was down are made up once.
+func (s *ScheduleService) Run() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+
+	for {
+		s.runDue()
+		<-ticker.C
+	}
+}
+
+func (s *ScheduleService) Create(userID uuid.UUID, req *CreateScheduleRequest) (*ScheduledPrompt, error) {
+	if strings.TrimSpace(req.Prompt) == "" {
+		return nil, errors.New("prompt is required")
+	}
+	if _, err := s.chat.GetChat(req.ChatID, userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkLimit(userID); err != nil {
+		return nil, err
+	}
+
+	schedule := &ScheduledPrompt{
+		UserID:       userID,
+		ChatID:       req.ChatID,
+		Name:         strings.TrimSpace(req.Name),
+		Prompt:       req.Prompt,
+		SystemPrompt: req.SystemPrompt,
+		Cron:         strings.TrimSpace(req.Cron),
+		RunAt:        req.RunAt,
+		Timezone:     strings.TrimSpace(req.Timezone),
+		Status:       ScheduleStatusActive,
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+
+	now := time.Now()
+	switch {
+	case schedule.Cron != "" && schedule.RunAt != nil:
+		return nil, errors.New("set either cron or run_at, not both")
+	case schedule.Cron != "":
+		if err := schedule.validateCron(now); err != nil {
+			return nil, err
+		}
+	case schedule.RunAt != nil:
+		if !schedule.RunAt.After(now) {
+			return nil, errors.New("run_at must be in the future")
+		}
+	default:
+		return nil, errors.New("cron or run_at is required")
+	}
+
+	next, err := schedule.nextRun(now)
+	if err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = next
+
+	if err := s.db.Create(schedule).Error; err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// checkLimit returns ErrScheduleLimitReached when the user already keeps as
+// many schedules as their tier allows. Completed one-off schedules do not
+// count.
+func (s *ScheduleService) checkLimit(userID uuid.UUID) error {
+	var user struct {
+		SubscriptionTier string
+		Role             string
+	}
+	err := s.db.Table("users").
+		Where("id = ?", userID).
+		Select("subscription_tier, role").
+		Scan(&user).Error
+	if err != nil {
+		return err
+	}
+	if user.Role == "superadmin" {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&ScheduledPrompt{}).
+		Where("user_id = ? AND status <> ?", userID, ScheduleStatusCompleted).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(scheduleLimits[tierRank(user.SubscriptionTier)]) {
+		return ErrScheduleLimitReached
+	}
+	return nil
+}
+
+// validateCron checks that the schedule's cron expression parses, fires
+// and does not fire more often than minScheduleInterval.
+func (p *ScheduledPrompt) validateCron(now time.Time) error {
+	schedule, err := cron.Parse(p.Cron)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone: %s", p.Timezone)
+	}
+
+	// Irregular expressions like "0,5 * * * *" only show their shortest
+	// interval over a few runs
+	t := schedule.Next(now.In(loc))
+	if t.IsZero() {
+		return errors.New("cron expression never fires")
+	}
+	for i := 0; i < 10; i++ {
+		next := schedule.Next(t)
+		if next.IsZero() {
+			break
+		}
+		if next.Sub(t) < minScheduleInterval {
+			return fmt.Errorf("schedules may run at most every %d minutes", int(minScheduleInterval.Minutes()))
+		}
+		t = next
+	}
+	return nil
+}
+
+// nextRun returns when the schedule runs next after t, or nil when it does
+// not run again.
+func (p *ScheduledPrompt) nextRun(t time.Time) (*time.Time, error) {
+	if p.Cron == "" {
+		if p.RunAt != nil && p.RunAt.After(t) {
+			return p.RunAt, nil
+		}
+		return nil, nil
+	}
+
+	schedule, err := cron.Parse(p.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %s", p.Timezone)
+	}
+	next := schedule.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+func (s *ScheduleService) List(userID uuid.UUID) ([]ScheduledPrompt, error) {
+	var schedules []ScheduledPrompt
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&schedules).Error
+	return schedules, err
+}
+
+func (s *ScheduleService) Get(id, userID uuid.UUID) (*ScheduledPrompt, error) {
+	var schedule ScheduledPrompt
+	if err := s.db.Where("id = ? AND user_id = ?", id, userID).First(&schedule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// Delete removes a schedule and its history. The messages of past runs stay
+// in the chat.
+func (s *ScheduleService) Delete(id, userID uuid.UUID) error {
+	if _, err := s.Get(id, userID); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("schedule_id = ?", id).Delete(&ScheduleRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&ScheduledPrompt{}, "id = ?", id).Error
+	})
+}
+
+// Pause stops an active schedule from running until it is resumed.
+func (s *ScheduleService) Pause(id, userID uuid.UUID) (*ScheduledPrompt, error) {
+	schedule, err := s.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status != ScheduleStatusActive {
+		return nil, errors.New("only active schedules can be paused")
+	}
+
+	schedule.Status = ScheduleStatusPaused
+	schedule.NextRunAt = nil
+	err = s.db.Model(&ScheduledPrompt{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": schedule.Status, "next_run_at": nil, "updated_at": time.Now()}).Error
+	return schedule, err
+}
+
+// Resume reactivates a paused schedule. Runs missed while it was paused are
+// skipped; a one-off schedule whose time has passed runs right away.
+func (s *ScheduleService) Resume(id, userID uuid.UUID) (*ScheduledPrompt, error) {
+	schedule, err := s.Get(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status != ScheduleStatusPaused {
+		return nil, errors.New("only paused schedules can be resumed")
+	}
+
+	now := time.Now()
+	next, err := schedule.nextRun(now)
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		if schedule.Cron != "" {
+			return nil, errors.New("cron expression never fires")
+		}
+		next = &now
+	}
+
+	schedule.Status = ScheduleStatusActive
+	schedule.NextRunAt = next
+	err = s.db.Model(&ScheduledPrompt{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": schedule.Status, "next_run_at": next, "updated_at": now}).Error
+	return schedule, err
+}
+
+// History lists the runs of a schedule, newest first.
+func (s *ScheduleService) History(id, userID uuid.UUID, limit, offset int) ([]ScheduleRun, error) {
+	if _, err := s.Get(id, userID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	var runs []ScheduleRun
+	err := s.db.Where("schedule_id = ?", id).
+		Order("started_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&runs).Error
+	return runs, err
+}
+
+// runDue claims the schedules that are due and runs them, at most
+// scheduleWorkers at once.
+func (s *ScheduleService) runDue() {
+	var due []ScheduledPrompt
+	if err := s.db.
+		Where("status = ? AND next_run_at <= ?", ScheduleStatusActive, time.Now()).
+		Order("next_run_at").
+		Limit(100).
+		Find(&due).Error; err != nil {
+		log.Printf("Scheduled prompts: failed to load due schedules: %v", err)
+		return
+	}
+
+	for i := range due {
+		schedule := due[i]
+		if !s.claim(&schedule) {
+			continue
+		}
+
+		s.slots <- struct{}{}
+		go func() {
+			defer func() { <-s.slots }()
+			s.execute(&schedule)
+		}()
+	}
+}
+
+// claim moves a due schedule to its next run, or completes it when it has
+// none. It reports false when another instance claimed it first.
+func (s *ScheduleService) claim(schedule *ScheduledPrompt) bool {
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	next, err := schedule.nextRun(time.Now())
+	if err != nil {
+		// The expression was valid when saved, so the timezone database
+		// changed; stop instead of retrying every tick
+		log.Printf("Scheduled prompts: %s: %v", schedule.ID, err)
+		updates["status"] = ScheduleStatusPaused
+	}
+	if next == nil {
+		updates["next_run_at"] = nil
+		if schedule.Cron == "" {
+			updates["status"] = ScheduleStatusCompleted
+		}
+	} else {
+		updates["next_run_at"] = *next
+	}
+
+	result := s.db.Model(&ScheduledPrompt{}).
+		Where("id = ? AND status = ? AND next_run_at = ?", schedule.ID, ScheduleStatusActive, schedule.NextRunAt).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("Scheduled prompts: failed to claim %s: %v", schedule.ID, result.Error)
+		return false
+	}
+	return result.RowsAffected > 0 && err == nil
+}
+
+// execute sends the schedule's prompt to its chat, records the run and
+// notifies the owner.
+func (s *ScheduleService) execute(schedule *ScheduledPrompt) {
+	run := &ScheduleRun{
+		ScheduleID: schedule.ID,
+		ChatID:     schedule.ChatID,
+		StartedAt:  time.Now(),
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		log.Printf("Scheduled prompts: failed to record run of %s: %v", schedule.ID, err)
+		return
+	}
+
+	messageID, tokens, err := s.send(schedule)
+
+	now := time.Now()
+	run.Status = ScheduleRunCompleted
+	run.MessageID = messageID
+	run.Tokens = tokens
+	run.FinishedAt = &now
+	if err != nil {
+		run.Status = ScheduleRunFailed
+		run.Error = err.Error()
+	}
+	if err := s.db.Save(run).Error; err != nil {
+		log.Printf("Scheduled prompts: failed to save run of %s: %v", schedule.ID, err)
+	}
+
+	s.db.Model(&ScheduledPrompt{}).
+		Where("id = ?", schedule.ID).
+		Updates(map[string]interface{}{
+			"last_run_at": now,
+			"run_count":   gorm.Expr("run_count + 1"),
+		})
+
+	if s.notifier != nil {
+		s.notifier.BroadcastToUsers([]uuid.UUID{schedule.UserID}, ScheduleEvent, ScheduleNotification{
+			ScheduleID: schedule.ID,
+			RunID:      run.ID,
+			ChatID:     schedule.ChatID,
+			Name:       schedule.Name,
+			Status:     run.Status,
+			MessageID:  run.MessageID,
+			Error:      run.Error,
+		})
+	}
+}
+
+// send runs the prompt through the chat service like a message of the user
+// and waits for the reply. A schedule whose chat is gone is paused.
+func (s *ScheduleService) send(schedule *ScheduledPrompt) (*uuid.UUID, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scheduleRunTimeout)
+	defer cancel()
+
+	chunks, err := s.chat.SendMessage(ctx, schedule.ChatID, schedule.UserID, &SendMessageRequest{
+		Content:      schedule.Prompt,
+		SystemPrompt: schedule.SystemPrompt,
+	})
+	if err != nil {
+		if _, chatErr := s.chat.GetChat(schedule.ChatID, schedule.UserID); chatErr != nil {
+			s.db.Model(&ScheduledPrompt{}).
+				Where("id = ? AND status = ?", schedule.ID, ScheduleStatusActive).
+				Updates(map[string]interface{}{"status": ScheduleStatusPaused, "next_run_at": nil})
+		}
+		return nil, 0, err
+	}
+
+	var final StreamChunk
+	for chunk := range chunks {
+		if chunk.Done {
+			final = chunk
+		}
+	}
+
+	switch {
+	case final.Stopped:
+		return nil, final.TotalTokens, errors.New("the reply took too long and was stopped")
+	case final.Model == "" && final.Delta != "":
+		// Failed replies end with the error instead of a model
+		return nil, 0, errors.New(strings.TrimPrefix(final.Delta, "Error: "))
+	case final.Model == "":
+		return nil, 0, errors.New("no reply")
+	}
+
+	messageID, err := uuid.Parse(final.MessageID)
+	if err != nil {
+		return nil, final.TotalTokens, nil
+	}
+	return &messageID, final.TotalTokens, nil
+}
@@ -5,54 +5,148 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	openai "github.com/sashabaranov/go-openai"
 	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
 )
 
 type Service struct {
-	repo         *Repository
-	openaiClient *openai.Client
-	db           *gorm.DB
+	repo        *Repository
+	providers   *llm.Registry
+	tools       *ToolRegistry
+	documents   *DocumentsService
+	attachments *AttachmentsService
+	images      *ImagesService
+	speech      *SpeechService
+	memory      *MemoryService
+	catalog     *ModelCatalogService
+	analytics   *AnalyticsService
+	moderation  *moderation.Service
+	generations *generationRegistry
+	shareLimits *attemptLimiter // wrong share link passwords
+	db          *gorm.DB
 }
 
 const (
-	defaultImageSize = "1024x1024"
-	imageTokenCost   = 40
+	defaultImageModel = openai.CreateImageModelDallE2
+	imageTokenCost    = 40 // charged per generated image
 )
 
 func NewService(repo *Repository, db *gorm.DB) *Service {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Println("Warning: OPENAI_API_KEY not set - chat functionality will be limited")
-		apiKey = "sk-dummy-key-for-development" // Allow server to start without real API key
-	}
+	return NewServiceWithProviders(repo, db, llm.NewRegistryFromEnv())
+}
 
+// NewServiceWithProviders builds a Service on an explicit provider registry,
+// e.g. one holding only an llm.FakeProvider in tests.
+func NewServiceWithProviders(repo *Repository, db *gorm.DB, providers *llm.Registry) *Service {
 	return &Service{
-		repo:         repo,
-		openaiClient: openai.NewClient(apiKey),
-		db:           db,
+		repo:        repo,
+		providers:   providers,
+		generations: newGenerationRegistry(),
+		shareLimits: newAttemptLimiter(sharePasswordWindow),
+		db:          db,
+	}
+}
+
+// SetTools sets the tools offered to the model in chats. Without tools the
+// model only answers with text.
+func (s *Service) SetTools(tools *ToolRegistry) {
+	s.tools = tools
+}
+
+// SetModelCatalog restricts chats to the models of the catalog allowed for
+// the user's tier. Without a catalog any model name is accepted.
+func (s *Service) SetModelCatalog(catalog *ModelCatalogService) {
+	s.catalog = catalog
+}
+
+// checkModel verifies the user may use a model.
+func (s *Service) checkModel(userID uuid.UUID, model string) error {
+	if s.catalog == nil {
+		return nil
+	}
+	_, err := s.catalog.CheckAccess(userID, model)
+	return err
+}
+
+// modelChain returns the model followed by its catalog fallbacks that the
+// user may use. The model itself is not checked; callers check it with
+// checkModel first.
+func (s *Service) modelChain(userID uuid.UUID, model string) []string {
+	chain := []string{model}
+	if s.catalog == nil {
+		return chain
+	}
+	entry, err := s.catalog.GetModel(model)
+	if err != nil {
+		return chain
 	}
+	for _, fallback := range entry.FallbackChain() {
+		if s.checkModel(userID, fallback) == nil {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// contextWindow returns the model's context window from the catalog,
+// falling back to the built-in table.
+func (s *Service) contextWindow(model string) int {
+	if s.catalog != nil {
+		if entry, err := s.catalog.GetModel(model); err == nil && entry.ContextWindow > 0 {
+			return entry.ContextWindow
+		}
+	}
+	return ContextWindow(model)
 }
 
 func (s *Service) CreateChat(userID uuid.UUID, req *CreateChatRequest) (*Chat, error) {
+	var persona *Persona
+	if req.PersonaID != nil {
+		var err error
+		persona, err = s.loadPersona(*req.PersonaID, userID, req.PersonaVariables)
+		if err != nil {
+			return nil, err
+		}
+		if req.Model == "" {
+			req.Model = persona.Model
+		}
+	}
+
 	if req.Model == "" {
-		req.Model = "gpt-4o"
+		req.Model = defaultModel
+	}
+	if err := s.checkModel(userID, req.Model); err != nil {
+		return nil, err
+	}
+
+	if req.ContextStrategy == "" {
+		req.ContextStrategy = ContextStrategySummarize
 	}
 
 	chat := &Chat{
-		UserID: userID,
-		Title:  req.Title,
-		Model:  req.Model,
+		UserID:          userID,
+		Title:           req.Title,
+		Model:           req.Model,
+		ContextStrategy: req.ContextStrategy,
+	}
+
+	if persona != nil {
+		chat.PersonaID = &persona.ID
+		chat.PersonaVariables = encodePersonaVariables(req.PersonaVariables)
 	}
 
 	if chat.Title == "" {
 		chat.Title = "New Chat"
+	} else {
+		chat.TitleSource = TitleSourceUser
 	}
 
 	if err := s.repo.CreateChat(chat); err != nil {
@@ -79,15 +173,29 @@ func (s *Service) UpdateChat(chatID, userID uuid.UUID, req *UpdateChatRequest) (
 		return nil, err
 	}
 
+	updates := make(map[string]interface{})
 	if req.Title != "" {
 		chat.Title = req.Title
+		chat.TitleSource = TitleSourceUser
+		updates["title"] = chat.Title
+		updates["title_source"] = chat.TitleSource
 	}
-	if req.Model != "" {
+	if req.Model != "" && req.Model != chat.Model {
+		if err := s.checkModel(userID, req.Model); err != nil {
+			return nil, err
+		}
 		chat.Model = req.Model
+		updates["model"] = chat.Model
+	}
+	if req.ContextStrategy != "" {
+		chat.ContextStrategy = req.ContextStrategy
+		updates["context_strategy"] = chat.ContextStrategy
 	}
 
-	if err := s.repo.UpdateChat(chat); err != nil {
-		return nil, err
+	if len(updates) > 0 {
+		if err := s.repo.UpdateChat(chatID, updates); err != nil {
+			return nil, err
+		}
 	}
 
 	return chat, nil
@@ -142,141 +250,328 @@ func (s *Service) CheckTokenLimit(userID uuid.UUID) (bool, int64, int64, error)
 	return hasCapacity, user.TokensUsed, user.TokensLimit, nil
 }
 
-func (s *Service) SendMessage(chatID, userID uuid.UUID, req *SendMessageRequest) (<-chan StreamChunk, error) {
+func (s *Service) SendMessage(ctx context.Context, chatID, userID uuid.UUID, req *SendMessageRequest) (<-chan StreamChunk, error) {
 	// Get chat and verify ownership
 	chat, err := s.repo.GetChatByID(chatID, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	// The user's tier may have changed since the chat was created
+	if err := s.checkModel(userID, chat.Model); err != nil {
+		return nil, err
+	}
+	output, err := newStructuredOutput(req.ResponseFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check token limit
-	hasCapacity, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
+	if err := s.ensureTokenCapacity(userID); err != nil {
+		return nil, err
+	}
+
+	verdict, err := s.moderatePrompt(ctx, chatID, userID, req.Content)
 	if err != nil {
 		return nil, err
 	}
-	if !hasCapacity {
-		return nil, fmt.Errorf("token limit exceeded: %d/%d tokens used", tokensUsed, tokensLimit)
+
+	// Images are checked against the model and priced before anything is saved
+	attachments, attachmentTokens, err := s.prepareAttachments(ctx, chat, userID, req.Attachments)
+	if err != nil {
+		return nil, err
 	}
 
-	// Save user message
+	// Save user message as a child of the active branch
 	userMessage := &Message{
-		ChatID:  chatID,
-		Role:    "user",
-		Content: req.Content,
-		Tokens:  countTokens(req.Content),
+		ChatID:   chatID,
+		ParentID: chat.leafID(),
+		Role:     "user",
+		Content:  req.Content,
+		Tokens:   tokenizer.Count(chat.Model, req.Content) + attachmentTokens,
 	}
 
-	if err := s.repo.CreateMessage(userMessage); err != nil {
+	if err := s.repo.CreateMessageWithAttachments(userMessage, attachments); err != nil {
 		return nil, err
 	}
+	s.recordPrompt(verdict, userID, userMessage)
+	if err := s.repo.SetActiveLeaf(chatID, userMessage.ID); err != nil {
+		return nil, err
+	}
+
+	return s.streamReply(ctx, chat, userID, req.SystemPrompt, userMessage.ID, output)
+}
 
-	// Build conversation history
-	messages, err := s.repo.GetChatMessages(chatID)
+// RegenerateMessage streams a new answer to the prompt of an assistant
+// message. req may be nil.
+func (s *Service) RegenerateMessage(ctx context.Context, chatID, messageID, userID uuid.UUID, req *RegenerateMessageRequest) (<-chan StreamChunk, error) {
+	// Get chat and verify ownership
+	chat, err := s.repo.GetChatByID(chatID, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	openaiMessages := make([]openai.ChatCompletionMessage, 0, len(messages)+1)
+	message := findMessage(chat.Messages, messageID)
+	if message == nil || message.Role != "assistant" {
+		return nil, errors.New("message not found or not an assistant message")
+	}
+	if message.ParentID == nil {
+		return nil, errors.New("message has no prompt to regenerate from")
+	}
 
-	// Add system prompt if provided
-	if req.SystemPrompt != "" {
-		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleSystem,
-			Content: req.SystemPrompt,
-		})
+	if err := s.checkModel(userID, chat.Model); err != nil {
+		return nil, err
+	}
+	var format *ResponseFormat
+	if req != nil {
+		format = req.ResponseFormat
+	}
+	output, err := newStructuredOutput(format)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ensureTokenCapacity(userID); err != nil {
+		return nil, err
 	}
 
-	// Add conversation history
-	for _, msg := range messages {
-		role := openai.ChatMessageRoleUser
+	// The new answer becomes a sibling of the old one, which is kept
+	return s.streamReply(ctx, chat, userID, "", *message.ParentID, output)
+}
+
+// streamReply streams a new assistant reply to parentID, using the branch
+// that ends at parentID as history. When the model calls tools, the calls and
+// their results are saved on the branch and the model is invoked again with
+// them. The last saved message becomes the chat's active leaf.
+//
+// Generation stops when ctx is cancelled (client disconnect) or through
+// StopGeneration; the partial reply is then saved with the stopped status.
+//
+// With a response format (output not nil) the reply is generated by
+// structuredReply instead.
+func (s *Service) streamReply(ctx context.Context, chat *Chat, userID uuid.UUID, systemPrompt string, parentID uuid.UUID, output *structuredOutput) (<-chan StreamChunk, error) {
+	messageID := uuid.New()
+	ctx, gen := s.generations.start(ctx, chat.ID, userID, messageID)
+
+	// Build conversation history along the branch
+	messages, err := s.repo.GetChatMessages(chat.ID)
+	if err != nil {
+		s.generations.finish(gen)
+		return nil, err
+	}
+	history := activePath(messages, &parentID)
+	models := s.modelChain(userID, chat.Model)
+
+	// Chats are titled after their first reply only, so a failed attempt
+	// is not repeated on every turn; the title endpoint can be used instead
+	autoTitle := chat.TitleSource == ""
+	for _, msg := range history {
 		if msg.Role == "assistant" {
-			role = openai.ChatMessageRoleAssistant
-		} else if msg.Role == "system" {
-			role = openai.ChatMessageRoleSystem
+			autoTitle = false
+			break
 		}
+	}
+	if s.loadAttachmentImages(ctx, chat, history) {
+		models = s.visionChain(models)
+	}
 
-		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
-			Role:    role,
-			Content: msg.Content,
-		})
+	systemPrompt, err = s.composeSystemPrompt(chat, userID, systemPrompt)
+	if err != nil {
+		s.generations.finish(gen)
+		return nil, err
 	}
 
-	// Create streaming request
+	// Remind the model of what it knows about the user
+	systemPrompt = withMemories(systemPrompt, s.recallMemories(ctx, userID, history))
+
+	// Ground the reply in the chat's attached documents
+	citations := s.retrieveCitations(ctx, chat.ID, history)
+	systemPrompt = withCitations(systemPrompt, citations)
+
+	// Fit the system prompt and history into the model's context window
+	openaiMessages := s.buildContext(ctx, chat, userID, systemPrompt, history)
+	promptTokens := tokenizer.CountMessages(chat.Model, openaiMessages) + sentImageTokens(history, openaiMessages)
+
+	if output != nil {
+		return s.structuredReply(ctx, gen, chat, userID, parentID, messageID, models, openaiMessages, output, citations, autoTitle), nil
+	}
+
+	// Create streaming request; the model is set per provider of the chain
 	streamReq := openai.ChatCompletionRequest{
-		Model:    chat.Model,
-		Messages: openaiMessages,
-		Stream:   true,
+		Messages:      openaiMessages,
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+		Tools:         s.chatTools(userID, chat.Model),
 	}
 
-	stream, err := s.openaiClient.CreateChatCompletionStream(context.Background(), streamReq)
+	// Rate limits and outages are retried, then the chat model's fallbacks
+	// are tried in turn
+	stream, usedModel, err := s.providers.CreateChatCompletionStream(ctx, models, streamReq)
 	if err != nil {
+		s.generations.finish(gen)
 		return nil, err
 	}
 
 	// Create channel for streaming chunks
 	chunkChan := make(chan StreamChunk)
-	assistantMessageID := uuid.New()
+
+	// send gives up once the generation is cancelled, so a vanished client
+	// cannot block the goroutine
+	send := func(chunk StreamChunk) {
+		select {
+		case chunkChan <- chunk:
+		case <-ctx.Done():
+		}
+	}
 
 	go func() {
 		defer close(chunkChan)
-		defer stream.Close()
-
-		var fullContent string
-		var totalTokens int
-
-		for {
-			response, err := stream.Recv()
-			if errors.Is(err, io.EOF) {
-				// Stream finished - save assistant message
-				assistantMessage := &Message{
-					ID:      assistantMessageID,
-					ChatID:  chatID,
-					Role:    "assistant",
-					Content: fullContent,
-					Tokens:  totalTokens,
-					Model:   chat.Model,
+		defer s.generations.finish(gen)
+
+		toolCtx := ToolContext{UserID: userID, ChatID: chat.ID}
+		var total openai.Usage
+
+		for round := 1; ; round++ {
+			turn, err := receiveTurn(stream, chat.Model, promptTokens, messageID, send)
+			total.PromptTokens += turn.usage.PromptTokens
+			total.CompletionTokens += turn.usage.CompletionTokens
+			total.TotalTokens += turn.usage.TotalTokens
+
+			parent := parentID
+			assistantMessage := &Message{
+				ID:       messageID,
+				ChatID:   chat.ID,
+				ParentID: &parent,
+				Role:     "assistant",
+				Content:  turn.content,
+				Tokens:   turn.usage.CompletionTokens,
+				Model:    usedModel,
+				Status:   MessageStatusCompleted,
+			}
+			if len(turn.toolCalls) == 0 {
+				assistantMessage.Citations = encodeCitations(citations)
+			}
+
+			if err != nil && ctx.Err() != nil {
+				// Stopped - keep what was generated so far
+				if turn.content != "" {
+					assistantMessage.Status = MessageStatusStopped
+					s.repo.CreateMessage(assistantMessage)
+					s.repo.SetActiveLeaf(chat.ID, messageID)
 				}
+				s.addTokenUsage(userID, total.TotalTokens)
+
+				// The stream writer drains the channel, so this only waits
+				// when the client is already gone
+				select {
+				case chunkChan <- StreamChunk{
+					Type:             ChunkTypeContent,
+					MessageID:        messageID.String(),
+					Done:             true,
+					Model:            usedModel,
+					Stopped:          true,
+					PromptTokens:     total.PromptTokens,
+					CompletionTokens: total.CompletionTokens,
+					TotalTokens:      total.TotalTokens,
+					Citations:        citations,
+				}:
+				case <-time.After(stoppedChunkTimeout):
+				}
+				return
+			}
 
+			if err != nil {
+				s.addTokenUsage(userID, total.TotalTokens)
+				send(StreamChunk{
+					Type:      ChunkTypeContent,
+					Delta:     fmt.Sprintf("Error: %v", err),
+					MessageID: messageID.String(),
+					Done:      true,
+				})
+				return
+			}
+
+			if len(turn.toolCalls) == 0 {
+				// Stream finished - save assistant message, as a notice when
+				// moderation withholds it
+				blocked := s.moderateReply(ctx, userID, assistantMessage)
 				s.repo.CreateMessage(assistantMessage)
 
 				// Update user's token usage
-				s.db.Table("users").
-					Where("id = ?", userID).
-					UpdateColumn("tokens_used", gorm.Expr("tokens_used + ?", totalTokens+userMessage.Tokens))
+				s.addTokenUsage(userID, total.TotalTokens)
 
-				// Update chat timestamp
-				chat.UpdatedAt = time.Now()
-				s.repo.UpdateChat(chat)
+				// Select the new branch and update chat timestamp
+				s.repo.SetActiveLeaf(chat.ID, messageID)
 
-				// Send final chunk
-				chunkChan <- StreamChunk{
-					Delta:       "",
-					MessageID:   assistantMessageID.String(),
-					Done:        true,
-					TotalTokens: totalTokens,
+				// Title new chats in the background
+				if autoTitle {
+					go s.autoTitle(chat.ID, userID)
 				}
+
+				// Send final chunk
+				send(StreamChunk{
+					Type:             ChunkTypeContent,
+					Delta:            "",
+					MessageID:        messageID.String(),
+					Done:             true,
+					Model:            usedModel,
+					Blocked:          blocked,
+					PromptTokens:     total.PromptTokens,
+					CompletionTokens: total.CompletionTokens,
+					TotalTokens:      total.TotalTokens,
+					Citations:        citations,
+				})
 				return
 			}
 
-			if err != nil {
-				chunkChan <- StreamChunk{
-					Delta:     fmt.Sprintf("Error: %v", err),
-					MessageID: assistantMessageID.String(),
-					Done:      true,
+			// Save the calls, run them and hand the results back to the model
+			assistantMessage.ToolCalls = encodeToolCalls(turn.toolCalls)
+			s.repo.CreateMessage(assistantMessage)
+			streamReq.Messages = append(streamReq.Messages, toOpenAIMessage(*assistantMessage))
+			parentID = messageID
+
+			for _, call := range turn.toolCalls {
+				send(StreamChunk{
+					Type:      ChunkTypeToolCall,
+					MessageID: messageID.String(),
+					Tool: &ToolChunk{
+						ID:        call.ID,
+						Name:      call.Function.Name,
+						Arguments: call.Function.Arguments,
+					},
+				})
+
+				toolMessage, toolErr := s.runTool(ctx, toolCtx, chat, parentID, call)
+				parentID = toolMessage.ID
+				streamReq.Messages = append(streamReq.Messages, toOpenAIMessage(*toolMessage))
+
+				result := &ToolChunk{ID: call.ID, Name: call.Function.Name, Result: toolMessage.Content}
+				if toolErr != nil {
+					result.Result = ""
+					result.Error = toolErr.Error()
 				}
-				return
+				send(StreamChunk{
+					Type:      ChunkTypeToolResult,
+					MessageID: toolMessage.ID.String(),
+					Tool:      result,
+				})
 			}
+			s.repo.SetActiveLeaf(chat.ID, parentID)
 
-			if len(response.Choices) > 0 {
-				delta := response.Choices[0].Delta.Content
-				fullContent += delta
-				totalTokens = countTokens(fullContent)
+			// Out of rounds: ask for a final answer without further calls
+			if round >= maxToolRounds {
+				streamReq.ToolChoice = "none"
+			}
 
-				chunkChan <- StreamChunk{
-					Delta:     delta,
-					MessageID: assistantMessageID.String(),
-					Done:      false,
-				}
+			messageID = uuid.New()
+			s.generations.track(gen, messageID)
+			promptTokens = tokenizer.CountMessages(chat.Model, streamReq.Messages)
+			var next string
+			stream, next, err = s.providers.CreateChatCompletionStream(ctx, models, streamReq)
+			if err != nil {
+				// Let the next receiveTurn report it, stopped or failed
+				stream = failedStream{err: err}
+			} else {
+				usedModel = next
 			}
 		}
 	}()
@@ -284,121 +579,134 @@ func (s *Service) SendMessage(chatID, userID uuid.UUID, req *SendMessageRequest)
 	return chunkChan, nil
 }
 
-func (s *Service) RegenerateMessage(chatID, messageID, userID uuid.UUID) (<-chan StreamChunk, error) {
-	// Get chat and verify ownership
-	chat, err := s.repo.GetChatByID(chatID, userID)
-	if err != nil {
-		return nil, err
-	}
+// stoppedChunkTimeout bounds how long a stopped generation waits to report
+// the stop to a client that may have disconnected.
+const stoppedChunkTimeout = 5 * time.Second
 
-	// Get all messages up to the one being regenerated
-	messages, err := s.repo.GetChatMessages(chatID)
-	if err != nil {
-		return nil, err
-	}
+// streamTurn is one streamed completion: text, requested tool calls, usage.
+type streamTurn struct {
+	content   string
+	toolCalls []openai.ToolCall
+	usage     openai.Usage
+}
+
+// receiveTurn forwards a completion's content deltas through send and
+// collects the turn. On error the partial turn is returned with it. The
+// stream is closed when done.
+func receiveTurn(stream llm.ChatStream, model string, promptTokens int, messageID uuid.UUID, send func(StreamChunk)) (*streamTurn, error) {
+	defer stream.Close()
+
+	var fullContent strings.Builder
+	var reported *openai.Usage
+	var toolCalls []openai.ToolCall
+	var streamErr error
 
-	// Find the message index
-	messageIndex := -1
-	for i, msg := range messages {
-		if msg.ID == messageID {
-			messageIndex = i
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			streamErr = err
 			break
 		}
-	}
-
-	if messageIndex == -1 || messages[messageIndex].Role != "assistant" {
-		return nil, errors.New("message not found or not an assistant message")
-	}
 
-	// Build conversation up to the previous user message
-	openaiMessages := make([]openai.ChatCompletionMessage, 0)
-	for i := 0; i < messageIndex; i++ {
-		msg := messages[i]
-		role := openai.ChatMessageRoleUser
-		if msg.Role == "assistant" {
-			role = openai.ChatMessageRoleAssistant
-		} else if msg.Role == "system" {
-			role = openai.ChatMessageRoleSystem
+		if response.Usage != nil {
+			reported = response.Usage
+		}
+		if len(response.Choices) == 0 {
+			continue
 		}
 
-		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
-			Role:    role,
-			Content: msg.Content,
-		})
+		delta := response.Choices[0].Delta
+		for _, call := range delta.ToolCalls {
+			toolCalls = mergeToolCallDelta(toolCalls, call)
+		}
+		if delta.Content != "" {
+			fullContent.WriteString(delta.Content)
+			send(StreamChunk{
+				Type:      ChunkTypeContent,
+				Delta:     delta.Content,
+				MessageID: messageID.String(),
+				Done:      false,
+			})
+		}
 	}
 
-	// Create streaming request
-	streamReq := openai.ChatCompletionRequest{
-		Model:    chat.Model,
-		Messages: openaiMessages,
-		Stream:   true,
+	// Arguments count towards the completion when usage is estimated locally
+	completion := fullContent.String()
+	for i, call := range toolCalls {
+		completion += call.Function.Name + call.Function.Arguments
+		// Results are matched to calls by ID; not every backend sends one
+		if call.ID == "" {
+			toolCalls[i].ID = "call_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+		}
 	}
 
-	stream, err := s.openaiClient.CreateChatCompletionStream(context.Background(), streamReq)
-	if err != nil {
-		return nil, err
+	turn := &streamTurn{
+		content:   fullContent.String(),
+		toolCalls: toolCalls,
 	}
+	// A request that failed outright was not billed
+	if fullContent.Len() > 0 || len(toolCalls) > 0 || reported != nil {
+		turn.usage = resolveUsage(reported, model, promptTokens, completion)
+	}
+	return turn, streamErr
+}
 
-	chunkChan := make(chan StreamChunk)
+// failedStream is a ChatStream whose request could not be started.
+type failedStream struct {
+	err error
+}
 
-	go func() {
-		defer close(chunkChan)
-		defer stream.Close()
-
-		var fullContent string
-		var totalTokens int
-
-		for {
-			response, err := stream.Recv()
-			if errors.Is(err, io.EOF) {
-				// Update existing message
-				messages[messageIndex].Content = fullContent
-				messages[messageIndex].Tokens = totalTokens
-				s.repo.UpdateMessage(&messages[messageIndex])
-
-				chunkChan <- StreamChunk{
-					Delta:       "",
-					MessageID:   messageID.String(),
-					Done:        true,
-					TotalTokens: totalTokens,
-				}
-				return
-			}
+func (s failedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	return openai.ChatCompletionStreamResponse{}, s.err
+}
 
-			if err != nil {
-				chunkChan <- StreamChunk{
-					Delta:     fmt.Sprintf("Error: %v", err),
-					MessageID: messageID.String(),
-					Done:      true,
-				}
-				return
-			}
+func (s failedStream) Close() error {
+	return nil
+}
 
-			if len(response.Choices) > 0 {
-				delta := response.Choices[0].Delta.Content
-				fullContent += delta
-				totalTokens = countTokens(fullContent)
+func (s *Service) ensureTokenCapacity(userID uuid.UUID) error {
+	hasCapacity, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
+	if err != nil {
+		return err
+	}
+	if !hasCapacity {
+		return fmt.Errorf("token limit exceeded: %d/%d tokens used", tokensUsed, tokensLimit)
+	}
+	return nil
+}
 
-				chunkChan <- StreamChunk{
-					Delta:     delta,
-					MessageID: messageID.String(),
-					Done:      false,
-				}
-			}
-		}
-	}()
+// resolveUsage prefers the usage reported by the provider and falls back to
+// counting the prompt and completion with the model's tokenizer.
+func resolveUsage(reported *openai.Usage, model string, promptTokens int, completion string) openai.Usage {
+	if reported != nil && reported.TotalTokens > 0 {
+		return *reported
+	}
 
-	return chunkChan, nil
+	completionTokens := tokenizer.Count(model, completion)
+	return openai.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
 }
 
-// Simple token counter (approximation: ~4 chars per token for English)
-// In production, use tiktoken-go for accurate counting
-func countTokens(text string) int {
-	return len(text) / 4
+func (s *Service) addTokenUsage(userID uuid.UUID, tokens int) error {
+	return s.db.Table("users").
+		Where("id = ?", userID).
+		UpdateColumn("tokens_used", gorm.Expr("tokens_used + ?", tokens)).Error
 }
 
-// CallOpenAI for messenger AI integration
-func (s *Service) CallOpenAI(userID uuid.UUID, model string, messages []map[string]interface{}) (map[string]interface{}, error) {
+// CallOpenAI for messenger AI integration. With a JSON response format
+// the reply is validated, see completeStructured.
+func (s *Service) CallOpenAI(userID uuid.UUID, model string, messages []map[string]interface{}, responseFormat *ResponseFormat) (map[string]interface{}, error) {
+	output, err := newStructuredOutput(responseFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check token limit
 	hasCapacity, _, _, err := s.CheckTokenLimit(userID)
 	if err != nil {
@@ -438,24 +746,52 @@ func (s *Service) CallOpenAI(userID uuid.UUID, model string, messages []map[stri
 
 	// Use default model if not specified
 	if model == "" {
-		model = "gpt-4o"
+		model = defaultModel
+	}
+	if err := s.checkModel(userID, model); err != nil {
+		return nil, err
+	}
+	if err := s.moderateRequest(context.Background(), userID, moderation.SourceAPIPrompt, promptText(openaiMessages)); err != nil {
+		return nil, err
 	}
 
 	req := openai.ChatCompletionRequest{
-		Model:    model,
 		Messages: openaiMessages,
 	}
 
-	resp, err := s.openaiClient.CreateChatCompletion(context.Background(), req)
+	if output != nil {
+		resp, usedModel, usage, err := s.completeStructured(context.Background(), s.modelChain(userID, model), req, output)
+		s.addTokenUsage(userID, usage.TotalTokens)
+		if err != nil {
+			return nil, err
+		}
+		s.moderateChoices(context.Background(), userID, resp.Choices)
+		return map[string]interface{}{
+			"id":      resp.ID,
+			"model":   usedModel,
+			"choices": resp.Choices,
+			"usage":   resp.Usage,
+		}, nil
+	}
+
+	resp, usedModel, err := s.providers.CreateChatCompletion(context.Background(), s.modelChain(userID, model), req)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update token usage
-	tokensUsed := resp.Usage.TotalTokens
-	s.db.Table("users").
-		Where("id = ?", userID).
-		UpdateColumn("tokens_used", gorm.Expr("tokens_used + ?", tokensUsed))
+	// Update token usage, counting locally if the provider reports none
+	if resp.Usage.TotalTokens == 0 {
+		completion := ""
+		if len(resp.Choices) > 0 {
+			completion = resp.Choices[0].Message.Content
+		}
+		resp.Usage = resolveUsage(nil, usedModel, tokenizer.CountMessages(usedModel, openaiMessages), completion)
+	}
+	s.addTokenUsage(userID, resp.Usage.TotalTokens)
+	s.moderateChoices(context.Background(), userID, resp.Choices)
+	if resp.Model == "" {
+		resp.Model = usedModel
+	}
 
 	// Return in OpenAI format
 	return map[string]interface{}{
@@ -465,77 +801,3 @@ func (s *Service) CallOpenAI(userID uuid.UUID, model string, messages []map[stri
 		"usage":   resp.Usage,
 	}, nil
 }
-
-func (s *Service) GenerateImage(userID uuid.UUID, prompt, size string) (string, error) {
-	prompt = strings.TrimSpace(prompt)
-	if prompt == "" {
-		return "", errors.New("prompt is required")
-	}
-
-	if size == "" {
-		size = defaultImageSize
-	}
-
-	// Check user subscription tier - only Premium+ can generate images
-	var user struct {
-		SubscriptionTier string
-		Role             string
-		TokensUsed       int64
-		TokensLimit      int64
-	}
-
-	err := s.db.Table("users").
-		Where("id = ?", userID).
-		Select("subscription_tier, role, tokens_used, tokens_limit").
-		Scan(&user).Error
-
-	if err != nil {
-		return "", err
-	}
-
-	// Superadmins can always generate images
-	if user.Role != "superadmin" {
-		// Only Premium and Unlimited tiers can generate images
-		if user.SubscriptionTier == "basic" {
-			return "", errors.New("image generation is only available for Premium and Unlimited subscribers")
-		}
-	}
-
-	hasCapacity, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
-	if err != nil {
-		return "", err
-	}
-
-	if !hasCapacity {
-		return "", errors.New("token limit exceeded")
-	}
-
-	projectedTokens := tokensUsed + imageTokenCost
-	if tokensLimit != -1 && projectedTokens > tokensLimit {
-		return "", fmt.Errorf("not enough tokens for image generation (%d/%d)", projectedTokens, tokensLimit)
-	}
-
-	req := openai.ImageRequest{
-		Prompt:         prompt,
-		Size:           size,
-		N:              1,
-		ResponseFormat: openai.CreateImageResponseFormatURL,
-	}
-
-	resp, err := s.openaiClient.CreateImage(context.Background(), req)
-	if err != nil {
-		return "", err
-	}
-
-	if len(resp.Data) == 0 || resp.Data[0].URL == "" {
-		return "", errors.New("image service returned an invalid response")
-	}
-
-	if err := s.db.Table("users").
-		Where("id = ?", userID).
-		UpdateColumn("tokens_used", gorm.Expr("tokens_used + ?", imageTokenCost)).Error; err != nil {
-		return "", err
-	}
-
-	return resp.Data[0].URL, nil
-}
@@ -0,0 +1,477 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/testutil"
+)
+
+// testDB creates the tables the chat service uses in a schema of the
+// database of TEST_DATABASE_URL, see testutil.Postgres.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testutil.Postgres(t, "chat_test")
+
+	// The columns of users the chat service reads, see migrateDatabase
+	err := db.Exec(`CREATE TABLE users (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		subscription_tier VARCHAR(20) DEFAULT 'basic',
+		role VARCHAR(20) DEFAULT 'user',
+		tokens_used BIGINT DEFAULT 0,
+		tokens_limit BIGINT DEFAULT 20000,
+		reset_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+		preferences TEXT DEFAULT '{}',
+		deleted_at TIMESTAMPTZ
+	)`).Error
+	if err != nil {
+		t.Fatalf("failed to create users: %v", err)
+	}
+	if err := db.AutoMigrate(&Chat{}, &Message{}, &MessageAttachment{}, &ChatSummary{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
+// createTestUser adds a user who has used tokensUsed of tokensLimit tokens.
+func createTestUser(t *testing.T, db *gorm.DB, tokensUsed, tokensLimit int64) uuid.UUID {
+	t.Helper()
+	userID := uuid.New()
+	err := db.Exec("INSERT INTO users (id, tokens_used, tokens_limit, reset_at) VALUES (?, ?, ?, ?)",
+		userID, tokensUsed, tokensLimit, time.Now().Add(time.Hour)).Error
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return userID
+}
+
+func tokensUsed(t *testing.T, db *gorm.DB, userID uuid.UUID) int64 {
+	t.Helper()
+	var used int64
+	if err := db.Table("users").Where("id = ?", userID).Select("tokens_used").Scan(&used).Error; err != nil {
+		t.Fatal(err)
+	}
+	return used
+}
+
+// newTestService returns a service answering through a fake provider for
+// "fake/" models, retrying without delay.
+func newTestService(t *testing.T) (*Service, *llm.FakeProvider, *gorm.DB) {
+	t.Helper()
+	db := testDB(t)
+
+	fake := llm.NewFakeProvider()
+	registry := llm.NewRegistry()
+	registry.Register(fake, "fake/")
+	registry.SetRetryPolicy(llm.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	return NewServiceWithProviders(NewRepository(db), db, registry), fake, db
+}
+
+// collect reads a reply stream to its end.
+func collect(t *testing.T, chunks <-chan StreamChunk) (string, StreamChunk) {
+	t.Helper()
+	var content strings.Builder
+	var last StreamChunk
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return content.String(), last
+			}
+			content.WriteString(chunk.Delta)
+			last = chunk
+		case <-timeout:
+			t.Fatal("reply stream did not end")
+		}
+	}
+}
+
+var (
+	errUnavailable = &openai.APIError{HTTPStatusCode: 503, Message: "overloaded"}
+	errBadRequest  = &openai.APIError{HTTPStatusCode: 400, Message: "invalid request"}
+)
+
+func TestSendMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		reply        string
+		failWith     error
+		tokensUsed   int64
+		wantErr      string
+		wantRequests int
+	}{
+		{name: "streams the reply", reply: "Hello there, how can I help?", wantRequests: 1},
+		{name: "echoes without a queued reply", wantRequests: 1},
+		{name: "provider rejects the request", failWith: errBadRequest, wantErr: "invalid request", wantRequests: 1},
+		{name: "provider unavailable after retries", failWith: errUnavailable, wantErr: "overloaded", wantRequests: 2},
+		{name: "token limit reached", tokensUsed: 1000, wantErr: "token limit exceeded", wantRequests: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, db := newTestService(t)
+			userID := createTestUser(t, db, tt.tokensUsed, 1000)
+			chat, err := s.CreateChat(userID, &CreateChatRequest{Title: "Test", Model: "fake/model", ContextStrategy: ContextStrategyFull})
+			if err != nil {
+				t.Fatalf("CreateChat: %v", err)
+			}
+			if tt.reply != "" {
+				fake.Enqueue(tt.reply)
+			}
+			fake.FailWith(tt.failWith)
+
+			chunks, err := s.SendMessage(context.Background(), chat.ID, userID, &SendMessageRequest{
+				Content:      "What is a monad?",
+				SystemPrompt: "Be brief.",
+			})
+
+			requests := fake.Requests()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("got %d requests, want %d", len(requests), tt.wantRequests)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if used := tokensUsed(t, db, userID); used != tt.tokensUsed {
+					t.Errorf("tokens used = %d, want %d", used, tt.tokensUsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SendMessage: %v", err)
+			}
+
+			content, final := collect(t, chunks)
+			want := tt.reply
+			if want == "" {
+				want = "Echo: What is a monad?"
+			}
+			if content != want {
+				t.Errorf("streamed %q, want %q", content, want)
+			}
+
+			req := requests[0]
+			if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+				t.Errorf("request does not stream with usage")
+			}
+			if req.Model != "model" {
+				t.Errorf("provider was sent model %q, want the upstream name", req.Model)
+			}
+			if len(req.Messages) != 2 || req.Messages[0].Content != "Be brief." || req.Messages[1].Content != "What is a monad?" {
+				t.Errorf("unexpected prompt: %+v", req.Messages)
+			}
+
+			// The usage the provider reported is sent and charged
+			wantPrompt := len(strings.Fields("Be brief. What is a monad?"))
+			wantCompletion := len(strings.Fields(want))
+			if !final.Done || final.Model != "fake/model" {
+				t.Errorf("final chunk = %+v", final)
+			}
+			if final.PromptTokens != wantPrompt || final.CompletionTokens != wantCompletion || final.TotalTokens != wantPrompt+wantCompletion {
+				t.Errorf("usage = %d+%d=%d, want %d+%d", final.PromptTokens, final.CompletionTokens, final.TotalTokens, wantPrompt, wantCompletion)
+			}
+			if used := tokensUsed(t, db, userID); used != int64(final.TotalTokens) {
+				t.Errorf("tokens used = %d, want %d", used, final.TotalTokens)
+			}
+
+			saved, err := s.GetChat(chat.ID, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved.Messages) != 2 {
+				t.Fatalf("chat has %d messages, want 2", len(saved.Messages))
+			}
+			prompt, reply := saved.Messages[0], saved.Messages[1]
+			if reply.Role != "assistant" || reply.Content != want || reply.Model != "fake/model" ||
+				reply.Tokens != wantCompletion || reply.ParentID == nil || *reply.ParentID != prompt.ID {
+				t.Errorf("saved reply = %+v", reply)
+			}
+			if reply.ID.String() != final.MessageID {
+				t.Errorf("final chunk names message %s, saved as %s", final.MessageID, reply.ID)
+			}
+			if saved.ActiveLeafID == nil || *saved.ActiveLeafID != reply.ID {
+				t.Errorf("active leaf = %v, want the reply", saved.ActiveLeafID)
+			}
+		})
+	}
+}
+
+func TestSendMessageStructured(t *testing.T) {
+	format := &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &ResponseJSONSchema{Name: "person", Schema: json.RawMessage(personSchema)},
+	}
+
+	tests := []struct {
+		name         string
+		format       *ResponseFormat
+		replies      []string
+		wantContent  string // of the saved reply, none when empty
+		wantErr      string // returned, or in the final chunk
+		wantRequests int
+	}{
+		{
+			name:         "valid reply",
+			format:       format,
+			replies:      []string{`{"name": "Ada", "age": 36}`},
+			wantContent:  `{"name": "Ada", "age": 36}`,
+			wantRequests: 1,
+		},
+		{
+			name:         "re-prompted before saving",
+			format:       format,
+			replies:      []string{`{"name": "Ada"}`, `{"name": "Ada", "age": 36}`},
+			wantContent:  `{"name": "Ada", "age": 36}`,
+			wantRequests: 2,
+		},
+		{
+			name:         "never valid",
+			format:       format,
+			replies:      []string{`{}`, `{}`, `{}`},
+			wantErr:      "did not match the response format",
+			wantRequests: 1 + structuredOutputRetries,
+		},
+		{
+			name:    "invalid response format",
+			format:  &ResponseFormat{Type: "yaml"},
+			wantErr: "invalid response_format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, db := newTestService(t)
+			userID := createTestUser(t, db, 0, 1000)
+			chat, err := s.CreateChat(userID, &CreateChatRequest{Title: "Test", Model: "fake/model", ContextStrategy: ContextStrategyFull})
+			if err != nil {
+				t.Fatalf("CreateChat: %v", err)
+			}
+			fake.Enqueue(tt.replies...)
+
+			chunks, err := s.SendMessage(context.Background(), chat.ID, userID, &SendMessageRequest{
+				Content:        "Who wrote the first program?",
+				ResponseFormat: tt.format,
+			})
+			content := ""
+			if err == nil {
+				var final StreamChunk
+				content, final = collect(t, chunks)
+				if tt.wantErr == "" && (!final.Done || final.TotalTokens == 0) {
+					t.Errorf("final chunk = %+v", final)
+				}
+			}
+
+			if n := len(fake.Requests()); n != tt.wantRequests {
+				t.Fatalf("got %d requests, want %d", n, tt.wantRequests)
+			}
+			if tt.wantErr != "" {
+				if (err == nil || !strings.Contains(err.Error(), tt.wantErr)) && !strings.Contains(content, tt.wantErr) {
+					t.Fatalf("err = %v, streamed %q, want %q", err, content, tt.wantErr)
+				}
+			} else if content != tt.wantContent {
+				t.Errorf("streamed %q, want %q", content, tt.wantContent)
+			}
+			for _, req := range fake.Requests() {
+				if req.Stream || len(req.Tools) > 0 {
+					t.Errorf("structured replies are neither streamed nor offered tools")
+				}
+			}
+
+			saved, err := s.GetChat(chat.ID, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var replies []string
+			for _, msg := range saved.Messages {
+				if msg.Role == "assistant" {
+					replies = append(replies, msg.Content)
+				}
+			}
+			if tt.wantContent == "" && len(replies) > 0 {
+				t.Errorf("saved replies %q, want none", replies)
+			}
+			if tt.wantContent != "" && (len(replies) != 1 || replies[0] != tt.wantContent) {
+				t.Errorf("saved replies %q, want %q", replies, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestRegenerateMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		failWith error
+		wantErr  string
+	}{
+		{name: "adds a sibling reply", reply: "A burrito of computations."},
+		{name: "provider error", failWith: errBadRequest, wantErr: "invalid request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, db := newTestService(t)
+			userID := createTestUser(t, db, 0, 1000)
+			chat, err := s.CreateChat(userID, &CreateChatRequest{Title: "Test", Model: "fake/model", ContextStrategy: ContextStrategyFull})
+			if err != nil {
+				t.Fatalf("CreateChat: %v", err)
+			}
+			fake.Enqueue("A monoid in the category of endofunctors.")
+			chunks, err := s.SendMessage(context.Background(), chat.ID, userID, &SendMessageRequest{Content: "What is a monad?"})
+			if err != nil {
+				t.Fatalf("SendMessage: %v", err)
+			}
+			_, first := collect(t, chunks)
+			firstID := uuid.MustParse(first.MessageID)
+			usedBefore := tokensUsed(t, db, userID)
+
+			fake.Enqueue(tt.reply)
+			fake.FailWith(tt.failWith)
+			chunks, err = s.RegenerateMessage(context.Background(), chat.ID, firstID, userID, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if used := tokensUsed(t, db, userID); used != usedBefore {
+					t.Errorf("tokens used = %d, want %d", used, usedBefore)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RegenerateMessage: %v", err)
+			}
+			content, final := collect(t, chunks)
+			if content != tt.reply {
+				t.Errorf("streamed %q, want %q", content, tt.reply)
+			}
+
+			// The old reply is not part of the new prompt
+			requests := fake.Requests()
+			last := requests[len(requests)-1].Messages
+			if len(last) != 1 || last[0].Content != "What is a monad?" {
+				t.Errorf("regeneration prompt = %+v", last)
+			}
+			if used := tokensUsed(t, db, userID); used != usedBefore+int64(final.TotalTokens) {
+				t.Errorf("tokens used = %d, want %d", used, usedBefore+int64(final.TotalTokens))
+			}
+
+			saved, err := s.GetChat(chat.ID, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved.Messages) != 3 {
+				t.Fatalf("chat has %d messages, want 3", len(saved.Messages))
+			}
+			old, regenerated := findMessage(saved.Messages, firstID), saved.Messages[2]
+			if old == nil || regenerated.Content != tt.reply || *regenerated.ParentID != *old.ParentID {
+				t.Errorf("regenerated reply %+v is not a sibling of %+v", regenerated, old)
+			}
+			if saved.ActiveLeafID == nil || *saved.ActiveLeafID != regenerated.ID {
+				t.Errorf("active leaf = %v, want the regenerated reply", saved.ActiveLeafID)
+			}
+		})
+	}
+
+	t.Run("rejects user messages", func(t *testing.T) {
+		s, fake, db := newTestService(t)
+		userID := createTestUser(t, db, 0, 1000)
+		chat, _ := s.CreateChat(userID, &CreateChatRequest{Title: "Test", Model: "fake/model"})
+		chunks, err := s.SendMessage(context.Background(), chat.ID, userID, &SendMessageRequest{Content: "Hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		collect(t, chunks)
+
+		saved, _ := s.GetChat(chat.ID, userID)
+		if _, err := s.RegenerateMessage(context.Background(), chat.ID, saved.Messages[0].ID, userID, nil); err == nil {
+			t.Error("regenerated a user message")
+		}
+		if len(fake.Requests()) != 1 {
+			t.Errorf("got %d requests, want 1", len(fake.Requests()))
+		}
+	})
+}
+
+func TestCallOpenAI(t *testing.T) {
+	messages := []map[string]interface{}{
+		{"role": "system", "content": "Answer in JSON."},
+		{"role": "user", "content": "Give me a number"},
+	}
+
+	tests := []struct {
+		name         string
+		format       *ResponseFormat
+		replies      []string
+		failWith     error
+		tokensUsed   int64
+		wantContent  string
+		wantErr      string
+		wantRequests int
+	}{
+		{name: "plain reply", replies: []string{"Forty two"}, wantContent: "Forty two", wantRequests: 1},
+		{
+			name:         "structured reply re-prompted",
+			format:       &ResponseFormat{Type: "json_object"},
+			replies:      []string{"42", `{"n": 42}`},
+			wantContent:  `{"n": 42}`,
+			wantRequests: 2,
+		},
+		{name: "provider rejects the request", failWith: errBadRequest, wantErr: "invalid request", wantRequests: 1},
+		{name: "provider unavailable after retries", failWith: errUnavailable, wantErr: "overloaded", wantRequests: 2},
+		{name: "token limit reached", tokensUsed: 1000, wantErr: "token limit exceeded"},
+		{name: "invalid response format", format: &ResponseFormat{Type: "yaml"}, wantErr: "invalid response_format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake, db := newTestService(t)
+			userID := createTestUser(t, db, tt.tokensUsed, 1000)
+			fake.Enqueue(tt.replies...)
+			fake.FailWith(tt.failWith)
+
+			result, err := s.CallOpenAI(userID, "fake/model", messages, tt.format)
+
+			requests := fake.Requests()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("got %d requests, want %d", len(requests), tt.wantRequests)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if used := tokensUsed(t, db, userID); used != tt.tokensUsed {
+					t.Errorf("tokens used = %d, want %d", used, tt.tokensUsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CallOpenAI: %v", err)
+			}
+
+			choices := result["choices"].([]openai.ChatCompletionChoice)
+			if got := choices[0].Message.Content; got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+			if requests[0].Messages[len(requests[0].Messages)-1].Role != openai.ChatMessageRoleUser {
+				t.Errorf("roles not converted: %+v", requests[0].Messages)
+			}
+
+			// Every attempt is charged
+			usage := result["usage"].(openai.Usage)
+			if used := tokensUsed(t, db, userID); used != int64(usage.TotalTokens) || used == 0 {
+				t.Errorf("tokens used = %d, want %d", used, usage.TotalTokens)
+			}
+		})
+	}
+}
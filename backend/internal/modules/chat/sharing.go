@@ -0,0 +1,377 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Share modes
+const (
+	ShareModeSnapshot = "snapshot" // the chat as it was when the link was created
+	ShareModeLive     = "live"     // the chat's current active branch
+)
+
+var (
+	ErrShareNotFound         = errors.New("shared chat not found")
+	ErrShareExpired          = errors.New("share link has expired")
+	ErrSharePasswordRequired = errors.New("password required")
+	ErrShareWrongPassword    = errors.New("wrong password")
+	ErrShareTooManyAttempts  = errors.New("too many wrong passwords, try again later")
+)
+
+// Wrong share passwords are limited per link and per client address, so
+// protected links cannot be brute-forced.
+const (
+	sharePasswordWindow        = 15 * time.Minute
+	maxSharePasswordFailures   = 20 // per link
+	maxSharePasswordFailuresIP = 10 // per client address
+	attemptLimiterMaxKeys      = 10000
+)
+
+// ChatShare is a public read-only link to a chat.
+type ChatShare struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ChatID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"chat_id"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Slug         string     `gorm:"type:varchar(32);not null;uniqueIndex" json:"slug"`
+	Mode         string     `gorm:"type:varchar(20);default:'snapshot'" json:"mode"` // snapshot, live
+	PasswordHash string     `gorm:"type:varchar(255)" json:"-"`
+	Snapshot     string     `gorm:"type:text" json:"-"` // JSON SharedChatResponse for snapshot links
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	ViewCount    int        `gorm:"default:0" json:"view_count"`
+	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+type CreateShareRequest struct {
+	Mode      string     `json:"mode"`                 // snapshot (default) or live
+	Password  string     `json:"password,omitempty"`   // optional
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // optional
+}
+
+type ShareResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	ChatID      uuid.UUID  `json:"chat_id"`
+	Slug        string     `json:"slug"`
+	Mode        string     `json:"mode"`
+	HasPassword bool       `json:"has_password"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	ViewCount   int        `json:"view_count"`
+	URL         string     `json:"url"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// SharedChatResponse is what visitors of a share link see. It leaves out
+// the owner and chat settings.
+type SharedChatResponse struct {
+	Slug     string       `json:"slug"`
+	Mode     string       `json:"mode"`
+	Title    string       `json:"title"`
+	Model    string       `json:"model"`
+	SharedAt time.Time    `json:"shared_at"`
+	Messages []MessageDTO `json:"messages"`
+}
+
+type ForkSharedChatRequest struct {
+	Password string `json:"password,omitempty"`
+}
+
+func (s *ChatShare) ToDTO() *ShareResponse {
+	return &ShareResponse{
+		ID:          s.ID,
+		ChatID:      s.ChatID,
+		Slug:        s.Slug,
+		Mode:        s.Mode,
+		HasPassword: s.PasswordHash != "",
+		ExpiresAt:   s.ExpiresAt,
+		ViewCount:   s.ViewCount,
+		URL:         "/share/" + s.Slug,
+		CreatedAt:   s.CreatedAt,
+	}
+}
+
+func (s *ChatShare) expired() bool {
+	return s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt)
+}
+
+// attemptLimiter counts failures per key over fixed windows.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	window   time.Duration
+	failures map[string]*attemptWindow
+}
+
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+func newAttemptLimiter(window time.Duration) *attemptLimiter {
+	return &attemptLimiter{window: window, failures: make(map[string]*attemptWindow)}
+}
+
+// allowed reports whether key has failed fewer than limit times in its
+// current window.
+func (l *attemptLimiter) allowed(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.failures[key]
+	return !ok || time.Since(w.start) >= l.window || w.count < limit
+}
+
+// fail records a failure of key.
+func (l *attemptLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.failures) >= attemptLimiterMaxKeys {
+		for k, w := range l.failures {
+			if now.Sub(w.start) >= l.window {
+				delete(l.failures, k)
+			}
+		}
+	}
+
+	w, ok := l.failures[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.failures[key] = &attemptWindow{start: now, count: 1}
+		return
+	}
+	w.count++
+}
+
+// checkPassword verifies access to a password-protected share.
+func (s *ChatShare) checkPassword(password string) error {
+	if s.PasswordHash == "" {
+		return nil
+	}
+	if password == "" {
+		return ErrSharePasswordRequired
+	}
+	if bcrypt.CompareHashAndPassword([]byte(s.PasswordHash), []byte(password)) != nil {
+		return ErrShareWrongPassword
+	}
+	return nil
+}
+
+// sharedView renders a chat for visitors. Only the active branch is shown
+// and sibling counts are dropped, since visitors cannot switch branches.
+// Attached images are only served to their owner and are left out.
+func sharedView(share *ChatShare, chat *Chat) *SharedChatResponse {
+	path := chat.ActivePath()
+	messages := make([]MessageDTO, 0, len(path))
+	for _, msg := range path {
+		dto := msg.ToDTO()
+		dto.SiblingCount, dto.SiblingIndex = 1, 0
+		dto.Attachments = nil
+		messages = append(messages, dto)
+	}
+
+	return &SharedChatResponse{
+		Slug:     share.Slug,
+		Mode:     share.Mode,
+		Title:    chat.Title,
+		Model:    chat.Model,
+		SharedAt: share.CreatedAt,
+		Messages: messages,
+	}
+}
+
+// newShareSlug returns a random URL-safe slug of 16 characters.
+func newShareSlug() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateShare creates a share link for one of the user's chats.
+func (s *Service) CreateShare(chatID, userID uuid.UUID, req *CreateShareRequest) (*ChatShare, error) {
+	if req.Mode == "" {
+		req.Mode = ShareModeSnapshot
+	}
+	if req.Mode != ShareModeSnapshot && req.Mode != ShareModeLive {
+		return nil, errors.New("invalid share mode. Allowed: snapshot, live")
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	slug, err := newShareSlug()
+	if err != nil {
+		return nil, err
+	}
+
+	share := &ChatShare{
+		ChatID:    chat.ID,
+		UserID:    userID,
+		Slug:      slug,
+		Mode:      req.Mode,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		share.PasswordHash = string(hash)
+	}
+
+	if share.Mode == ShareModeSnapshot {
+		snapshot, err := json.Marshal(sharedView(share, chat))
+		if err != nil {
+			return nil, err
+		}
+		share.Snapshot = string(snapshot)
+	}
+
+	if err := s.repo.CreateShare(share); err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+func (s *Service) GetChatShares(chatID, userID uuid.UUID) ([]ChatShare, error) {
+	if _, err := s.repo.GetChatByID(chatID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetChatShares(chatID)
+}
+
+func (s *Service) DeleteShare(chatID, shareID, userID uuid.UUID) error {
+	return s.repo.DeleteShare(chatID, shareID, userID)
+}
+
+// GetSharedChat returns the chat behind a share link and counts the view.
+func (s *Service) GetSharedChat(slug, password, clientIP string) (*SharedChatResponse, error) {
+	share, err := s.openShare(slug, password, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := s.sharedChat(share)
+	if err != nil {
+		return nil, err
+	}
+
+	s.repo.IncrementShareViews(share.ID)
+	return view, nil
+}
+
+// ForkSharedChat copies the shared conversation into a new chat owned by
+// userID, keeping message order and timestamps.
+func (s *Service) ForkSharedChat(slug, password, clientIP string, userID uuid.UUID) (*Chat, error) {
+	share, err := s.openShare(slug, password, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := s.sharedChat(share)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := &Chat{
+		ID:              uuid.New(),
+		UserID:          userID,
+		Title:           view.Title,
+		Model:           s.catalog.ModelOrDefault(userID, view.Model),
+		ContextStrategy: ContextStrategySummarize,
+	}
+
+	messages := make([]Message, 0, len(view.Messages))
+	var parentID *uuid.UUID
+	for _, dto := range view.Messages {
+		id := uuid.New()
+		message := Message{
+			ID:         id,
+			ChatID:     chat.ID,
+			ParentID:   parentID,
+			Role:       dto.Role,
+			Content:    dto.Content,
+			ToolCallID: dto.ToolCallID,
+			Tokens:     dto.Tokens,
+			Model:      dto.Model,
+			Status:     dto.Status,
+			Citations:  encodeCitations(dto.Citations),
+			CreatedAt:  dto.CreatedAt,
+		}
+		if len(dto.ToolCalls) > 0 {
+			calls, _ := json.Marshal(dto.ToolCalls)
+			message.ToolCalls = string(calls)
+		}
+		messages = append(messages, message)
+		parentID = &id
+	}
+	chat.ActiveLeafID = parentID
+
+	if err := s.repo.CreateChatWithMessages(chat, messages); err != nil {
+		return nil, err
+	}
+	chat.Messages = messages
+
+	return chat, nil
+}
+
+// openShare finds a live share and checks its password, rejecting clients
+// and links with too many wrong passwords before bcrypt is run.
+func (s *Service) openShare(slug, password, clientIP string) (*ChatShare, error) {
+	share, err := s.repo.GetShareBySlug(slug)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	if share.expired() {
+		return nil, ErrShareExpired
+	}
+	if share.PasswordHash != "" && password != "" {
+		if !s.shareLimits.allowed("slug:"+slug, maxSharePasswordFailures) ||
+			!s.shareLimits.allowed("ip:"+clientIP, maxSharePasswordFailuresIP) {
+			return nil, ErrShareTooManyAttempts
+		}
+	}
+	if err := share.checkPassword(password); err != nil {
+		if errors.Is(err, ErrShareWrongPassword) {
+			s.shareLimits.fail("slug:" + slug)
+			s.shareLimits.fail("ip:" + clientIP)
+		}
+		return nil, err
+	}
+	return share, nil
+}
+
+func (s *Service) sharedChat(share *ChatShare) (*SharedChatResponse, error) {
+	if share.Mode == ShareModeSnapshot {
+		var view SharedChatResponse
+		if err := json.Unmarshal([]byte(share.Snapshot), &view); err != nil {
+			return nil, err
+		}
+		return &view, nil
+	}
+
+	chat, err := s.repo.GetChatByID(share.ChatID, share.UserID)
+	if err != nil {
+		return nil, ErrShareNotFound
+	}
+	return sharedView(share, chat), nil
+}
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"errors"
+	"html/template"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// sharePasswordHeader carries the password of a protected share link on
+// the JSON endpoints.
+const sharePasswordHeader = "X-Share-Password"
+
+func (h *Handler) CreateShare(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	var req CreateShareRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	share, err := h.service.CreateShare(chatID, userID, &req)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(share.ToDTO())
+}
+
+func (h *Handler) GetChatShares(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	shares, err := h.service.GetChatShares(chatID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	dtos := make([]*ShareResponse, len(shares))
+	for i := range shares {
+		dtos[i] = shares[i].ToDTO()
+	}
+
+	return c.JSON(dtos)
+}
+
+func (h *Handler) DeleteShare(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	shareID, err := uuid.Parse(c.Params("shareId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid share ID",
+		})
+	}
+
+	if err := h.service.DeleteShare(chatID, shareID, userID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Share link deleted successfully",
+	})
+}
+
+// GetSharedChat returns a shared chat as JSON. No authentication is needed.
+func (h *Handler) GetSharedChat(c *fiber.Ctx) error {
+	view, err := h.service.GetSharedChat(c.Params("slug"), c.Get(sharePasswordHeader), c.IP())
+	if err != nil {
+		return c.Status(shareErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(view)
+}
+
+// ForkSharedChat copies a shared chat into the caller's account.
+func (h *Handler) ForkSharedChat(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	var req ForkSharedChatRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if req.Password == "" {
+		req.Password = c.Get(sharePasswordHeader)
+	}
+
+	chat, err := h.service.ForkSharedChat(c.Params("slug"), req.Password, c.IP(), userID)
+	if err != nil {
+		return c.Status(shareErrorStatus(err)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(chat.ToDTO())
+}
+
+// ViewSharedChat renders a shared chat as an HTML page for browsers and
+// link previews. Protected links show a password form that posts back to
+// the same URL.
+func (h *Handler) ViewSharedChat(c *fiber.Ctx) error {
+	password := ""
+	if c.Method() == fiber.MethodPost {
+		password = c.FormValue("password")
+	}
+
+	page := sharedPage{}
+	view, err := h.service.GetSharedChat(c.Params("slug"), password, c.IP())
+	switch {
+	case err == nil:
+		page.Chat = view
+	case errors.Is(err, ErrSharePasswordRequired):
+		page.NeedsPassword = true
+	case errors.Is(err, ErrShareWrongPassword):
+		page.NeedsPassword = true
+		page.Error = "Wrong password, please try again."
+	default:
+		page.Error = sharedPageError(err)
+	}
+
+	status := fiber.StatusOK
+	if err != nil && !errors.Is(err, ErrSharePasswordRequired) {
+		status = shareErrorStatus(err)
+	}
+
+	var b strings.Builder
+	if err := sharedPageTemplate.Execute(&b, page); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to render page")
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set("X-Robots-Tag", "noindex")
+	return c.Status(status).SendString(b.String())
+}
+
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrShareNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, ErrShareExpired):
+		return fiber.StatusGone
+	case errors.Is(err, ErrSharePasswordRequired), errors.Is(err, ErrShareWrongPassword):
+		return fiber.StatusUnauthorized
+	case errors.Is(err, ErrShareTooManyAttempts):
+		return fiber.StatusTooManyRequests
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func sharedPageError(err error) string {
+	switch {
+	case errors.Is(err, ErrShareNotFound):
+		return "This shared chat does not exist or was removed."
+	case errors.Is(err, ErrShareExpired):
+		return "This share link has expired."
+	case errors.Is(err, ErrShareTooManyAttempts):
+		return "Too many wrong passwords, please try again later."
+	default:
+		return "Something went wrong while loading this chat."
+	}
+}
+
+type sharedPage struct {
+	Chat          *SharedChatResponse
+	NeedsPassword bool
+	Error         string
+}
+
+var sharedPageTemplate = template.Must(template.New("shared").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{if .Chat}}{{.Chat.Title}} - {{end}}Kintsugi AI</title>
+{{if .Chat}}<meta property="og:title" content="{{.Chat.Title}}">
+<meta property="og:description" content="A conversation shared from Kintsugi AI">{{end}}
+<style>
+body{font-family:system-ui,-apple-system,sans-serif;max-width:760px;margin:0 auto;padding:24px 16px;color:#1f2328;background:#fafafa}
+h1{font-size:1.4rem;margin:0 0 4px}
+.meta{color:#6e7781;font-size:.85rem;margin-bottom:24px}
+.msg{padding:12px 16px;border-radius:10px;margin:12px 0;white-space:pre-wrap;word-wrap:break-word;line-height:1.5}
+.user{background:#e7f0ff}
+.assistant{background:#fff;border:1px solid #e5e7eb}
+.role{font-size:.75rem;font-weight:600;text-transform:uppercase;color:#6e7781;margin-bottom:4px}
+.error{color:#b42318;margin:12px 0}
+form{margin-top:16px}
+input{padding:8px;border:1px solid #d0d7de;border-radius:6px}
+button{padding:8px 14px;border:0;border-radius:6px;background:#1f6feb;color:#fff;cursor:pointer}
+</style>
+</head>
+<body>
+{{if .Chat}}
+<h1>{{.Chat.Title}}</h1>
+<div class="meta">{{.Chat.Model}} &middot; shared {{.Chat.SharedAt.Format "Jan 2, 2006"}}</div>
+{{range .Chat.Messages}}{{if or (eq .Role "user") (eq .Role "assistant")}}{{if .Content}}
+<div class="msg {{.Role}}"><div class="role">{{.Role}}</div>{{.Content}}</div>
+{{end}}{{end}}{{end}}
+{{else}}
+<h1>Shared chat</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .NeedsPassword}}
+<form method="post">
+<p>This chat is password protected.</p>
+<input type="password" name="password" placeholder="Password" autofocus required>
+<button type="submit">View chat</button>
+</form>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
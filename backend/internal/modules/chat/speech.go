@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// speechVoicePreference is the user preference holding the voice replies
+// are read in.
+const speechVoicePreference = "tts_voice"
+
+var ErrSpeechDisabled = errors.New("text-to-speech is not configured")
+
+// speechFileExts maps the audio types synthesizers produce to the file
+// extension of cached files.
+var speechFileExts = map[string]string{
+	"audio/mpeg": ".mp3",
+	"audio/wav":  ".wav",
+	"audio/ogg":  ".ogg",
+	"audio/aac":  ".aac",
+	"audio/flac": ".flac",
+}
+
+// Speech is an assistant reply read aloud.
+type Speech struct {
+	MessageID   uuid.UUID
+	Voice       string
+	ContentType string
+	Data        []byte
+	Characters  int  // characters synthesized, 0 when served from the cache
+	Cached      bool // served from the cache
+}
+
+type SpeechVoicesResponse struct {
+	Voices   []string `json:"voices"`
+	Selected string   `json:"selected"` // the user's preference, or the default
+}
+
+var (
+	speechCodeBlock  = regexp.MustCompile("(?s)```.*?(```|$)")
+	speechInlineCode = regexp.MustCompile("`([^`]*)`")
+	speechImage      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	speechLink       = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	speechLineMarker = regexp.MustCompile(`(?m)^[ \t]*(#{1,6}|>+|[-*+])[ \t]+`)
+	speechTableEdge  = regexp.MustCompile(`(?m)^[ \t]*\|[ \t]*|[ \t]*\|[ \t]*$`)
+	speechEmphasis   = regexp.MustCompile(`(\*\*|__|~~|\*)`)
+	speechTableRule  = regexp.MustCompile(`(?m)^[ \t|:-]*-[ \t|:-]*$`)
+	speechBlankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// speechText turns a Markdown reply into the text to read aloud: code
+// blocks are skipped, and links, images and formatting are reduced to
+// their text.
+func speechText(content string) string {
+	text := speechCodeBlock.ReplaceAllString(content, "")
+	text = speechInlineCode.ReplaceAllString(text, "$1")
+	text = speechImage.ReplaceAllString(text, "$1")
+	text = speechLink.ReplaceAllString(text, "$1")
+	text = speechTableRule.ReplaceAllString(text, "")
+	text = speechLineMarker.ReplaceAllString(text, "")
+	text = speechTableEdge.ReplaceAllString(text, "")
+	text = speechEmphasis.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, " | ", ", ")
+	text = speechBlankLines.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
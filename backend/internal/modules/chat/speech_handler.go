@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+type SpeechHandler struct {
+	service     *SpeechService
+	chatService *Service
+}
+
+func NewSpeechHandler(service *SpeechService, chatService *Service) *SpeechHandler {
+	return &SpeechHandler{service: service, chatService: chatService}
+}
+
+// GetVoices lists the voices replies can be read in and the one selected
+// in the user's preferences.
+func (h *SpeechHandler) GetVoices(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+
+	voices, err := h.service.Voices(userID)
+	if err != nil {
+		return speechError(c, err)
+	}
+
+	return c.JSON(voices)
+}
+
+// GetMessageSpeech serves an assistant reply as audio, in ?voice= or the
+// user's preferred voice. Range requests are supported for seeking.
+func (h *SpeechHandler) GetMessageSpeech(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("chatId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+	messageID, err := uuid.Parse(c.Params("messageId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid message ID",
+		})
+	}
+
+	speech, err := h.chatService.MessageSpeech(c.Context(), userID, chatID, messageID, c.Query("voice"))
+	if err != nil {
+		return speechError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, speech.ContentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	c.Set("X-Speech-Voice", speech.Voice)
+	c.Set("X-Speech-Characters", fmt.Sprint(speech.Characters))
+	return sendRange(c, speech.Data)
+}
+
+// sendRange sends data, or the single byte range the request asks for.
+func sendRange(c *fiber.Ctx, data []byte) error {
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	header := c.Get(fiber.HeaderRange)
+	if header == "" {
+		return c.Send(data)
+	}
+
+	start, end, err := fasthttp.ParseByteRange([]byte(header), len(data))
+	if err != nil {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", len(data)))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+	return c.Status(fiber.StatusPartialContent).Send(data[start : end+1])
+}
+
+func speechError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	if errors.Is(err, ErrSpeechDisabled) {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
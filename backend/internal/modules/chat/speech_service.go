@@ -0,0 +1,205 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/storage"
+)
+
+// SpeechService reads assistant replies aloud and caches the audio in
+// storage, one file per message and voice.
+type SpeechService struct {
+	db          *gorm.DB
+	storage     storage.Storage
+	synthesizer llm.Synthesizer
+
+	// inflight lets concurrent requests for the same audio share one
+	// synthesis, and one charge
+	inflight singleflight.Group
+}
+
+func NewSpeechService(db *gorm.DB, store storage.Storage, synthesizer llm.Synthesizer) *SpeechService {
+	return &SpeechService{
+		db:          db,
+		storage:     store,
+		synthesizer: synthesizer,
+	}
+}
+
+// Voices lists the voices offered with the user's preferred one, falling
+// back to the provider's default.
+func (s *SpeechService) Voices(userID uuid.UUID) (*SpeechVoicesResponse, error) {
+	if s.synthesizer == nil {
+		return nil, ErrSpeechDisabled
+	}
+
+	voice, err := s.preferredVoice(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &SpeechVoicesResponse{
+		Voices:   s.synthesizer.Voices(),
+		Selected: voice,
+	}, nil
+}
+
+// resolveVoice checks a requested voice, or picks the user's preference
+// when none is given.
+func (s *SpeechService) resolveVoice(userID uuid.UUID, voice string) (string, error) {
+	if voice == "" {
+		return s.preferredVoice(userID)
+	}
+	if !slices.Contains(s.synthesizer.Voices(), voice) {
+		return "", fmt.Errorf("unknown voice %q", voice)
+	}
+	return voice, nil
+}
+
+// preferredVoice returns the voice in the user's preferences if the
+// provider offers it, otherwise the provider's default.
+func (s *SpeechService) preferredVoice(userID uuid.UUID) (string, error) {
+	var preferences string
+	err := s.db.Table("users").
+		Where("id = ?", userID).
+		Select("preferences").
+		Scan(&preferences).Error
+	if err != nil {
+		return "", err
+	}
+
+	voices := s.synthesizer.Voices()
+	var values map[string]interface{}
+	if json.Unmarshal([]byte(preferences), &values) == nil {
+		if voice, ok := values[speechVoicePreference].(string); ok && slices.Contains(voices, voice) {
+			return voice, nil
+		}
+	}
+	return voices[0], nil
+}
+
+// getMessage loads a message of one of the user's chats.
+func (s *SpeechService) getMessage(chatID, messageID, userID uuid.UUID) (*Message, error) {
+	var message Message
+	err := s.db.Joins("JOIN chats ON chats.id = messages.chat_id AND chats.deleted_at IS NULL").
+		Where("messages.id = ? AND messages.chat_id = ? AND chats.user_id = ?", messageID, chatID, userID).
+		First(&message).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (s *SpeechService) cacheKey(userID, messageID uuid.UUID, voice string) string {
+	return fmt.Sprintf("speech/%s/%s/%s%s", userID, messageID, voice, speechFileExts[s.synthesizer.ContentType()])
+}
+
+// cached returns the stored audio for key, or nil if there is none.
+func (s *SpeechService) cached(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Service methods for speech
+
+func (s *Service) SetSpeech(speech *SpeechService) {
+	s.speech = speech
+}
+
+// MessageSpeech returns an assistant reply read in voice, or in the user's
+// preferred voice when voice is empty. Audio is synthesized once per
+// message and voice; each synthesized character counts as one token
+// against the user's quota, replays from the cache are free.
+func (s *Service) MessageSpeech(ctx context.Context, userID, chatID, messageID uuid.UUID, voice string) (*Speech, error) {
+	if s.speech == nil || s.speech.synthesizer == nil || s.speech.storage == nil {
+		return nil, ErrSpeechDisabled
+	}
+
+	message, err := s.speech.getMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Role != "assistant" {
+		return nil, errors.New("only assistant replies can be read aloud")
+	}
+
+	voice, err = s.speech.resolveVoice(userID, voice)
+	if err != nil {
+		return nil, err
+	}
+
+	speech := &Speech{
+		MessageID:   message.ID,
+		Voice:       voice,
+		ContentType: s.speech.synthesizer.ContentType(),
+	}
+	key := s.speech.cacheKey(userID, message.ID, voice)
+
+	if speech.Data, err = s.speech.cached(ctx, key); err != nil {
+		return nil, err
+	}
+	if speech.Data != nil {
+		speech.Cached = true
+		return speech, nil
+	}
+
+	text := speechText(message.Content)
+	if text == "" {
+		return nil, errors.New("the reply has no text to read aloud")
+	}
+	characters := utf8.RuneCountInString(text)
+
+	hasCapacity, tokensUsed, tokensLimit, err := s.CheckTokenLimit(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCapacity {
+		return nil, errors.New("token limit exceeded")
+	}
+	projectedTokens := tokensUsed + int64(characters)
+	if tokensLimit != -1 && projectedTokens > tokensLimit {
+		return nil, fmt.Errorf("not enough tokens to read this reply aloud (%d/%d)", projectedTokens, tokensLimit)
+	}
+
+	result, err, _ := s.speech.inflight.Do(key, func() (interface{}, error) {
+		data, err := s.speech.synthesizer.Synthesize(ctx, text, voice)
+		if err != nil {
+			return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+		}
+		if err := s.speech.storage.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to store speech: %w", err)
+		}
+		if err := s.addTokenUsage(userID, characters); err != nil {
+			return nil, err
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	speech.Data = result.([]byte)
+	speech.Characters = characters
+	return speech, nil
+}
@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/jsonschema"
+)
+
+// structuredOutputRetries is how often a reply that does not follow the
+// response format is sent back to the model with the errors before the
+// request fails.
+const structuredOutputRetries = 2
+
+var ErrInvalidResponseFormat = errors.New("invalid response_format")
+
+// ResponseFormat asks for a JSON reply, optionally following a schema, as
+// in OpenAI's response_format. The schema is kept as sent: go-openai drops
+// the keywords its own schema type does not know.
+type ResponseFormat struct {
+	Type       string              `json:"type"` // text, json_object or json_schema
+	JSONSchema *ResponseJSONSchema `json:"json_schema,omitempty"`
+}
+
+type ResponseJSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict"`
+}
+
+// StructuredOutputError is returned when no attempt produced a reply that
+// follows the response format.
+type StructuredOutputError struct {
+	Attempts int
+	Errors   []jsonschema.ValidationError // of the last reply
+	Content  string                       // the last reply
+}
+
+func (e *StructuredOutputError) Error() string {
+	shown := e.Errors
+	if len(shown) > 3 {
+		shown = shown[:3]
+	}
+	messages := make([]string, len(shown))
+	for i, err := range shown {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("the reply did not match the response format after %d attempts: %s", e.Attempts, strings.Join(messages, "; "))
+}
+
+// structuredOutput checks replies against a response format.
+type structuredOutput struct {
+	format *ResponseFormat
+	schema *jsonschema.Schema // nil for json_object
+}
+
+// newStructuredOutput compiles a response format, or returns nil when the
+// reply may be any text.
+func newStructuredOutput(format *ResponseFormat) (*structuredOutput, error) {
+	if format == nil {
+		return nil, nil
+	}
+
+	switch format.Type {
+	case "", string(openai.ChatCompletionResponseFormatTypeText):
+		return nil, nil
+	case string(openai.ChatCompletionResponseFormatTypeJSONObject):
+		return &structuredOutput{format: format}, nil
+	case string(openai.ChatCompletionResponseFormatTypeJSONSchema):
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidResponseFormat, format.Type)
+	}
+
+	if format.JSONSchema == nil || len(format.JSONSchema.Schema) == 0 {
+		return nil, fmt.Errorf("%w: json_schema.schema is required", ErrInvalidResponseFormat)
+	}
+	if format.JSONSchema.Name == "" {
+		return nil, fmt.Errorf("%w: json_schema.name is required", ErrInvalidResponseFormat)
+	}
+	schema, err := jsonschema.Compile(format.JSONSchema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponseFormat, err)
+	}
+	return &structuredOutput{format: format, schema: schema}, nil
+}
+
+// native returns the format for models that take it in the request.
+func (o *structuredOutput) native() *openai.ChatCompletionResponseFormat {
+	if o.schema == nil {
+		return &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:        o.format.JSONSchema.Name,
+			Description: o.format.JSONSchema.Description,
+			Schema:      o.format.JSONSchema.Schema,
+			Strict:      o.format.JSONSchema.Strict,
+		},
+	}
+}
+
+// instructions describes the format in a system prompt, for models that
+// cannot take it in the request. OpenAI also wants JSON mentioned in the
+// messages when asked for a JSON object.
+func (o *structuredOutput) instructions() string {
+	if o.schema == nil {
+		return "Reply with only a JSON object, without Markdown code fences or any other text."
+	}
+
+	var b strings.Builder
+	b.WriteString("Reply with only a JSON value that matches the following JSON Schema, without Markdown code fences or any other text.\n\n")
+	fmt.Fprintf(&b, "Schema %q", o.format.JSONSchema.Name)
+	if o.format.JSONSchema.Description != "" {
+		fmt.Fprintf(&b, " (%s)", o.format.JSONSchema.Description)
+	}
+	b.WriteString(":\n")
+	b.Write(o.format.JSONSchema.Schema)
+	return b.String()
+}
+
+// check returns the JSON of a reply, without code fences some models wrap
+// it in, and the ways it breaks the format.
+func (o *structuredOutput) check(content string) (string, []jsonschema.ValidationError) {
+	content = stripCodeFence(content)
+
+	if o.schema != nil {
+		return content, o.schema.ValidateJSON([]byte(content))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return content, []jsonschema.ValidationError{{Message: fmt.Sprintf("not valid JSON: %v", err)}}
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return content, []jsonschema.ValidationError{{Message: "expected a JSON object"}}
+	}
+	return content, nil
+}
+
+// correction asks the model to fix a reply.
+func (o *structuredOutput) correction(errs []jsonschema.ValidationError) string {
+	var b strings.Builder
+	b.WriteString("Your reply does not match the required format:\n")
+	for _, err := range errs {
+		fmt.Fprintf(&b, "- %s\n", err.Error())
+	}
+	b.WriteString("\nReply again with only the corrected JSON.")
+	return b.String()
+}
+
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	if i := strings.IndexByte(content, '\n'); i >= 0 {
+		content = content[i+1:]
+	} else {
+		content = strings.TrimPrefix(content, "```")
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
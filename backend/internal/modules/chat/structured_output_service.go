@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// Service methods for structured output
+
+// supportsJSONSchema reports whether a model takes response_format
+// json_schema natively. Models not in the catalog are told the format in
+// the prompt instead.
+func (s *Service) supportsJSONSchema(model string) bool {
+	if s.catalog == nil {
+		return false
+	}
+	entry, err := s.catalog.GetModel(model)
+	return err == nil && entry.SupportsJSONSchema
+}
+
+// structuredChain drops the fallbacks of a model chain that cannot take the
+// response format the first model is sent.
+func (s *Service) structuredChain(models []string) []string {
+	chain := models[:1]
+	for _, model := range models[1:] {
+		if s.supportsJSONSchema(model) {
+			chain = append(chain, model)
+		}
+	}
+	return chain
+}
+
+// completeStructured runs a completion whose reply must follow output. The
+// format is forwarded to models that support it and described in the
+// prompt for the others; either way the reply is validated here. A reply
+// that does not validate is sent back with the errors, up to
+// structuredOutputRetries times, before failing with a
+// *StructuredOutputError.
+//
+// The returned usage covers every attempt and is valid on error too, so
+// the caller can charge it.
+func (s *Service) completeStructured(ctx context.Context, models []string, req openai.ChatCompletionRequest, output *structuredOutput) (openai.ChatCompletionResponse, string, openai.Usage, error) {
+	var total openai.Usage
+	if req.N > 1 {
+		return openai.ChatCompletionResponse{}, "", total, fmt.Errorf("%w: structured output supports a single choice", ErrInvalidResponseFormat)
+	}
+
+	native := s.supportsJSONSchema(models[0])
+	if native {
+		models = s.structuredChain(models)
+		req.ResponseFormat = output.native()
+	} else {
+		req.ResponseFormat = nil
+	}
+	if !native || output.schema == nil {
+		req.Messages = append([]openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: output.instructions(),
+		}}, req.Messages...)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, usedModel, err := s.providers.CreateChatCompletion(ctx, models, req)
+		if err != nil {
+			return resp, usedModel, total, err
+		}
+
+		content := ""
+		if len(resp.Choices) > 0 {
+			content = resp.Choices[0].Message.Content
+		}
+		usage := resp.Usage
+		if usage.TotalTokens == 0 {
+			usage = resolveUsage(nil, usedModel, tokenizer.CountMessages(usedModel, req.Messages), content)
+		}
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.TotalTokens += usage.TotalTokens
+
+		cleaned, errs := output.check(content)
+		if len(errs) == 0 {
+			resp.Choices[0].Message.Content = cleaned
+			resp.Usage = total
+			return resp, usedModel, total, nil
+		}
+		if attempt > structuredOutputRetries {
+			return resp, usedModel, total, &StructuredOutputError{
+				Attempts: attempt,
+				Errors:   errs,
+				Content:  content,
+			}
+		}
+
+		req.Messages = append(req.Messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: output.correction(errs)},
+		)
+	}
+}
+
+// structuredReply answers in a chat with a reply that follows output. The
+// reply must validate before it is shown, so it is generated with
+// completeStructured and sent as a single chunk, and no tools are offered.
+// The final chunk and the saved message match those of streamReply.
+func (s *Service) structuredReply(ctx context.Context, gen *generation, chat *Chat, userID uuid.UUID, parentID, messageID uuid.UUID, models []string, messages []openai.ChatCompletionMessage, output *structuredOutput, citations []Citation, autoTitle bool) <-chan StreamChunk {
+	chunkChan := make(chan StreamChunk)
+	send := func(chunk StreamChunk) {
+		select {
+		case chunkChan <- chunk:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(chunkChan)
+		defer s.generations.finish(gen)
+
+		req := openai.ChatCompletionRequest{Messages: messages}
+		resp, usedModel, usage, err := s.completeStructured(ctx, models, req, output)
+		s.addTokenUsage(userID, usage.TotalTokens)
+
+		if err != nil && ctx.Err() != nil {
+			// Stopped - nothing was shown, so nothing is kept
+			select {
+			case chunkChan <- StreamChunk{
+				Type:             ChunkTypeContent,
+				MessageID:        messageID.String(),
+				Done:             true,
+				Stopped:          true,
+				PromptTokens:     usage.PromptTokens,
+				CompletionTokens: usage.CompletionTokens,
+				TotalTokens:      usage.TotalTokens,
+			}:
+			case <-time.After(stoppedChunkTimeout):
+			}
+			return
+		}
+		if err != nil {
+			send(StreamChunk{
+				Type:      ChunkTypeContent,
+				Delta:     fmt.Sprintf("Error: %v", err),
+				MessageID: messageID.String(),
+				Done:      true,
+			})
+			return
+		}
+
+		assistantMessage := &Message{
+			ID:        messageID,
+			ChatID:    chat.ID,
+			ParentID:  &parentID,
+			Role:      "assistant",
+			Content:   resp.Choices[0].Message.Content,
+			Tokens:    usage.CompletionTokens,
+			Model:     usedModel,
+			Status:    MessageStatusCompleted,
+			Citations: encodeCitations(citations),
+		}
+		blocked := s.moderateReply(ctx, userID, assistantMessage)
+		s.repo.CreateMessage(assistantMessage)
+		s.repo.SetActiveLeaf(chat.ID, messageID)
+		if autoTitle {
+			go s.autoTitle(chat.ID, userID)
+		}
+
+		if !blocked {
+			send(StreamChunk{
+				Type:      ChunkTypeContent,
+				Delta:     assistantMessage.Content,
+				MessageID: messageID.String(),
+			})
+		}
+		send(StreamChunk{
+			Type:             ChunkTypeContent,
+			MessageID:        messageID.String(),
+			Done:             true,
+			Model:            usedModel,
+			Blocked:          blocked,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+			Citations:        citations,
+		})
+	}()
+
+	return chunkChan
+}
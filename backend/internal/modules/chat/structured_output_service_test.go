@@ -0,0 +1,191 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string"},
+		"age": {"type": "integer", "minimum": 0}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false
+}`
+
+func TestCompleteStructured(t *testing.T) {
+	schemaFormat := &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &ResponseJSONSchema{Name: "person", Schema: json.RawMessage(personSchema)},
+	}
+	objectFormat := &ResponseFormat{Type: "json_object"}
+
+	tests := []struct {
+		name         string
+		format       *ResponseFormat
+		n            int
+		replies      []string
+		failWith     error
+		wantContent  string
+		wantRequests int
+		wantErr      error // matched with errors.Is or by message, or by type for *StructuredOutputError
+		wantAttempts int
+	}{
+		{
+			name:         "valid first time",
+			format:       schemaFormat,
+			replies:      []string{`{"name": "Ada", "age": 36}`},
+			wantContent:  `{"name": "Ada", "age": 36}`,
+			wantRequests: 1,
+		},
+		{
+			name:         "code fence stripped",
+			format:       schemaFormat,
+			replies:      []string{"```json\n{\"name\": \"Ada\", \"age\": 36}\n```"},
+			wantContent:  `{"name": "Ada", "age": 36}`,
+			wantRequests: 1,
+		},
+		{
+			name:         "re-prompted once",
+			format:       schemaFormat,
+			replies:      []string{`{"name": "Ada"}`, `{"name": "Ada", "age": 36}`},
+			wantContent:  `{"name": "Ada", "age": 36}`,
+			wantRequests: 2,
+		},
+		{
+			name:         "re-prompted after invalid JSON",
+			format:       schemaFormat,
+			replies:      []string{`Sure! Here it is`, `{"name": "Ada", "age": -1}`, `{"name": "Ada", "age": 1}`},
+			wantContent:  `{"name": "Ada", "age": 1}`,
+			wantRequests: 3,
+		},
+		{
+			name:         "gives up after retries",
+			format:       schemaFormat,
+			replies:      []string{`{}`, `{}`, `{}`, `{"name": "Ada", "age": 36}`},
+			wantRequests: 1 + structuredOutputRetries,
+			wantErr:      &StructuredOutputError{},
+			wantAttempts: 1 + structuredOutputRetries,
+		},
+		{
+			name:         "json_object needs an object",
+			format:       objectFormat,
+			replies:      []string{`[1, 2]`, `{"a": 1}`},
+			wantContent:  `{"a": 1}`,
+			wantRequests: 2,
+		},
+		{
+			name:         "provider error",
+			format:       schemaFormat,
+			failWith:     &openai.APIError{HTTPStatusCode: 400, Message: "bad request"},
+			wantRequests: 1,
+			wantErr:      errors.New("bad request"),
+		},
+		{
+			name:    "several choices",
+			format:  schemaFormat,
+			n:       2,
+			wantErr: ErrInvalidResponseFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := llm.NewFakeProvider()
+			fake.Enqueue(tt.replies...)
+			fake.FailWith(tt.failWith)
+			registry := llm.NewRegistry()
+			registry.Register(fake, "fake/")
+			s := NewServiceWithProviders(nil, nil, registry)
+
+			output, err := newStructuredOutput(tt.format)
+			if err != nil {
+				t.Fatalf("newStructuredOutput: %v", err)
+			}
+			req := openai.ChatCompletionRequest{
+				N: tt.n,
+				Messages: []openai.ChatCompletionMessage{
+					{Role: openai.ChatMessageRoleUser, Content: "Who wrote the first program?"},
+				},
+			}
+
+			resp, used, usage, err := s.completeStructured(context.Background(), []string{"fake/model"}, req, output)
+
+			requests := fake.Requests()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("got %d requests, want %d", len(requests), tt.wantRequests)
+			}
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if used != "fake/model" {
+					t.Errorf("used model = %q", used)
+				}
+				if got := resp.Choices[0].Message.Content; got != tt.wantContent {
+					t.Errorf("content = %q, want %q", got, tt.wantContent)
+				}
+			case *StructuredOutputError:
+				var outputErr *StructuredOutputError
+				if !errors.As(err, &outputErr) {
+					t.Fatalf("err = %v, want a StructuredOutputError", err)
+				}
+				if outputErr.Attempts != tt.wantAttempts || len(outputErr.Errors) == 0 {
+					t.Errorf("attempts = %d with %d errors, want %d attempts", outputErr.Attempts, len(outputErr.Errors), tt.wantAttempts)
+				}
+			default:
+				if err == nil || (!errors.Is(err, want) && !strings.Contains(err.Error(), want.Error())) {
+					t.Fatalf("err = %v, want %v", err, want)
+				}
+			}
+
+			// Every attempt is charged, on error too. The fake provider counts
+			// words as tokens.
+			wantUsage := 0
+			for i, r := range requests {
+				if r.ResponseFormat != nil {
+					t.Errorf("response_format sent to a model without native support")
+				}
+				if r.Messages[0].Role != openai.ChatMessageRoleSystem {
+					t.Errorf("format instructions missing from the prompt")
+				}
+				if tt.failWith != nil {
+					continue
+				}
+				for _, msg := range r.Messages {
+					wantUsage += len(strings.Fields(msg.Content))
+				}
+				wantUsage += len(strings.Fields(tt.replies[i]))
+			}
+			if usage.TotalTokens != wantUsage {
+				t.Errorf("usage = %d tokens, want %d", usage.TotalTokens, wantUsage)
+			}
+
+			// Each re-prompt carries the rejected reply and the errors
+			for i := 1; i < len(requests); i++ {
+				messages := requests[i].Messages
+				if got, want := len(messages), len(requests[i-1].Messages)+2; got != want {
+					t.Fatalf("request %d has %d messages, want %d", i+1, got, want)
+				}
+				rejected, correction := messages[len(messages)-2], messages[len(messages)-1]
+				if rejected.Role != openai.ChatMessageRoleAssistant || rejected.Content != tt.replies[i-1] {
+					t.Errorf("request %d does not repeat the rejected reply: %+v", i+1, rejected)
+				}
+				if correction.Role != openai.ChatMessageRoleUser || !strings.HasPrefix(correction.Content, "Your reply does not match the required format:") {
+					t.Errorf("request %d does not carry the correction: %+v", i+1, correction)
+				}
+			}
+		})
+	}
+}
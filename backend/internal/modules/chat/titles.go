@@ -0,0 +1,203 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+// Chats are titled and summarized in the background after their first
+// reply. Titles set by the user are never replaced automatically.
+
+// Title sources
+const (
+	TitleSourceUser = "user"
+	TitleSourceAuto = "auto"
+)
+
+const (
+	defaultTitleModel = "gpt-4o-mini"
+	titleTimeout      = time.Minute
+	maxTitleLength    = 80
+
+	// maxTitleTranscript bounds the characters of the conversation sent to
+	// the title model; long chats are represented by their first message
+	// and their latest ones.
+	maxTitleTranscript = 12000
+
+	titleInstructions = "You title and summarize conversations between a user and an AI assistant. " +
+		"On the first line write a concise title of at most six words, without quotes or a trailing period. " +
+		"On the following lines write a one-paragraph summary of what the conversation is about and what was concluded. " +
+		"Write in the conversation's language. Do not add labels."
+)
+
+// GenerateTitleRequest re-runs titling. Titles set by the user are kept
+// unless OverwriteTitle is set; the summary is always replaced.
+type GenerateTitleRequest struct {
+	OverwriteTitle bool `json:"overwrite_title"`
+}
+
+// autoTitle titles a chat that has none yet, after a reply was saved.
+func (s *Service) autoTitle(chatID, userID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil || chat.TitleSource != "" {
+		return
+	}
+	if _, err := s.generateTitle(ctx, chat, userID, false); err != nil {
+		log.Printf("Failed to title chat %s: %v", chatID, err)
+	}
+}
+
+// GenerateTitle titles and summarizes a chat on request.
+func (s *Service) GenerateTitle(ctx context.Context, chatID, userID uuid.UUID, req *GenerateTitleRequest) (*Chat, error) {
+	chat, err := s.repo.GetChatByID(chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.generateTitle(ctx, chat, userID, req.OverwriteTitle)
+}
+
+// titleModel returns CHAT_TITLE_MODEL, or the cheapest catalog model when
+// the user may not use it.
+func (s *Service) titleModel(userID uuid.UUID) (string, error) {
+	model := os.Getenv("CHAT_TITLE_MODEL")
+	if model == "" {
+		model = defaultTitleModel
+	}
+	err := s.checkModel(userID, model)
+	if err == nil {
+		return model, nil
+	}
+	if cheapest, cheapestErr := s.catalog.CheapestModel(userID); cheapestErr == nil {
+		return cheapest, nil
+	}
+	return "", err
+}
+
+// generateTitle asks the title model for a title and a summary of the chat's
+// active branch and stores them, charging the user for the call. The title
+// is only stored when the user has not set one, checked again on write in
+// case they rename the chat meanwhile.
+func (s *Service) generateTitle(ctx context.Context, chat *Chat, userID uuid.UUID, overwrite bool) (*Chat, error) {
+	transcript := titleTranscript(chat.ActivePath())
+	if transcript == "" {
+		return nil, errors.New("chat has no messages to title")
+	}
+	if err := s.ensureTokenCapacity(userID); err != nil {
+		return nil, err
+	}
+
+	model, err := s.titleModel(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	req := openai.ChatCompletionRequest{
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: titleInstructions},
+			{Role: openai.ChatMessageRoleUser, Content: transcript},
+		},
+	}
+
+	resp, model, err := s.providers.CreateChatCompletion(ctx, s.modelChain(userID, model), req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("title model returned an empty response")
+	}
+
+	content := resp.Choices[0].Message.Content
+	usage := resolveUsage(&resp.Usage, model, tokenizer.CountMessages(model, req.Messages), content)
+	s.addTokenUsage(userID, usage.TotalTokens)
+
+	title, summary := parseTitleReply(content)
+	if title == "" {
+		return nil, errors.New("title model returned an empty response")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Chat{}).
+			Where("id = ?", chat.ID).
+			UpdateColumn("summary", summary).Error; err != nil {
+			return err
+		}
+		chat.Summary = summary
+
+		query := tx.Model(&Chat{}).Where("id = ?", chat.ID)
+		if !overwrite {
+			query = query.Where("title_source IS NULL OR title_source <> ?", TitleSourceUser)
+		}
+		result := query.Updates(map[string]interface{}{
+			"title":        title,
+			"title_source": TitleSourceAuto,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			chat.Title = title
+			chat.TitleSource = TitleSourceAuto
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// titleTranscript renders the user and assistant messages of a branch,
+// keeping the first message and as many of the latest as fit.
+func titleTranscript(path []Message) string {
+	var lines []string
+	for _, msg := range path {
+		if (msg.Role == "user" || msg.Role == "assistant") && strings.TrimSpace(msg.Content) != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", msg.Role, truncateRunes(msg.Content, maxTitleTranscript/4)))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	size := len(lines[0])
+	start := len(lines)
+	for start > 1 && size+len(lines[start-1]) <= maxTitleTranscript {
+		start--
+		size += len(lines[start])
+	}
+
+	if start > 1 {
+		lines = append([]string{lines[0], "..."}, lines[start:]...)
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// parseTitleReply splits the model's reply into the title on its first line
+// and the summary on the rest.
+func parseTitleReply(reply string) (string, string) {
+	title, summary, _ := strings.Cut(strings.TrimSpace(reply), "\n")
+
+	title = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(title), "Title:"))
+	title = strings.Trim(title, "\"'*# ")
+	title = strings.TrimSuffix(title, ".")
+	if len([]rune(title)) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+	}
+
+	summary = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(summary), "Summary:"))
+	return title, summary
+}
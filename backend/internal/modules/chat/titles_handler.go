@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GenerateTitle re-runs titling and summarizing of a chat. The body is
+// optional, see GenerateTitleRequest.
+func (h *Handler) GenerateTitle(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	chatID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid chat ID",
+		})
+	}
+
+	var req GenerateTitleRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	chat, err := h.service.GenerateTitle(c.Context(), chatID, userID, &req)
+	if err != nil {
+		return c.Status(modelErrorStatus(err, fiber.StatusBadRequest)).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(chat.ToDTO())
+}
@@ -0,0 +1,214 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/kintsugi-ai/backend/internal/tokenizer"
+)
+
+const (
+	// maxToolRounds bounds how many times one reply may call tools before the
+	// model is asked to answer without them.
+	maxToolRounds = 5
+
+	// maxToolResultLength keeps large tool output from flooding the context.
+	maxToolResultLength = 16000
+)
+
+// ToolContext identifies who a tool runs for.
+type ToolContext struct {
+	UserID uuid.UUID
+	ChatID uuid.UUID
+}
+
+// ToolHandler runs a tool with the JSON arguments produced by the model and
+// returns the result passed back to it, usually JSON.
+type ToolHandler func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error)
+
+// Tool is a function the model may call. Parameters is a JSON schema object.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+	Handler     ToolHandler
+}
+
+// ToolRegistry holds the tools offered to the model in chats.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	order []string
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]Tool)}
+}
+
+// Register adds a tool, replacing any tool with the same name.
+func (r *ToolRegistry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tools[tool.Name]; !exists {
+		r.order = append(r.order, tool.Name)
+	}
+	r.tools[tool.Name] = tool
+}
+
+func (r *ToolRegistry) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.tools)
+}
+
+// Definitions returns the tools in the format sent with chat requests.
+func (r *ToolRegistry) Definitions() []openai.Tool {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	defs := make([]openai.Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tool := r.tools[name]
+		defs = append(defs, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return defs
+}
+
+// Execute runs the tool named in call. Tool failures are returned as errors
+// so the caller can report them to the model instead of aborting the reply.
+func (r *ToolRegistry) Execute(ctx context.Context, tc ToolContext, call openai.ToolCall) (string, error) {
+	if r == nil {
+		return "", fmt.Errorf("unknown tool: %s", call.Function.Name)
+	}
+
+	r.mu.RLock()
+	tool, ok := r.tools[call.Function.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", call.Function.Name)
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	if !json.Valid(args) {
+		return "", fmt.Errorf("invalid arguments for %s: not valid JSON", tool.Name)
+	}
+
+	result, err := tool.Handler(ctx, tc, args)
+	if err != nil {
+		return "", err
+	}
+	if len(result) > maxToolResultLength {
+		result = strings.ToValidUTF8(result[:maxToolResultLength], "") + "\n[truncated]"
+	}
+	return result, nil
+}
+
+// chatTools returns the tools offered in the user's chats; none when the
+// catalog says the chat's model cannot call tools, and the memory tool only
+// while memory is on.
+func (s *Service) chatTools(userID uuid.UUID, model string) []openai.Tool {
+	if s.catalog != nil {
+		if entry, err := s.catalog.GetModel(model); err == nil && !entry.SupportsTools {
+			return nil
+		}
+	}
+
+	return s.filterMemoryTool(userID, s.tools.Definitions())
+}
+
+// runTool executes a tool call and saves its result as a tool message below
+// parentID. Failures are saved too, so the model can react to them; the
+// error is returned alongside the message.
+func (s *Service) runTool(ctx context.Context, tc ToolContext, chat *Chat, parentID uuid.UUID, call openai.ToolCall) (*Message, error) {
+	result, toolErr := s.tools.Execute(ctx, tc, call)
+	if toolErr != nil {
+		result = toolResultJSON(map[string]string{"error": toolErr.Error()})
+	}
+
+	message := &Message{
+		ChatID:     chat.ID,
+		ParentID:   &parentID,
+		Role:       "tool",
+		Content:    result,
+		ToolCallID: call.ID,
+		Tokens:     tokenizer.Count(chat.Model, result),
+	}
+	if err := s.repo.CreateMessage(message); err != nil {
+		log.Printf("Chat %s: failed to save result of tool %s: %v", chat.ID, call.Function.Name, err)
+		message.ID = uuid.New()
+	}
+
+	return message, toolErr
+}
+
+// mergeToolCallDelta folds a streamed tool call fragment into calls. The
+// first fragment of a call carries its ID and name, later ones append to the
+// arguments.
+func mergeToolCallDelta(calls []openai.ToolCall, delta openai.ToolCall) []openai.ToolCall {
+	index := len(calls)
+	if delta.Index != nil {
+		index = *delta.Index
+	} else if delta.ID == "" && len(calls) > 0 {
+		index = len(calls) - 1
+	}
+
+	for len(calls) <= index {
+		calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+	}
+
+	call := &calls[index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	return calls
+}
+
+func encodeToolCalls(calls []openai.ToolCall) string {
+	records := make([]ToolCall, len(calls))
+	for i, call := range calls {
+		records[i] = ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		}
+	}
+	data, _ := json.Marshal(records)
+	return string(data)
+}
+
+// toolResultJSON encodes a tool result, falling back to a JSON error.
+func toolResultJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf(`{"error":%q}`, err.Error())
+	}
+	return string(data)
+}
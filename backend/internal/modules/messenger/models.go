@@ -50,6 +50,7 @@ type ConversationMessage struct {
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 	Reactions      []Reaction     `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"reactions,omitempty"`
 	ReadReceipts   []ReadReceipt  `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE" json:"read_receipts,omitempty"`
+	Voice          *VoiceMessage  `gorm:"foreignKey:MessageID" json:"voice,omitempty"` // audio messages recorded in the app
 }
 
 type Reaction struct {
@@ -86,6 +87,35 @@ type StoryView struct {
 	ViewedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"viewed_at"`
 }
 
+// Transcription states of a voice message
+const (
+	VoiceStatusPending    = "pending"
+	VoiceStatusProcessing = "processing"
+	VoiceStatusCompleted  = "completed"
+	VoiceStatusFailed     = "failed"
+)
+
+// VoiceMessage is the recording behind an audio message. Its waveform and
+// transcription are filled in by a background job after upload.
+type VoiceMessage struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	MessageID     uuid.UUID  `gorm:"type:uuid;index" json:"message_id,omitempty"` // Conversation message ID
+	FileURL       string     `gorm:"type:varchar(500);not null" json:"file_url"`
+	FileName      string     `gorm:"type:varchar(255)" json:"file_name"`
+	ContentType   string     `gorm:"type:varchar(100)" json:"content_type"`
+	FileSize      int64      `gorm:"default:0" json:"file_size"`
+	StorageKey    string     `gorm:"type:varchar(500)" json:"-"`
+	Duration      int        `gorm:"type:integer" json:"duration"`                     // Duration in seconds
+	WaveformData  string     `gorm:"type:jsonb" json:"waveform_data"`                  // JSON array of amplitudes in [0, 1]
+	Status        string     `gorm:"type:varchar(20);default:'pending'" json:"status"` // pending, processing, completed, failed
+	Transcription string     `gorm:"type:text" json:"transcription,omitempty"`
+	Language      string     `gorm:"type:varchar(16)" json:"language,omitempty"` // requested, then as detected
+	Error         string     `gorm:"type:text" json:"error,omitempty"`
+	TranscribedAt *time.Time `json:"transcribed_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
 type InviteCode struct {
 	ID        uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	Code      string         `gorm:"type:varchar(50);unique;not null;index" json:"code"`
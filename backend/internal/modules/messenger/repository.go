@@ -105,6 +105,7 @@ func (r *Repository) GetMessageByID(id uuid.UUID) (*ConversationMessage, error)
 	err := r.db.Where("id = ?", id).
 		Preload("Reactions").
 		Preload("ReadReceipts").
+		Preload("Voice").
 		First(&message).Error
 
 	if err != nil {
@@ -121,6 +122,7 @@ func (r *Repository) GetConversationMessages(conversationID uuid.UUID, limit, of
 	err := r.db.Where("conversation_id = ?", conversationID).
 		Preload("Reactions").
 		Preload("ReadReceipts").
+		Preload("Voice").
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
@@ -396,3 +398,59 @@ func (r *Repository) GetAllUsers(limit int) ([]map[string]interface{}, error) {
 
 	return users, nil
 }
+
+// Voice messages
+
+// CreateVoiceMessage saves an audio message together with its recording.
+func (r *Repository) CreateVoiceMessage(message *ConversationMessage, voice *VoiceMessage) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Voice").Create(message).Error; err != nil {
+			return err
+		}
+		voice.MessageID = message.ID
+		return tx.Create(voice).Error
+	})
+}
+
+func (r *Repository) GetVoiceMessage(id uuid.UUID) (*VoiceMessage, error) {
+	var voice VoiceMessage
+	if err := r.db.Where("id = ?", id).First(&voice).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("voice message not found")
+		}
+		return nil, err
+	}
+	return &voice, nil
+}
+
+func (r *Repository) UpdateVoiceMessage(voice *VoiceMessage) error {
+	return r.db.Save(voice).Error
+}
+
+// ClaimVoiceMessage marks a pending voice message as processing. It
+// reports false if the message is gone or another worker has it.
+func (r *Repository) ClaimVoiceMessage(id uuid.UUID) (bool, error) {
+	result := r.db.Model(&VoiceMessage{}).
+		Where("id = ? AND status = ?", id, VoiceStatusPending).
+		Updates(map[string]interface{}{"status": VoiceStatusProcessing, "error": ""})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ResetVoiceMessages returns voice messages left processing by a previous
+// run to pending.
+func (r *Repository) ResetVoiceMessages() error {
+	return r.db.Model(&VoiceMessage{}).
+		Where("status = ?", VoiceStatusProcessing).
+		Update("status", VoiceStatusPending).Error
+}
+
+// PendingVoiceMessages lists the voice messages waiting to be transcribed,
+// oldest first.
+func (r *Repository) PendingVoiceMessages() ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.Model(&VoiceMessage{}).
+		Where("status = ?", VoiceStatusPending).
+		Order("created_at").
+		Pluck("id", &ids).Error
+	return ids, err
+}
@@ -5,7 +5,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handler) {
+func RegisterRoutes(app *fiber.App, handler *Handler, voiceHandler *VoiceHandler, authMiddleware fiber.Handler) {
 	// WebSocket endpoint
 	app.Get("/ws", websocket.New(handler.HandleWebSocket))
 
@@ -26,6 +26,12 @@ func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware fiber.Handl
 	messenger.Put("/messages/:messageId", handler.UpdateMessage)
 	messenger.Delete("/messages/:messageId", handler.DeleteMessage)
 
+	// Voice messages (recordings transcribed in the background)
+	messenger.Post("/conversations/:id/voice", voiceHandler.UploadVoiceMessage)
+	messenger.Get("/voice/:id", voiceHandler.GetVoiceMessage)
+	messenger.Get("/voice/:id/content", voiceHandler.GetVoiceContent)
+	messenger.Post("/voice/:id/transcribe", voiceHandler.RetryTranscription)
+
 	// Reactions
 	messenger.Post("/messages/:messageId/reactions", handler.AddReaction)
 	messenger.Delete("/messages/:messageId/reactions", handler.RemoveReaction)
@@ -1,16 +1,20 @@
 package messenger
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
 )
 
 type Service struct {
-	repo *Repository
-	hub  *Hub
+	repo       *Repository
+	hub        *Hub
+	moderation *moderation.Service
 }
 
 func NewService(repo *Repository, hub *Hub) *Service {
@@ -20,6 +24,47 @@ func NewService(repo *Repository, hub *Hub) *Service {
 	}
 }
 
+// SetModeration checks messages before they are sent. Without it messages
+// are not moderated.
+func (s *Service) SetModeration(moderationService *moderation.Service) {
+	s.moderation = moderationService
+}
+
+// moderate checks a message text before it is saved. Blocked texts are
+// recorded and rejected with moderation.ErrContentBlocked; the verdict of
+// flagged ones is returned to be recorded once the message is saved.
+func (s *Service) moderate(conversationID, userID uuid.UUID, content string) (*moderation.Verdict, error) {
+	if s.moderation == nil {
+		return nil, nil
+	}
+
+	verdict := s.moderation.Check(context.Background(), content)
+	if verdict.Blocked() {
+		s.moderation.Record(verdict, &moderation.ModerationEvent{
+			UserID:         userID,
+			Source:         moderation.SourceMessenger,
+			Content:        content,
+			ConversationID: &conversationID,
+		})
+		return nil, moderation.ErrContentBlocked
+	}
+	return verdict, nil
+}
+
+// recordModeration queues a saved message for review if it was flagged.
+func (s *Service) recordModeration(verdict *moderation.Verdict, message *ConversationMessage) {
+	if s.moderation == nil {
+		return
+	}
+	s.moderation.Record(verdict, &moderation.ModerationEvent{
+		UserID:         message.SenderID,
+		Source:         moderation.SourceMessenger,
+		Content:        message.Content,
+		ConversationID: &message.ConversationID,
+		MessageID:      &message.ID,
+	})
+}
+
 // Conversations
 
 func (s *Service) CreateConversation(userID uuid.UUID, req *CreateConversationRequest) (*Conversation, error) {
@@ -152,6 +197,11 @@ func (s *Service) SendMessage(conversationID, userID uuid.UUID, req *SendMessage
 		messageType = "text"
 	}
 
+	verdict, err := s.moderate(conversationID, userID, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
 	message := &ConversationMessage{
 		ConversationID: conversationID,
 		SenderID:       userID,
@@ -164,6 +214,7 @@ func (s *Service) SendMessage(conversationID, userID uuid.UUID, req *SendMessage
 	if err := s.repo.CreateMessage(message); err != nil {
 		return nil, err
 	}
+	s.recordModeration(verdict, message)
 
 	// Update conversation timestamp
 	conversation, _ := s.repo.GetConversationByID(conversationID)
@@ -207,12 +258,18 @@ func (s *Service) UpdateMessage(messageID, userID uuid.UUID, req *UpdateMessageR
 		return nil, errors.New("not authorized to edit this message")
 	}
 
+	verdict, err := s.moderate(message.ConversationID, userID, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
 	message.Content = req.Content
 	message.IsEdited = true
 
 	if err := s.repo.UpdateMessage(message); err != nil {
 		return nil, err
 	}
+	s.recordModeration(verdict, message)
 
 	// Broadcast update via WebSocket
 	conversation, _ := s.repo.GetConversationByID(message.ConversationID)
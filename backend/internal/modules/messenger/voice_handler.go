@@ -0,0 +1,156 @@
+package messenger
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type VoiceHandler struct {
+	service *VoiceService
+}
+
+func NewVoiceHandler(service *VoiceService) *VoiceHandler {
+	return &VoiceHandler{service: service}
+}
+
+// UploadVoiceMessage takes a multipart "file" with the recording and
+// optional "language" (ISO-639-1 hint) and "reply_to_id".
+func (h *VoiceHandler) UploadVoiceMessage(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	conversationID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid conversation ID",
+		})
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "File is required",
+		})
+	}
+	if fileHeader.Size > maxVoiceSize {
+		return voiceError(c, ErrVoiceTooLarge)
+	}
+
+	var replyToID *uuid.UUID
+	if value := c.FormValue("reply_to_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid reply ID",
+			})
+		}
+		replyToID = &id
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxVoiceSize))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Failed to read file",
+		})
+	}
+
+	message, err := h.service.UploadVoiceMessage(c.Context(), conversationID, userID, fileHeader.Filename, data, c.FormValue("language"), replyToID)
+	if err != nil {
+		return voiceError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(message)
+}
+
+func (h *VoiceHandler) GetVoiceMessage(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	voiceID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid voice message ID",
+		})
+	}
+
+	voice, _, err := h.service.GetVoiceMessage(voiceID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(voice)
+}
+
+// GetVoiceContent serves the recording to the conversation's participants.
+func (h *VoiceHandler) GetVoiceContent(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	voiceID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid voice message ID",
+		})
+	}
+
+	voice, data, err := h.service.GetVoiceContent(c.Context(), voiceID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, voice.ContentType)
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.Send(data)
+}
+
+// RetryTranscription queues a failed transcription again. The body may
+// carry a different "language" hint.
+func (h *VoiceHandler) RetryTranscription(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uuid.UUID)
+	voiceID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid voice message ID",
+		})
+	}
+
+	var req struct {
+		Language string `json:"language"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	voice, err := h.service.RetryTranscription(voiceID, userID, req.Language)
+	if err != nil {
+		return voiceError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(voice)
+}
+
+func voiceError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrVoiceTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrVoiceDisabled):
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
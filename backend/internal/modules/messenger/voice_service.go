@@ -0,0 +1,444 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kintsugi-ai/backend/internal/audio"
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/modules/moderation"
+	"github.com/kintsugi-ai/backend/internal/storage"
+)
+
+const (
+	// maxVoiceSize is the largest recording accepted, the limit of the
+	// Whisper API.
+	maxVoiceSize = 25 << 20
+
+	voiceWaveformBars = 64
+	voiceWorkers      = 2
+	voiceQueueSize    = 256
+	voiceJobTimeout   = 10 * time.Minute
+
+	// voiceSweepInterval is how often pending recordings are looked for,
+	// besides when one is uploaded or retried.
+	voiceSweepInterval = time.Minute
+)
+
+var (
+	ErrVoiceDisabled     = errors.New("voice storage is not configured")
+	ErrVoiceTooLarge     = errors.New("recording is too large, maximum size is 25MB")
+	ErrUnsupportedVoice  = errors.New("unsupported audio format, allowed: webm, ogg, mp3, m4a, wav, flac")
+	errNoTranscriber     = errors.New("voice transcription is not configured")
+	errVoiceNotRetryable = errors.New("only failed transcriptions can be retried")
+)
+
+// voiceContentTypes maps the formats the STT providers accept to their
+// content type.
+var voiceContentTypes = map[string]string{
+	".webm": "audio/webm",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".mp3":  "audio/mpeg",
+	".mpga": "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".mp4":  "audio/mp4",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+}
+
+// VoiceService stores recorded audio messages and transcribes them in the
+// background, notifying the conversation over the hub when done.
+type VoiceService struct {
+	repo        *Repository
+	hub         *Hub
+	storage     storage.Storage
+	transcriber llm.Transcriber
+	moderation  *moderation.Service
+	jobs        chan uuid.UUID
+	queued      sync.Map // IDs in jobs, so a sweep does not queue them twice
+}
+
+// NewVoiceService creates the service. Without a transcriber recordings
+// still get a waveform, but their transcription fails.
+func NewVoiceService(repo *Repository, hub *Hub, storage storage.Storage, transcriber llm.Transcriber) *VoiceService {
+	return &VoiceService{
+		repo:        repo,
+		hub:         hub,
+		storage:     storage,
+		transcriber: transcriber,
+		jobs:        make(chan uuid.UUID, voiceQueueSize),
+	}
+}
+
+// SetModeration checks transcriptions like typed messages. Without it
+// transcriptions are not moderated.
+func (s *VoiceService) SetModeration(moderationService *moderation.Service) {
+	s.moderation = moderationService
+}
+
+// Run processes queued recordings until the process exits, starting with
+// those left unfinished by a previous run. Pending recordings are looked for
+// every voiceSweepInterval, so those that did not fit in the queue are not
+// left waiting.
+func (s *VoiceService) Run() {
+	for i := 0; i < voiceWorkers; i++ {
+		go func() {
+			for id := range s.jobs {
+				s.queued.Delete(id)
+				s.process(id)
+			}
+		}()
+	}
+
+	if err := s.repo.ResetVoiceMessages(); err != nil {
+		log.Printf("Voice transcription: failed to reset unfinished recordings: %v", err)
+	}
+
+	ticker := time.NewTicker(voiceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep()
+		<-ticker.C
+	}
+}
+
+// sweep queues pending recordings, oldest first, while the queue has room.
+func (s *VoiceService) sweep() {
+	ids, err := s.repo.PendingVoiceMessages()
+	if err != nil {
+		log.Printf("Voice transcription: failed to load pending recordings: %v", err)
+		return
+	}
+	for _, id := range ids {
+		if !s.enqueue(id) {
+			return
+		}
+	}
+}
+
+// UploadVoiceMessage stores a recording and posts it to the conversation as
+// an audio message. The transcription follows over the hub.
+func (s *VoiceService) UploadVoiceMessage(ctx context.Context, conversationID, userID uuid.UUID, fileName string, data []byte, language string, replyToID *uuid.UUID) (*ConversationMessage, error) {
+	if s.storage == nil {
+		return nil, ErrVoiceDisabled
+	}
+	if !s.repo.IsParticipant(conversationID, userID) {
+		return nil, errors.New("not a participant")
+	}
+	if len(data) > maxVoiceSize {
+		return nil, ErrVoiceTooLarge
+	}
+
+	ext, contentType, err := detectVoiceFormat(fileName, data)
+	if err != nil {
+		return nil, err
+	}
+
+	voiceID := uuid.New()
+	key := fmt.Sprintf("voice/%s/%s%s", userID, voiceID, ext)
+	if err := s.storage.Put(ctx, key, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to store recording: %w", err)
+	}
+
+	contentURL := fmt.Sprintf("/api/messenger/voice/%s/content", voiceID)
+	message := &ConversationMessage{
+		ConversationID: conversationID,
+		SenderID:       userID,
+		MessageType:    "audio",
+		MediaURL:       contentURL,
+		ReplyToID:      replyToID,
+	}
+	voice := &VoiceMessage{
+		ID:           voiceID,
+		UserID:       userID,
+		FileURL:      contentURL,
+		FileName:     filepath.Base(fileName),
+		ContentType:  contentType,
+		FileSize:     int64(len(data)),
+		StorageKey:   key,
+		WaveformData: "[]",
+		Status:       VoiceStatusPending,
+		Language:     strings.ToLower(strings.TrimSpace(language)),
+	}
+	if err := s.repo.CreateVoiceMessage(message, voice); err != nil {
+		s.storage.Delete(ctx, key)
+		return nil, err
+	}
+	message.Voice = voice
+
+	// Update conversation timestamp and notify the participants
+	if conversation, err := s.repo.GetConversationByID(conversationID); err == nil {
+		conversation.UpdatedAt = time.Now()
+		s.repo.UpdateConversation(conversation)
+	}
+	s.broadcast(conversationID, "new_message", message)
+
+	s.enqueue(voiceID)
+	return message, nil
+}
+
+// GetVoiceMessage returns a recording to a participant of its conversation.
+func (s *VoiceService) GetVoiceMessage(voiceID, userID uuid.UUID) (*VoiceMessage, *ConversationMessage, error) {
+	voice, err := s.repo.GetVoiceMessage(voiceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	message, err := s.repo.GetMessageByID(voice.MessageID)
+	if err != nil {
+		return nil, nil, errors.New("voice message not found")
+	}
+	if !s.repo.IsParticipant(message.ConversationID, userID) {
+		return nil, nil, errors.New("voice message not found")
+	}
+	return voice, message, nil
+}
+
+func (s *VoiceService) GetVoiceContent(ctx context.Context, voiceID, userID uuid.UUID) (*VoiceMessage, []byte, error) {
+	voice, _, err := s.GetVoiceMessage(voiceID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.storage == nil || voice.StorageKey == "" {
+		return nil, nil, errors.New("recording not found")
+	}
+
+	data, err := s.read(ctx, voice.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return voice, data, nil
+}
+
+// RetryTranscription queues a failed recording again, optionally with a
+// different language hint. Only the sender may retry.
+func (s *VoiceService) RetryTranscription(voiceID, userID uuid.UUID, language string) (*VoiceMessage, error) {
+	voice, err := s.repo.GetVoiceMessage(voiceID)
+	if err != nil {
+		return nil, err
+	}
+	if voice.UserID != userID {
+		return nil, errors.New("voice message not found")
+	}
+	if voice.Status != VoiceStatusFailed {
+		return nil, errVoiceNotRetryable
+	}
+
+	voice.Status = VoiceStatusPending
+	voice.Error = ""
+	if language != "" {
+		voice.Language = strings.ToLower(strings.TrimSpace(language))
+	}
+	if err := s.repo.UpdateVoiceMessage(voice); err != nil {
+		return nil, err
+	}
+
+	s.enqueue(voice.ID)
+	return voice, nil
+}
+
+// enqueue hands a recording to the workers unless it is queued already.
+// When the queue is full it stays pending for the next sweep, and false is
+// returned.
+func (s *VoiceService) enqueue(id uuid.UUID) bool {
+	if _, queued := s.queued.LoadOrStore(id, true); queued {
+		return true
+	}
+	select {
+	case s.jobs <- id:
+		return true
+	default:
+		s.queued.Delete(id)
+		log.Printf("Voice transcription: queue full, %s stays pending", id)
+		return false
+	}
+}
+
+// process extracts the waveform of a recording and transcribes it, then
+// tells the conversation the result.
+func (s *VoiceService) process(id uuid.UUID) {
+	claimed, err := s.repo.ClaimVoiceMessage(id)
+	if err != nil {
+		log.Printf("Voice transcription: failed to claim %s: %v", id, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	voice, err := s.repo.GetVoiceMessage(id)
+	if err != nil {
+		log.Printf("Voice transcription: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), voiceJobTimeout)
+	defer cancel()
+
+	if err := s.transcribe(ctx, voice); err != nil {
+		log.Printf("Voice transcription of %s failed: %v", id, err)
+		voice.Status = VoiceStatusFailed
+		voice.Error = err.Error()
+	} else {
+		now := time.Now()
+		voice.Status = VoiceStatusCompleted
+		voice.TranscribedAt = &now
+		s.moderateTranscription(ctx, voice)
+	}
+
+	if err := s.repo.UpdateVoiceMessage(voice); err != nil {
+		log.Printf("Voice transcription: failed to save %s: %v", id, err)
+		return
+	}
+
+	message, err := s.repo.GetMessageByID(voice.MessageID)
+	if err != nil {
+		// Deleted while it was being transcribed
+		return
+	}
+	s.broadcast(message.ConversationID, "voice_transcription", map[string]interface{}{
+		"message_id":      message.ID,
+		"conversation_id": message.ConversationID,
+		"voice":           voice,
+	})
+}
+
+// moderateTranscription checks the transcription of a recording like the
+// text of a message. A blocked transcription is replaced by a notice; the
+// recording itself stays playable.
+func (s *VoiceService) moderateTranscription(ctx context.Context, voice *VoiceMessage) {
+	if s.moderation == nil || voice.Transcription == "" {
+		return
+	}
+	message, err := s.repo.GetMessageByID(voice.MessageID)
+	if err != nil {
+		return
+	}
+
+	verdict := s.moderation.Check(ctx, voice.Transcription)
+	s.moderation.Record(verdict, &moderation.ModerationEvent{
+		UserID:         message.SenderID,
+		Source:         moderation.SourceMessenger,
+		Content:        voice.Transcription,
+		ConversationID: &message.ConversationID,
+		MessageID:      &message.ID,
+	})
+	if verdict.Blocked() {
+		voice.Transcription = moderation.BlockedTranscriptionNotice
+	}
+}
+
+// transcribe fills in the waveform, duration and transcription of voice.
+// A waveform that cannot be extracted is left empty.
+func (s *VoiceService) transcribe(ctx context.Context, voice *VoiceMessage) error {
+	if s.storage == nil {
+		return ErrVoiceDisabled
+	}
+	data, err := s.read(ctx, voice.StorageKey)
+	if err != nil {
+		return err
+	}
+
+	summary, err := audio.Analyze(ctx, data, voiceWaveformBars)
+	if err != nil {
+		log.Printf("Voice transcription: no waveform for %s: %v", voice.ID, err)
+	} else {
+		waveform, _ := json.Marshal(summary.Peaks)
+		voice.WaveformData = string(waveform)
+		voice.Duration = int(math.Round(summary.Duration))
+	}
+
+	if s.transcriber == nil {
+		return errNoTranscriber
+	}
+	transcript, err := s.transcriber.Transcribe(ctx, bytes.NewReader(data), voice.FileName, voice.Language)
+	if err != nil {
+		return err
+	}
+
+	voice.Transcription = transcript.Text
+	if transcript.Language != "" {
+		voice.Language = normalizeLanguage(transcript.Language)
+	}
+	if voice.Duration == 0 && transcript.Duration > 0 {
+		voice.Duration = int(math.Round(transcript.Duration))
+	}
+	return nil
+}
+
+func (s *VoiceService) read(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.storage.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, errors.New("recording not found")
+		}
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// broadcast sends an event to every participant of a conversation.
+func (s *VoiceService) broadcast(conversationID uuid.UUID, messageType string, payload interface{}) {
+	participants, err := s.repo.GetParticipants(conversationID)
+	if err != nil {
+		log.Printf("Voice transcription: failed to load participants: %v", err)
+		return
+	}
+
+	participantIDs := make([]uuid.UUID, 0, len(participants))
+	for _, p := range participants {
+		participantIDs = append(participantIDs, p.UserID)
+	}
+	s.hub.BroadcastToUsers(participantIDs, messageType, payload)
+}
+
+// detectVoiceFormat picks the format from the file extension, falling back
+// to sniffing the content.
+func detectVoiceFormat(fileName string, data []byte) (string, string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if contentType, ok := voiceContentTypes[ext]; ok {
+		return ext, contentType, nil
+	}
+
+	switch http.DetectContentType(data) {
+	case "audio/wave":
+		ext = ".wav"
+	case "application/ogg":
+		ext = ".ogg"
+	case "video/webm", "audio/webm":
+		ext = ".webm"
+	case "audio/mpeg":
+		ext = ".mp3"
+	case "video/mp4", "audio/mp4":
+		ext = ".m4a"
+	default:
+		return "", "", ErrUnsupportedVoice
+	}
+	return ext, voiceContentTypes[ext], nil
+}
+
+// normalizeLanguage fits a detected language into the column. The Whisper
+// API reports names such as "english", whisper.cpp reports codes.
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if len(language) > 16 {
+		language = language[:16]
+	}
+	return language
+}
@@ -0,0 +1,105 @@
+package moderation
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// ListEvents serves the review queue. Filters: status (pending by default,
+// "all" for every status), source, action and user_id.
+func (h *Handler) ListEvents(c *fiber.Ctx) error {
+	query := ListEventsQuery{
+		Status: c.Query("status"),
+		Source: c.Query("source"),
+		Action: c.Query("action"),
+		Limit:  c.QueryInt("limit", 50),
+		Offset: c.QueryInt("offset", 0),
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+		query.UserID = &id
+	}
+
+	events, total, err := h.service.ListEvents(query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	responses := make([]*ModerationEventResponse, len(events))
+	for i := range events {
+		responses[i] = events[i].ToDTO()
+	}
+
+	return c.JSON(fiber.Map{
+		"events": responses,
+		"total":  total,
+		"limit":  query.Limit,
+		"offset": query.Offset,
+	})
+}
+
+func (h *Handler) GetEvent(c *fiber.Ctx) error {
+	eventID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid event ID",
+		})
+	}
+
+	event, err := h.service.GetEvent(eventID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(event.ToDTO())
+}
+
+// ReviewEvent dismisses or confirms an event.
+func (h *Handler) ReviewEvent(c *fiber.Ctx) error {
+	reviewerID := c.Locals("user_id").(uuid.UUID)
+	eventID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid event ID",
+		})
+	}
+
+	var req ReviewEventRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	event, err := h.service.ReviewEvent(eventID, reviewerID, &req)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, ErrEventNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(event.ToDTO())
+}
@@ -0,0 +1,119 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sources of moderated content
+const (
+	SourceChatPrompt  = "chat_prompt"
+	SourceChatReply   = "chat_reply"
+	SourceAPIPrompt   = "api_prompt" // completions outside chats: the API, batches and messenger AI
+	SourceAPIReply    = "api_reply"
+	SourceImagePrompt = "image_prompt"
+	SourceMessenger   = "messenger_message"
+)
+
+// Actions the policy takes on content
+const (
+	ActionAllow = "allow"
+	ActionFlag  = "flag"  // kept, and queued for review
+	ActionBlock = "block" // rejected or withheld, and queued for review
+)
+
+// Review statuses of events
+const (
+	StatusPending   = "pending"
+	StatusDismissed = "dismissed" // not a violation
+	StatusConfirmed = "confirmed"
+)
+
+// Notices replacing content withheld by the policy
+const (
+	BlockedReplyNotice         = "This reply was withheld by content moderation."
+	BlockedTranscriptionNotice = "This transcription was withheld by content moderation."
+)
+
+var (
+	ErrContentBlocked = errors.New("content was blocked by the moderation policy")
+	ErrEventNotFound  = errors.New("moderation event not found")
+)
+
+// ModerationEvent records content the policy flagged or blocked.
+type ModerationEvent struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"` // author, or owner of the chat for replies
+	Source         string     `gorm:"type:varchar(30);not null" json:"source"`
+	Action         string     `gorm:"type:varchar(10);not null" json:"action"`
+	Provider       string     `gorm:"type:varchar(30)" json:"provider"`
+	Categories     string     `gorm:"type:text" json:"-"` // comma-separated
+	Scores         string     `gorm:"type:text" json:"-"` // JSON map of category to score
+	Content        string     `gorm:"type:text;not null" json:"content"`
+	ChatID         *uuid.UUID `gorm:"type:uuid" json:"chat_id,omitempty"`
+	ConversationID *uuid.UUID `gorm:"type:uuid" json:"conversation_id,omitempty"`
+	MessageID      *uuid.UUID `gorm:"type:uuid" json:"message_id,omitempty"` // unset for blocked prompts and messages, which are not saved
+	Status         string     `gorm:"type:varchar(20);not null;default:'pending'" json:"status"`
+	ReviewedBy     *uuid.UUID `gorm:"type:uuid" json:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote     string     `gorm:"type:text" json:"review_note,omitempty"`
+	CreatedAt      time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (ModerationEvent) TableName() string {
+	return "moderation_events"
+}
+
+type ModerationEventResponse struct {
+	*ModerationEvent
+	Categories []string           `json:"categories"`
+	Scores     map[string]float64 `json:"scores,omitempty"`
+}
+
+func (e *ModerationEvent) ToDTO() *ModerationEventResponse {
+	response := &ModerationEventResponse{ModerationEvent: e, Categories: []string{}}
+	if e.Categories != "" {
+		response.Categories = strings.Split(e.Categories, ",")
+	}
+	if e.Scores != "" {
+		json.Unmarshal([]byte(e.Scores), &response.Scores)
+	}
+	return response
+}
+
+// Verdict is the policy's decision on a piece of content.
+type Verdict struct {
+	Action     string
+	Provider   string
+	Categories []string
+	Scores     map[string]float64
+}
+
+// Blocked reports whether the content must not be kept or shown.
+func (v *Verdict) Blocked() bool {
+	return v != nil && v.Action == ActionBlock
+}
+
+// Flagged reports whether the content needs to be recorded for review.
+func (v *Verdict) Flagged() bool {
+	return v != nil && v.Action != ActionAllow
+}
+
+// ListEventsQuery filters the review queue. Status defaults to pending.
+type ListEventsQuery struct {
+	Status string
+	Source string
+	Action string
+	UserID *uuid.UUID
+	Limit  int
+	Offset int
+}
+
+type ReviewEventRequest struct {
+	Status string `json:"status" validate:"required,oneof=dismissed confirmed"`
+	Note   string `json:"note,omitempty"`
+}
@@ -0,0 +1,14 @@
+package moderation
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegisterRoutes serves the review queue to admins, adminOnly being the
+// admin check of the chat module.
+func RegisterRoutes(app *fiber.App, handler *Handler, authMiddleware, adminOnly fiber.Handler) {
+	admin := app.Group("/api/admin/moderation", authMiddleware, adminOnly)
+	admin.Get("/events", handler.ListEvents)
+	admin.Get("/events/:id", handler.GetEvent)
+	admin.Put("/events/:id/review", handler.ReviewEvent)
+}
@@ -0,0 +1,206 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+)
+
+// checkTimeout bounds a moderation call; content is let through when the
+// provider does not answer in time.
+const checkTimeout = 10 * time.Second
+
+// Policy decides what happens to flagged content.
+type Policy struct {
+	// Action is taken on flagged content: ActionFlag or ActionBlock.
+	Action string
+
+	// BlockCategories are always blocked, whatever Action is.
+	BlockCategories map[string]bool
+}
+
+// PolicyFromEnv reads the policy from the environment:
+//
+//	MODERATION_ACTION           - flag (default) or block
+//	MODERATION_BLOCK_CATEGORIES - comma-separated categories to block even
+//	                              when the action is flag, e.g. sexual/minors
+func PolicyFromEnv() Policy {
+	policy := Policy{Action: ActionFlag, BlockCategories: make(map[string]bool)}
+
+	switch action := os.Getenv("MODERATION_ACTION"); action {
+	case "", ActionFlag:
+	case ActionBlock:
+		policy.Action = ActionBlock
+	default:
+		log.Printf("Warning: unknown MODERATION_ACTION %q - flagged content is only recorded", action)
+	}
+
+	for _, category := range strings.Split(os.Getenv("MODERATION_BLOCK_CATEGORIES"), ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			policy.BlockCategories[category] = true
+		}
+	}
+	return policy
+}
+
+// Service classifies content with a moderation provider, applies the
+// policy and keeps the review queue of flagged content.
+type Service struct {
+	db        *gorm.DB
+	moderator llm.Moderator
+	policy    Policy
+}
+
+// NewService returns the moderation service. Without a moderator all
+// content is allowed, but the review queue is still served.
+func NewService(db *gorm.DB, moderator llm.Moderator, policy Policy) *Service {
+	return &Service{
+		db:        db,
+		moderator: moderator,
+		policy:    policy,
+	}
+}
+
+// Check classifies text and returns the policy's verdict. Provider errors
+// are logged and the text is allowed, so an outage does not stop chats.
+func (s *Service) Check(ctx context.Context, text string) *Verdict {
+	verdict := &Verdict{Action: ActionAllow}
+	if s.moderator == nil || strings.TrimSpace(text) == "" {
+		return verdict
+	}
+	verdict.Provider = s.moderator.Name()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	result, err := s.moderator.Moderate(ctx, text)
+	if err != nil {
+		log.Printf("Moderation: %s failed, allowing content: %v", s.moderator.Name(), err)
+		return verdict
+	}
+	if !result.Flagged {
+		return verdict
+	}
+
+	verdict.Action = s.policy.Action
+	verdict.Categories = result.Categories
+	verdict.Scores = result.Scores
+	for _, category := range result.Categories {
+		if s.policy.BlockCategories[category] {
+			verdict.Action = ActionBlock
+		}
+	}
+	return verdict
+}
+
+// Record queues flagged content for review. event carries who wrote the
+// content, where and the content itself; the verdict fills in the rest.
+func (s *Service) Record(verdict *Verdict, event *ModerationEvent) {
+	if !verdict.Flagged() {
+		return
+	}
+
+	event.Action = verdict.Action
+	event.Provider = verdict.Provider
+	event.Categories = strings.Join(verdict.Categories, ",")
+	if len(verdict.Scores) > 0 {
+		scores, _ := json.Marshal(verdict.Scores)
+		event.Scores = string(scores)
+	}
+	event.Status = StatusPending
+
+	if err := s.db.Create(event).Error; err != nil {
+		log.Printf("Moderation: failed to record %s event for user %s: %v", event.Source, event.UserID, err)
+	}
+}
+
+// Admin
+
+// ListEvents returns a page of the review queue, oldest first so reviewers
+// work through it in order, and the number of events matching the query.
+func (s *Service) ListEvents(query ListEventsQuery) ([]ModerationEvent, int64, error) {
+	if query.Limit <= 0 || query.Limit > 100 {
+		query.Limit = 50
+	}
+	if query.Status == "" {
+		query.Status = StatusPending
+	}
+
+	db := s.db.Model(&ModerationEvent{})
+	if query.Status != "all" {
+		db = db.Where("status = ?", query.Status)
+	}
+	if query.Source != "" {
+		db = db.Where("source = ?", query.Source)
+	}
+	if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if query.UserID != nil {
+		db = db.Where("user_id = ?", *query.UserID)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []ModerationEvent
+	err := db.Order("created_at ASC").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&events).Error
+	return events, total, err
+}
+
+func (s *Service) GetEvent(id uuid.UUID) (*ModerationEvent, error) {
+	var event ModerationEvent
+	if err := s.db.First(&event, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEventNotFound
+		}
+		return nil, err
+	}
+	return &event, nil
+}
+
+// ReviewEvent settles an event. Events can be reviewed again to correct a
+// decision.
+func (s *Service) ReviewEvent(id, reviewerID uuid.UUID, req *ReviewEventRequest) (*ModerationEvent, error) {
+	if req.Status != StatusDismissed && req.Status != StatusConfirmed {
+		return nil, errors.New("status must be dismissed or confirmed")
+	}
+
+	event, err := s.GetEvent(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	event.Status = req.Status
+	event.ReviewedBy = &reviewerID
+	event.ReviewedAt = &now
+	event.ReviewNote = req.Note
+
+	err = s.db.Model(&ModerationEvent{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      event.Status,
+			"reviewed_by": reviewerID,
+			"reviewed_at": now,
+			"review_note": event.ReviewNote,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
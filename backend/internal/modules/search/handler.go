@@ -20,7 +20,7 @@ func NewHandler(service *Service, db *gorm.DB) *Handler {
 
 // GlobalSearch handles global search request
 func (h *Handler) GlobalSearch(c *fiber.Ctx) error {
-	userID, ok := c.Locals("userID").(uuid.UUID)
+	userID, ok := c.Locals("user_id").(uuid.UUID)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
@@ -40,7 +40,7 @@ func (h *Handler) GlobalSearch(c *fiber.Ctx) error {
 
 // QuickSwitch handles quick switcher search
 func (h *Handler) QuickSwitch(c *fiber.Ctx) error {
-	userID, ok := c.Locals("userID").(uuid.UUID)
+	userID, ok := c.Locals("user_id").(uuid.UUID)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
@@ -60,7 +60,7 @@ func (h *Handler) QuickSwitch(c *fiber.Ctx) error {
 
 // GetRecentItems returns recently accessed items
 func (h *Handler) GetRecentItems(c *fiber.Ctx) error {
-	userID, ok := c.Locals("userID").(uuid.UUID)
+	userID, ok := c.Locals("user_id").(uuid.UUID)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
@@ -79,7 +79,7 @@ func (h *Handler) GetRecentItems(c *fiber.Ctx) error {
 
 // RecordRecentItem records a recently accessed item
 func (h *Handler) RecordRecentItem(c *fiber.Ctx) error {
-	userID, ok := c.Locals("userID").(uuid.UUID)
+	userID, ok := c.Locals("user_id").(uuid.UUID)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
@@ -104,7 +104,7 @@ func (h *Handler) RecordRecentItem(c *fiber.Ctx) error {
 
 // GetSuggestions returns AI-powered suggestions
 func (h *Handler) GetSuggestions(c *fiber.Ctx) error {
-	userID, ok := c.Locals("userID").(uuid.UUID)
+	userID, ok := c.Locals("user_id").(uuid.UUID)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
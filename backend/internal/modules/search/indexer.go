@@ -0,0 +1,207 @@
+package search
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/vectorstore"
+)
+
+// Vector store namespaces of indexed messages
+const (
+	ChatMessageNamespace         = "chat_message"
+	ConversationMessageNamespace = "conversation_message"
+)
+
+const (
+	indexBatchSize       = 64
+	defaultIndexInterval = time.Minute
+
+	// maxIndexedRunes keeps long messages under the embedding model's input
+	// limit; the start of a message is the best summary of it.
+	maxIndexedRunes = 6000
+)
+
+// pendingMessage is a message that has no embedding for the current model.
+type pendingMessage struct {
+	ID      uuid.UUID
+	ScopeID uuid.UUID
+	Content string
+}
+
+// Indexer embeds AI chat and messenger messages for semantic search. The
+// first run backfills existing history; later runs pick up new and edited
+// messages and drop entries of deleted ones.
+type Indexer struct {
+	db       *gorm.DB
+	embedder llm.Embedder
+	interval time.Duration
+}
+
+// NewIndexer creates an indexer that runs every SEARCH_INDEX_INTERVAL
+// (default 1m).
+func NewIndexer(db *gorm.DB, embedder llm.Embedder) *Indexer {
+	interval := defaultIndexInterval
+	if value := os.Getenv("SEARCH_INDEX_INTERVAL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			interval = d
+		} else {
+			log.Printf("Warning: invalid SEARCH_INDEX_INTERVAL %q, using %s", value, defaultIndexInterval)
+		}
+	}
+
+	return &Indexer{
+		db:       db,
+		embedder: embedder,
+		interval: interval,
+	}
+}
+
+// Run indexes messages until the process exits.
+func (i *Indexer) Run() {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := i.RunOnce(context.Background()); err != nil {
+			log.Printf("Search indexer: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// RunOnce embeds every pending message and removes entries whose message
+// is gone.
+func (i *Indexer) RunOnce(ctx context.Context) error {
+	indexed := 0
+	for _, source := range i.sources() {
+		for {
+			var pending []pendingMessage
+			if err := i.db.WithContext(ctx).Raw(source.pending, i.embedder.Model(), indexBatchSize).Scan(&pending).Error; err != nil {
+				return err
+			}
+			if len(pending) == 0 {
+				break
+			}
+			if err := i.index(ctx, source.namespace, pending); err != nil {
+				return err
+			}
+			indexed += len(pending)
+			if len(pending) < indexBatchSize {
+				break
+			}
+		}
+
+		if err := i.db.WithContext(ctx).Exec(source.prune, source.namespace).Error; err != nil {
+			return err
+		}
+	}
+
+	if indexed > 0 {
+		log.Printf("Search indexer: embedded %d messages", indexed)
+	}
+	return nil
+}
+
+func (i *Indexer) index(ctx context.Context, namespace string, pending []pendingMessage) error {
+	texts := make([]string, len(pending))
+	ids := make([]uuid.UUID, len(pending))
+	for n, message := range pending {
+		texts[n] = truncate(message.Content, maxIndexedRunes)
+		ids[n] = message.ID
+	}
+
+	vectors, err := i.embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]vectorstore.Entry, len(pending))
+	for n, message := range pending {
+		entries[n] = vectorstore.Entry{
+			Namespace: namespace,
+			SourceID:  message.ID,
+			ScopeID:   message.ScopeID,
+			Content:   texts[n],
+			Model:     i.embedder.Model(),
+			Embedding: vectors[n],
+		}
+	}
+
+	// Edited messages are re-indexed, so replace what they had
+	return vectorstore.New(i.db).Transaction(ctx, func(store *vectorstore.Store) error {
+		if err := store.DeleteSources(ctx, namespace, ids); err != nil {
+			return err
+		}
+		return store.Add(ctx, entries)
+	})
+}
+
+type indexSource struct {
+	namespace string
+	pending   string // selects pendingMessage rows; args: model, limit
+	prune     string // deletes entries of removed messages; args: namespace
+}
+
+func (i *Indexer) sources() []indexSource {
+	return []indexSource{
+		{
+			namespace: ChatMessageNamespace,
+			pending: `
+				SELECT m.id, m.chat_id AS scope_id, m.content
+				FROM messages m
+				JOIN chats c ON c.id = m.chat_id
+				LEFT JOIN embeddings e
+					ON e.namespace = 'chat_message' AND e.source_id = m.id AND e.model = ?
+				WHERE m.deleted_at IS NULL AND c.deleted_at IS NULL
+				AND m.role IN ('user', 'assistant')
+				AND m.content <> ''
+				AND e.id IS NULL
+				ORDER BY m.created_at
+				LIMIT ?`,
+			prune: `
+				DELETE FROM embeddings e
+				WHERE e.namespace = ?
+				AND NOT EXISTS (
+					SELECT 1 FROM messages m
+					JOIN chats c ON c.id = m.chat_id
+					WHERE m.id = e.source_id AND m.deleted_at IS NULL AND c.deleted_at IS NULL
+				)`,
+		},
+		{
+			namespace: ConversationMessageNamespace,
+			pending: `
+				SELECT cm.id, cm.conversation_id AS scope_id, cm.content
+				FROM conversation_messages cm
+				LEFT JOIN embeddings e
+					ON e.namespace = 'conversation_message' AND e.source_id = cm.id AND e.model = ?
+				WHERE cm.deleted_at IS NULL
+				AND cm.message_type = 'text'
+				AND cm.content <> ''
+				AND (e.id IS NULL OR e.created_at < cm.updated_at)
+				ORDER BY cm.created_at
+				LIMIT ?`,
+			prune: `
+				DELETE FROM embeddings e
+				WHERE e.namespace = ?
+				AND NOT EXISTS (
+					SELECT 1 FROM conversation_messages cm
+					WHERE cm.id = e.source_id AND cm.deleted_at IS NULL
+				)`,
+		},
+	}
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,278 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/testutil"
+)
+
+// testDB creates the tables search reads in a schema of the database of
+// TEST_DATABASE_URL, see testutil.Postgres. Tests are skipped without
+// pgvector.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testutil.Postgres(t, "search_test", "vector")
+
+	// The columns search and the indexer use, see migrateDatabase
+	statements := []string{
+		`CREATE TABLE chats (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL,
+			title VARCHAR(255) DEFAULT '',
+			deleted_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE messages (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			chat_id UUID NOT NULL,
+			role VARCHAR(20) NOT NULL,
+			content TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			deleted_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE conversations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(255)
+		)`,
+		`CREATE TABLE conversation_participants (
+			conversation_id UUID NOT NULL,
+			user_id UUID NOT NULL
+		)`,
+		`CREATE TABLE conversation_messages (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			conversation_id UUID NOT NULL,
+			content TEXT NOT NULL DEFAULT '',
+			message_type VARCHAR(20) DEFAULT 'text',
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			deleted_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE embeddings (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			namespace VARCHAR(50) NOT NULL,
+			source_id UUID NOT NULL,
+			scope_id UUID NOT NULL,
+			position INTEGER DEFAULT 0,
+			content TEXT NOT NULL,
+			metadata TEXT,
+			model VARCHAR(100) NOT NULL,
+			embedding vector NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("failed to create tables: %v", err)
+		}
+	}
+	return db
+}
+
+// seed holds the rows created by seedMessages.
+type seed struct {
+	user, other      uuid.UUID
+	assistantMessage uuid.UUID
+	meetingMessage   uuid.UUID
+}
+
+// seedMessages creates chats and conversations of two users, with messages
+// the indexer must skip next to the ones it embeds.
+func seedMessages(t *testing.T, db *gorm.DB) seed {
+	t.Helper()
+
+	s := seed{user: uuid.New(), other: uuid.New()}
+	exec := func(sql string, args ...interface{}) {
+		t.Helper()
+		if err := db.Exec(sql, args...).Error; err != nil {
+			t.Fatalf("failed to seed: %v", err)
+		}
+	}
+	chat := func(userID uuid.UUID, title string, deleted bool) uuid.UUID {
+		id := uuid.New()
+		exec("INSERT INTO chats (id, user_id, title) VALUES (?, ?, ?)", id, userID, title)
+		if deleted {
+			exec("UPDATE chats SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		}
+		return id
+	}
+	message := func(chatID uuid.UUID, role, content string, deleted bool) uuid.UUID {
+		id := uuid.New()
+		exec("INSERT INTO messages (id, chat_id, role, content) VALUES (?, ?, ?, ?)", id, chatID, role, content)
+		if deleted {
+			exec("UPDATE messages SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		}
+		return id
+	}
+	conversation := func(name string, userIDs ...uuid.UUID) uuid.UUID {
+		id := uuid.New()
+		exec("INSERT INTO conversations (id, name) VALUES (?, ?)", id, name)
+		for _, userID := range userIDs {
+			exec("INSERT INTO conversation_participants (conversation_id, user_id) VALUES (?, ?)", id, userID)
+		}
+		return id
+	}
+	conversationMessage := func(conversationID uuid.UUID, messageType, content string) uuid.UUID {
+		id := uuid.New()
+		exec("INSERT INTO conversation_messages (id, conversation_id, message_type, content) VALUES (?, ?, ?, ?)",
+			id, conversationID, messageType, content)
+		return id
+	}
+
+	baking := chat(s.user, "Baking", false)
+	message(baking, "system", "You are a baker", false)
+	message(baking, "user", "How do I feed a sourdough starter?", false)
+	s.assistantMessage = message(baking, "assistant", "Feed the starter before baking bread", false)
+	message(baking, "assistant", "", false)
+	message(baking, "user", "Forget this", true)
+	message(chat(s.user, "Old", true), "user", "Old bread starter", false)
+	message(chat(s.other, "Notes", false), "user", "Hidden bread starter", false)
+
+	team := conversation("Team", s.user)
+	s.meetingMessage = conversationMessage(team, "text", "Meeting moved to Thursday afternoon")
+	conversationMessage(team, "text", "Can we meet on Thursday afternoon?")
+	conversationMessage(team, "image", "thursday-meeting.png")
+	conversationMessage(conversation("Other team", s.other), "text", "Thursday meeting notes")
+
+	return s
+}
+
+// indexed returns the contents of the entries of a model by namespace,
+// sorted, and the IDs of the entries.
+func indexed(t *testing.T, db *gorm.DB, model string) (map[string][]string, []string) {
+	t.Helper()
+
+	var rows []struct {
+		ID        string
+		Namespace string
+		Content   string
+	}
+	if err := db.Raw("SELECT id, namespace, content FROM embeddings WHERE model = ? ORDER BY id", model).Scan(&rows).Error; err != nil {
+		t.Fatalf("failed to read embeddings: %v", err)
+	}
+
+	contents := make(map[string][]string)
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		contents[row.Namespace] = append(contents[row.Namespace], row.Content)
+		ids[i] = row.ID
+	}
+	for _, c := range contents {
+		sort.Strings(c)
+	}
+	return contents, ids
+}
+
+// renamedEmbedder is a FakeEmbedder posing as another embedding model.
+type renamedEmbedder struct {
+	*llm.FakeEmbedder
+	model string
+}
+
+func (e renamedEmbedder) Model() string {
+	return e.model
+}
+
+func TestIndexerRunOnce(t *testing.T) {
+	db := testDB(t)
+	s := seedMessages(t, db)
+
+	fake := llm.NewFakeEmbedder()
+	other := renamedEmbedder{fake, "other-embedding"}
+
+	steps := []struct {
+		name     string
+		change   func(t *testing.T)
+		embedder llm.Embedder
+		want     map[string][]string // contents of the embedder's entries by namespace
+		keepIDs  bool                // whether entries must be left in place
+	}{
+		{
+			name:     "backfill",
+			embedder: fake,
+			want: map[string][]string{
+				ChatMessageNamespace:         {"Feed the starter before baking bread", "Hidden bread starter", "How do I feed a sourdough starter?"},
+				ConversationMessageNamespace: {"Can we meet on Thursday afternoon?", "Meeting moved to Thursday afternoon", "Thursday meeting notes"},
+			},
+		},
+		{
+			name:     "nothing new",
+			embedder: fake,
+			want: map[string][]string{
+				ChatMessageNamespace:         {"Feed the starter before baking bread", "Hidden bread starter", "How do I feed a sourdough starter?"},
+				ConversationMessageNamespace: {"Can we meet on Thursday afternoon?", "Meeting moved to Thursday afternoon", "Thursday meeting notes"},
+			},
+			keepIDs: true,
+		},
+		{
+			name: "edited message re-indexed",
+			change: func(t *testing.T) {
+				err := db.Exec("UPDATE conversation_messages SET content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+					"Meeting moved to Friday morning", s.meetingMessage).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			embedder: fake,
+			want: map[string][]string{
+				ChatMessageNamespace:         {"Feed the starter before baking bread", "Hidden bread starter", "How do I feed a sourdough starter?"},
+				ConversationMessageNamespace: {"Can we meet on Thursday afternoon?", "Meeting moved to Friday morning", "Thursday meeting notes"},
+			},
+		},
+		{
+			name: "deleted messages pruned",
+			change: func(t *testing.T) {
+				if err := db.Exec("UPDATE messages SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", s.assistantMessage).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Exec("DELETE FROM conversation_messages WHERE id = ?", s.meetingMessage).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			embedder: fake,
+			want: map[string][]string{
+				ChatMessageNamespace:         {"Hidden bread starter", "How do I feed a sourdough starter?"},
+				ConversationMessageNamespace: {"Can we meet on Thursday afternoon?", "Thursday meeting notes"},
+			},
+		},
+		{
+			name:     "new model indexes again",
+			embedder: other,
+			want: map[string][]string{
+				ChatMessageNamespace:         {"Hidden bread starter", "How do I feed a sourdough starter?"},
+				ConversationMessageNamespace: {"Can we meet on Thursday afternoon?", "Thursday meeting notes"},
+			},
+		},
+	}
+
+	for _, step := range steps {
+		if step.change != nil {
+			step.change(t)
+		}
+		_, before := indexed(t, db, step.embedder.Model())
+
+		if err := NewIndexer(db, step.embedder).RunOnce(context.Background()); err != nil {
+			t.Fatalf("%s: RunOnce: %v", step.name, err)
+		}
+
+		got, after := indexed(t, db, step.embedder.Model())
+		for _, namespace := range []string{ChatMessageNamespace, ConversationMessageNamespace} {
+			if strings.Join(got[namespace], "|") != strings.Join(step.want[namespace], "|") {
+				t.Errorf("%s: %s entries = %q, want %q", step.name, namespace, got[namespace], step.want[namespace])
+			}
+		}
+		if step.keepIDs && strings.Join(after, ",") != strings.Join(before, ",") {
+			t.Errorf("%s: entries were replaced", step.name)
+		}
+	}
+
+	// Entries of the first model stay for searches still using it
+	if got, _ := indexed(t, db, fake.Model()); len(got[ChatMessageNamespace]) != 2 || len(got[ConversationMessageNamespace]) != 2 {
+		t.Errorf("entries of %s = %q", fake.Model(), got)
+	}
+}
@@ -1,21 +1,52 @@
 package search
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+	"github.com/kintsugi-ai/backend/internal/vectorstore"
+)
+
+const (
+	// semanticLimit is how many similar messages each module contributes.
+	semanticLimit = 50
+
+	// semanticMinScore drops messages that are barely related to the query.
+	semanticMinScore = 0.3
+
+	// semanticWeight scales cosine similarity (0..1) to the keyword score.
+	semanticWeight = 100.0
+
+	// maxKeywordScore caps keyword scoring so repeated words cannot drown
+	// out semantic matches.
+	maxKeywordScore = 100.0
+
+	queryEmbeddingTimeout = 10 * time.Second
 )
 
 type Service struct {
-	db *gorm.DB
+	db       *gorm.DB
+	embedder llm.Embedder
+	vectors  *vectorstore.Store
 }
 
 func NewService(db *gorm.DB) *Service {
-	return &Service{db: db}
+	return &Service{db: db, vectors: vectorstore.New(db)}
+}
+
+// SetEmbedder enables semantic search over messages indexed by the Indexer
+// with the same embedder.
+func (s *Service) SetEmbedder(embedder llm.Embedder) {
+	s.embedder = embedder
 }
 
 // GlobalSearch performs search across all modules
@@ -35,6 +66,8 @@ func (s *Service) GlobalSearch(userID uuid.UUID, req SearchRequest) (*SearchResp
 		modules = []string{"chat", "messenger", "translation", "files"}
 	}
 
+	queryVector := s.embedQuery(req.Query, modules)
+
 	// Search in each module
 	for _, module := range modules {
 		var moduleResults []SearchResult
@@ -42,9 +75,9 @@ func (s *Service) GlobalSearch(userID uuid.UUID, req SearchRequest) (*SearchResp
 
 		switch module {
 		case "chat":
-			moduleResults, err = s.searchChats(userID, req.Query)
+			moduleResults, err = s.searchChats(userID, req.Query, queryVector)
 		case "messenger":
-			moduleResults, err = s.searchMessages(userID, req.Query)
+			moduleResults, err = s.searchMessages(userID, req.Query, queryVector)
 		case "translation":
 			moduleResults, err = s.searchTranslations(userID, req.Query)
 		case "files":
@@ -59,7 +92,11 @@ func (s *Service) GlobalSearch(userID uuid.UUID, req SearchRequest) (*SearchResp
 	}
 
 	// Sort by relevance/date
-	if req.SortBy == "date" {
+	if req.SortBy == "" || req.SortBy == "relevance" {
+		sort.SliceStable(allResults, func(i, j int) bool {
+			return allResults[i].Score > allResults[j].Score
+		})
+	} else if req.SortBy == "date" {
 		// Sort by created_at descending
 		for i := 0; i < len(allResults); i++ {
 			for j := i + 1; j < len(allResults); j++ {
@@ -93,12 +130,22 @@ func (s *Service) GlobalSearch(userID uuid.UUID, req SearchRequest) (*SearchResp
 	}, nil
 }
 
-// searchChats searches in chat messages
-func (s *Service) searchChats(userID uuid.UUID, query string) ([]SearchResult, error) {
+// searchChats searches in chat messages by keyword and, when a query
+// vector is given, by meaning
+func (s *Service) searchChats(userID uuid.UUID, query string, queryVector vectorstore.Vector) ([]SearchResult, error) {
 	var results []SearchResult
 
 	queryPattern := "%" + strings.ToLower(query) + "%"
 
+	var chatIDs []uuid.UUID
+	if queryVector != nil {
+		if err := s.db.Table("chats").Where("user_id = ? AND deleted_at IS NULL", userID).Pluck("id", &chatIDs).Error; err != nil {
+			log.Printf("Search: failed to list chats, using keywords only: %v", err)
+		}
+	}
+	similar := s.similarMessages(ChatMessageNamespace, chatIDs, queryVector)
+	ids := similarIDs(similar)
+
 	rows, err := s.db.Raw(`
 		SELECT
 			m.id,
@@ -107,14 +154,16 @@ func (s *Service) searchChats(userID uuid.UUID, query string) ([]SearchResult, e
 			m.content,
 			'chat' as module,
 			m.created_at,
-			m.updated_at
+			m.created_at as updated_at,
+			c.id as chat_id
 		FROM messages m
 		JOIN chats c ON m.chat_id = c.id
 		WHERE c.user_id = ?
-		AND LOWER(m.content) LIKE ?
-		ORDER BY m.created_at DESC
-		LIMIT 50
-	`, userID, queryPattern).Rows()
+		AND m.deleted_at IS NULL AND c.deleted_at IS NULL
+		AND (LOWER(m.content) LIKE ? OR m.id IN ?)
+		ORDER BY m.id IN ? DESC, m.created_at DESC
+		LIMIT ?
+	`, userID, queryPattern, ids, ids, 50+len(ids)).Rows()
 
 	if err != nil {
 		return nil, err
@@ -123,41 +172,53 @@ func (s *Service) searchChats(userID uuid.UUID, query string) ([]SearchResult, e
 
 	for rows.Next() {
 		var r SearchResult
-		if err := rows.Scan(&r.ID, &r.Type, &r.Title, &r.Content, &r.Module, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		var chatID string
+		if err := rows.Scan(&r.ID, &r.Type, &r.Title, &r.Content, &r.Module, &r.CreatedAt, &r.UpdatedAt, &chatID); err != nil {
 			continue
 		}
 		r.Highlight = s.highlightText(r.Content, query)
-		r.URL = fmt.Sprintf("/chat.html?id=%s", r.ID)
-		r.Score = s.calculateScore(r.Content, query)
+		r.URL = fmt.Sprintf("/chat.html?id=%s", chatID)
+		r.Score = s.calculateScore(r.Content, query, similar[r.ID])
 		results = append(results, r)
 	}
 
 	return results, nil
 }
 
-// searchMessages searches in messenger conversations
-func (s *Service) searchMessages(userID uuid.UUID, query string) ([]SearchResult, error) {
+// searchMessages searches in messenger conversations by keyword and, when
+// a query vector is given, by meaning
+func (s *Service) searchMessages(userID uuid.UUID, query string, queryVector vectorstore.Vector) ([]SearchResult, error) {
 	var results []SearchResult
 
 	queryPattern := "%" + strings.ToLower(query) + "%"
 
+	var conversationIDs []uuid.UUID
+	if queryVector != nil {
+		if err := s.db.Table("conversation_participants").Where("user_id = ?", userID).Pluck("conversation_id", &conversationIDs).Error; err != nil {
+			log.Printf("Search: failed to list conversations, using keywords only: %v", err)
+		}
+	}
+	similar := s.similarMessages(ConversationMessageNamespace, conversationIDs, queryVector)
+	ids := similarIDs(similar)
+
 	rows, err := s.db.Raw(`
-		SELECT DISTINCT
+		SELECT
 			cm.id,
 			'messenger_message' as type,
-			c.name as title,
+			COALESCE(c.name, '') as title,
 			cm.content,
 			'messenger' as module,
 			cm.created_at,
-			cm.updated_at
+			cm.updated_at,
+			cm.conversation_id
 		FROM conversation_messages cm
 		JOIN conversations c ON cm.conversation_id = c.id
-		JOIN conversation_participants cp ON c.id = cp.conversation_id
-		WHERE cp.user_id = ?
-		AND LOWER(cm.content) LIKE ?
-		ORDER BY cm.created_at DESC
-		LIMIT 50
-	`, userID, queryPattern).Rows()
+		WHERE c.id IN (SELECT conversation_id FROM conversation_participants WHERE user_id = ?)
+		AND cm.deleted_at IS NULL
+		AND (LOWER(cm.content) LIKE ? OR cm.id IN ?)
+		ORDER BY cm.id IN ? DESC, cm.created_at DESC
+		LIMIT ?
+	`, userID, queryPattern, ids, ids, 50+len(ids)).Rows()
 
 	if err != nil {
 		return nil, err
@@ -166,12 +227,13 @@ func (s *Service) searchMessages(userID uuid.UUID, query string) ([]SearchResult
 
 	for rows.Next() {
 		var r SearchResult
-		if err := rows.Scan(&r.ID, &r.Type, &r.Title, &r.Content, &r.Module, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		var conversationID string
+		if err := rows.Scan(&r.ID, &r.Type, &r.Title, &r.Content, &r.Module, &r.CreatedAt, &r.UpdatedAt, &conversationID); err != nil {
 			continue
 		}
 		r.Highlight = s.highlightText(r.Content, query)
-		r.URL = fmt.Sprintf("/messenger.html?conversation=%s", r.ID)
-		r.Score = s.calculateScore(r.Content, query)
+		r.URL = fmt.Sprintf("/messenger.html?conversation=%s", conversationID)
+		r.Score = s.calculateScore(r.Content, query, similar[r.ID])
 		results = append(results, r)
 	}
 
@@ -212,7 +274,7 @@ func (s *Service) searchTranslations(userID uuid.UUID, query string) ([]SearchRe
 		}
 		r.Highlight = s.highlightText(r.Content, query)
 		r.URL = fmt.Sprintf("/translation.html?id=%s", r.ID)
-		r.Score = s.calculateScore(r.Content, query)
+		r.Score = s.calculateScore(r.Content, query, 0)
 		results = append(results, r)
 	}
 
@@ -253,7 +315,7 @@ func (s *Service) searchFiles(userID uuid.UUID, query string) ([]SearchResult, e
 		}
 		r.Highlight = r.Title
 		r.URL = fmt.Sprintf("/files/%s", r.ID)
-		r.Score = s.calculateScore(r.Title, query)
+		r.Score = s.calculateScore(r.Title, query, 0)
 		results = append(results, r)
 	}
 
@@ -294,23 +356,88 @@ func (s *Service) highlightText(text, query string) string {
 	return snippet
 }
 
-// calculateScore calculates relevance score
-func (s *Service) calculateScore(text, query string) float64 {
+// calculateScore calculates relevance score. similarity is the cosine
+// similarity of the text's embedding to the query (0 when unknown), so
+// results matching both by keyword and by meaning rank first.
+func (s *Service) calculateScore(text, query string, similarity float64) float64 {
 	lowerText := strings.ToLower(text)
 	lowerQuery := strings.ToLower(query)
 
 	// Simple scoring based on occurrences
-	count := float64(strings.Count(lowerText, lowerQuery))
-	if count == 0 {
-		return 0
-	}
+	keyword := float64(strings.Count(lowerText, lowerQuery)) * 10.0
 
 	// Boost if query is at the start
 	if strings.HasPrefix(lowerText, lowerQuery) {
-		count *= 2
+		keyword *= 2
+	}
+	if keyword > maxKeywordScore {
+		keyword = maxKeywordScore
+	}
+
+	return keyword + similarity*semanticWeight
+}
+
+// embedQuery returns the query's embedding when semantic search is enabled
+// and a searched module is indexed.
+func (s *Service) embedQuery(query string, modules []string) vectorstore.Vector {
+	if s.embedder == nil || strings.TrimSpace(query) == "" {
+		return nil
+	}
+
+	indexed := false
+	for _, module := range modules {
+		if module == "chat" || module == "messenger" {
+			indexed = true
+		}
+	}
+	if !indexed {
+		return nil
 	}
 
-	return count * 10.0
+	ctx, cancel := context.WithTimeout(context.Background(), queryEmbeddingTimeout)
+	defer cancel()
+
+	vectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		log.Printf("Search: failed to embed query, using keywords only: %v", err)
+		return nil
+	}
+	return vectors[0]
+}
+
+// similarMessages returns the messages in the given scopes closest in
+// meaning to the query, keyed by ID with their similarity.
+func (s *Service) similarMessages(namespace string, scopeIDs []uuid.UUID, queryVector vectorstore.Vector) map[string]float64 {
+	similar := make(map[string]float64)
+	if queryVector == nil || len(scopeIDs) == 0 {
+		return similar
+	}
+
+	matches, err := s.vectors.Search(context.Background(), vectorstore.Query{
+		Namespace: namespace,
+		ScopeIDs:  scopeIDs,
+		Model:     s.embedder.Model(),
+		Vector:    queryVector,
+		Limit:     semanticLimit,
+		MinScore:  semanticMinScore,
+	})
+	if err != nil {
+		log.Printf("Search: semantic search in %s failed: %v", namespace, err)
+		return similar
+	}
+
+	for _, match := range matches {
+		similar[match.SourceID.String()] = match.Score
+	}
+	return similar
+}
+
+func similarIDs(similar map[string]float64) []string {
+	ids := make([]string, 0, len(similar))
+	for id := range similar {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 // generateSuggestions generates search suggestions
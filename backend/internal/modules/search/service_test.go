@@ -0,0 +1,139 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kintsugi-ai/backend/internal/llm"
+)
+
+func TestGlobalSearch(t *testing.T) {
+	db := testDB(t)
+	s := seedMessages(t, db)
+
+	embedder := llm.NewFakeEmbedder()
+	if err := NewIndexer(db, embedder).RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	// FakeEmbedder similarities to the queries: "starter bread" is 0.50 to
+	// "Feed the starter before baking bread" and 0.23 to "How do I feed a
+	// sourdough starter?"; "Thursday meeting" is 0.54 to "Meeting moved to
+	// Thursday afternoon" and 0.24 to "Can we meet on Thursday afternoon?".
+	tests := []struct {
+		name     string
+		userID   uuid.UUID
+		query    string
+		modules  []string
+		semantic bool
+		want     []string // "module: content" of the results, best first
+	}{
+		{
+			name:    "keyword",
+			userID:  s.user,
+			query:   "sourdough",
+			modules: []string{"chat"},
+			want:    []string{"chat: How do I feed a sourdough starter?"},
+		},
+		{
+			name:    "keyword ignores case",
+			userID:  s.user,
+			query:   "SOURDOUGH",
+			modules: []string{"chat"},
+			want:    []string{"chat: How do I feed a sourdough starter?"},
+		},
+		{
+			name:    "keyword skips deleted messages",
+			userID:  s.user,
+			query:   "forget",
+			modules: []string{"chat"},
+		},
+		{
+			name:     "semantic",
+			userID:   s.user,
+			query:    "starter bread",
+			modules:  []string{"chat"},
+			semantic: true,
+			want:     []string{"chat: Feed the starter before baking bread"},
+		},
+		{
+			name:    "semantic needs an embedder",
+			userID:  s.user,
+			query:   "starter bread",
+			modules: []string{"chat"},
+		},
+		{
+			name:     "semantic in the messenger",
+			userID:   s.user,
+			query:    "Thursday meeting",
+			modules:  []string{"messenger"},
+			semantic: true,
+			want:     []string{"messenger: Meeting moved to Thursday afternoon"},
+		},
+		{
+			name:     "semantic within the user's chats",
+			userID:   s.other,
+			query:    "starter bread",
+			modules:  []string{"chat"},
+			semantic: true,
+			want:     []string{"chat: Hidden bread starter"},
+		},
+		{
+			name:     "all modules",
+			userID:   s.other,
+			query:    "Thursday meeting",
+			semantic: true,
+			want:     []string{"messenger: Thursday meeting notes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(db)
+			if tt.semantic {
+				service.SetEmbedder(embedder)
+			}
+
+			resp, err := service.GlobalSearch(tt.userID, SearchRequest{Query: tt.query, Modules: tt.modules})
+			if err != nil {
+				t.Fatalf("GlobalSearch: %v", err)
+			}
+
+			var got []string
+			for _, r := range resp.Results {
+				got = append(got, r.Module+": "+r.Content)
+				if r.Score <= 0 {
+					t.Errorf("%q scored %v", r.Content, r.Score)
+				}
+				if want := resultURL(t, db, r); r.URL != want {
+					t.Errorf("%q links to %s, want %s", r.Content, r.URL, want)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("results = %q, want %q", got, tt.want)
+			}
+			if resp.Total != len(tt.want) {
+				t.Errorf("total = %d, want %d", resp.Total, len(tt.want))
+			}
+		})
+	}
+}
+
+// resultURL returns the page of the chat or conversation of a result.
+func resultURL(t *testing.T, db *gorm.DB, r SearchResult) string {
+	t.Helper()
+
+	query, page := "SELECT chat_id FROM messages WHERE id = ?", "/chat.html?id="
+	if r.Module == "messenger" {
+		query, page = "SELECT conversation_id FROM conversation_messages WHERE id = ?", "/messenger.html?conversation="
+	}
+	var scopeID string
+	if err := db.Raw(query, r.ID).Scan(&scopeID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return page + scopeID
+}
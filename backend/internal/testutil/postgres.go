@@ -0,0 +1,75 @@
+// Package testutil holds helpers shared by tests of several packages.
+package testutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Postgres connects to the database of TEST_DATABASE_URL in a schema of its
+// own, named prefix and a random suffix, which is dropped when the test
+// ends. The schema starts empty; tests create the tables they use. Tests
+// are skipped when the variable is unset, see `make test-backend-db`.
+//
+// The extensions are created in the database first, and the test is skipped
+// when one is unavailable. public stays on the search path for their types.
+func Postgres(t testing.TB, prefix string, extensions ...string) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	for _, extension := range extensions {
+		if err := admin.Exec("CREATE EXTENSION IF NOT EXISTS " + extension).Error; err != nil {
+			t.Skipf("%s extension unavailable: %v", extension, err)
+		}
+	}
+
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	schema := prefix + "_" + hex.EncodeToString(suffix)
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	// The DSN is either key=value pairs or a URL
+	separator := " "
+	if strings.Contains(dsn, "://") {
+		separator = "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+	}
+	db, err := gorm.Open(postgres.Open(dsn+separator+"search_path="+schema+",public"), config)
+	if err != nil {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		t.Fatalf("failed to connect to the test schema: %v", err)
+	}
+	// Registered after the admin cleanup, so it runs before it
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+	return db
+}
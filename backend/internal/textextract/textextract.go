@@ -0,0 +1,220 @@
+// Package textextract pulls plain text out of uploaded documents so it can
+// be chunked and embedded. PDF, DOCX, Markdown and plain text are supported.
+package textextract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Supported formats
+const (
+	FormatPDF      = "pdf"
+	FormatDOCX     = "docx"
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+)
+
+// maxDOCXBodySize limits the unpacked document body of a DOCX file, so a
+// small upload cannot expand into gigabytes.
+const maxDOCXBodySize = 64 << 20
+
+var ErrUnsupportedFormat = errors.New("unsupported document format, allowed: pdf, docx, md, txt")
+
+// Section is a part of a document with its location. Page is 1-based for
+// PDFs and 0 for formats without pages.
+type Section struct {
+	Page int
+	Text string
+}
+
+// DetectFormat picks the format from the file extension, falling back to
+// the MIME type.
+func DetectFormat(fileName, contentType string) (string, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".pdf":
+		return FormatPDF, nil
+	case ".docx":
+		return FormatDOCX, nil
+	case ".md", ".markdown":
+		return FormatMarkdown, nil
+	case ".txt", ".text":
+		return FormatText, nil
+	}
+
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch contentType {
+	case "application/pdf":
+		return FormatPDF, nil
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return FormatDOCX, nil
+	case "text/markdown", "text/x-markdown":
+		return FormatMarkdown, nil
+	case "text/plain":
+		return FormatText, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Extract returns the text of a document in the given format.
+func Extract(format string, data []byte) ([]Section, error) {
+	switch format {
+	case FormatPDF:
+		return extractPDF(data)
+	case FormatDOCX:
+		return extractDOCX(data)
+	case FormatMarkdown, FormatText:
+		if !utf8.Valid(data) {
+			return nil, errors.New("text file is not valid UTF-8")
+		}
+		text := strings.TrimPrefix(string(data), "\ufeff")
+		return []Section{{Text: normalize(text)}}, nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+func extractPDF(data []byte) (sections []Section, err error) {
+	// The PDF parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			sections, err = nil, fmt.Errorf("failed to parse PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				font := page.Font(name)
+				fonts[name] = &font
+			}
+		}
+		text, err := page.GetPlainText(fonts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PDF page %d: %w", i, err)
+		}
+		if text = normalize(text); text != "" {
+			sections = append(sections, Section{Page: i, Text: text})
+		}
+	}
+
+	if len(sections) == 0 {
+		return nil, errors.New("PDF contains no extractable text")
+	}
+	return sections, nil
+}
+
+func extractDOCX(data []byte) ([]Section, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DOCX: %w", err)
+	}
+
+	for _, file := range archive.File {
+		if file.Name != "word/document.xml" {
+			continue
+		}
+		if file.UncompressedSize64 > maxDOCXBodySize {
+			return nil, errors.New("DOCX body is too large")
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open DOCX body: %w", err)
+		}
+		defer rc.Close()
+
+		// The declared size can be forged, the reader is limited too
+		text, err := docxText(io.LimitReader(rc, maxDOCXBodySize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DOCX body: %w", err)
+		}
+		return []Section{{Text: normalize(text)}}, nil
+	}
+	return nil, errors.New("DOCX has no document body")
+}
+
+// docxText walks WordprocessingML, keeping text runs and turning paragraphs,
+// breaks and tabs into whitespace.
+func docxText(r io.Reader) (string, error) {
+	decoder := xml.NewDecoder(r)
+	var b strings.Builder
+	inText := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteByte('\t')
+			case "br", "cr":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				b.WriteString("\n\n")
+			case "tc":
+				b.WriteByte('\t')
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// normalize unifies line endings and collapses runs of blank lines.
+func normalize(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,103 @@
+// Package tokenizer counts tokens with the BPE encodings used by OpenAI
+// models. The BPE rank files are embedded, so no network access is needed.
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	EncodingCL100K = "cl100k_base"
+	EncodingO200K  = "o200k_base"
+)
+
+// Per-message framing overhead used by the chat format
+// (see openai-cookbook "How to count tokens with tiktoken").
+const (
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+)
+
+var (
+	loaderOnce sync.Once
+	mu         sync.Mutex
+	encoders   = make(map[string]*tiktoken.Tiktoken)
+)
+
+// o200kPrefixes lists model families tokenized with o200k_base. Everything
+// else, including non-OpenAI models we have no exact tokenizer for, falls
+// back to cl100k_base which is a close approximation.
+var o200kPrefixes = []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "chatgpt-4o", "o1", "o3", "o4"}
+
+// EncodingForModel returns the encoding name for a chat model. Provider
+// namespaces such as "ollama/" are ignored.
+func EncodingForModel(model string) string {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	for _, prefix := range o200kPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return EncodingO200K
+		}
+	}
+	return EncodingCL100K
+}
+
+func encoder(encoding string) (*tiktoken.Tiktoken, error) {
+	loaderOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if enc, ok := encoders[encoding]; ok {
+		return enc, nil
+	}
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	encoders[encoding] = enc
+	return enc, nil
+}
+
+// Count returns the number of tokens in text for the given model.
+func Count(model, text string) int {
+	if text == "" {
+		return 0
+	}
+	enc, err := encoder(EncodingForModel(model))
+	if err != nil {
+		// Should not happen with embedded ranks; keep quotas moving regardless
+		return len(text) / 4
+	}
+	return len(enc.Encode(text, nil, nil))
+}
+
+// CountMessages returns the prompt tokens for a chat request, including the
+// per-message framing and the tokens that prime the assistant reply.
+func CountMessages(model string, messages []openai.ChatCompletionMessage) int {
+	total := tokensPerReply
+	for _, msg := range messages {
+		total += tokensPerMessage
+		total += Count(model, msg.Role)
+		total += Count(model, msg.Content)
+		for _, part := range msg.MultiContent {
+			total += Count(model, part.Text)
+		}
+		if msg.Name != "" {
+			total += tokensPerName + Count(model, msg.Name)
+		}
+		for _, call := range msg.ToolCalls {
+			total += Count(model, call.Function.Name) + Count(model, call.Function.Arguments)
+		}
+	}
+	return total
+}
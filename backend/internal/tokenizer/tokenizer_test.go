@@ -0,0 +1,124 @@
+package tokenizer
+
+import (
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o", EncodingO200K},
+		{"gpt-4o-mini", EncodingO200K},
+		{"gpt-4.1-nano", EncodingO200K},
+		{"o3-mini", EncodingO200K},
+		{"openai/gpt-5", EncodingO200K},
+		{"gpt-4", EncodingCL100K},
+		{"gpt-3.5-turbo", EncodingCL100K},
+		{"claude-3-5-sonnet-latest", EncodingCL100K},
+		{"ollama/llama3", EncodingCL100K},
+		{"", EncodingCL100K},
+	}
+
+	for _, tt := range tests {
+		if got := EncodingForModel(tt.model); got != tt.want {
+			t.Errorf("EncodingForModel(%q) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+}
+
+// The expected counts are those of OpenAI's tiktoken.
+func TestCount(t *testing.T) {
+	tests := []struct {
+		text  string
+		cl100 int
+		o200  int
+	}{
+		{"", 0, 0},
+		{"hello world", 2, 2},
+		{"Hello, world!", 4, 4},
+		{"tiktoken is great!", 6, 6},
+		{"antidisestablishmentarianism", 6, 6},
+		{"2 + 2 = 4", 7, 7},
+		{"お誕生日おめでとう", 9, 8},
+	}
+
+	for _, tt := range tests {
+		if got := Count("gpt-4", tt.text); got != tt.cl100 {
+			t.Errorf("cl100k_base: Count(%q) = %d, want %d", tt.text, got, tt.cl100)
+		}
+		if got := Count("gpt-4o", tt.text); got != tt.o200 {
+			t.Errorf("o200k_base: Count(%q) = %d, want %d", tt.text, got, tt.o200)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		encoding string
+		want     []int
+	}{
+		{EncodingCL100K, []int{83, 1609, 5963, 374, 2294, 0}},
+		{EncodingO200K, []int{83, 8251, 2488, 382, 2212, 0}},
+	}
+
+	for _, tt := range tests {
+		enc, err := encoder(tt.encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.encoding, err)
+		}
+		got := enc.Encode("tiktoken is great!", nil, nil)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got tokens %v, want %v", tt.encoding, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s: got tokens %v, want %v", tt.encoding, got, tt.want)
+			}
+		}
+	}
+}
+
+// The example conversation of the openai-cookbook "How to count tokens with
+// tiktoken", whose prompt the API counts as 129 tokens for gpt-4 and 124
+// for gpt-4o.
+func TestCountMessages(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: "system", Content: "You are a helpful, pattern-following assistant that translates corporate jargon into plain English."},
+		{Role: "system", Name: "example_user", Content: "New synergies will help drive top-line growth."},
+		{Role: "system", Name: "example_assistant", Content: "Things working well together will increase revenue."},
+		{Role: "system", Name: "example_user", Content: "Let's circle back when we have more bandwidth to touch base on opportunities for increased leverage."},
+		{Role: "system", Name: "example_assistant", Content: "Let's talk later when we're less busy about how to do better."},
+		{Role: "user", Content: "This late pivot means we don't have time to boil the ocean for the client deliverable."},
+	}
+
+	tests := []struct {
+		model    string
+		messages []openai.ChatCompletionMessage
+		want     int
+	}{
+		{"gpt-4", messages, 129},
+		{"gpt-4o", messages, 124},
+		{"gpt-4o", nil, tokensPerReply},
+		{
+			"gpt-4",
+			[]openai.ChatCompletionMessage{{
+				Role: "user",
+				MultiContent: []openai.ChatMessagePart{
+					{Type: openai.ChatMessagePartTypeText, Text: "hello world"},
+					{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "https://example.com/a.png"}},
+				},
+			}},
+			tokensPerReply + tokensPerMessage + 1 + 2, // "user" and "hello world"
+		},
+	}
+
+	for _, tt := range tests {
+		if got := CountMessages(tt.model, tt.messages); got != tt.want {
+			t.Errorf("CountMessages(%s, %d messages) = %d, want %d", tt.model, len(tt.messages), got, tt.want)
+		}
+	}
+}
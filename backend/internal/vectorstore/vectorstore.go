@@ -0,0 +1,231 @@
+// Package vectorstore keeps text embeddings in Postgres using the pgvector
+// extension and finds the entries closest to a query vector.
+package vectorstore
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Vector is an embedding stored in a pgvector column.
+type Vector []float32
+
+func (v Vector) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(f), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String(), nil
+}
+
+func (v *Vector) Scan(src interface{}) error {
+	var text string
+	switch s := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		text = s
+	case []byte:
+		text = string(s)
+	default:
+		return fmt.Errorf("vectorstore: cannot scan %T into Vector", src)
+	}
+
+	text = strings.Trim(strings.TrimSpace(text), "[]")
+	if text == "" {
+		*v = Vector{}
+		return nil
+	}
+	parts := strings.Split(text, ",")
+	out := make(Vector, len(parts))
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return fmt.Errorf("vectorstore: invalid vector element %q", part)
+		}
+		out[i] = float32(f)
+	}
+	*v = out
+	return nil
+}
+
+// Entry is one embedded piece of text. Namespace tells what the entry was
+// made from; SourceID is the row it belongs to and ScopeID the owner used to
+// restrict searches (e.g. a document or a chat).
+type Entry struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Namespace string    `gorm:"type:varchar(50);not null;index" json:"namespace"`
+	SourceID  uuid.UUID `gorm:"type:uuid;not null;index" json:"source_id"`
+	ScopeID   uuid.UUID `gorm:"type:uuid;not null;index" json:"scope_id"`
+	Position  int       `gorm:"default:0" json:"position"` // order of the entry within its source
+	Content   string    `gorm:"type:text;not null" json:"content"`
+	Metadata  string    `gorm:"type:text" json:"metadata,omitempty"` // JSON, owned by the namespace
+	Model     string    `gorm:"type:varchar(100);not null" json:"model"`
+	Embedding Vector    `gorm:"type:vector" json:"-"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+func (Entry) TableName() string {
+	return "embeddings"
+}
+
+// Match is an entry found by Search with its cosine similarity to the query.
+type Match struct {
+	Entry
+	Score float64 `json:"score"`
+}
+
+// Query selects the entries to compare against Vector. Only entries created
+// with the same embedding model are considered.
+type Query struct {
+	Namespace string
+	ScopeIDs  []uuid.UUID
+	Model     string
+	Vector    Vector
+	Limit     int
+	MinScore  float64
+}
+
+type Store struct {
+	db   *gorm.DB
+	root *gorm.DB // outside any transaction, to create indexes in the background
+}
+
+func New(db *gorm.DB) *Store {
+	return &Store{db: db, root: db}
+}
+
+// Transaction runs fn with a store whose changes are committed together,
+// e.g. to replace the entries of a source. Use it rather than New with a
+// transaction, which cannot create indexes.
+func (s *Store) Transaction(ctx context.Context, fn func(store *Store) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&Store{db: tx, root: s.root})
+	})
+}
+
+// Add saves entries, replacing nothing; use DeleteSource first to re-index.
+func (s *Store) Add(ctx context.Context, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, entry := range entries {
+		s.ensureIndex(entry.Model, len(entry.Embedding))
+	}
+	return s.db.WithContext(ctx).CreateInBatches(entries, 100).Error
+}
+
+// maxIndexedDimensions is the largest vector an HNSW index accepts.
+// Entries of larger models are searched without an index.
+const maxIndexedDimensions = 2000
+
+// indexed holds the model and dimension pairs whose index was ensured by
+// this process.
+var indexed sync.Map
+
+// ensureIndex creates, in the background, the HNSW index of an embedding
+// model. The embedding column has no dimension since models differ, so each
+// model gets a partial index on the column cast to its dimension; Search
+// filters and orders with the same expressions to use it.
+func (s *Store) ensureIndex(model string, dimensions int) {
+	if dimensions == 0 || dimensions > maxIndexedDimensions {
+		return
+	}
+	key := fmt.Sprintf("%s/%d", model, dimensions)
+	if _, ok := indexed.LoadOrStore(key, true); ok {
+		return
+	}
+
+	sum := sha1.Sum([]byte(model))
+	stmt := fmt.Sprintf(
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_embeddings_hnsw_%x_%d ON embeddings USING hnsw ((embedding::vector(%d)) vector_cosine_ops) WHERE %s",
+		sum[:6], dimensions, dimensions, modelCondition(model, dimensions))
+	go func() {
+		if err := s.root.Exec(stmt).Error; err != nil {
+			log.Printf("Vector store: failed to index %s embeddings: %v", model, err)
+			indexed.Delete(key)
+		}
+	}()
+}
+
+// modelCondition selects the entries of a model. The values are written
+// into the SQL because a partial index is only used when the query repeats
+// its condition literally.
+func modelCondition(model string, dimensions int) string {
+	return fmt.Sprintf("model = '%s' AND vector_dims(embedding) = %d", strings.ReplaceAll(model, "'", "''"), dimensions)
+}
+
+// DeleteSource removes every entry made from a source row.
+func (s *Store) DeleteSource(ctx context.Context, namespace string, sourceID uuid.UUID) error {
+	return s.db.WithContext(ctx).
+		Where("namespace = ? AND source_id = ?", namespace, sourceID).
+		Delete(&Entry{}).Error
+}
+
+// DeleteSources removes the entries made from several source rows.
+func (s *Store) DeleteSources(ctx context.Context, namespace string, sourceIDs []uuid.UUID) error {
+	if len(sourceIDs) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).
+		Where("namespace = ? AND source_id IN ?", namespace, sourceIDs).
+		Delete(&Entry{}).Error
+}
+
+// DeleteScope removes every entry in a scope.
+func (s *Store) DeleteScope(ctx context.Context, namespace string, scopeID uuid.UUID) error {
+	return s.db.WithContext(ctx).
+		Where("namespace = ? AND scope_id = ?", namespace, scopeID).
+		Delete(&Entry{}).Error
+}
+
+// Search returns the entries most similar to the query vector, best first.
+func (s *Store) Search(ctx context.Context, q Query) ([]Match, error) {
+	if len(q.Vector) == 0 {
+		return nil, errors.New("vectorstore: empty query vector")
+	}
+	if len(q.ScopeIDs) == 0 {
+		return []Match{}, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = 5
+	}
+
+	dimensions := len(q.Vector)
+	distance := fmt.Sprintf("embedding::vector(%d) <=> ?::vector(%d)", dimensions, dimensions)
+
+	var matches []Match
+	err := s.db.WithContext(ctx).
+		Table("embeddings").
+		Select("*, 1 - ("+distance+") AS score", q.Vector).
+		Where(modelCondition(q.Model, dimensions)).
+		Where("namespace = ? AND scope_id IN ?", q.Namespace, q.ScopeIDs).
+		Where("1 - ("+distance+") >= ?", q.Vector, q.MinScore).
+		Order(gorm.Expr(distance, q.Vector)).
+		Limit(q.Limit).
+		Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}